@@ -0,0 +1,263 @@
+package aof
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zedis/config"
+	"zedis/interface/db"
+	"zedis/logger"
+	"zedis/redis/connection"
+	"zedis/redis/parser"
+	"zedis/redis/protocol"
+)
+
+// CmdLine 命令行，例如 [set key value]
+type CmdLine = [][]byte
+
+const (
+	aofQueueSize = 1 << 16
+)
+
+// AOF刷盘策略
+const (
+	// FsyncAlways 每条命令写入后立即刷盘
+	FsyncAlways = "always"
+	// FsyncEverySec 每秒刷盘一次
+	FsyncEverySec = "everysec"
+	// FsyncNo 由操作系统决定何时刷盘
+	FsyncNo = "no"
+)
+
+type payload struct {
+	cmdLine CmdLine
+	dbIndex int
+}
+
+// Persister 负责将写命令追加到AOF文件，并在启动时从AOF文件恢复数据
+type Persister struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	db          db.DBEngine
+	aofChan     chan *payload
+	aofFile     *os.File
+	aofFilename string
+	aofFsync    string
+	// 后台写入协程结束后，通过该channel通知Close
+	aofFinished chan struct{}
+	// SaveCmdLine持有读锁，Close持有写锁设置closed，之后不再向aofChan发送命令
+	closeMu sync.RWMutex
+	closed  bool
+	// 写文件、刷盘时加锁
+	mu sync.Mutex
+	// 最后一条写入AOF的命令所在的数据库
+	currentDB int
+
+	// 创建临时引擎，用于AOF重写时加载旧AOF文件
+	tmpDBMaker func() db.DBEngine
+	// 是否正在进行AOF重写
+	rewriting atomic.Bool
+	// 重写期间写入的命令，重写完成后追加到新AOF文件；不在重写时为nil
+	rewriteBuffer []*payload
+	// 上一次重写后（或启动时）AOF文件的大小，用于判断是否需要自动重写
+	aofBaseSize int64
+}
+
+// NewPersister 创建Persister，如果load为true，则先加载AOF文件中已有的命令
+func NewPersister(engine db.DBEngine, filename string, load bool, fsync string, tmpDBMaker func() db.DBEngine) (*Persister, error) {
+	p := &Persister{
+		db:          engine,
+		aofFilename: filename,
+		aofFsync:    fsync,
+		currentDB:   0,
+		tmpDBMaker:  tmpDBMaker,
+	}
+	if p.aofFsync != FsyncAlways && p.aofFsync != FsyncNo {
+		p.aofFsync = FsyncEverySec
+	}
+	if load {
+		p.LoadAof(0)
+	}
+	aofFile, err := os.OpenFile(p.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	p.aofFile = aofFile
+	if info, err := aofFile.Stat(); err == nil {
+		p.aofBaseSize = info.Size()
+	}
+	p.aofChan = make(chan *payload, aofQueueSize)
+	p.aofFinished = make(chan struct{})
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	go p.listenCmd()
+	if p.aofFsync == FsyncEverySec {
+		p.fsyncEverySecond()
+	}
+	if config.Config.AutoAofRewritePercentage > 0 {
+		p.autoRewrite()
+	}
+	return p, nil
+}
+
+// SaveCmdLine 将命令追加到AOF文件
+// always策略下同步写入并刷盘，其余策略下放入队列，由后台协程写入
+// Persister关闭后调用时丢弃命令
+func (p *Persister) SaveCmdLine(dbIndex int, cmdLine CmdLine) {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		logger.Warn("aof persister is closed, command discarded")
+		return
+	}
+	if p.aofFsync == FsyncAlways {
+		p.writeAof(&payload{
+			cmdLine: cmdLine,
+			dbIndex: dbIndex,
+		})
+		return
+	}
+	p.aofChan <- &payload{
+		cmdLine: cmdLine,
+		dbIndex: dbIndex,
+	}
+}
+
+// listenCmd 后台协程，从队列中取出命令写入AOF文件
+func (p *Persister) listenCmd() {
+	for pl := range p.aofChan {
+		p.writeAof(pl)
+	}
+	p.aofFinished <- struct{}{}
+}
+
+func (p *Persister) writeAof(pl *payload) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 正在重写时，同时写入重写缓冲区
+	if p.rewriteBuffer != nil {
+		p.rewriteBuffer = append(p.rewriteBuffer, pl)
+	}
+
+	// 数据库切换时，先写入SELECT命令
+	if pl.dbIndex != p.currentDB {
+		selectCmd := CmdLine{[]byte("SELECT"), []byte(strconv.Itoa(pl.dbIndex))}
+		_, err := p.aofFile.Write(protocol.NewMultiBulkReply(selectCmd).ToBytes())
+		if err != nil {
+			logger.Warn(err)
+			return
+		}
+		p.currentDB = pl.dbIndex
+	}
+	_, err := p.aofFile.Write(protocol.NewMultiBulkReply(pl.cmdLine).ToBytes())
+	if err != nil {
+		logger.Warn(err)
+		return
+	}
+	if p.aofFsync == FsyncAlways {
+		_ = p.aofFile.Sync()
+	}
+}
+
+// LoadAof 读取AOF文件并执行其中的命令，maxBytes > 0 时只读取前maxBytes个字节
+func (p *Persister) LoadAof(maxBytes int64) {
+	p.loadAofTo(p.db, maxBytes)
+}
+
+// loadAofTo 读取AOF文件，并在engine上执行其中的命令
+func (p *Persister) loadAofTo(engine db.DBEngine, maxBytes int64) {
+	file, err := os.Open(p.aofFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		logger.Warn(err)
+		return
+	}
+	defer file.Close()
+
+	var reader io.Reader
+	if maxBytes > 0 {
+		reader = io.LimitReader(file, maxBytes)
+	} else {
+		reader = file
+	}
+	ch := parser.ParseRequestStream(reader)
+	fakeConn := connection.NewFakeConn()
+	fakeConn.SetPassword(config.Config.RequirePass)
+	for pl := range ch {
+		if pl.Error != nil {
+			if pl.Error == io.EOF {
+				break
+			}
+			logger.Errorf("parse aof error: %v", pl.Error)
+			continue
+		}
+		if pl.Data == nil {
+			logger.Error("empty payload")
+			continue
+		}
+		r, ok := pl.Data.(*protocol.MultiBulkReply)
+		if !ok {
+			logger.Error("require multi bulk protocol")
+			continue
+		}
+		ret := engine.Exec(fakeConn, r.Texts)
+		if protocol.IsErrorReply(ret) {
+			logger.Errorf("exec aof command error: %s", string(ret.ToBytes()))
+		}
+	}
+	logger.Infof("load aof file %s finished", p.aofFilename)
+}
+
+// Fsync 将AOF文件刷盘
+func (p *Persister) Fsync() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.aofFile.Sync(); err != nil {
+		logger.Errorf("fsync aof file failed: %v", err)
+	}
+}
+
+// fsyncEverySecond 每秒刷盘一次
+func (p *Persister) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				p.Fsync()
+			case <-p.ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Close 等待队列中的命令全部写入后，刷盘并关闭AOF文件
+func (p *Persister) Close() {
+	if p.aofFile == nil {
+		return
+	}
+	// 队列满时SaveCmdLine持有读锁等待，后台协程会继续写入，因此这里可以获取写锁
+	p.closeMu.Lock()
+	if p.closed {
+		p.closeMu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.aofChan)
+	p.closeMu.Unlock()
+	<-p.aofFinished
+	p.cancel()
+	p.Fsync()
+	if err := p.aofFile.Close(); err != nil {
+		logger.Warn(err)
+	}
+}
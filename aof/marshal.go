@@ -0,0 +1,143 @@
+package aof
+
+import (
+	"strconv"
+	"time"
+	"zedis/datastruct/dict"
+	"zedis/datastruct/list"
+	"zedis/datastruct/set"
+	"zedis/datastruct/sortedset"
+	"zedis/datastruct/stream"
+	"zedis/interface/db"
+)
+
+// EntityToCmds 将DataEntity转换为能够重建它的命令，消息流需要多条命令
+func EntityToCmds(key string, entity *db.DataEntity) []CmdLine {
+	if entity == nil {
+		return nil
+	}
+	switch entity.Type {
+	case db.StringType:
+		return []CmdLine{stringToCmd(key, entity.Data.([]byte))}
+	case db.ListType:
+		return []CmdLine{listToCmd(key, entity.Data.(list.List))}
+	case db.HashType:
+		return []CmdLine{hashToCmd(key, entity.Data.(dict.Dict))}
+	case db.SetType:
+		return []CmdLine{setToCmd(key, entity.Data.(set.Set))}
+	case db.SortedType:
+		return []CmdLine{sortedSetToCmd(key, entity.Data.(*sortedset.SortedSet))}
+	case db.StreamType:
+		return streamToCmds(key, entity.Data.(*stream.Stream))
+	}
+	return nil
+}
+
+func stringToCmd(key string, bytes []byte) CmdLine {
+	return CmdLine{[]byte("SET"), []byte(key), bytes}
+}
+
+func listToCmd(key string, l list.List) CmdLine {
+	cmdLine := make(CmdLine, 2, 2+l.Length())
+	cmdLine[0] = []byte("RPUSH")
+	cmdLine[1] = []byte(key)
+	l.ForEach(func(index int, v []byte) bool {
+		cmdLine = append(cmdLine, v)
+		return true
+	})
+	return cmdLine
+}
+
+func hashToCmd(key string, hash dict.Dict) CmdLine {
+	cmdLine := make(CmdLine, 2, 2+hash.Len()*2)
+	cmdLine[0] = []byte("HSET")
+	cmdLine[1] = []byte(key)
+	hash.ForEach(func(field string, val any) bool {
+		cmdLine = append(cmdLine, []byte(field), val.([]byte))
+		return true
+	})
+	return cmdLine
+}
+
+func setToCmd(key string, s set.Set) CmdLine {
+	cmdLine := make(CmdLine, 2, 2+s.Len())
+	cmdLine[0] = []byte("SADD")
+	cmdLine[1] = []byte(key)
+	s.ForEach(func(member string) bool {
+		cmdLine = append(cmdLine, []byte(member))
+		return true
+	})
+	return cmdLine
+}
+
+func sortedSetToCmd(key string, zset *sortedset.SortedSet) CmdLine {
+	cmdLine := make(CmdLine, 2, 2+zset.Len()*2)
+	cmdLine[0] = []byte("ZADD")
+	cmdLine[1] = []byte(key)
+	zset.ForEachByRank(0, zset.Len(), false, func(element *sortedset.Element) bool {
+		score := strconv.FormatFloat(element.Score, 'g', -1, 64)
+		cmdLine = append(cmdLine, []byte(score), []byte(element.Member))
+		return true
+	})
+	return cmdLine
+}
+
+// streamToCmds 使用XADD重建消息，XSETID恢复最后的ID等元数据，XGROUP和XCLAIM重建消费者组和待确认消息
+func streamToCmds(key string, s *stream.Stream) []CmdLine {
+	keyBytes := []byte(key)
+	cmds := make([]CmdLine, 0, s.Len()+2)
+	if s.Len() == 0 {
+		// 添加一条消息后立即裁剪，得到一个空的消息流
+		cmds = append(cmds, CmdLine{
+			[]byte("XADD"), keyBytes, []byte("MAXLEN"), []byte("0"), []byte("0-1"), []byte("x"), []byte("y"),
+		})
+	}
+	s.ForEach(stream.MinID, stream.MaxID, func(entry *stream.Entry) bool {
+		cmdLine := make(CmdLine, 0, 3+len(entry.Fields))
+		cmdLine = append(cmdLine, []byte("XADD"), keyBytes, []byte(entry.ID.String()))
+		cmdLine = append(cmdLine, entry.Fields...)
+		cmds = append(cmds, cmdLine)
+		return true
+	})
+	cmds = append(cmds, CmdLine{
+		[]byte("XSETID"), keyBytes, []byte(s.LastID().String()),
+		[]byte("ENTRIESADDED"), []byte(strconv.FormatUint(s.EntriesAdded(), 10)),
+		[]byte("MAXDELETEDID"), []byte(s.MaxDeletedID().String()),
+	})
+	for _, g := range s.Groups() {
+		groupName := []byte(g.Name)
+		cmds = append(cmds, CmdLine{
+			[]byte("XGROUP"), []byte("CREATE"), keyBytes, groupName, []byte(g.LastID.String()),
+			[]byte("ENTRIESREAD"), []byte(strconv.FormatInt(g.EntriesRead, 10)),
+		})
+		for _, consumer := range g.Consumers() {
+			cmds = append(cmds, CmdLine{
+				[]byte("XGROUP"), []byte("CREATECONSUMER"), keyBytes, groupName, []byte(consumer.Name),
+			})
+		}
+		g.Pending(stream.MinID, stream.MaxID, func(pe *stream.PendingEntry) bool {
+			cmds = append(cmds, CmdLine{
+				[]byte("XCLAIM"), keyBytes, groupName, []byte(pe.Consumer.Name), []byte("0"), []byte(pe.ID.String()),
+				[]byte("TIME"), []byte(strconv.FormatInt(pe.DeliveryTime, 10)),
+				[]byte("RETRYCOUNT"), []byte(strconv.FormatInt(pe.DeliveryCount, 10)),
+				[]byte("FORCE"), []byte("JUSTID"),
+			})
+			return true
+		})
+	}
+	return cmds
+}
+
+// MakeExpireCmd 生成 PEXPIREAT key timestamp 命令，使用绝对时间，重放时不受加载时间影响
+func MakeExpireCmd(key string, expireAt time.Time) CmdLine {
+	return CmdLine{
+		[]byte("PEXPIREAT"),
+		[]byte(key),
+		[]byte(strconv.FormatInt(expireAt.UnixMilli(), 10)),
+	}
+}
+
+// MakeFunctionLoadCmd 生成 FUNCTION LOAD code 命令，重建函数库
+func MakeFunctionLoadCmd(code []byte) CmdLine {
+	return CmdLine{[]byte("FUNCTION"), []byte("LOAD"), code}
+}
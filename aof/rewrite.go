@@ -0,0 +1,234 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"zedis/config"
+	"zedis/interface/db"
+	"zedis/logger"
+	"zedis/redis/protocol"
+)
+
+// ErrRewriteInProgress 已有重写正在进行
+var ErrRewriteInProgress = errors.New("aof rewrite already in progress")
+
+// rewriteCtx 保存一次AOF重写过程中的状态
+type rewriteCtx struct {
+	tmpFile *os.File
+	// 开始重写时AOF文件的大小，重写只加载这部分内容，之后的命令由重写缓冲区补充
+	fileSize int64
+	// 临时文件中最后一条命令所在的数据库
+	dbIndex int
+}
+
+// IsRewriting 返回是否正在进行AOF重写
+func (p *Persister) IsRewriting() bool {
+	return p.rewriting.Load()
+}
+
+// Rewrite 重写AOF文件：将旧AOF文件加载到临时数据库，根据其中的数据生成最少的命令写入新文件，
+// 再将重写期间写入的命令追加到新文件，最后用新文件替换旧文件
+func (p *Persister) Rewrite() error {
+	if !p.rewriting.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+	defer p.rewriting.Store(false)
+
+	ctx, err := p.startRewrite()
+	if err != nil {
+		return err
+	}
+	if err = p.doRewrite(ctx); err != nil {
+		p.abortRewrite(ctx)
+		return err
+	}
+	return p.finishRewrite(ctx)
+}
+
+// startRewrite 记录当前AOF文件大小，创建临时文件，并开始缓冲新写入的命令
+func (p *Persister) startRewrite() (*rewriteCtx, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.aofFile.Sync(); err != nil {
+		return nil, err
+	}
+	info, err := p.aofFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// 临时文件与AOF文件放在同一目录下，保证rename是原子操作
+	tmpFile, err := os.CreateTemp(filepath.Dir(p.aofFilename), "temp-rewriteaof-*.aof")
+	if err != nil {
+		return nil, err
+	}
+	p.rewriteBuffer = make([]*payload, 0)
+	return &rewriteCtx{
+		tmpFile:  tmpFile,
+		fileSize: info.Size(),
+	}, nil
+}
+
+// doRewrite 加载旧AOF文件到临时数据库，遍历数据写入临时文件
+func (p *Persister) doRewrite(ctx *rewriteCtx) error {
+	tmpEngine := p.tmpDBMaker()
+	p.loadAofTo(tmpEngine, ctx.fileSize)
+
+	writer := bufio.NewWriter(ctx.tmpFile)
+	var err error
+	write := func(cmdLine CmdLine) bool {
+		_, err = writer.Write(protocol.NewMultiBulkReply(cmdLine).ToBytes())
+		return err == nil
+	}
+	// 函数库不属于任何数据库，写在所有key之前
+	for _, code := range tmpEngine.FunctionLibraries() {
+		if !write(MakeFunctionLoadCmd(code)) {
+			return err
+		}
+	}
+	// 加载AOF时默认使用0号数据库，切换到其他数据库中的key之前写入SELECT
+	ctx.dbIndex = 0
+	for i := 0; i < config.Config.Databases; i++ {
+		tmpEngine.ForEach(i, func(key string, entity *db.DataEntity, expiration *time.Time) bool {
+			cmdLines := EntityToCmds(key, entity)
+			if len(cmdLines) == 0 {
+				return true
+			}
+			if ctx.dbIndex != i {
+				if !write(CmdLine{[]byte("SELECT"), []byte(strconv.Itoa(i))}) {
+					return false
+				}
+				ctx.dbIndex = i
+			}
+			for _, cmdLine := range cmdLines {
+				if !write(cmdLine) {
+					return false
+				}
+			}
+			if expiration != nil {
+				return write(MakeExpireCmd(key, *expiration))
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// finishRewrite 将重写缓冲区中的命令追加到临时文件，并用临时文件替换旧AOF文件
+func (p *Persister) finishRewrite(ctx *rewriteCtx) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Persister已关闭，放弃本次重写
+	if p.ctx.Err() != nil {
+		p.cleanRewrite(ctx)
+		return errors.New("aof persister is closed")
+	}
+
+	writer := bufio.NewWriter(ctx.tmpFile)
+	for _, pl := range p.rewriteBuffer {
+		if pl.dbIndex != ctx.dbIndex {
+			selectCmd := CmdLine{[]byte("SELECT"), []byte(strconv.Itoa(pl.dbIndex))}
+			if _, err := writer.Write(protocol.NewMultiBulkReply(selectCmd).ToBytes()); err != nil {
+				p.cleanRewrite(ctx)
+				return err
+			}
+			ctx.dbIndex = pl.dbIndex
+		}
+		if _, err := writer.Write(protocol.NewMultiBulkReply(pl.cmdLine).ToBytes()); err != nil {
+			p.cleanRewrite(ctx)
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		p.cleanRewrite(ctx)
+		return err
+	}
+	if err := ctx.tmpFile.Sync(); err != nil {
+		p.cleanRewrite(ctx)
+		return err
+	}
+	_ = ctx.tmpFile.Close()
+	p.rewriteBuffer = nil
+
+	if err := os.Rename(ctx.tmpFile.Name(), p.aofFilename); err != nil {
+		_ = os.Remove(ctx.tmpFile.Name())
+		return err
+	}
+
+	// 重新打开新的AOF文件，后续命令追加到新文件
+	aofFile, err := os.OpenFile(p.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	_ = p.aofFile.Close()
+	p.aofFile = aofFile
+	p.currentDB = ctx.dbIndex
+	if info, err := aofFile.Stat(); err == nil {
+		p.aofBaseSize = info.Size()
+	}
+	logger.Infof("rewrite aof file %s finished", p.aofFilename)
+	return nil
+}
+
+// abortRewrite 放弃本次重写，删除临时文件
+func (p *Persister) abortRewrite(ctx *rewriteCtx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanRewrite(ctx)
+}
+
+// cleanRewrite 停止缓冲并删除临时文件，调用时需持有锁
+func (p *Persister) cleanRewrite(ctx *rewriteCtx) {
+	p.rewriteBuffer = nil
+	_ = ctx.tmpFile.Close()
+	_ = os.Remove(ctx.tmpFile.Name())
+}
+
+// needRewrite AOF文件大小超过最小值，且相比上次重写后的增长比例超过阈值时，需要重写
+func (p *Persister) needRewrite() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	info, err := p.aofFile.Stat()
+	if err != nil {
+		return false
+	}
+	size := info.Size()
+	if size < config.Config.AutoAofRewriteMinSize {
+		return false
+	}
+	base := p.aofBaseSize
+	if base <= 0 {
+		base = 1
+	}
+	growth := (size - base) * 100 / base
+	return growth >= int64(config.Config.AutoAofRewritePercentage)
+}
+
+// autoRewrite 每秒检查一次AOF文件大小，满足条件时自动重写
+func (p *Persister) autoRewrite() {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if p.IsRewriting() || !p.needRewrite() {
+					continue
+				}
+				if err := p.Rewrite(); err != nil {
+					logger.Errorf("auto rewrite aof failed: %v", err)
+				}
+			case <-p.ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
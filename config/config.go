@@ -21,6 +21,40 @@ type ServerConfig struct {
 	Databases    int    `yaml:"Databases"`   // 数据库数量
 	ReplTimeout  int    `yaml:"ReplTimeout"` // 服务端响应超时
 
+	ClientOutputBufferLimit int `yaml:"ClientOutputBufferLimit"` // 每个客户端发送队列的最大字节数，推送消息使队列超过该值时断开连接，为0时不限制
+
+	ProtoInlineMaxSize int `yaml:"ProtoInlineMaxSize"` // 内联命令一行的最大字节数，超过时返回错误并关闭连接
+
+	NotifyKeyspaceEvents string `yaml:"NotifyKeyspaceEvents"` // 开启的键空间通知类型，如"KEA"，为空时不发布通知
+
+	ReplicaOf       string `yaml:"ReplicaOf"`       // 启动时作为从节点连接的主节点地址，格式为"host port"，为空时作为主节点启动
+	MasterAuth      string `yaml:"MasterAuth"`      // 连接主节点时使用的密码
+	ReplBacklogSize int    `yaml:"ReplBacklogSize"` // 复制积压缓冲区的字节数，从节点断线重连后可以从中部分重同步
+
+	ClusterEnabled     bool   `yaml:"ClusterEnabled"`     // 是否开启集群模式
+	ClusterConfigFile  string `yaml:"ClusterConfigFile"`  // 集群配置文件名，保存集群节点和槽的分配信息，由节点自动维护
+	ClusterNodeTimeout int    `yaml:"ClusterNodeTimeout"` // 超过该毫秒数没有收到节点的消息时，认为节点可能下线
+
+	SentinelMonitor               []string `yaml:"SentinelMonitor"`               // 哨兵模式下监控的主节点，每项的格式为"name host port quorum"
+	SentinelDownAfterMilliseconds int      `yaml:"SentinelDownAfterMilliseconds"` // 超过该毫秒数没有收到有效的PING回复时，认为节点主观下线
+	SentinelFailoverTimeout       int      `yaml:"SentinelFailoverTimeout"`       // 故障转移的超时毫秒数，同一个主节点两次故障转移至少间隔该值的两倍
+
+	DBFilename string `yaml:"DBFilename"` // RDB文件名
+
+	AppendOnly     bool   `yaml:"AppendOnly"`     // 是否开启AOF持久化
+	AppendFilename string `yaml:"AppendFilename"` // AOF文件名
+	AppendFsync    string `yaml:"AppendFsync"`    // AOF刷盘策略：always、everysec、no
+
+	AutoAofRewritePercentage int   `yaml:"AutoAofRewritePercentage"` // AOF文件相比上次重写后增长的百分比超过该值时自动重写，为0时不自动重写
+	AutoAofRewriteMinSize    int64 `yaml:"AutoAofRewriteMinSize"`    // 自动重写时AOF文件的最小字节数
+
+	ListMaxZiplistEntries int `yaml:"ListMaxZiplistEntries"` // 列表元素数量不超过该值时使用ziplist编码
+	ListMaxZiplistValue   int `yaml:"ListMaxZiplistValue"`   // 列表每个元素的字节数都不超过该值时使用ziplist编码
+	ListMaxZiplistSize    int `yaml:"ListMaxZiplistSize"`    // quicklist每个节点的大小，正数表示元素个数，-1 ~ -5表示字节数上限为4KB ~ 64KB
+	ListCompressDepth     int `yaml:"ListCompressDepth"`     // quicklist两端不压缩的节点数量，为0时不压缩
+
+	HllSparseMaxBytes int `yaml:"HllSparseMaxBytes"` // HyperLogLog稀疏编码的最大字节数，超过时转换为稠密编码
+
 	ConfigFilePath string `yaml:"configFilePath omitempty"` // 配置文件路径
 }
 
@@ -40,12 +74,47 @@ func init() {
 		StartUpTime: time.Now(),
 	}
 
-	Config = &ServerConfig{
-		RunId: GenRandomRunID(40),
-		Bind:  "127.0.0.1",
-		Port:  6379,
-	}
+	Config = defaultConfig()
+}
+
+// defaultConfig 返回所有配置项都为默认值的配置，没有配置文件或配置文件中没有设置的配置项使用这些值
+func defaultConfig() *ServerConfig {
+	return &ServerConfig{
+		RunId:      GenRandomRunID(40),
+		Bind:       "127.0.0.1",
+		Port:       6379,
+		Dir:        ".",
+		MaxClients: 100,
+		Databases:  16,
+
+		ClientOutputBufferLimit: 32 * 1024 * 1024,
+
+		ReplTimeout: 60,
+
+		ProtoInlineMaxSize: 64 * 1024,
+
+		ReplBacklogSize: 1024 * 1024,
 
+		ClusterConfigFile:  "nodes.conf",
+		ClusterNodeTimeout: 15000,
+
+		SentinelDownAfterMilliseconds: 30000,
+		SentinelFailoverTimeout:       180000,
+
+		DBFilename: "dump.rdb",
+
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "everysec",
+
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 * 1024 * 1024,
+
+		ListMaxZiplistEntries: 128,
+		ListMaxZiplistValue:   64,
+		ListMaxZiplistSize:    -2,
+
+		HllSparseMaxBytes: 3000,
+	}
 }
 
 var numberAndLetters = []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -64,7 +133,7 @@ func parseConfigFile(confFilePath string) (*ServerConfig, error) {
 		panic(err)
 	}
 	defer reader.Close()
-	config := &ServerConfig{}
+	config := defaultConfig()
 	fileBytes, err := io.ReadAll(reader)
 	if err != nil {
 		panic(err)
@@ -92,6 +161,36 @@ func SetupConfig(configFilePath string) {
 	if Config.Dir == "" {
 		Config.Dir = "."
 	}
+	if Config.Databases <= 0 {
+		Config.Databases = 16
+	}
+	if Config.DBFilename == "" {
+		Config.DBFilename = "dump.rdb"
+	}
+	if Config.AppendFilename == "" {
+		Config.AppendFilename = "appendonly.aof"
+	}
+	if Config.AppendFsync == "" {
+		Config.AppendFsync = "everysec"
+	}
+	if Config.ReplTimeout <= 0 {
+		Config.ReplTimeout = 60
+	}
+	if Config.ReplBacklogSize <= 0 {
+		Config.ReplBacklogSize = 1024 * 1024
+	}
+	if Config.ClusterConfigFile == "" {
+		Config.ClusterConfigFile = "nodes.conf"
+	}
+	if Config.ClusterNodeTimeout <= 0 {
+		Config.ClusterNodeTimeout = 15000
+	}
+	if Config.SentinelDownAfterMilliseconds <= 0 {
+		Config.SentinelDownAfterMilliseconds = 30000
+	}
+	if Config.SentinelFailoverTimeout <= 0 {
+		Config.SentinelFailoverTimeout = 180000
+	}
 }
 
 func GetTempDir() string {
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"zedis/aof"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+// CmdLine 命令行，例如 [set key value]
+type CmdLine = aof.CmdLine
+
+// toCmdLine 将命令名和参数拼接为命令行
+func toCmdLine(cmdName string, args [][]byte) CmdLine {
+	line := make(CmdLine, 0, len(args)+1)
+	line = append(line, []byte(cmdName))
+	line = append(line, args...)
+	return line
+}
+
+// appendAof 将执行成功的写命令追加到AOF
+func (d *DB) appendAof(cmd *command, args [][]byte, reply redis.Reply) {
+	if cmd.toAof == nil {
+		d.addAof(toCmdLine(cmd.name, args))
+		return
+	}
+	for _, line := range cmd.toAof(d, args, reply) {
+		d.addAof(line)
+	}
+}
+
+// getExpireTime 返回key的过期时间，没有设置过期时间时第二个返回值为false
+func (d *DB) getExpireTime(key string) (time.Time, bool) {
+	raw, exists := d.ttlMap.GetWithLock(key)
+	if !exists {
+		return time.Time{}, false
+	}
+	return raw.(time.Time), true
+}
+
+// expireToAof EXPIRE、PEXPIRE、EXPIREAT、PEXPIREAT命令统一转换为 PEXPIREAT
+func expireToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	key := string(args[0])
+	expireAt, exists := d.getExpireTime(key)
+	if !exists {
+		return nil
+	}
+	return []CmdLine{aof.MakeExpireCmd(key, expireAt)}
+}
+
+// setToAof SET命令去掉过期时间相关参数，过期时间单独使用 PEXPIREAT 记录
+func setToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	if reply == protocol.NullBulkReply {
+		return nil
+	}
+	key := string(args[0])
+	line := CmdLine{[]byte("set"), args[0], args[1]}
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		if arg == "EX" || arg == "PX" || arg == "EXAT" || arg == "PXAT" {
+			i++
+			continue
+		}
+		line = append(line, args[i])
+	}
+	lines := []CmdLine{line}
+	if expireAt, exists := d.getExpireTime(key); exists {
+		lines = append(lines, aof.MakeExpireCmd(key, expireAt))
+	}
+	return lines
+}
+
+// getExToAof GETEX命令只需要记录过期时间的变化
+func getExToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	if len(args) == 1 || reply == protocol.NullBulkReply {
+		return nil
+	}
+	key := string(args[0])
+	if expireAt, exists := d.getExpireTime(key); exists {
+		return []CmdLine{aof.MakeExpireCmd(key, expireAt)}
+	}
+	return []CmdLine{{[]byte("persist"), args[0]}}
+}
+
+// sPopToAof SPOP随机弹出元素，转换为删除被弹出元素的 SREM 命令
+func sPopToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	r, ok := reply.(*protocol.MultiBulkReply)
+	if !ok || len(r.Texts) == 0 {
+		return nil
+	}
+	line := CmdLine{[]byte("srem"), args[0]}
+	line = append(line, r.Texts...)
+	return []CmdLine{line}
+}
+
+// bPopToAof BLPOP、BRPOP只会从其中一个key弹出元素，转换为对该key的 LPOP、RPOP 命令
+func bPopToAof(popCmd string) AofFunc {
+	return func(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+		r, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(r.Texts) != 2 {
+			return nil
+		}
+		return []CmdLine{{[]byte(popCmd), r.Texts[0]}}
+	}
+}
+
+// blMoveToAof BLMOVE转换为 LMOVE 命令
+func blMoveToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	if reply == protocol.NullBulkReply {
+		return nil
+	}
+	return []CmdLine{toCmdLine("lmove", args[:4])}
+}
+
+// blmPopToAof BLMPOP转换为对实际弹出元素的key执行 LPOP、RPOP key count 命令
+func blmPopToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	r, ok := reply.(*protocol.ArrayReply)
+	if !ok || len(r.Replies) != 2 {
+		return nil
+	}
+	keyReply, ok := r.Replies[0].(*protocol.BulkReply)
+	if !ok {
+		return nil
+	}
+	values, ok := r.Replies[1].(*protocol.MultiBulkReply)
+	if !ok {
+		return nil
+	}
+	numKeys, _ := parseInt(args[1])
+	popCmd := "lpop"
+	if strings.ToLower(string(args[2+numKeys])) == "right" {
+		popCmd = "rpop"
+	}
+	return []CmdLine{{
+		[]byte(popCmd),
+		keyReply.Text,
+		[]byte(strconv.Itoa(len(values.Texts))),
+	}}
+}
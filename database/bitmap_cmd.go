@@ -2,6 +2,7 @@ package database
 
 import (
 	"github.com/duke-git/lancet/v2/mathutil"
+	"math"
 	"math/bits"
 	"strings"
 	"zedis/datastruct/bitmap"
@@ -64,6 +65,7 @@ func SetBitCommand(d *DB, args [][]byte) redis.Reply {
 		res = 1
 	}
 	d.PutEntity(key, buildBitMapEntity(*bm))
+	d.notify(notifyString, "setbit", key)
 	return protocol.NewIntReply(res)
 }
 
@@ -263,6 +265,7 @@ func BitOpCommand(d *DB, args [][]byte) redis.Reply {
 			res[i] = ^b
 		}
 		d.PutEntity(destKey, BuildStringEntity(res))
+		d.notify(notifyString, "set", destKey)
 		return protocol.NewIntReply(int64(len(res)))
 	}
 
@@ -279,9 +282,331 @@ func BitOpCommand(d *DB, args [][]byte) redis.Reply {
 		return protocol.ZeroReply
 	}
 	d.PutEntity(destKey, buildBitMapEntity(res))
+	d.notify(notifyString, "set", destKey)
 	return protocol.NewIntReply(int64(len(res)))
 }
 
+/* ---- BITFIELD ---- */
+
+const (
+	bitFieldGet = iota
+	bitFieldSet
+	bitFieldIncrBy
+)
+
+// 溢出处理方式
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitFieldOp BITFIELD中的一个子命令
+type bitFieldOp struct {
+	opcode   int
+	offset   int64
+	bits     int
+	signed   bool
+	value    int64
+	overflow int
+}
+
+// parseBitFieldType 解析字段类型，有符号整数为i1~i64，无符号整数为u1~u63
+func parseBitFieldType(arg []byte) (bool, int, redis.Reply) {
+	errReply := protocol.NewErrorReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(arg) < 2 {
+		return false, 0, errReply
+	}
+	var signed bool
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+		signed = false
+	default:
+		return false, 0, errReply
+	}
+	bitNum, err := parseInt(arg[1:])
+	if err != nil || bitNum < 1 || (signed && bitNum > 64) || (!signed && bitNum > 63) {
+		return false, 0, errReply
+	}
+	return signed, bitNum, nil
+}
+
+// parseBitFieldOffset 解析字段的偏移量，以#开头时偏移量为字段宽度的倍数
+func parseBitFieldOffset(arg []byte, bitNum int) (int64, redis.Reply) {
+	errReply := protocol.NewErrorReply("ERR bit offset is not an integer or out of range")
+	multiply := len(arg) > 0 && arg[0] == '#'
+	if multiply {
+		arg = arg[1:]
+	}
+	offset, err := parseInt64(arg)
+	if !checkOffset(offset, err) {
+		return 0, errReply
+	}
+	if multiply {
+		offset *= int64(bitNum)
+		if !checkOffset(offset, nil) {
+			return 0, errReply
+		}
+	}
+	return offset, nil
+}
+
+// parseBitFieldOps 解析BITFIELD的子命令，args从第一个子命令开始
+// OVERFLOW只影响之后的SET和INCRBY，默认为WRAP
+func parseBitFieldOps(args [][]byte) ([]*bitFieldOp, redis.Reply) {
+	ops := make([]*bitFieldOp, 0)
+	overflow := overflowWrap
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		op := &bitFieldOp{overflow: overflow}
+		switch subCmd := strings.ToUpper(string(args[i])); {
+		case subCmd == "GET" && remaining >= 2:
+			op.opcode = bitFieldGet
+		case subCmd == "SET" && remaining >= 3:
+			op.opcode = bitFieldSet
+		case subCmd == "INCRBY" && remaining >= 3:
+			op.opcode = bitFieldIncrBy
+		case subCmd == "OVERFLOW" && remaining >= 1:
+			switch strings.ToUpper(string(args[i+1])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, protocol.NewErrorReply("ERR Invalid OVERFLOW type specified")
+			}
+			i++
+			continue
+		default:
+			return nil, protocol.ErrorSyntaxReply
+		}
+
+		var errReply redis.Reply
+		op.signed, op.bits, errReply = parseBitFieldType(args[i+1])
+		if errReply != nil {
+			return nil, errReply
+		}
+		op.offset, errReply = parseBitFieldOffset(args[i+2], op.bits)
+		if errReply != nil {
+			return nil, errReply
+		}
+		if op.opcode == bitFieldGet {
+			i += 2
+		} else {
+			value, err := parseInt64(args[i+3])
+			if err != nil {
+				return nil, protocol.ErrorNotIntegerReply
+			}
+			op.value = value
+			i += 3
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// checkUnsignedOverflow 检查value加上incr后是否超出bitNum位无符号整数的范围
+// 溢出时返回true，以及WRAP、SAT方式下应当写入的值
+func checkUnsignedOverflow(value uint64, incr int64, bitNum int, overflow int) (bool, uint64) {
+	max := uint64(1)<<bitNum - 1
+	maxIncr := int64(max - value)
+	minIncr := -int64(value)
+	if value > max || (incr > 0 && incr > maxIncr) {
+		if overflow == overflowSat {
+			return true, max
+		}
+	} else if incr < 0 && incr < minIncr {
+		if overflow == overflowSat {
+			return true, 0
+		}
+	} else {
+		return false, 0
+	}
+	// WRAP时只保留低bitNum位，FAIL时不使用返回值
+	return true, (value + uint64(incr)) & max
+}
+
+// checkSignedOverflow 检查value加上incr后是否超出bitNum位有符号整数的范围
+// 溢出时返回true，以及WRAP、SAT方式下应当写入的值
+func checkSignedOverflow(value int64, incr int64, bitNum int, overflow int) (bool, int64) {
+	max := int64(math.MaxInt64)
+	if bitNum < 64 {
+		max = int64(1)<<(bitNum-1) - 1
+	}
+	min := -max - 1
+	// maxIncr和minIncr可能溢出，但只在value位于范围内时使用，此时不会溢出
+	maxIncr := int64(uint64(max) - uint64(value))
+	minIncr := min - value
+	if value > max || (bitNum != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+		if overflow == overflowSat {
+			return true, max
+		}
+	} else if value < min || (bitNum != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+		if overflow == overflowSat {
+			return true, min
+		}
+	} else {
+		return false, 0
+	}
+	// WRAP时按无符号数相加，再根据符号位扩展高位
+	c := uint64(value) + uint64(incr)
+	if bitNum < 64 {
+		mask := ^uint64(0) << bitNum
+		if c&(uint64(1)<<(bitNum-1)) != 0 {
+			c |= mask
+		} else {
+			c &^= mask
+		}
+	}
+	return true, int64(c)
+}
+
+// getSignedBits 读取有符号整数字段，根据符号位扩展高位
+func getSignedBits(bm *bitmap.BitMap, offset int64, bitNum int) int64 {
+	value := bm.GetBits(offset, bitNum)
+	if bitNum < 64 && value&(uint64(1)<<(bitNum-1)) != 0 {
+		value |= ^uint64(0) << bitNum
+	}
+	return int64(value)
+}
+
+// execBitFieldOp 执行一个子命令，返回子命令的响应以及字段的值是否改变
+// SET返回原值，INCRBY返回新值，OVERFLOW FAIL时发生溢出不修改字段，返回nil
+func execBitFieldOp(bm *bitmap.BitMap, op *bitFieldOp) (redis.Reply, bool) {
+	if op.signed {
+		oldValue := getSignedBits(bm, op.offset, op.bits)
+		if op.opcode == bitFieldGet {
+			return protocol.NewIntReply(oldValue), false
+		}
+		var newValue, ret int64
+		var overflowed bool
+		var limit int64
+		if op.opcode == bitFieldIncrBy {
+			overflowed, limit = checkSignedOverflow(oldValue, op.value, op.bits, op.overflow)
+			newValue = oldValue + op.value
+			if overflowed {
+				newValue = limit
+			}
+			ret = newValue
+		} else {
+			overflowed, limit = checkSignedOverflow(op.value, 0, op.bits, op.overflow)
+			newValue = op.value
+			if overflowed {
+				newValue = limit
+			}
+			ret = oldValue
+		}
+		if overflowed && op.overflow == overflowFail {
+			return protocol.NullBulkReply, false
+		}
+		bm.SetBits(op.offset, op.bits, uint64(newValue))
+		return protocol.NewIntReply(ret), oldValue != newValue
+	}
+
+	oldValue := bm.GetBits(op.offset, op.bits)
+	if op.opcode == bitFieldGet {
+		return protocol.NewIntReply(int64(oldValue)), false
+	}
+	var newValue, ret, limit uint64
+	var overflowed bool
+	if op.opcode == bitFieldIncrBy {
+		overflowed, limit = checkUnsignedOverflow(oldValue, op.value, op.bits, op.overflow)
+		newValue = oldValue + uint64(op.value)
+		if overflowed {
+			newValue = limit
+		}
+		ret = newValue
+	} else {
+		overflowed, limit = checkUnsignedOverflow(uint64(op.value), 0, op.bits, op.overflow)
+		newValue = uint64(op.value)
+		if overflowed {
+			newValue = limit
+		}
+		ret = oldValue
+	}
+	if overflowed && op.overflow == overflowFail {
+		return protocol.NullBulkReply, false
+	}
+	bm.SetBits(op.offset, op.bits, newValue)
+	return protocol.NewIntReply(int64(ret)), oldValue != newValue
+}
+
+// BitFieldCommand 将字符串看作由任意宽度的整数字段组成，依次执行多个子命令，返回每个子命令的结果
+// BITFIELD key [GET encoding offset | [OVERFLOW <WRAP | SAT | FAIL>] <SET encoding offset value | INCRBY encoding offset increment> [GET encoding offset | [OVERFLOW <WRAP | SAT | FAIL>] <SET encoding offset value | INCRBY encoding offset increment> ...]]
+// encoding为i1~i64或u1~u63，offset以#开头时表示第几个字段
+// 包含SET或INCRBY时，即使全部因为溢出失败，字符串也会扩展到能够容纳所有写入的字段
+func BitFieldCommand(d *DB, args [][]byte) redis.Reply {
+	return bitField(d, args, false)
+}
+
+// BitFieldROCommand BITFIELD的只读版本，只支持GET子命令
+// BITFIELD_RO key [GET encoding offset [GET encoding offset ...]]
+func BitFieldROCommand(d *DB, args [][]byte) redis.Reply {
+	return bitField(d, args, true)
+}
+
+func bitField(d *DB, args [][]byte, readOnly bool) redis.Reply {
+	key := string(args[0])
+	ops, errReply := parseBitFieldOps(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	highestBit := int64(-1)
+	for _, op := range ops {
+		if op.opcode != bitFieldGet && op.offset+int64(op.bits)-1 > highestBit {
+			highestBit = op.offset + int64(op.bits) - 1
+		}
+	}
+	if readOnly && highestBit >= 0 {
+		return protocol.NewErrorReply("ERR BITFIELD_RO only supports the GET subcommand")
+	}
+
+	data, errReply := d.getEntityAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	// 复制一份再修改，避免影响已经返回给其他客户端的字符串
+	bm := bitmap.NewBitMap(append([]byte(nil), data...))
+	grown := false
+	if highestBit >= 0 && (data == nil || bm.BitSize() <= highestBit) {
+		// 写入最高位以扩展长度
+		bm.SetBits(highestBit, 1, 0)
+		grown = true
+	}
+
+	res := make([]redis.Reply, 0, len(ops))
+	changes := 0
+	for _, op := range ops {
+		reply, changed := execBitFieldOp(bm, op)
+		if changed {
+			changes++
+		}
+		res = append(res, reply)
+	}
+	if grown || changes > 0 {
+		d.PutEntity(key, buildBitMapEntity(*bm))
+	}
+	if changes > 0 {
+		d.notify(notifyString, "setbit", key)
+	}
+	return protocol.NewArrayReply(res)
+}
+
+// bitFieldToAof 只包含GET子命令时不需要写入AOF
+func bitFieldToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	ops, _ := parseBitFieldOps(args[1:])
+	for _, op := range ops {
+		if op.opcode != bitFieldGet {
+			return []CmdLine{toCmdLine("bitfield", args)}
+		}
+	}
+	return nil
+}
+
 func init() {
 	registerNormalCommand("setbit", SetBitCommand, writeFirstKey, 4, tagWrite)
 	registerNormalCommand("getbit", GetBitCommand, readFirstKey, 3, tagRead)
@@ -289,7 +614,8 @@ func init() {
 	registerNormalCommand("bitcount", BitCountCommand, readFirstKey, -2, tagRead)
 	registerNormalCommand("bitpos", BitPosCommand, readFirstKey, -3, tagRead)
 	registerNormalCommand("bitop", BitOpCommand, prepareBitOp, -4, tagWrite)
-	// BITFIELD、BITFIELD_RO有点复杂，后面再实现
+	registerNormalCommand("bitfield", BitFieldCommand, writeFirstKey, -2, tagWrite).attachAof(bitFieldToAof)
+	registerNormalCommand("bitfield_ro", BitFieldROCommand, readFirstKey, -2, tagRead)
 }
 
 func checkOffset(index int64, err error) bool {
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"container/list"
+	"sync"
+	"time"
+	"zedis/interface/db"
+	"zedis/interface/redis"
+)
+
+// blockReply 阻塞命令没有可以弹出的元素时返回，由DB挂起客户端，直到keys被写入、超时或连接断开
+type blockReply struct {
+	keys []string
+	// 为0表示永久阻塞
+	timeout time.Duration
+	// 超时或连接断开时返回给客户端的响应
+	timeoutReply redis.Reply
+	// 被唤醒后重新执行命令使用的参数，为空时使用原参数
+	// 用于XREAD等需要将 $ 替换为阻塞时最后一条消息ID的命令
+	args [][]byte
+}
+
+func (r *blockReply) ToBytes() []byte {
+	return r.timeoutReply.ToBytes()
+}
+
+func newBlockReply(keys []string, timeout time.Duration, timeoutReply redis.Reply) *blockReply {
+	return &blockReply{
+		keys:         keys,
+		timeout:      timeout,
+		timeoutReply: timeoutReply,
+	}
+}
+
+// waiter 表示一个被阻塞命令挂起的客户端
+type waiter struct {
+	keys []string
+	// 被唤醒时写入，缓冲为1，唤醒方不会被阻塞
+	ready chan struct{}
+	// key -> 在该key等待队列中的位置，用于从队列中移除
+	elements map[string]*list.Element
+}
+
+// blockingKeys 保存每个key上被阻塞的客户端，按阻塞的先后顺序排队，先阻塞的客户端先被唤醒
+type blockingKeys struct {
+	mu sync.Mutex
+	// key -> 等待队列，元素为*waiter
+	waiters map[string]*list.List
+}
+
+func newBlockingKeys() *blockingKeys {
+	return &blockingKeys{
+		waiters: make(map[string]*list.List),
+	}
+}
+
+// add 将waiter加入所有key的等待队列
+// front为true时加到队首，用于被唤醒后没有抢到元素的客户端，保持原来的排队顺序
+func (b *blockingKeys) add(w *waiter, front bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w.elements = make(map[string]*list.Element, len(w.keys))
+	for _, key := range w.keys {
+		if _, ok := w.elements[key]; ok {
+			continue
+		}
+		queue, ok := b.waiters[key]
+		if !ok {
+			queue = list.New()
+			b.waiters[key] = queue
+		}
+		if front {
+			w.elements[key] = queue.PushFront(w)
+		} else {
+			w.elements[key] = queue.PushBack(w)
+		}
+	}
+}
+
+// remove 将waiter从所有key的等待队列中移除，waiter已经被唤醒时返回false
+func (b *blockingKeys) remove(w *waiter) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.removeLocked(w)
+}
+
+func (b *blockingKeys) removeLocked(w *waiter) bool {
+	if w.elements == nil {
+		return false
+	}
+	for key, element := range w.elements {
+		queue := b.waiters[key]
+		queue.Remove(element)
+		if queue.Len() == 0 {
+			delete(b.waiters, key)
+		}
+	}
+	w.elements = nil
+	return true
+}
+
+// wakeUp 唤醒在key上等待最久的客户端，并将其从所有key的等待队列中移除
+func (b *blockingKeys) wakeUp(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, ok := b.waiters[key]
+	if !ok {
+		return
+	}
+	w := queue.Front().Value.(*waiter)
+	b.removeLocked(w)
+	w.ready <- struct{}{}
+}
+
+// wakeUpAll 唤醒在key上等待的所有客户端，用于消息流，新消息可以被所有客户端读取
+func (b *blockingKeys) wakeUpAll(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, ok := b.waiters[key]
+	if !ok {
+		return
+	}
+	for queue.Len() > 0 {
+		w := queue.Front().Value.(*waiter)
+		b.removeLocked(w)
+		w.ready <- struct{}{}
+	}
+}
+
+func (b *blockingKeys) hasWaiters(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.waiters[key]
+	return ok
+}
+
+// signalKeys 有元素可以弹出时，唤醒在keys上等待的客户端，调用方需要持有keys的锁
+// 列表每次只唤醒一个，被唤醒的客户端执行完后如果还有元素，会继续唤醒下一个
+// 消息流唤醒所有客户端，由客户端重新执行命令判断是否有可以读取的消息
+func (d *DB) signalKeys(keys []string) {
+	for _, key := range keys {
+		if !d.blocking.hasWaiters(key) {
+			continue
+		}
+		entity, exists := d.GetEntity(key)
+		if !exists {
+			continue
+		}
+		switch entity.Type {
+		case db.ListType:
+			l, _ := d.getEntityAsList(key)
+			if l.Length() > 0 {
+				d.blocking.wakeUp(key)
+			}
+		case db.StreamType:
+			d.blocking.wakeUpAll(key)
+		}
+	}
+}
+
+// blockUntilReady 挂起客户端，直到keys被写入后重新执行命令成功，或者超时、连接断开
+// 等待期间释放命令持有的锁，返回前重新加锁
+func (d *DB) blockUntilReady(c redis.Connection, cmd *command, cmdArgs [][]byte, block *blockReply,
+	writeKeys, readKeys []string) redis.Reply {
+	// 事务中的阻塞命令不阻塞，直接返回超时的响应，与Redis一致
+	if c.InMultiState() {
+		return block.timeoutReply
+	}
+	var deadline <-chan time.Time
+	if block.timeout > 0 {
+		timer := time.NewTimer(block.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	front := false
+	args := cmdArgs
+	for {
+		// 持有锁时加入等待队列，其他客户端在释放锁之前无法写入keys，不会错过唤醒
+		w := &waiter{keys: block.keys, ready: make(chan struct{}, 1)}
+		d.blocking.add(w, front)
+		d.RWUnLocks(writeKeys, readKeys)
+		// 阻塞期间不能妨碍全量同步生成快照
+		if cmd.tags&tagWrite > 0 {
+			d.snapshotMu.RUnlock()
+		}
+		woken := true
+		select {
+		case <-w.ready:
+		case <-deadline:
+			woken = false
+		case <-c.Done():
+			woken = false
+		}
+		if cmd.tags&tagWrite > 0 {
+			d.snapshotMu.RLock()
+		}
+		d.RWLocks(writeKeys, readKeys)
+
+		if !woken {
+			// 超时的同时被唤醒，需要唤醒下一个客户端，避免元素无人处理
+			if !d.blocking.remove(w) {
+				d.signalKeys(block.keys)
+			}
+			return block.timeoutReply
+		}
+		if block.args != nil {
+			args = block.args
+		}
+		if cmd.tags&tagWrite > 0 {
+			d.removeExpired(writeKeys)
+		}
+		reply := cmd.executor(d, args)
+		next, ok := reply.(*blockReply)
+		if !ok {
+			return reply
+		}
+		// 元素被其他客户端抢先弹出，重新排到队首等待
+		block = next
+		front = true
+	}
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+// Client CLIENT命令，查看和设置连接的属性，开启客户端缓存
+// CLIENT ID | GETNAME | SETNAME name | GETREDIR | TRACKINGINFO | CACHING YES|NO
+// CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func Client(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("client")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "id":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("client|id")
+		}
+		return protocol.NewIntReply(c.ID())
+	case "getname":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("client|getname")
+		}
+		if c.GetClientName() == "" {
+			return protocol.NullBulkReply
+		}
+		return protocol.NewBulkReply([]byte(c.GetClientName()))
+	case "setname":
+		if len(args) != 2 {
+			return protocol.NewArgNumErrReply("client|setname")
+		}
+		if bytes.ContainsAny(args[1], " \r\n") {
+			return protocol.NewErrorReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.SetClientName(string(args[1]))
+		return protocol.OKReply
+	case "tracking":
+		return clientTracking(engine, c, args[1:])
+	case "caching":
+		return clientCaching(engine, c, args[1:])
+	case "getredir":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("client|getredir")
+		}
+		tc := engine.tracking.get(c)
+		if tc == nil {
+			return protocol.NewIntReply(-1)
+		}
+		return protocol.NewIntReply(tc.redirect)
+	case "trackinginfo":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("client|trackinginfo")
+		}
+		return trackingInfo(engine, c)
+	}
+	return protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", string(args[0])))
+}
+
+// clientTracking CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTracking(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("client|tracking")
+	}
+	opts := &trackingClient{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "redirect":
+			if i+1 >= len(args) {
+				return protocol.ErrorSyntaxReply
+			}
+			i++
+			id, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			opts.redirect = id
+		case "prefix":
+			if i+1 >= len(args) {
+				return protocol.ErrorSyntaxReply
+			}
+			i++
+			opts.prefixes = append(opts.prefixes, string(args[i]))
+		case "bcast":
+			opts.bcast = true
+		case "optin":
+			opts.optIn = true
+		case "optout":
+			opts.optOut = true
+		case "noloop":
+			opts.noLoop = true
+		default:
+			return protocol.ErrorSyntaxReply
+		}
+	}
+
+	switch strings.ToLower(string(args[0])) {
+	case "on":
+		if len(opts.prefixes) > 0 && !opts.bcast {
+			return protocol.NewErrorReply("ERR PREFIX option requires BCAST mode to be enabled")
+		}
+		if opts.bcast && (opts.optIn || opts.optOut) {
+			return protocol.NewErrorReply("ERR OPTIN and OPTOUT are not compatible with BCAST")
+		}
+		if opts.optIn && opts.optOut {
+			return protocol.NewErrorReply("ERR You can't use both OPTIN and OPTOUT")
+		}
+		return engine.tracking.enable(c, opts)
+	case "off":
+		engine.tracking.disable(c)
+		return protocol.OKReply
+	}
+	return protocol.ErrorSyntaxReply
+}
+
+// clientCaching CLIENT CACHING YES|NO，OPTIN模式下YES表示记录下一条命令读取的key，OPTOUT模式下NO表示不记录
+func clientCaching(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.NewArgNumErrReply("client|caching")
+	}
+	tc := engine.tracking.get(c)
+	if tc == nil || (!tc.optIn && !tc.optOut) {
+		return protocol.NewErrorReply("ERR CLIENT CACHING can be called only when the client is in tracking mode " +
+			"with OPTIN or OPTOUT mode enabled")
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "yes":
+		if !tc.optIn {
+			return protocol.NewErrorReply("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		engine.tracking.setCaching(c, 1)
+	case "no":
+		if !tc.optOut {
+			return protocol.NewErrorReply("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		engine.tracking.setCaching(c, -1)
+	default:
+		return protocol.ErrorSyntaxReply
+	}
+	return protocol.OKReply
+}
+
+// trackingInfo CLIENT TRACKINGINFO，返回客户端缓存的模式、重定向的连接id和广播模式的前缀
+func trackingInfo(engine *Engine, c redis.Connection) redis.Reply {
+	flags := make([][]byte, 0)
+	redirect := int64(-1)
+	prefixes := make([][]byte, 0)
+	tc := engine.tracking.get(c)
+	if tc == nil {
+		flags = append(flags, []byte("off"))
+	} else {
+		flags = append(flags, []byte("on"))
+		redirect = tc.redirect
+		if tc.bcast {
+			flags = append(flags, []byte("bcast"))
+		}
+		if tc.optIn {
+			flags = append(flags, []byte("optin"))
+			if tc.nextCaching > 0 {
+				flags = append(flags, []byte("caching-yes"))
+			}
+		}
+		if tc.optOut {
+			flags = append(flags, []byte("optout"))
+			if tc.nextCaching < 0 {
+				flags = append(flags, []byte("caching-no"))
+			}
+		}
+		if tc.noLoop {
+			flags = append(flags, []byte("noloop"))
+		}
+		for _, prefix := range tc.prefixes {
+			prefixes = append(prefixes, []byte(prefix))
+		}
+	}
+	return protocol.NewMapReply([]redis.Reply{
+		protocol.NewBulkReply([]byte("flags")), protocol.NewSetReply(protocol.BulkReplies(flags)),
+		protocol.NewBulkReply([]byte("redirect")), protocol.NewIntReply(redirect),
+		protocol.NewBulkReply([]byte("prefixes")), protocol.NewMultiBulkReply(prefixes),
+	})
+}
@@ -0,0 +1,609 @@
+package database
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"zedis/config"
+	"zedis/interface/redis"
+	"zedis/lib/crc16"
+	"zedis/logger"
+	"zedis/redis/client"
+	"zedis/redis/protocol"
+)
+
+/*
+集群模式，参考Redis Cluster的实现
+所有key按CRC16(key) % 16384分配到16384个哈希槽，每个槽由一个节点负责，key中包含{hash tag}时只使用hash tag计算槽
+节点之间不使用单独的集群总线，而是通过普通端口上的CLUSTER GOSSIP命令每秒交换一次信息：
+自身的id、地址、配置纪元、负责的槽，以及已知的其他节点，这样CLUSTER MEET一个节点后，就能逐渐认识整个集群
+多个节点声明同一个槽时，配置纪元大的节点获胜
+重新分片时，源节点将槽标记为MIGRATING，目标节点标记为IMPORTING，使用MIGRATE逐个迁移key，
+迁移期间源节点对已经不存在的key返回ASK重定向，客户端先发送ASKING再到目标节点执行命令，
+迁移完成后两个节点都执行CLUSTER SETSLOT NODE，目标节点使用新的配置纪元，通过交换信息让其他节点更新槽的分配
+*/
+
+const (
+	slotCount = 16384
+	// 节点之间交换信息的间隔
+	clusterGossipInterval = time.Second
+)
+
+type clusterNode struct {
+	id   string
+	host string
+	port int
+	// 配置纪元，多个节点声明同一个槽时，纪元大的节点获胜
+	epoch uint64
+	// 上一次收到该节点消息的时间
+	lastPong time.Time
+	// 超过ClusterNodeTimeout没有收到该节点的消息时为true，只是本节点的判断
+	pfail bool
+}
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+// clusterState 本节点所知道的集群状态
+type clusterState struct {
+	mu     sync.RWMutex
+	myself *clusterNode
+	// 所有节点，包括自身: id -> node
+	nodes map[string]*clusterNode
+	// 执行CLUSTER MEET后还没有收到回复的地址: addr -> 开始握手的时间
+	handshakes map[string]time.Time
+	// 每个槽所在的节点，未分配时为nil
+	slots        [slotCount]*clusterNode
+	currentEpoch uint64
+	// 正在迁出的槽: slot -> 目标节点
+	migrating map[int]*clusterNode
+	// 正在导入的槽: slot -> 源节点
+	importing map[int]*clusterNode
+
+	// 与其他节点通信的客户端: addr -> client
+	clientsMu sync.Mutex
+	clients   map[string]*client.Client
+
+	// 保证配置文件按修改的顺序写入
+	saveMu sync.Mutex
+	stop   chan struct{}
+}
+
+// getSlot 计算key所在的槽，key中包含非空的{hash tag}时只使用hash tag计算
+func getSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16.Checksum([]byte(key))) % slotCount
+}
+
+func genNodeID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// getMyHost 返回其他节点连接本节点使用的地址
+func getMyHost() string {
+	if config.Config.AnnounceHost != "" {
+		return config.Config.AnnounceHost
+	}
+	if config.Config.Bind == "" || config.Config.Bind == "0.0.0.0" {
+		return "127.0.0.1"
+	}
+	return config.Config.Bind
+}
+
+func getClusterConfigFilename() string {
+	return filepath.Join(config.Config.Dir, config.Config.ClusterConfigFile)
+}
+
+func getNodeTimeout() time.Duration {
+	return time.Duration(config.Config.ClusterNodeTimeout) * time.Millisecond
+}
+
+// newClusterState 从集群配置文件加载集群状态，文件不存在时创建只包含自身的集群
+func newClusterState() (*clusterState, error) {
+	cs := &clusterState{
+		nodes:      make(map[string]*clusterNode),
+		handshakes: make(map[string]time.Time),
+		migrating:  make(map[int]*clusterNode),
+		importing:  make(map[int]*clusterNode),
+		clients:    make(map[string]*client.Client),
+		stop:       make(chan struct{}),
+	}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	if cs.myself == nil {
+		cs.myself = &clusterNode{id: genNodeID()}
+		cs.nodes[cs.myself.id] = cs.myself
+	}
+	cs.myself.host = getMyHost()
+	cs.myself.port = config.Config.Port
+	cs.save()
+	return cs, nil
+}
+
+// start 开始与其他节点交换信息
+func (cs *clusterState) start() {
+	go func() {
+		ticker := time.NewTicker(clusterGossipInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cs.gossip()
+			case <-cs.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (cs *clusterState) close() {
+	close(cs.stop)
+	cs.clientsMu.Lock()
+	defer cs.clientsMu.Unlock()
+	for addr, c := range cs.clients {
+		c.Close()
+		delete(cs.clients, addr)
+	}
+}
+
+// sendToNode 向指定地址的节点发送命令，出错时关闭客户端，下次发送时重新连接
+func (cs *clusterState) sendToNode(addr string, cmdLine CmdLine) (redis.Reply, error) {
+	cs.clientsMu.Lock()
+	c, ok := cs.clients[addr]
+	if !ok {
+		var err error
+		c, err = client.MakeClient(addr, config.Config.RequirePass, getNodeTimeout())
+		if err != nil {
+			cs.clientsMu.Unlock()
+			return nil, err
+		}
+		cs.clients[addr] = c
+	}
+	cs.clientsMu.Unlock()
+
+	reply, err := c.Send(cmdLine)
+	if err != nil {
+		cs.clientsMu.Lock()
+		if cs.clients[addr] == c {
+			delete(cs.clients, addr)
+		}
+		cs.clientsMu.Unlock()
+	}
+	return reply, err
+}
+
+/* ---- 节点之间交换信息 ---- */
+
+// buildGossip 生成发送给其他节点的消息：id host port 配置纪元 当前纪元 槽 [其他节点 ...]
+// 槽使用"0-100,200"的形式，其他节点使用"id host port"的形式，调用方需要持有读锁
+func (cs *clusterState) buildGossip() CmdLine {
+	myself := cs.myself
+	msg := CmdLine{
+		[]byte(myself.id),
+		[]byte(myself.host),
+		[]byte(strconv.Itoa(myself.port)),
+		[]byte(strconv.FormatUint(myself.epoch, 10)),
+		[]byte(strconv.FormatUint(cs.currentEpoch, 10)),
+		[]byte(formatSlotRanges(cs.getNodeSlots(myself), ",")),
+	}
+	for _, node := range cs.nodes {
+		if node == myself {
+			continue
+		}
+		msg = append(msg, []byte(fmt.Sprintf("%s %s %d", node.id, node.host, node.port)))
+	}
+	return msg
+}
+
+// gossip 向所有已知节点和握手中的地址发送自身信息，并处理对方回复的信息
+func (cs *clusterState) gossip() {
+	cs.mu.Lock()
+	msg := append(CmdLine{[]byte("CLUSTER"), []byte("GOSSIP")}, cs.buildGossip()...)
+	addrs := make([]string, 0, len(cs.nodes)+len(cs.handshakes))
+	for _, node := range cs.nodes {
+		if node != cs.myself {
+			addrs = append(addrs, node.addr())
+		}
+	}
+	for addr, startTime := range cs.handshakes {
+		if time.Since(startTime) > getNodeTimeout() {
+			logger.Warnf("cluster handshake with %s timed out", addr)
+			delete(cs.handshakes, addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	cs.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			reply, err := cs.sendToNode(addr, msg)
+			if err != nil {
+				return
+			}
+			r, ok := reply.(*protocol.MultiBulkReply)
+			if !ok {
+				logger.Warnf("unexpected cluster gossip reply from %s: %s", addr, string(reply.ToBytes()))
+				return
+			}
+			if err := cs.handleGossip(r.Texts); err != nil {
+				logger.Warnf("invalid cluster gossip reply from %s: %v", addr, err)
+			}
+		}(addr)
+	}
+	wg.Wait()
+	cs.updateFailState()
+}
+
+// handleGossip 处理其他节点发送的信息，更新节点和槽的分配
+func (cs *clusterState) handleGossip(msg CmdLine) error {
+	if len(msg) < 6 {
+		return fmt.Errorf("wrong number of fields: %d", len(msg))
+	}
+	id, host := string(msg[0]), string(msg[1])
+	port, err := strconv.Atoi(string(msg[2]))
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", msg[2])
+	}
+	epoch, err := strconv.ParseUint(string(msg[3]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid config epoch: %s", msg[3])
+	}
+	currentEpoch, err := strconv.ParseUint(string(msg[4]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid current epoch: %s", msg[4])
+	}
+	slots, err := parseSlotRanges(string(msg[5]))
+	if err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	changed := false
+	myself := cs.myself
+	if id == myself.id {
+		// MEET了自己
+		delete(cs.handshakes, net.JoinHostPort(host, strconv.Itoa(port)))
+		cs.mu.Unlock()
+		return nil
+	}
+	node, ok := cs.nodes[id]
+	if !ok {
+		node = &clusterNode{id: id}
+		cs.nodes[id] = node
+		logger.Infof("cluster node %s (%s:%d) joined", id, host, port)
+		changed = true
+	}
+	if node.host != host || node.port != port {
+		node.host, node.port = host, port
+		changed = true
+	}
+	delete(cs.handshakes, node.addr())
+	node.lastPong = time.Now()
+	if node.pfail {
+		node.pfail = false
+		logger.Infof("cluster node %s is reachable again", id)
+	}
+	if node.epoch != epoch {
+		node.epoch = epoch
+		changed = true
+	}
+	if currentEpoch > cs.currentEpoch {
+		cs.currentEpoch = currentEpoch
+		changed = true
+	}
+	// 配置纪元相同时，id较小的节点使用新的纪元，保证所有节点的配置纪元最终各不相同
+	if node.epoch == myself.epoch && myself.id < node.id {
+		cs.currentEpoch++
+		myself.epoch = cs.currentEpoch
+		logger.Infof("cluster config epoch collision with node %s, my epoch is now %d", id, myself.epoch)
+		changed = true
+	}
+	for _, slot := range slots {
+		owner := cs.slots[slot]
+		if owner == node || (owner != nil && owner.epoch >= node.epoch) {
+			continue
+		}
+		if owner == myself {
+			logger.Infof("slot %d is taken over by node %s with a greater config epoch", slot, id)
+			delete(cs.migrating, slot)
+		}
+		cs.slots[slot] = node
+		changed = true
+	}
+	for _, peer := range msg[6:] {
+		fields := strings.Fields(string(peer))
+		if len(fields) != 3 {
+			continue
+		}
+		if _, ok := cs.nodes[fields[0]]; ok {
+			continue
+		}
+		peerPort, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		cs.nodes[fields[0]] = &clusterNode{
+			id:       fields[0],
+			host:     fields[1],
+			port:     peerPort,
+			lastPong: time.Now(),
+		}
+		logger.Infof("cluster node %s (%s:%s) discovered via %s", fields[0], fields[1], fields[2], id)
+		changed = true
+	}
+	cs.mu.Unlock()
+	if changed {
+		cs.save()
+	}
+	return nil
+}
+
+// updateFailState 超过ClusterNodeTimeout没有收到消息的节点标记为可能下线
+func (cs *clusterState) updateFailState() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, node := range cs.nodes {
+		if node == cs.myself || node.pfail {
+			continue
+		}
+		if time.Since(node.lastPong) > getNodeTimeout() {
+			node.pfail = true
+			logger.Warnf("cluster node %s (%s) is not reachable", node.id, node.addr())
+		}
+	}
+}
+
+/* ---- 槽 ---- */
+
+// getNodeSlots 返回节点负责的所有槽，调用方需要持有锁
+func (cs *clusterState) getNodeSlots(node *clusterNode) []int {
+	slots := make([]int, 0)
+	for slot, owner := range cs.slots {
+		if owner == node {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// formatSlotRanges 将有序的槽合并为连续的区间，单个槽不写区间，如"0-100"、"200"，区间之间使用sep分隔
+func formatSlotRanges(slots []int, sep string) string {
+	ranges := make([]string, 0)
+	for i := 0; i < len(slots); {
+		j := i
+		for j+1 < len(slots) && slots[j+1] == slots[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(slots[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", slots[i], slots[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, sep)
+}
+
+// parseSlotRanges 解析formatSlotRanges生成的区间，区间之间可以使用逗号或空格分隔
+func parseSlotRanges(s string) ([]int, error) {
+	slots := make([]int, 0)
+	for _, r := range strings.FieldsFunc(s, func(c rune) bool { return c == ',' || c == ' ' }) {
+		start, end, found := strings.Cut(r, "-")
+		if !found {
+			end = start
+		}
+		from, err1 := strconv.Atoi(start)
+		to, err2 := strconv.Atoi(end)
+		if err1 != nil || err2 != nil || from < 0 || to >= slotCount || from > to {
+			return nil, fmt.Errorf("invalid slot range: %s", r)
+		}
+		for slot := from; slot <= to; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+// assignedSlots 返回已分配的槽的数量，调用方需要持有锁
+func (cs *clusterState) assignedSlots() int {
+	count := 0
+	for _, owner := range cs.slots {
+		if owner != nil {
+			count++
+		}
+	}
+	return count
+}
+
+/* ---- 集群配置文件 ---- */
+
+// nodeDescription 返回CLUSTER NODES中的一行，集群配置文件使用相同的格式，调用方需要持有锁
+// <id> <host:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func (cs *clusterState) nodeDescription(node *clusterNode) string {
+	flags := "master"
+	if node == cs.myself {
+		flags = "myself,master"
+	} else if node.pfail {
+		flags = "master,fail?"
+	}
+	var pongRecv int64
+	if node != cs.myself && !node.lastPong.IsZero() {
+		pongRecv = node.lastPong.UnixMilli()
+	}
+	linkState := "connected"
+	if node.pfail {
+		linkState = "disconnected"
+	}
+	// 节点之间使用普通端口通信，没有单独的集群总线端口
+	line := fmt.Sprintf("%s %s@%d %s - 0 %d %d %s", node.id, node.addr(), node.port, flags,
+		pongRecv, node.epoch, linkState)
+	if slots := formatSlotRanges(cs.getNodeSlots(node), " "); slots != "" {
+		line += " " + slots
+	}
+	if node == cs.myself {
+		line += formatMigratingSlots(cs.migrating, "->-") + formatMigratingSlots(cs.importing, "-<-")
+	}
+	return line
+}
+
+// formatMigratingSlots 返回正在迁出或导入的槽，与Redis相同，使用"[slot->-id]"和"[slot-<-id]"的形式
+func formatMigratingSlots(m map[int]*clusterNode, arrow string) string {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	var buf strings.Builder
+	for _, slot := range slots {
+		buf.WriteString(fmt.Sprintf(" [%d%s%s]", slot, arrow, m[slot].id))
+	}
+	return buf.String()
+}
+
+// nodesDescription 返回CLUSTER NODES的内容，自身排在第一行，调用方需要持有锁
+func (cs *clusterState) nodesDescription() string {
+	nodes := make([]*clusterNode, 0, len(cs.nodes))
+	for _, node := range cs.nodes {
+		if node != cs.myself {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].id < nodes[j].id
+	})
+	var buf strings.Builder
+	buf.WriteString(cs.nodeDescription(cs.myself) + "\n")
+	for _, node := range nodes {
+		buf.WriteString(cs.nodeDescription(node) + "\n")
+	}
+	return buf.String()
+}
+
+// save 保存集群配置文件，先写入临时文件，完成后替换旧文件
+func (cs *clusterState) save() {
+	cs.saveMu.Lock()
+	defer cs.saveMu.Unlock()
+	cs.mu.RLock()
+	content := cs.nodesDescription() + fmt.Sprintf("vars currentEpoch %d\n", cs.currentEpoch)
+	cs.mu.RUnlock()
+
+	filename := getClusterConfigFilename()
+	tmpFilename := filename + ".tmp"
+	if err := os.WriteFile(tmpFilename, []byte(content), 0644); err != nil {
+		logger.Errorf("save cluster config failed: %v", err)
+		return
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		logger.Errorf("save cluster config failed: %v", err)
+	}
+}
+
+// load 加载集群配置文件，文件不存在时直接返回
+func (cs *clusterState) load() error {
+	file, err := os.Open(getClusterConfigFilename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	// 迁移中的槽引用的节点可能在之后的行中，全部读取后再处理
+	var migratingSlots []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					cs.currentEpoch, _ = strconv.ParseUint(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return fmt.Errorf("invalid cluster config line: %s", scanner.Text())
+		}
+		addr, _, _ := strings.Cut(fields[1], "@")
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid node address: %s", fields[1])
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("invalid node address: %s", fields[1])
+		}
+		epoch, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid config epoch: %s", fields[6])
+		}
+		node := &clusterNode{
+			id:       fields[0],
+			host:     host,
+			port:     port,
+			epoch:    epoch,
+			lastPong: time.Now(),
+		}
+		cs.nodes[node.id] = node
+		if strings.Contains(fields[2], "myself") {
+			cs.myself = node
+		}
+		ranges := make([]string, 0, len(fields)-8)
+		for _, field := range fields[8:] {
+			if strings.HasPrefix(field, "[") {
+				migratingSlots = append(migratingSlots, field)
+			} else {
+				ranges = append(ranges, field)
+			}
+		}
+		slots, err := parseSlotRanges(strings.Join(ranges, " "))
+		if err != nil {
+			return err
+		}
+		for _, slot := range slots {
+			cs.slots[slot] = node
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, field := range migratingSlots {
+		m, arrow := cs.migrating, "->-"
+		if strings.Contains(field, "-<-") {
+			m, arrow = cs.importing, "-<-"
+		}
+		slotStr, id, _ := strings.Cut(strings.Trim(field, "[]"), arrow)
+		slot, err := strconv.Atoi(slotStr)
+		node, ok := cs.nodes[id]
+		if err != nil || slot < 0 || slot >= slotCount || !ok {
+			return fmt.Errorf("invalid migrating slot: %s", field)
+		}
+		m[slot] = node
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package database
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"zedis/interface/db"
+	"zedis/interface/redis"
+	"zedis/logger"
+	"zedis/redis/protocol"
+)
+
+var (
+	errCrossSlot       = protocol.NewErrorReply("CROSSSLOT Keys in request don't hash to the same slot")
+	errClusterDown     = protocol.NewErrorReply("CLUSTERDOWN Hash slot not served")
+	errInvalidSlot     = protocol.NewErrorReply("ERR Invalid or out of range slot")
+	errClusterDisabled = protocol.NewErrorReply("ERR This instance has cluster support disabled")
+	errTryAgain        = protocol.NewErrorReply("TRYAGAIN Multiple keys request during rehashing of slot")
+)
+
+// Cluster 命令，查看和修改集群状态
+// CLUSTER INFO | MYID | NODES | SLOTS | KEYSLOT key | MEET host port | ADDSLOTS slot [slot ...]
+// CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE node-id | SETSLOT slot STABLE
+// CLUSTER GETKEYSINSLOT slot count | COUNTKEYSINSLOT slot
+func Cluster(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("cluster")
+	}
+	cs := engine.cluster
+	if cs == nil {
+		return errClusterDisabled
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	subArgs := args[1:]
+	switch subCmd {
+	case "info":
+		return clusterInfo(cs)
+	case "myid":
+		return protocol.NewBulkReply([]byte(cs.myself.id))
+	case "nodes":
+		cs.mu.RLock()
+		defer cs.mu.RUnlock()
+		return protocol.NewBulkReply([]byte(cs.nodesDescription()))
+	case "slots":
+		return clusterSlots(cs)
+	case "keyslot":
+		if len(subArgs) != 1 {
+			return protocol.NewArgNumErrReply("cluster|keyslot")
+		}
+		return protocol.NewIntReply(int64(getSlot(string(subArgs[0]))))
+	case "meet":
+		return clusterMeet(cs, subArgs)
+	case "addslots":
+		return clusterAddSlots(cs, subArgs)
+	case "setslot":
+		return clusterSetSlot(engine, cs, subArgs)
+	case "getkeysinslot":
+		return clusterGetKeysInSlot(engine, subArgs)
+	case "countkeysinslot":
+		return clusterCountKeysInSlot(engine, subArgs)
+	case "gossip":
+		// 节点之间交换信息，回复自身的信息
+		if err := cs.handleGossip(subArgs); err != nil {
+			return protocol.NewErrorReply("ERR " + err.Error())
+		}
+		cs.mu.RLock()
+		defer cs.mu.RUnlock()
+		return protocol.NewMultiBulkReply(cs.buildGossip())
+	}
+	return protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLUSTER HELP.", string(args[0])))
+}
+
+// clusterInfo CLUSTER INFO，所有槽都已分配时集群状态为ok
+func clusterInfo(cs *clusterState) redis.Reply {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	assigned := cs.assignedSlots()
+	state := "fail"
+	if assigned == slotCount {
+		state = "ok"
+	}
+	// 可能下线的节点负责的槽数，以及负责至少一个槽的节点数
+	pfail, size := 0, 0
+	for _, node := range cs.nodes {
+		slots := len(cs.getNodeSlots(node))
+		if node.pfail {
+			pfail += slots
+		}
+		if slots > 0 {
+			size++
+		}
+	}
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("cluster_state:%s\r\n", state))
+	buf.WriteString(fmt.Sprintf("cluster_slots_assigned:%d\r\n", assigned))
+	buf.WriteString(fmt.Sprintf("cluster_slots_ok:%d\r\n", assigned-pfail))
+	buf.WriteString(fmt.Sprintf("cluster_slots_pfail:%d\r\n", pfail))
+	buf.WriteString("cluster_slots_fail:0\r\n")
+	buf.WriteString(fmt.Sprintf("cluster_known_nodes:%d\r\n", len(cs.nodes)))
+	buf.WriteString(fmt.Sprintf("cluster_size:%d\r\n", size))
+	buf.WriteString(fmt.Sprintf("cluster_current_epoch:%d\r\n", cs.currentEpoch))
+	buf.WriteString(fmt.Sprintf("cluster_my_epoch:%d\r\n", cs.myself.epoch))
+	return protocol.NewBulkReply([]byte(buf.String()))
+}
+
+// clusterSlots CLUSTER SLOTS，返回每个连续的槽区间及其所在的节点：[[start, end, [host, port, id]] ...]
+func clusterSlots(cs *clusterState) redis.Reply {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	ranges := make([]redis.Reply, 0)
+	for start := 0; start < slotCount; {
+		owner := cs.slots[start]
+		end := start
+		for end+1 < slotCount && cs.slots[end+1] == owner {
+			end++
+		}
+		if owner != nil {
+			ranges = append(ranges, protocol.NewArrayReply([]redis.Reply{
+				protocol.NewIntReply(int64(start)),
+				protocol.NewIntReply(int64(end)),
+				protocol.NewArrayReply([]redis.Reply{
+					protocol.NewBulkReply([]byte(owner.host)),
+					protocol.NewIntReply(int64(owner.port)),
+					protocol.NewBulkReply([]byte(owner.id)),
+				}),
+			}))
+		}
+		start = end + 1
+	}
+	return protocol.NewArrayReply(ranges)
+}
+
+// clusterMeet CLUSTER MEET host port，与指定节点握手，之后通过交换信息认识它所知道的其他节点
+func clusterMeet(cs *clusterState, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.NewArgNumErrReply("cluster|meet")
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return protocol.NewErrorReply("ERR Invalid node address specified: " + host + ":" + string(args[1]))
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, node := range cs.nodes {
+		if node.addr() == addr {
+			return protocol.OKReply
+		}
+	}
+	cs.handshakes[addr] = time.Now()
+	return protocol.OKReply
+}
+
+// clusterAddSlots CLUSTER ADDSLOTS slot [slot ...]，由本节点负责指定的槽，槽已经分配时返回错误
+func clusterAddSlots(cs *clusterState, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("cluster|addslots")
+	}
+	slots := make([]int, 0, len(args))
+	seen := make(map[int]struct{}, len(args))
+	for _, arg := range args {
+		slot, err := strconv.Atoi(string(arg))
+		if err != nil || slot < 0 || slot >= slotCount {
+			return errInvalidSlot
+		}
+		if _, ok := seen[slot]; ok {
+			return protocol.NewErrorReply(fmt.Sprintf("ERR Slot %d specified multiple times", slot))
+		}
+		seen[slot] = struct{}{}
+		slots = append(slots, slot)
+	}
+	cs.mu.Lock()
+	for _, slot := range slots {
+		if cs.slots[slot] != nil {
+			cs.mu.Unlock()
+			return protocol.NewErrorReply(fmt.Sprintf("ERR Slot %d is already busy", slot))
+		}
+	}
+	for _, slot := range slots {
+		cs.slots[slot] = cs.myself
+	}
+	cs.mu.Unlock()
+	cs.save()
+	return protocol.OKReply
+}
+
+// parseSlot 解析槽的编号
+func parseSlot(arg []byte) (int, bool) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= slotCount {
+		return 0, false
+	}
+	return slot, true
+}
+
+// clusterSetSlot CLUSTER SETSLOT，重新分片时修改槽的状态
+// MIGRATING: 槽正在迁出到指定节点，只能由负责该槽的节点执行
+// IMPORTING: 槽正在从指定节点导入，不能由负责该槽的节点执行
+// STABLE: 清除迁出和导入状态
+// NODE: 将槽分配给指定节点，本节点仍有该槽的key时不能分配给其他节点，导入完成时使用新的配置纪元，让其他节点接受新的分配
+func clusterSetSlot(engine *Engine, cs *clusterState, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return protocol.NewArgNumErrReply("cluster|setslot")
+	}
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		return errInvalidSlot
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		if len(args) != 2 {
+			return protocol.ErrorSyntaxReply
+		}
+		cs.mu.Lock()
+		delete(cs.migrating, slot)
+		delete(cs.importing, slot)
+		cs.mu.Unlock()
+		cs.save()
+		return protocol.OKReply
+	}
+	if len(args) != 3 || (action != "migrating" && action != "importing" && action != "node") {
+		return protocol.ErrorSyntaxReply
+	}
+	id := string(args[2])
+	// NODE需要检查槽中是否还有key，在加锁之前统计，避免遍历数据库时持有集群状态的锁
+	var keyCount int
+	if action == "node" {
+		keyCount = countKeysInSlot(engine, slot)
+	}
+
+	cs.mu.Lock()
+	node, ok := cs.nodes[id]
+	if !ok {
+		cs.mu.Unlock()
+		return protocol.NewErrorReply("ERR I don't know about node " + id)
+	}
+	myself := cs.myself
+	switch action {
+	case "migrating":
+		if cs.slots[slot] != myself {
+			cs.mu.Unlock()
+			return protocol.NewErrorReply(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+		}
+		if node == myself {
+			cs.mu.Unlock()
+			return protocol.NewErrorReply("ERR I can't migrate a hash slot to myself")
+		}
+		cs.migrating[slot] = node
+	case "importing":
+		if cs.slots[slot] == myself {
+			cs.mu.Unlock()
+			return protocol.NewErrorReply(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+		}
+		if node == myself {
+			cs.mu.Unlock()
+			return protocol.NewErrorReply("ERR I can't import a hash slot from myself")
+		}
+		cs.importing[slot] = node
+	case "node":
+		if cs.slots[slot] == myself && node != myself && keyCount > 0 {
+			cs.mu.Unlock()
+			return protocol.NewErrorReply(fmt.Sprintf(
+				"ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+		}
+		if _, ok := cs.migrating[slot]; ok && node != myself {
+			delete(cs.migrating, slot)
+		}
+		if _, ok := cs.importing[slot]; ok && node == myself {
+			delete(cs.importing, slot)
+			// 使用新的配置纪元，其他节点收到消息后将槽分配给本节点
+			cs.currentEpoch++
+			myself.epoch = cs.currentEpoch
+			logger.Infof("slot %d imported, my config epoch is now %d", slot, myself.epoch)
+		}
+		cs.slots[slot] = node
+	}
+	cs.mu.Unlock()
+	cs.save()
+	return protocol.OKReply
+}
+
+// keysInSlot 返回槽中最多count个未过期的key，count小于0时返回全部
+// 集群模式下只能使用0号数据库
+func keysInSlot(engine *Engine, slot int, count int) []string {
+	keys := make([]string, 0)
+	if count == 0 {
+		return keys
+	}
+	engine.ForEach(0, func(key string, entity *db.DataEntity, expiration *time.Time) bool {
+		if getSlot(key) == slot {
+			keys = append(keys, key)
+		}
+		return count < 0 || len(keys) < count
+	})
+	return keys
+}
+
+func countKeysInSlot(engine *Engine, slot int) int {
+	return len(keysInSlot(engine, slot, -1))
+}
+
+// clusterGetKeysInSlot CLUSTER GETKEYSINSLOT slot count，返回槽中最多count个key，用于重新分片时逐批迁移
+func clusterGetKeysInSlot(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.NewArgNumErrReply("cluster|getkeysinslot")
+	}
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		return errInvalidSlot
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil || count < 0 {
+		return protocol.NewErrorReply("ERR Invalid number of keys")
+	}
+	keys := keysInSlot(engine, slot, count)
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return protocol.NewMultiBulkReply(result)
+}
+
+// clusterCountKeysInSlot CLUSTER COUNTKEYSINSLOT slot，返回槽中key的数量
+func clusterCountKeysInSlot(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.NewArgNumErrReply("cluster|countkeysinslot")
+	}
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		return errInvalidSlot
+	}
+	return protocol.NewIntReply(int64(countKeysInSlot(engine, slot)))
+}
+
+// Asking 命令，下一条命令访问正在导入的槽时不返回MOVED，在事务中时对整个事务有效
+// ASKING
+func Asking(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("asking")
+	}
+	if engine.cluster == nil {
+		return errClusterDisabled
+	}
+	c.SetAsking(true)
+	return protocol.OKReply
+}
+
+// commandKeys 返回命令涉及的所有key，命令不存在或参数数量错误时返回nil，由之后的执行过程返回错误
+func commandKeys(cmdName string, cmdArgs [][]byte) []string {
+	if cmdName == "watch" {
+		keys := make([]string, len(cmdArgs))
+		for i, arg := range cmdArgs {
+			keys[i] = string(arg)
+		}
+		return keys
+	}
+	if isScriptCommand(cmdName) {
+		keys, _ := prepareEval(cmdArgs)
+		return keys
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, len(cmdArgs)+1) {
+		return nil
+	}
+	writeKeys, readKeys := cmd.prepare(cmdArgs)
+	return append(writeKeys, readKeys...)
+}
+
+// checkSlot 检查命令涉及的key是否由本节点负责，返回需要立即返回的错误，以及需要在持有key的锁之后执行的检查
+// key不在同一个槽时返回CROSSSLOT，槽不由本节点负责时返回MOVED，重定向到负责该槽的节点
+// 槽正在迁出时，key已经不存在则返回ASK，重定向到目标节点，部分key不存在时返回TRYAGAIN
+// 槽正在导入时，只处理设置了ASKING的客户端和RESTORE-ASKING，多个key中部分不存在时返回TRYAGAIN
+func (cs *clusterState) checkSlot(d *DB, cmdName string, cmdArgs [][]byte, asking bool) (redis.Reply, func() redis.Reply) {
+	keys := commandKeys(cmdName, cmdArgs)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	slot := getSlot(keys[0])
+	for _, key := range keys[1:] {
+		if getSlot(key) != slot {
+			return errCrossSlot, nil
+		}
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	owner := cs.slots[slot]
+	if owner == cs.myself {
+		target, ok := cs.migrating[slot]
+		if !ok {
+			return nil, nil
+		}
+		addr := target.addr()
+		return nil, func() redis.Reply {
+			missing := countMissingKeys(d, keys)
+			if missing == 0 {
+				return nil
+			}
+			if missing < len(keys) {
+				return errTryAgain
+			}
+			return protocol.NewErrorReply(fmt.Sprintf("ASK %d %s", slot, addr))
+		}
+	}
+	if _, ok := cs.importing[slot]; ok && (asking || cmdName == "restore-asking") {
+		if len(keys) == 1 {
+			return nil, nil
+		}
+		return nil, func() redis.Reply {
+			if countMissingKeys(d, keys) > 0 {
+				return errTryAgain
+			}
+			return nil
+		}
+	}
+	if owner == nil {
+		return errClusterDown, nil
+	}
+	return protocol.NewErrorReply(fmt.Sprintf("MOVED %d %s", slot, owner.addr())), nil
+}
+
+// countMissingKeys 返回不存在的key的数量
+func countMissingKeys(d *DB, keys []string) int {
+	missing := 0
+	for _, key := range keys {
+		if !d.Exists(key) {
+			missing++
+		}
+	}
+	return missing
+}
@@ -11,12 +11,19 @@ const (
 	tagWrite = 1 << iota
 	tagRead
 	tagSpecial
+	// 不能在持有其他key的锁时执行的命令，例如需要遍历所有key的命令会与已经持有的锁产生死锁
+	// 脚本中不能执行这些命令，事务中包含这些命令时EXEC不预先锁住key，见execMultiExclusive
+	tagNoMulti
 )
 
 // PrepareFunc 执行命令前的操作，返回write keys和read keys
 type PrepareFunc func(args [][]byte) ([]string, []string)
 type ExecFunc func(db *DB, args [][]byte) redis.Reply
 
+// AofFunc 将执行成功的写命令转换为写入AOF的命令
+// 用于设置相对过期时间、随机删除元素等直接重放会产生不同结果的命令
+type AofFunc func(db *DB, args [][]byte, reply redis.Reply) []CmdLine
+
 type command struct {
 	name     string
 	executor ExecFunc
@@ -26,6 +33,9 @@ type command struct {
 	// 例如 get命令 arity为2; mget命令 arity -2
 	arity int
 	tags  int
+
+	// 为空时，直接将原命令写入AOF
+	toAof AofFunc
 }
 
 // registerNormalCommand 注册一个普通Command
@@ -53,3 +63,9 @@ func registerSpecialCommand(name string, arity, tags int) *command {
 	cmdTable[name] = cmd
 	return cmd
 }
+
+// attachAof 为命令设置写入AOF时的转换函数
+func (cmd *command) attachAof(toAof AofFunc) *command {
+	cmd.toAof = toAof
+	return cmd
+}
@@ -1,51 +1,143 @@
 package database
 
 import (
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 	"zedis/datastruct/dict"
 	"zedis/interface/db"
 	"zedis/interface/redis"
 	"zedis/lib/timewheel"
 	"zedis/logger"
+	"zedis/pubsub"
 	"zedis/redis/protocol"
 )
 
 // DB 存储数据并执行用户命令
 type DB struct {
+	// 数据库编号，SWAPDB后会改变，执行命令的同时可能被SWAPDB修改，需要原子读写
+	index atomic.Int64
+	// 全局唯一的数据库id，用于区分不同数据库中相同key的过期任务
+	id uint64
+
 	// key -> DataEntity
 	data *dict.ConcurrentDict
 	// key -> expireTime(time.Time)
 	ttlMap *dict.ConcurrentDict
-
-	// callbacks
+	// callbacks，key被插入或修改时由notify调用insertCallback，key被删除或过期时由Remove调用deleteCallback
 	insertCallback db.KeyEventCallback
 	deleteCallback db.KeyEventCallback
+
+	// 为true时只在ttlMap中记录过期时间，不创建时间轮任务，用于AOF重写时的临时引擎
+	// 临时引擎用完即丢弃，时间轮任务会让它一直无法释放
+	noExpireTask bool
+
+	// 被WATCH的key的版本号，与数据库编号对应，SWAPDB时随编号交换
+	watched *watchTable
+
+	// 将写命令追加到AOF并发送给从节点，加载AOF期间为空操作
+	addAof func(CmdLine)
+	// 引擎的快照锁，写命令执行期间持有读锁，在key的锁之前获取
+	snapshotMu *sync.RWMutex
+
+	// 被BLPOP等阻塞命令挂起的客户端
+	blocking *blockingKeys
+	// 客户端缓存，所有数据库共用
+	tracking *trackingTable
+
+	// 开启的键空间通知类型，为0时不发布通知，通知发布到引擎的hub
+	notifyFlags int
+	hub         *pubsub.Hub
 }
 
-func makeDB() *DB {
-	return &DB{
-		data:   dict.NewConcurrentDict(1 << 16),
-		ttlMap: dict.NewConcurrentDict(1 << 10),
+// dbIDGenerator 用于生成数据库id
+var dbIDGenerator atomic.Uint64
+
+func makeDB(index int) *DB {
+	d := &DB{
+		id:       dbIDGenerator.Add(1),
+		data:     dict.NewConcurrentDict(1 << 16),
+		ttlMap:   dict.NewConcurrentDict(1 << 10),
+		watched:  newWatchTable(),
+		addAof:   func(line CmdLine) {},
+		blocking: newBlockingKeys(),
+		tracking: newTrackingTable(),
 	}
+	d.index.Store(int64(index))
+	return d
+}
+
+// getIndex 返回数据库当前的编号
+func (d *DB) getIndex() int {
+	return int(d.index.Load())
 }
 
 func (d *DB) Exec(c redis.Connection, cmdName string, cmdArgs [][]byte) redis.Reply {
+	return d.execChecked(c, cmdName, cmdArgs, nil)
+}
+
+// execChecked 与Exec相同，check不为空时在持有key的锁之后、执行命令之前调用，返回值不为nil时不执行命令，直接返回
+// 用于集群模式下根据key是否存在决定是否重定向，检查和执行之间key不会被修改
+func (d *DB) execChecked(c redis.Connection, cmdName string, cmdArgs [][]byte, check func() redis.Reply) redis.Reply {
+	cmd, errReply := lookupCommand(cmdName, cmdArgs)
+	if errReply != nil {
+		return errReply
+	}
+
+	if cmd.tags&tagWrite > 0 {
+		d.snapshotMu.RLock()
+		defer d.snapshotMu.RUnlock()
+	}
+	var writeKeys, readKeys []string
+	if cmd.prepare != nil {
+		writeKeys, readKeys = cmd.prepare(cmdArgs)
+		d.RWLocks(writeKeys, readKeys)
+		defer d.RWUnLocks(writeKeys, readKeys)
+	}
+	if check != nil {
+		if reply := check(); reply != nil {
+			return reply
+		}
+	}
+	return d.execWithLock(c, cmd, cmdArgs, writeKeys, readKeys)
+}
+
+// lookupCommand 查找命令并检查参数数量
+func lookupCommand(cmdName string, cmdArgs [][]byte) (*command, protocol.ErrorReply) {
 	cmd, ok := cmdTable[cmdName]
 	if !ok {
-		return protocol.NewUnknownCommandErrReply(cmdName)
+		return nil, protocol.NewUnknownCommandErrReply(cmdName)
 	}
 	if !validateArity(cmd.arity, len(cmdArgs)+1) {
-		return protocol.NewArgNumErrReply(cmdName)
+		return nil, protocol.NewArgNumErrReply(cmdName)
 	}
+	return cmd, nil
+}
 
-	prepare := cmd.prepare
-	executor := cmd.executor
-	if prepare != nil {
-		writeKeys, readKeys := prepare(cmdArgs)
-		d.RWLocks(writeKeys, readKeys)
-		defer d.RWUnLocks(writeKeys, readKeys)
+// execWithLock 执行命令，调用方需要已经持有命令涉及的所有key的锁
+func (d *DB) execWithLock(c redis.Connection, cmd *command, cmdArgs [][]byte, writeKeys, readKeys []string) redis.Reply {
+	if cmd.tags&tagWrite > 0 {
+		d.removeExpired(writeKeys)
 	}
-	return executor(d, cmdArgs)
+	reply := cmd.executor(d, cmdArgs)
+	if block, ok := reply.(*blockReply); ok {
+		reply = d.blockUntilReady(c, cmd, cmdArgs, block, writeKeys, readKeys)
+		if reply == block.timeoutReply {
+			return reply
+		}
+	}
+	// 写命令执行成功后，在释放锁之前追加到AOF，保证AOF中命令的顺序与实际执行顺序一致
+	if cmd.tags&tagWrite > 0 && !protocol.IsErrorReply(reply) {
+		d.addVersion(writeKeys...)
+		d.appendAof(cmd, cmdArgs, reply)
+		d.signalKeys(writeKeys)
+		d.tracking.invalidate(c, writeKeys)
+	} else if cmd.tags&tagRead > 0 {
+		// 持有key的锁时记录，之后修改这些key的命令一定会发送失效消息
+		d.tracking.trackRead(c, readKeys)
+	}
+	return reply
 }
 
 /* ---- 锁相关方法 ---- */
@@ -76,22 +168,14 @@ func (d *DB) GetEntity(key string) (*db.DataEntity, bool) {
 // 插入 返回1
 // 覆盖 返回0
 func (d *DB) PutEntity(key string, entity *db.DataEntity) int {
-	ret := d.data.Put(key, entity)
-	if cb := d.insertCallback; ret > 0 && cb != nil {
-		cb(0, key, entity)
-	}
-	return ret
+	return d.data.Put(key, entity)
 }
 
 // PutEntityIfNotExists 插入key-value键值对，如果key已存在，则不插入
 // 插入成功 返回1
 // 插入失败，返回0
 func (d *DB) PutEntityIfNotExists(key string, entity *db.DataEntity) int {
-	ret := d.data.PutIfAbsent(key, entity)
-	if cb := d.insertCallback; ret > 0 && cb != nil {
-		cb(0, key, entity)
-	}
-	return ret
+	return d.data.PutIfAbsent(key, entity)
 }
 
 // PutEntityIfExists 覆盖原有key-value；如果key不存在，则不覆盖和插入
@@ -116,9 +200,10 @@ func (d *DB) Remove(key string) (*db.DataEntity, int) {
 		// 如果删除成功，则删除到期时间，等同于重置到期时间
 		entity = raw.(*db.DataEntity)
 		d.Persist(key)
+		d.addVersion(key)
 	}
 	if cb := d.deleteCallback; entity != nil && cb != nil {
-		cb(0, key, entity)
+		cb(d.getIndex(), key, entity)
 	}
 
 	if entity != nil {
@@ -142,8 +227,13 @@ func (d *DB) ForEach(consumer dict.Consumer) {
 	d.data.ForEach(consumer)
 }
 
+// Flush 清空数据库中所有key及其过期时间
 func (d *DB) Flush() {
+	if d.data.Len() > 0 {
+		d.touchAll()
+	}
 	d.data.Clear()
+	d.ttlMap.Clear()
 }
 
 // validateArity 验证参数数量
@@ -154,72 +244,96 @@ func validateArity(arity, argNum int) bool {
 	return argNum >= -arity
 }
 
-/* ---- TTL 相关方法 ---- */
+/* ---- 版本号相关方法 ---- */
 
-func genExpireTaskKey(key string) string {
-	return "expire:" + key
+// addVersion 更新key的版本号，表示key被修改过，只有被WATCH的key需要记录
+func (d *DB) addVersion(keys ...string) {
+	d.watched.touch(keys...)
 }
 
-func (d *DB) Expire(key string, delay time.Duration) {
-	d.ttlMap.PutWithLock(key, time.Now().Add(delay))
-	taskKey := genExpireTaskKey(key)
+// touchAll 所有key都相当于被修改过，用于FLUSHDB、SWAPDB等修改整个数据库的命令
+// 只更新WATCH表的epoch，客户端缓存的所有key都失效
+func (d *DB) touchAll() {
+	d.tracking.invalidateAll()
+	d.watched.touchAll()
+}
 
-	timewheel.Delay(delay, taskKey, func() {
-		keys := []string{key}
-		d.RWLocks(keys, nil)
-		defer d.RWUnLocks(keys, nil)
+// GetVersion 返回key的版本号，调用方需要持有快照锁
+func (d *DB) GetVersion(key string) uint64 {
+	return d.watched.version(key)
+}
 
-		rawExpireTime, ok := d.ttlMap.Get(key)
-		if !ok {
-			return
-		}
-		expireTime, _ := rawExpireTime.(time.Time)
-		expired := time.Now().After(expireTime)
-		if expired {
-			logger.Infof("the key %s has expired, deleted", key)
-			d.Remove(key)
-			d.ttlMap.Remove(key)
-		}
-	})
+/* ---- TTL 相关方法 ---- */
+
+func (d *DB) genExpireTaskKey(key string) string {
+	return "expire:" + strconv.FormatUint(d.id, 10) + ":" + key
 }
 
-func (d *DB) ExpireByTime(key string, at time.Time) {
+func (d *DB) Expire(key string, delay time.Duration) {
+	d.ExpireByTime(key, time.Now().Add(delay))
+}
 
+func (d *DB) ExpireByTime(key string, at time.Time) {
 	d.ttlMap.PutWithLock(key, at)
-	taskKey := genExpireTaskKey(key)
-	timewheel.At(at, taskKey, func() {
+	d.scheduleExpire(key, at)
+}
+
+// scheduleExpire 在at时刻删除key，已经过期的key在时间轮的下一次扫描时删除
+// 时间轮的精度为2秒，任务可能在过期时间之前执行，此时按key当前的过期时间重新调度
+func (d *DB) scheduleExpire(key string, at time.Time) {
+	if d.noExpireTask {
+		return
+	}
+	delay := time.Until(at)
+	if delay < 0 {
+		delay = 0
+	}
+	timewheel.Delay(delay, d.genExpireTaskKey(key), func() {
+		// 与其他写命令相同，先获取快照锁，再获取key的锁
+		d.snapshotMu.RLock()
+		defer d.snapshotMu.RUnlock()
 		keys := []string{key}
 		d.RWLocks(keys, nil)
 		defer d.RWUnLocks(keys, nil)
 
-		rawExpireTime, ok := d.ttlMap.Get(key)
+		rawExpireTime, ok := d.ttlMap.GetWithLock(key)
 		if !ok {
 			return
 		}
-		expireTime, _ := rawExpireTime.(time.Time)
-		expired := time.Now().After(expireTime)
-		if expired {
-			logger.Infof("the key %s has expired, deleted", key)
-			d.Remove(key)
+		if expireTime := rawExpireTime.(time.Time); !time.Now().After(expireTime) {
+			d.scheduleExpire(key, expireTime)
+			return
 		}
+		d.removeExpired(keys)
 	})
 }
 
 func (d *DB) Persist(key string) {
-	d.ttlMap.Remove(key)
-	timewheel.Cancel(genExpireTaskKey(key))
+	d.ttlMap.RemoveWithLock(key)
+	timewheel.Cancel(d.genExpireTaskKey(key))
 }
 
-// IsExpired check whether a key is expired
+// IsExpired 判断key是否已经过期，只读取过期时间，不删除key
+// 读命令只持有key的读锁，此时修改数据会与同一分片上的其他读操作冲突，过期的key由写命令执行前或时间轮删除
 func (d *DB) IsExpired(key string) bool {
-	rawExpireTime, ok := d.ttlMap.Get(key)
+	rawExpireTime, ok := d.ttlMap.GetWithLock(key)
 	if !ok {
 		return false
 	}
 	expireTime, _ := rawExpireTime.(time.Time)
-	expired := time.Now().After(expireTime)
-	if expired {
+	return time.Now().After(expireTime)
+}
+
+// removeExpired 删除keys中已经过期的key，调用方需要持有这些key的写锁
+func (d *DB) removeExpired(keys []string) {
+	for _, key := range keys {
+		if !d.IsExpired(key) {
+			continue
+		}
+		logger.Infof("the key %s has expired, deleted", key)
 		d.Remove(key)
+		d.ttlMap.RemoveWithLock(key)
+		d.tracking.invalidate(nil, []string{key})
+		d.notify(notifyExpired, "expired", key)
 	}
-	return expired
 }
@@ -1,35 +1,225 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zedis/aof"
 	"zedis/config"
+	"zedis/interface/db"
 	"zedis/interface/redis"
 	"zedis/logger"
+	"zedis/pubsub"
 	"zedis/redis/protocol"
 )
 
 // Engine 是一个redis引擎对象，可以执行所有命令
 type Engine struct {
-	db *DB
+	// 所有数据库，SWAPDB时需要原子地交换两个数据库，所以使用atomic.Pointer
+	dbSet []*atomic.Pointer[DB]
+	// SWAPDB、MOVE、FLUSHALL等涉及多个数据库的命令执行时加锁
+	dbSetMu sync.Mutex
+
+	// 开启AOF时不为空
+	persister *aof.Persister
+	// 上一次成功保存RDB文件的时间，Unix时间戳
+	lastSave atomic.Int64
+	// 是否正在后台保存RDB文件
+	bgSaving atomic.Bool
+
+	// 发布订阅的订阅关系
+	hub *pubsub.Hub
+
+	// 写命令执行期间持有读锁，全量同步生成快照时持有写锁，保证快照与复制偏移量一致
+	snapshotMu sync.RWMutex
+	// 作为主节点时的复制状态
+	master *masterStatus
+	// 作为从节点时的复制状态
+	slave *slaveStatus
+
+	// 集群模式下不为空
+	cluster *clusterState
+
+	// Lua脚本缓存
+	scripts *scriptCache
+	// FUNCTION LOAD加载的函数库
+	functions *functionRegistry
+	// 客户端缓存记录的key和开启了客户端缓存的连接
+	tracking *trackingTable
 }
 
 func NewEngine() *Engine {
-	engine := &Engine{}
+	engine := newBasicEngine()
 	err := os.MkdirAll(config.GetTempDir(), os.ModePerm)
 	if err != nil {
 		panic(fmt.Errorf("create tmp dir failed: %v", err))
 	}
-	engine.db = makeDB()
+	engine.lastSave.Store(time.Now().Unix())
+	// 与Redis一致，开启AOF时只从AOF文件恢复数据，否则从RDB文件恢复
+	if !config.Config.AppendOnly {
+		if err := engine.loadRDB(); err != nil {
+			panic(fmt.Errorf("load rdb file failed: %v", err))
+		}
+	}
+	if config.Config.AppendOnly {
+		aofFilename := filepath.Join(config.Config.Dir, config.Config.AppendFilename)
+		persister, err := aof.NewPersister(engine, aofFilename, true, config.Config.AppendFsync, makeTmpEngine)
+		if err != nil {
+			panic(fmt.Errorf("open aof file failed: %v", err))
+		}
+		engine.persister = persister
+	}
+	notifyFlags, err := parseKeyspaceEvents(config.Config.NotifyKeyspaceEvents)
+	if err != nil {
+		panic(err)
+	}
+	// 加载AOF完成后再设置，避免重放的命令被重复写入AOF
+	for _, holder := range engine.dbSet {
+		d := holder.Load()
+		d.addAof = func(line CmdLine) {
+			engine.propagate(d.getIndex(), line)
+		}
+		d.notifyFlags = notifyFlags
+	}
+	if config.Config.ClusterEnabled {
+		cs, err := newClusterState()
+		if err != nil {
+			panic(fmt.Errorf("load cluster config failed: %v", err))
+		}
+		engine.cluster = cs
+		cs.start()
+	}
+	engine.startReplicationFromConfig()
+	return engine
+}
+
+// newBasicEngine 创建引擎及其中的数据库，不加载持久化文件
+func newBasicEngine() *Engine {
+	engine := &Engine{
+		dbSet:     make([]*atomic.Pointer[DB], config.Config.Databases),
+		hub:       pubsub.NewHub(),
+		master:    newMasterStatus(),
+		slave:     &slaveStatus{},
+		scripts:   newScriptCache(),
+		functions: newFunctionRegistry(),
+		tracking:  newTrackingTable(),
+	}
+	for i := range engine.dbSet {
+		holder := &atomic.Pointer[DB]{}
+		d := makeDB(i)
+		d.snapshotMu = &engine.snapshotMu
+		d.tracking = engine.tracking
+		d.hub = engine.hub
+		holder.Store(d)
+		engine.dbSet[i] = holder
+	}
+	return engine
+}
+
+// makeTmpEngine 创建一个不开启持久化的引擎，用于AOF重写时加载旧AOF文件
+// 过期时间只记录在ttlMap中，遍历时跳过已过期的key，不会在时间轮中留下任务
+func makeTmpEngine() db.DBEngine {
+	engine := newBasicEngine()
+	for _, holder := range engine.dbSet {
+		holder.Load().noExpireTask = true
+	}
 	return engine
 }
 
+// selectDB 返回指定编号的数据库
+func (e *Engine) selectDB(dbIndex int) (*DB, *protocol.StandardErrorReply) {
+	if dbIndex < 0 || dbIndex >= len(e.dbSet) {
+		return nil, protocol.NewErrorReply("ERR DB index is out of range")
+	}
+	return e.dbSet[dbIndex].Load(), nil
+}
+
+// mustSelectDB 返回指定编号的数据库，编号超出范围时panic
+func (e *Engine) mustSelectDB(dbIndex int) *DB {
+	d, errReply := e.selectDB(dbIndex)
+	if errReply != nil {
+		panic(errReply.Error())
+	}
+	return d
+}
+
+// propagate 将写命令追加到AOF，并发送给从节点
+func (e *Engine) propagate(dbIndex int, cmdLine CmdLine) {
+	if e.persister != nil {
+		e.persister.SaveCmdLine(dbIndex, cmdLine)
+	}
+	e.master.feed(dbIndex, cmdLine)
+}
+
+// ForEach 遍历数据库中所有未过期的key
+func (e *Engine) ForEach(dbIndex int, cb func(key string, entity *db.DataEntity, expiration *time.Time) bool) {
+	d := e.mustSelectDB(dbIndex)
+	d.ForEach(func(key string, val any) bool {
+		entity, _ := val.(*db.DataEntity)
+		var expiration *time.Time
+		if raw, exists := d.ttlMap.GetWithLock(key); exists {
+			expireTime, _ := raw.(time.Time)
+			if time.Now().After(expireTime) {
+				return true
+			}
+			expiration = &expireTime
+		}
+		return cb(key, entity, expiration)
+	})
+}
+
+// SetKeyEventCallback 设置所有数据库的key插入和删除回调，回调收到的dbIndex是数据库当前的编号
+// 需要在开始处理命令之前调用
+func (e *Engine) SetKeyEventCallback(insert, remove db.KeyEventCallback) {
+	for _, holder := range e.dbSet {
+		d := holder.Load()
+		d.insertCallback = insert
+		d.deleteCallback = remove
+	}
+}
+
+// FunctionLibraries 返回所有函数库的源码，按库名排序
+func (e *Engine) FunctionLibraries() [][]byte {
+	return e.functions.codes()
+}
+
+// AfterClientConnect 记录客户端连接，客户端缓存的失效消息可以重定向到该连接
+func (e *Engine) AfterClientConnect(c redis.Connection) {
+	e.tracking.addConn(c)
+}
+
+// AfterClientClose 客户端连接关闭后清理其订阅关系、WATCH的key和客户端缓存，如果是从节点的连接，移除从节点
+func (e *Engine) AfterClientClose(c redis.Connection) {
+	pubsub.UnsubscribeAll(e.hub, c)
+	unwatchAll(e, c)
+	e.tracking.removeConn(c)
+	e.master.removeReplica(c)
+}
+
+// Close 关闭引擎，停止复制，将AOF缓冲区中的命令写入文件
+func (e *Engine) Close() {
+	e.slave.mu.Lock()
+	e.slave.stop()
+	e.slave.mu.Unlock()
+	close(e.master.stop)
+	if e.cluster != nil {
+		e.cluster.close()
+	}
+	if e.persister != nil {
+		e.persister.Close()
+	}
+}
+
 func (e *Engine) Exec(c redis.Connection, cmdLine [][]byte) (res redis.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Error("error occurs: %v\n%s", err, string(debug.Stack()))
+			logger.Errorf("error occurs: %v\n%s", err, string(debug.Stack()))
 			res = protocol.ErrorUnknownReply
 		}
 	}()
@@ -42,21 +232,165 @@ func (e *Engine) Exec(c redis.Connection, cmdLine [][]byte) (res redis.Reply) {
 	// 所有命令处理函数，传的都是去掉命令名称的cmdArgs
 	cmdArgs := cmdLine[1:]
 
-	if cmdName == "ping" {
+	// 事务中的PING需要放入队列，RESP3下订阅模式的PING与普通PING相同
+	if cmdName == "ping" && !c.InMultiState() {
+		if c.SubsCount() > 0 && c.GetProtocol() == protocol.RESP2 {
+			return pubsub.MakePingReply(cmdArgs)
+		}
 		return Ping(c, cmdArgs)
 	}
 	if cmdName == "auth" {
 		return Auth(c, cmdArgs)
 	}
+	if cmdName == "hello" {
+		return Hello(e, c, cmdArgs)
+	}
 
 	if !isAuthenticated(c) {
 		return protocol.NewErrorReply("NOAUTH Authentication required")
 	}
+	// CLIENT CACHING只对下一条命令有效，下一条命令是MULTI时对整个事务有效
+	if cmdName != "multi" && (!c.InMultiState() || cmdName == "exec") &&
+		!(cmdName == "client" && len(cmdArgs) > 0 && strings.ToLower(string(cmdArgs[0])) == "caching") {
+		e.tracking.beforeCommand(c)
+	}
+
+	// RESP2的订阅模式下只能执行订阅相关的命令，RESP3通过推送类型区分消息，可以执行任意命令
+	if c.SubsCount() > 0 && c.GetProtocol() == protocol.RESP2 && !pubsub.IsAllowedInSubscribeMode(cmdName) {
+		return pubsub.MakeSubscribeModeErrReply(cmdName)
+	}
+	if cmdName == "asking" {
+		return Asking(e, c, cmdArgs)
+	}
+	// ASKING只对下一条命令有效，事务中对整个事务有效
+	asking := c.IsAsking()
+	if !c.InMultiState() || cmdName == "exec" || cmdName == "discard" {
+		c.SetAsking(false)
+	}
+	if cmdName == "multi" {
+		return Multi(c, cmdArgs)
+	}
+	if cmdName == "exec" {
+		return ExecMulti(e, c, cmdArgs)
+	}
+	if cmdName == "discard" {
+		return Discard(e, c, cmdArgs)
+	}
+	if cmdName == "watch" {
+		return Watch(e, c, cmdArgs)
+	}
+	// 从节点只读，事务中的写命令同样记录错误，EXEC时放弃执行事务
+	if e.isReadOnly(c, cmdName) {
+		if c.InMultiState() {
+			c.AddTxError(errors.New(errReadOnlyReplica.Error()))
+		}
+		return errReadOnlyReplica
+	}
+	// 集群模式下，key不由本节点负责时重定向，主节点发送的命令不检查
+	// 槽正在迁移时需要根据key是否存在决定是否重定向，keyCheck在持有key的锁之后执行
+	var keyCheck func() redis.Reply
+	if e.cluster != nil && !e.slave.isMasterConn(c) {
+		var errReply redis.Reply
+		errReply, keyCheck = e.cluster.checkSlot(e.mustSelectDB(0), cmdName, cmdArgs, asking)
+		if errReply == nil && keyCheck != nil && c.InMultiState() {
+			errReply = keyCheck()
+		}
+		if errReply != nil {
+			if c.InMultiState() {
+				c.AddTxError(errors.New(string(errReply.ToBytes())))
+			}
+			return errReply
+		}
+	}
+	if c.InMultiState() {
+		return EnqueueCmd(c, cmdLine)
+	}
+	if cmdName == "unwatch" {
+		return UnWatch(e, c, cmdArgs)
+	}
+
+	if cmdName == "subscribe" {
+		return pubsub.Subscribe(e.hub, c, cmdArgs)
+	}
+	if cmdName == "unsubscribe" {
+		return pubsub.UnSubscribe(e.hub, c, cmdArgs)
+	}
+	if cmdName == "psubscribe" {
+		return pubsub.PSubscribe(e.hub, c, cmdArgs)
+	}
+	if cmdName == "punsubscribe" {
+		return pubsub.PUnSubscribe(e.hub, c, cmdArgs)
+	}
+	if cmdName == "publish" {
+		return pubsub.Publish(e.hub, cmdArgs)
+	}
+	if cmdName == "pubsub" {
+		return pubsub.PubSub(e.hub, cmdArgs)
+	}
 
 	if cmdName == "info" {
 		return Info(e, cmdArgs)
 	}
+	if cmdName == "client" {
+		return Client(e, c, cmdArgs)
+	}
+	if cmdName == "bgrewriteaof" {
+		return BGRewriteAOF(e, cmdArgs)
+	}
+	if cmdName == "save" {
+		return Save(e, cmdArgs)
+	}
+	if cmdName == "bgsave" {
+		return BGSave(e, cmdArgs)
+	}
+	if cmdName == "lastsave" {
+		return LastSave(e, cmdArgs)
+	}
+	if cmdName == "select" {
+		return Select(e, c, cmdArgs)
+	}
+	if cmdName == "swapdb" {
+		return SwapDB(e, c, cmdLine)
+	}
+	if cmdName == "move" {
+		return Move(e, c, cmdLine)
+	}
+	if cmdName == "flushall" {
+		return FlushAll(e, c, cmdLine)
+	}
 
-	return e.db.Exec(c, cmdName, cmdArgs)
+	if cmdName == "replicaof" || cmdName == "slaveof" {
+		return ReplicaOf(e, c, cmdArgs)
+	}
+	if cmdName == "psync" {
+		return PSync(e, c, cmdArgs)
+	}
+	if cmdName == "replconf" {
+		return ReplConf(e, c, cmdArgs)
+	}
+	if cmdName == "role" {
+		return Role(e, cmdArgs)
+	}
+	if cmdName == "cluster" {
+		return Cluster(e, c, cmdArgs)
+	}
+	if cmdName == "eval" || cmdName == "evalsha" {
+		return Eval(e, c, cmdName, cmdArgs, keyCheck)
+	}
+	if cmdName == "script" {
+		return Script(e, cmdArgs)
+	}
+	if cmdName == "fcall" || cmdName == "fcall_ro" {
+		return FCall(e, c, cmdName, cmdArgs, keyCheck)
+	}
+	if cmdName == "function" {
+		return Function(e, c, cmdLine)
+	}
+
+	d, errReply := e.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	return d.execChecked(c, cmdName, cmdArgs, keyCheck)
 
 }
@@ -0,0 +1,370 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+/*
+函数库
+
+FUNCTION LOAD加载的源码以"#!lua name=<库名>"开头，执行时通过redis.register_function注册函数，之后用FCALL调用
+每个函数库使用自己的Lua虚拟机，注册的回调函数保存在其中，同一个库的函数串行执行
+FCALL先锁住KEYS再获取函数库的锁，持有函数库锁期间不会再获取key的锁，不会死锁
+
+函数库是全局的，不属于任何数据库，保存在RDB文件中数据库之前；
+FUNCTION LOAD、DELETE、FLUSH、RESTORE写入AOF并发送给从节点，重启时重放这些命令恢复函数库
+*/
+
+// 注册函数时允许的标志
+var functionFlags = map[string]struct{}{
+	"no-writes":             {},
+	"allow-oom":             {},
+	"allow-stale":           {},
+	"no-cluster":            {},
+	"allow-cross-slot-keys": {},
+}
+
+// luaFunction 函数库中注册的一个函数
+type luaFunction struct {
+	name        string
+	library     *luaLibrary
+	callback    *lua.LFunction
+	description string
+	flags       []string
+	// 设置了no-writes标志，可以通过FCALL_RO调用，不能执行写命令
+	noWrites bool
+}
+
+// luaLibrary 一个函数库
+type luaLibrary struct {
+	name string
+	code []byte
+	// 函数库的虚拟机，执行函数时加锁
+	mu        sync.Mutex
+	vm        *scriptVM
+	functions map[string]*luaFunction
+}
+
+// functionRegistry 所有已加载的函数库
+type functionRegistry struct {
+	mu        sync.RWMutex
+	libraries map[string]*luaLibrary
+	// 函数名 -> 函数，函数名在所有函数库中唯一
+	functions map[string]*luaFunction
+}
+
+func newFunctionRegistry() *functionRegistry {
+	return &functionRegistry{
+		libraries: make(map[string]*luaLibrary),
+		functions: make(map[string]*luaFunction),
+	}
+}
+
+// parseLibraryMetadata 解析源码第一行的"#!<引擎> name=<库名>"，返回库名
+func parseLibraryMetadata(code []byte) (string, redis.Reply) {
+	firstLine, _, _ := strings.Cut(string(code), "\n")
+	if !strings.HasPrefix(firstLine, "#!") {
+		return "", protocol.NewErrorReply("ERR Missing library metadata")
+	}
+	fields := strings.Fields(firstLine[2:])
+	if len(fields) == 0 || strings.ToLower(fields[0]) != "lua" {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return "", protocol.NewErrorReply(fmt.Sprintf("ERR Engine '%s' not found", engine))
+	}
+	name := ""
+	for _, field := range fields[1:] {
+		value, ok := strings.CutPrefix(field, "name=")
+		if !ok {
+			return "", protocol.NewErrorReply("ERR Invalid metadata value given: " + field)
+		}
+		name = value
+	}
+	if name == "" {
+		return "", protocol.NewErrorReply("ERR Library name was not given")
+	}
+	if !isValidFunctionName(name) {
+		return "", protocol.NewErrorReply("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+// isValidFunctionName 函数名和库名只能由字母、数字和下划线组成
+func isValidFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// compileLibrary 在新的虚拟机中执行函数库的源码，收集通过redis.register_function注册的函数
+func compileLibrary(code []byte) (*luaLibrary, redis.Reply) {
+	name, errReply := parseLibraryMetadata(code)
+	if errReply != nil {
+		return nil, errReply
+	}
+	// 第一行的元数据不是Lua代码，替换为空行，保持错误信息中的行号不变
+	body := string(code)
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[i:]
+	} else {
+		body = ""
+	}
+	chunk, err := parse.Parse(strings.NewReader(body), "user_function")
+	if err != nil {
+		return nil, protocol.NewErrorReply("ERR Error compiling function: " + strings.TrimSpace(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "user_function")
+	if err != nil {
+		return nil, protocol.NewErrorReply("ERR Error compiling function: " + strings.TrimSpace(err.Error()))
+	}
+
+	lib := &luaLibrary{
+		name:      name,
+		code:      code,
+		vm:        newScriptVM(),
+		functions: make(map[string]*luaFunction),
+	}
+	L := lib.vm.L
+	redisLib := L.GetGlobal("redis").(*lua.LTable)
+	redisLib.RawSetString("register_function", L.NewFunction(lib.registerFunction))
+	L.Push(L.NewFunctionFromProto(proto))
+	err = L.PCall(0, 0, nil)
+	// 只能在加载时注册函数
+	redisLib.RawSetString("register_function", lua.LNil)
+	if err != nil {
+		msg := err.Error()
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			msg = apiErr.Object.String()
+		}
+		return nil, protocol.NewErrorReply("ERR Error registering functions: " + msg)
+	}
+	if len(lib.functions) == 0 {
+		return nil, protocol.NewErrorReply("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// registerFunction 实现redis.register_function
+// redis.register_function(name, callback)
+// redis.register_function{function_name=name, callback=callback, flags={...}, description=...}
+func (lib *luaLibrary) registerFunction(L *lua.LState) int {
+	fn := &luaFunction{library: lib}
+	if L.GetTop() == 1 {
+		args := L.CheckTable(1)
+		var ok bool
+		args.ForEach(func(key, value lua.LValue) {
+			switch key.String() {
+			case "function_name":
+				fn.name, ok = luaToString(value)
+				if !ok {
+					L.RaiseError("function_name argument given to redis.register_function must be a string")
+				}
+			case "callback":
+				fn.callback, ok = value.(*lua.LFunction)
+				if !ok {
+					L.RaiseError("callback argument given to redis.register_function must be a function")
+				}
+			case "description":
+				fn.description, ok = luaToString(value)
+				if !ok {
+					L.RaiseError("description argument given to redis.register_function must be a string")
+				}
+			case "flags":
+				flags, isTable := value.(*lua.LTable)
+				if !isTable {
+					L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+				}
+				for i := 1; i <= flags.Len(); i++ {
+					flag, isString := luaToString(flags.RawGetInt(i))
+					if _, known := functionFlags[flag]; !isString || !known {
+						L.RaiseError("unknown flag given")
+					}
+					fn.flags = append(fn.flags, flag)
+					if flag == "no-writes" {
+						fn.noWrites = true
+					}
+				}
+			default:
+				L.RaiseError("unknown argument given to redis.register_function")
+			}
+		})
+	} else {
+		name, ok := luaToString(L.Get(1))
+		if !ok {
+			L.RaiseError("first argument to redis.register_function must be a string")
+		}
+		fn.name = name
+		fn.callback = L.CheckFunction(2)
+	}
+	if fn.callback == nil {
+		L.RaiseError("redis.register_function must get a callback argument")
+	}
+	if !isValidFunctionName(fn.name) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if _, exists := lib.functions[fn.name]; exists {
+		L.RaiseError("Function already exists in the library")
+	}
+	lib.functions[fn.name] = fn
+	return 0
+}
+
+func luaToString(lv lua.LValue) (string, bool) {
+	s, ok := lv.(lua.LString)
+	return string(s), ok
+}
+
+// load 编译并加载函数库，replace为true时替换同名的函数库，返回库名
+func (r *functionRegistry) load(code []byte, replace bool) (string, redis.Reply) {
+	lib, errReply := compileLibrary(code)
+	if errReply != nil {
+		return "", errReply
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if errReply := r.checkConflict(lib, replace); errReply != nil {
+		return "", errReply
+	}
+	r.add(lib)
+	return lib.name, nil
+}
+
+// checkConflict 检查函数库能否加入，调用方需要持有锁
+func (r *functionRegistry) checkConflict(lib *luaLibrary, replace bool) redis.Reply {
+	old, exists := r.libraries[lib.name]
+	if exists && !replace {
+		return protocol.NewErrorReply(fmt.Sprintf("ERR Library '%s' already exists", lib.name))
+	}
+	for name := range lib.functions {
+		if fn, ok := r.functions[name]; ok && fn.library != old {
+			return protocol.NewErrorReply(fmt.Sprintf("ERR Function %s already exists", name))
+		}
+	}
+	return nil
+}
+
+// add 加入函数库，替换同名的函数库，调用方需要持有锁并已经检查过冲突
+func (r *functionRegistry) add(lib *luaLibrary) {
+	r.remove(lib.name)
+	r.libraries[lib.name] = lib
+	for name, fn := range lib.functions {
+		r.functions[name] = fn
+	}
+}
+
+// remove 删除函数库及其中的函数，调用方需要持有锁
+func (r *functionRegistry) remove(name string) bool {
+	lib, ok := r.libraries[name]
+	if !ok {
+		return false
+	}
+	for fnName := range lib.functions {
+		delete(r.functions, fnName)
+	}
+	delete(r.libraries, name)
+	return true
+}
+
+func (r *functionRegistry) delete(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.remove(name)
+}
+
+func (r *functionRegistry) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.libraries = make(map[string]*luaLibrary)
+	r.functions = make(map[string]*luaFunction)
+}
+
+func (r *functionRegistry) getFunction(name string) (*luaFunction, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.functions[name]
+	return fn, ok
+}
+
+// sortedLibraries 返回按库名排序的所有函数库
+func (r *functionRegistry) sortedLibraries() []*luaLibrary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	libs := make([]*luaLibrary, 0, len(r.libraries))
+	for _, lib := range r.libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool {
+		return libs[i].name < libs[j].name
+	})
+	return libs
+}
+
+// codes 返回所有函数库的源码，用于RDB、AOF重写和FUNCTION DUMP
+func (r *functionRegistry) codes() [][]byte {
+	libs := r.sortedLibraries()
+	codes := make([][]byte, len(libs))
+	for i, lib := range libs {
+		codes[i] = lib.code
+	}
+	return codes
+}
+
+// restore 加载多个函数库，policy为flush时先删除所有函数库，为replace时替换同名的函数库，为append时存在同名的函数库则失败
+// 所有函数库都检查通过后才加入，任意一个失败时不做任何修改
+func (r *functionRegistry) restore(codes [][]byte, policy string) redis.Reply {
+	libs := make([]*luaLibrary, 0, len(codes))
+	for _, code := range codes {
+		lib, errReply := compileLibrary(code)
+		if errReply != nil {
+			return errReply
+		}
+		libs = append(libs, lib)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 在副本上检查冲突，避免部分函数库已经加入后失败
+	staged := newFunctionRegistry()
+	if policy != "flush" {
+		for name, lib := range r.libraries {
+			staged.libraries[name] = lib
+		}
+		for name, fn := range r.functions {
+			staged.functions[name] = fn
+		}
+	}
+	for _, lib := range libs {
+		if errReply := staged.checkConflict(lib, policy == "replace"); errReply != nil {
+			return errReply
+		}
+		staged.add(lib)
+	}
+	r.libraries = staged.libraries
+	r.functions = staged.functions
+	return nil
+}
+
+// runFunction 调用函数，KEYS和ARGV作为参数传给回调函数，调用方需要持有快照锁的读锁和所有key的写锁
+func (e *Engine) runFunction(d *DB, c redis.Connection, fn *luaFunction, keys []string, args [][]byte, readOnly bool) redis.Reply {
+	lib := fn.library
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	L := lib.vm.L
+	run := e.newScriptRun(d, c, keys, readOnly || fn.noWrites)
+	return lib.vm.invoke(run, fn.name, fn.callback, keysTable(L, keys), argvTable(L, args))
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"zedis/interface/redis"
+	"zedis/lib/wildcard"
+	"zedis/rdb"
+	"zedis/redis/protocol"
+)
+
+// FCall FCALL和FCALL_RO命令，调用函数库中的函数
+// FCALL_RO只能调用设置了no-writes标志的函数，check的用法与Eval相同
+// FCALL function numkeys [key [key ...]] [arg [arg ...]]
+// FCALL_RO function numkeys [key [key ...]] [arg [arg ...]]
+func FCall(engine *Engine, c redis.Connection, cmdName string, args [][]byte, check func() redis.Reply) redis.Reply {
+	if len(args) < 2 {
+		return protocol.NewArgNumErrReply(cmdName)
+	}
+	keys, argv, errReply := parseEvalKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	fn, errReply := lookupFunction(engine, cmdName, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	d, dbErr := engine.selectDB(c.GetDBIndex())
+	if dbErr != nil {
+		return dbErr
+	}
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	d.RWLocks(keys, nil)
+	defer d.RWUnLocks(keys, nil)
+	if check != nil {
+		if reply := check(); reply != nil {
+			return reply
+		}
+	}
+	return engine.runFunction(d, c, fn, keys, argv, cmdName == "fcall_ro")
+}
+
+// lookupFunction 查找函数，FCALL_RO只能调用设置了no-writes标志的函数
+func lookupFunction(engine *Engine, cmdName string, name []byte) (*luaFunction, redis.Reply) {
+	fn, ok := engine.functions.getFunction(string(name))
+	if !ok {
+		return nil, protocol.NewErrorReply("ERR Function not found")
+	}
+	if cmdName == "fcall_ro" && !fn.noWrites {
+		return nil, protocol.NewErrorReply("ERR Can not execute a script with write flag using *_ro command.")
+	}
+	return fn, nil
+}
+
+// execQueuedFCall 执行事务中的FCALL、FCALL_RO，调用方已经持有所有key的锁
+func execQueuedFCall(engine *Engine, d *DB, c redis.Connection, cmdName string, args [][]byte) redis.Reply {
+	keys, argv, errReply := parseEvalKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	fn, errReply := lookupFunction(engine, cmdName, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	return engine.runFunction(d, c, fn, keys, argv, cmdName == "fcall_ro")
+}
+
+// Function FUNCTION命令，管理函数库
+// 修改函数库的子命令执行成功后写入AOF并发送给从节点，从节点不能执行这些子命令
+// FUNCTION LOAD [REPLACE] code | DELETE library | FLUSH [ASYNC|SYNC]
+// FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE] | DUMP | RESTORE payload [FLUSH|APPEND|REPLACE]
+func Function(engine *Engine, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	args := cmdLine[1:]
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("function")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "list":
+		return functionList(engine, args[1:])
+	case "dump":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("function|dump")
+		}
+		payload, err := rdb.DumpFunctions(engine.functions.codes())
+		if err != nil {
+			return protocol.NewErrorReply("ERR " + err.Error())
+		}
+		return protocol.NewBulkReply(payload)
+	case "load", "delete", "flush", "restore":
+	default:
+		return protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try FUNCTION HELP.", string(args[0])))
+	}
+
+	if engine.slave.isReplica() && !engine.slave.isMasterConn(c) {
+		return errReadOnlyReplica
+	}
+	// 与写命令相同，修改和传播期间持有快照锁的读锁，全量同步的快照与复制偏移量一致
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	var reply redis.Reply
+	switch subCmd {
+	case "load":
+		reply = functionLoad(engine, args[1:])
+	case "delete":
+		if len(args) != 2 {
+			return protocol.NewArgNumErrReply("function|delete")
+		}
+		if !engine.functions.delete(string(args[1])) {
+			return protocol.NewErrorReply("ERR Library not found")
+		}
+		reply = protocol.OKReply
+	case "flush":
+		if len(args) > 2 {
+			return protocol.NewArgNumErrReply("function|flush")
+		}
+		if len(args) == 2 {
+			mode := strings.ToLower(string(args[1]))
+			if mode != "async" && mode != "sync" {
+				return protocol.NewErrorReply("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+			}
+		}
+		engine.functions.flush()
+		reply = protocol.OKReply
+	case "restore":
+		reply = functionRestore(engine, args[1:])
+	}
+	if !protocol.IsErrorReply(reply) {
+		engine.propagate(c.GetDBIndex(), cmdLine)
+	}
+	return reply
+}
+
+// functionLoad FUNCTION LOAD [REPLACE] code
+func functionLoad(engine *Engine, args [][]byte) redis.Reply {
+	replace := false
+	if len(args) == 2 && strings.ToLower(string(args[0])) == "replace" {
+		replace = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return protocol.NewArgNumErrReply("function|load")
+	}
+	name, errReply := engine.functions.load(args[0], replace)
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.NewBulkReply([]byte(name))
+}
+
+// functionRestore FUNCTION RESTORE payload [FLUSH|APPEND|REPLACE]，默认为APPEND
+func functionRestore(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return protocol.NewArgNumErrReply("function|restore")
+	}
+	policy := "append"
+	if len(args) == 2 {
+		policy = strings.ToLower(string(args[1]))
+		if policy != "flush" && policy != "append" && policy != "replace" {
+			return protocol.NewErrorReply("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+		}
+	}
+	codes, err := rdb.RestoreFunctions(args[0])
+	if err != nil {
+		return protocol.NewErrorReply("ERR " + err.Error())
+	}
+	if errReply := engine.functions.restore(codes, policy); errReply != nil {
+		return errReply
+	}
+	return protocol.OKReply
+}
+
+// functionList FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+func functionList(engine *Engine, args [][]byte) redis.Reply {
+	var pattern *wildcard.Pattern
+	withCode := false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withcode":
+			withCode = true
+		case "libraryname":
+			if i+1 >= len(args) {
+				return protocol.NewErrorReply("ERR library name argument was not given")
+			}
+			i++
+			var err error
+			if pattern, err = wildcard.CompilePattern(string(args[i])); err != nil {
+				return protocol.ErrorSyntaxReply
+			}
+		default:
+			return protocol.NewErrorReply(fmt.Sprintf("ERR Unknown argument %s", string(args[i])))
+		}
+	}
+	replies := make([]redis.Reply, 0)
+	for _, lib := range engine.functions.sortedLibraries() {
+		if pattern != nil && !pattern.IsMatch(lib.name) {
+			continue
+		}
+		replies = append(replies, libraryInfo(lib, withCode))
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// libraryInfo 返回FUNCTION LIST中一个函数库的信息
+func libraryInfo(lib *luaLibrary, withCode bool) redis.Reply {
+	functions := make([]redis.Reply, 0, len(lib.functions))
+	for _, fn := range sortedFunctions(lib) {
+		var description redis.Reply = protocol.NullBulkReply
+		if fn.description != "" {
+			description = protocol.NewBulkReply([]byte(fn.description))
+		}
+		flags := make([][]byte, len(fn.flags))
+		for i, flag := range fn.flags {
+			flags[i] = []byte(flag)
+		}
+		functions = append(functions, protocol.NewArrayReply([]redis.Reply{
+			protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(fn.name)),
+			protocol.NewBulkReply([]byte("description")), description,
+			protocol.NewBulkReply([]byte("flags")), protocol.NewMultiBulkReply(flags),
+		}))
+	}
+	fields := []redis.Reply{
+		protocol.NewBulkReply([]byte("library_name")), protocol.NewBulkReply([]byte(lib.name)),
+		protocol.NewBulkReply([]byte("engine")), protocol.NewBulkReply([]byte("LUA")),
+		protocol.NewBulkReply([]byte("functions")), protocol.NewArrayReply(functions),
+	}
+	if withCode {
+		fields = append(fields, protocol.NewBulkReply([]byte("library_code")), protocol.NewBulkReply(lib.code))
+	}
+	return protocol.NewArrayReply(fields)
+}
+
+// sortedFunctions 返回按函数名排序的函数库中的函数
+func sortedFunctions(lib *luaLibrary) []*luaFunction {
+	functions := make([]*luaFunction, 0, len(lib.functions))
+	for _, fn := range lib.functions {
+		functions = append(functions, fn)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].name < functions[j].name
+	})
+	return functions
+}
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"strconv"
+	"strings"
 	"time"
+	"zedis/datastruct/list"
 	"zedis/interface/db"
 	"zedis/interface/redis"
 	"zedis/lib/wildcard"
@@ -16,6 +19,9 @@ var (
 	lessThanExpirePolicy  = "lessThanExpirePolicy"  // 只有新过期时间小于原过期时间，才更新
 )
 
+// embStrSizeLimit 不超过该长度的字符串，Redis使用embstr编码
+const embStrSizeLimit = 44
+
 // ExistsCommand 查询key是否存在，返回key存在的数量
 func ExistsCommand(d *DB, args [][]byte) redis.Reply {
 	var existCount int64 = 0
@@ -29,11 +35,15 @@ func ExistsCommand(d *DB, args [][]byte) redis.Reply {
 
 // DelCommand 删除所有key对应键值对，返回删除成功的数量
 func DelCommand(d *DB, args [][]byte) redis.Reply {
-	keys := make([]string, 0)
+	deleted := 0
 	for i := 0; i < len(args); i++ {
-		keys = append(keys, string(args[i]))
+		key := string(args[i])
+		if _, exists := d.Remove(key); exists > 0 {
+			deleted += exists
+			d.notify(notifyGeneric, "del", key)
+		}
 	}
-	return protocol.NewIntReply(int64(d.Removes(keys...)))
+	return protocol.NewIntReply(int64(deleted))
 }
 
 // KeysCommand 返回pattern对应的所有key，pattern为通配符
@@ -64,7 +74,7 @@ func ExpireCommand(d *DB, args [][]byte) redis.Reply {
 		expirePolicy = getExpirePolicy(string(args[2]))
 	}
 
-	oldExpireTimeVal, exists := d.ttlMap.Get(key)
+	oldExpireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	var oldExpireTime time.Time
 	if exists {
 		oldExpireTime = oldExpireTimeVal.(time.Time)
@@ -90,7 +100,7 @@ func ExpireAtCommand(d *DB, args [][]byte) redis.Reply {
 		expirePolicy = getExpirePolicy(string(args[2]))
 	}
 
-	oldExpireTimeVal, exists := d.ttlMap.Get(key)
+	oldExpireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	var oldExpireTime time.Time
 	if exists {
 		oldExpireTime = oldExpireTimeVal.(time.Time)
@@ -117,7 +127,7 @@ func PExpireCommand(d *DB, args [][]byte) redis.Reply {
 		expirePolicy = getExpirePolicy(string(args[2]))
 	}
 
-	oldExpireTimeVal, exists := d.ttlMap.Get(key)
+	oldExpireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	var oldExpireTime time.Time
 	if exists {
 		oldExpireTime = oldExpireTimeVal.(time.Time)
@@ -142,7 +152,7 @@ func PExpireAtCommand(d *DB, args [][]byte) redis.Reply {
 		expirePolicy = getExpirePolicy(string(args[2]))
 	}
 
-	oldExpireTimeVal, exists := d.ttlMap.Get(key)
+	oldExpireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	var oldExpireTime time.Time
 	if exists {
 		oldExpireTime = oldExpireTimeVal.(time.Time)
@@ -178,6 +188,7 @@ func setExpireTime(d *DB, key, expirePolicy string, oldExists bool, oldExpireTim
 	switch expirePolicy {
 	case defaultExpirePolicy:
 		d.ExpireByTime(key, newExpireTime)
+		d.notify(notifyGeneric, "expire", key)
 		return protocol.NewIntReply(1)
 	case insertExpirePolicy:
 		if oldExists {
@@ -198,6 +209,7 @@ func setExpireTime(d *DB, key, expirePolicy string, oldExists bool, oldExpireTim
 
 	}
 	d.ExpireByTime(key, newExpireTime)
+	d.notify(notifyGeneric, "expire", key)
 	return protocol.NewIntReply(1)
 }
 
@@ -207,7 +219,7 @@ func ExpireTimeCommand(d *DB, args [][]byte) redis.Reply {
 	if !d.Exists(key) {
 		return protocol.NewIntReply(-1)
 	}
-	expireTimeVal, exists := d.ttlMap.Get(key)
+	expireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	if !exists {
 		return protocol.NewIntReply(-2)
 	}
@@ -221,7 +233,7 @@ func PExpireTimeCommand(d *DB, args [][]byte) redis.Reply {
 	if !d.Exists(key) {
 		return protocol.NewIntReply(-1)
 	}
-	expireTimeVal, exists := d.ttlMap.Get(key)
+	expireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	if !exists {
 		return protocol.NewIntReply(-2)
 	}
@@ -235,7 +247,7 @@ func TTLCommand(d *DB, args [][]byte) redis.Reply {
 	if !d.Exists(key) {
 		return protocol.NewIntReply(-1)
 	}
-	expireTimeVal, exists := d.ttlMap.Get(key)
+	expireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	if !exists {
 		return protocol.NewIntReply(-2)
 	}
@@ -249,7 +261,7 @@ func PTTLCommand(d *DB, args [][]byte) redis.Reply {
 	if !d.Exists(key) {
 		return protocol.NewIntReply(-1)
 	}
-	expireTimeVal, exists := d.ttlMap.Get(key)
+	expireTimeVal, exists := d.ttlMap.GetWithLock(key)
 	if !exists {
 		return protocol.NewIntReply(-2)
 	}
@@ -257,6 +269,40 @@ func PTTLCommand(d *DB, args [][]byte) redis.Reply {
 	return protocol.NewIntReply(expireTime.Sub(time.Now()).Milliseconds())
 }
 
+// PersistCommand 移除key的过期时间，移除成功返回1，key不存在或没有设置过期时间返回0
+// persist key
+func PersistCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if !d.Exists(key) {
+		return protocol.ZeroReply
+	}
+	if _, exists := d.ttlMap.GetWithLock(key); !exists {
+		return protocol.ZeroReply
+	}
+	d.Persist(key)
+	d.notify(notifyGeneric, "persist", key)
+	return protocol.NewIntReply(1)
+}
+
+// FlushDBCommand 清空当前数据库
+// FLUSHDB [ASYNC | SYNC]
+func FlushDBCommand(d *DB, args [][]byte) redis.Reply {
+	if len(args) > 1 {
+		return protocol.NewArgNumErrReply("flushdb")
+	}
+	if len(args) == 1 && !isFlushMode(args[0]) {
+		return protocol.ErrorSyntaxReply
+	}
+	d.Flush()
+	return protocol.OKReply
+}
+
+// DBSizeCommand 返回当前数据库中key的数量
+// DBSIZE
+func DBSizeCommand(d *DB, args [][]byte) redis.Reply {
+	return protocol.NewIntReply(int64(d.data.Len()))
+}
+
 // TypeCommand 返回key 的类型，如果key不存在，返回null
 // type key
 func TypeCommand(d *DB, args [][]byte) redis.Reply {
@@ -264,33 +310,195 @@ func TypeCommand(d *DB, args [][]byte) redis.Reply {
 	t := "none"
 	entity, exists := d.GetEntity(key)
 	if exists {
-		switch entity.Type {
-		case db.ListType:
-			t = "list"
-		case db.SetType:
-			t = "set"
-		case db.HashType:
-			t = "hash"
-		case db.SortedType:
-			t = "zset"
-		case db.StringType:
-			t = "string"
-		}
+		t = typeName(entity)
 	}
 	return protocol.NewBulkReply([]byte(t))
 }
 
+// typeName 返回value的类型名称，与TYPE命令的返回值一致
+func typeName(entity *db.DataEntity) string {
+	switch entity.Type {
+	case db.ListType:
+		return "list"
+	case db.SetType:
+		return "set"
+	case db.HashType:
+		return "hash"
+	case db.SortedType:
+		return "zset"
+	case db.StreamType:
+		return "stream"
+	case db.StringType:
+		return "string"
+	}
+	return "none"
+}
+
+// scanOptions SCAN系列命令的可选参数
+type scanOptions struct {
+	// 为nil时不过滤
+	pattern *wildcard.Pattern
+	count   int
+	// 只用于SCAN，为空时不过滤
+	typeName string
+	// 只用于HSCAN，为true时只返回field
+	noValues bool
+}
+
+// match 判断key是否匹配MATCH指定的模式
+func (opts *scanOptions) match(key string) bool {
+	return opts.pattern == nil || opts.pattern.IsMatch(key)
+}
+
+// parseScanArgs 解析游标和之后的MATCH、COUNT等参数，cmdName为scan时接受TYPE，为hscan时接受NOVALUES
+func parseScanArgs(cmdName string, cursorArg []byte, args [][]byte) (uint64, *scanOptions, redis.Reply) {
+	cursor, err := strconv.ParseUint(string(cursorArg), 10, 64)
+	if err != nil {
+		return 0, nil, protocol.NewErrorReply("ERR invalid cursor")
+	}
+	opts := &scanOptions{count: 10}
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		remaining := len(args) - i - 1
+		switch {
+		case arg == "MATCH" && remaining > 0:
+			pattern := string(args[i+1])
+			opts.pattern = nil
+			if pattern != "*" {
+				opts.pattern, err = wildcard.CompilePattern(pattern)
+				if err != nil {
+					return 0, nil, protocol.NewErrorReply("ERR pattern is not a valid regex expression")
+				}
+			}
+			i++
+		case arg == "COUNT" && remaining > 0:
+			count, err := parseInt(args[i+1])
+			if err != nil {
+				return 0, nil, protocol.ErrorNotIntegerReply
+			}
+			if count < 1 {
+				return 0, nil, protocol.ErrorSyntaxReply
+			}
+			opts.count = count
+			i++
+		case arg == "TYPE" && remaining > 0 && cmdName == "scan":
+			opts.typeName = strings.ToLower(string(args[i+1]))
+			switch opts.typeName {
+			case "string", "list", "set", "zset", "hash", "stream":
+			default:
+				return 0, nil, protocol.NewErrorReply("ERR unknown type name '" + string(args[i+1]) + "'")
+			}
+			i++
+		case arg == "NOVALUES" && cmdName == "hscan":
+			opts.noValues = true
+		default:
+			return 0, nil, protocol.ErrorSyntaxReply
+		}
+	}
+	return cursor, opts, nil
+}
+
+// scanReply 返回下一次的游标和本次的结果
+func scanReply(cursor uint64, items [][]byte) redis.Reply {
+	return protocol.NewArrayReply([]redis.Reply{
+		protocol.NewBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		protocol.NewMultiBulkReply(items),
+	})
+}
+
+// ScanCommand 使用游标增量遍历数据库中的key，每次只遍历一部分分片，不会像KEYS一样长时间阻塞
+// 游标为0时开始遍历，返回的游标为0时遍历结束；MATCH和TYPE在遍历之后过滤，因此可能返回空结果但游标不为0
+// 遍历开始到结束期间一直存在的key一定会被返回，但同一个key可能返回多次
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func ScanCommand(d *DB, args [][]byte) redis.Reply {
+	cursor, opts, errReply := parseScanArgs("scan", args[0], args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([][]byte, 0)
+	now := time.Now()
+	cursor = d.data.Scan(cursor, opts.count, func(key string, val any) bool {
+		if !opts.match(key) {
+			return true
+		}
+		if opts.typeName != "" && typeName(val.(*db.DataEntity)) != opts.typeName {
+			return true
+		}
+		// 持有分片的锁，不能在这里删除过期的key，只跳过它们
+		if expireTime, ok := d.getExpireTime(key); ok && now.After(expireTime) {
+			return true
+		}
+		keys = append(keys, []byte(key))
+		return true
+	})
+	return scanReply(cursor, keys)
+}
+
+// ObjectCommand 查看key对应value的内部信息，目前只支持ENCODING
+// OBJECT ENCODING key
+// key不存在，返回nil
+func ObjectCommand(d *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "encoding":
+		if len(args) != 2 {
+			return protocol.NewArgNumErrReply("object|encoding")
+		}
+		entity, exists := d.GetEntity(string(args[1]))
+		if !exists {
+			return protocol.NullBulkReply
+		}
+		return protocol.NewBulkReply([]byte(getEncoding(entity)))
+	}
+	return protocol.NewErrorReply("ERR unknown subcommand '" + subCmd + "'. Try OBJECT HELP.")
+}
+
+// getEncoding 返回value的编码，与Redis的编码名称保持一致
+func getEncoding(entity *db.DataEntity) string {
+	switch entity.Type {
+	case db.StringType:
+		val := entity.Data.([]byte)
+		if n, err := strconv.ParseInt(string(val), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(val) {
+			return "int"
+		}
+		if len(val) <= embStrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case db.ListType:
+		switch entity.Data.(type) {
+		case *list.ZipList:
+			return "ziplist"
+		case *list.QuickList:
+			return "quicklist"
+		}
+		return "linkedlist"
+	case db.SetType, db.HashType:
+		return "hashtable"
+	case db.SortedType:
+		return "skiplist"
+	case db.StreamType:
+		return "stream"
+	}
+	return "unknown"
+}
+
 func init() {
 	registerNormalCommand("exists", ExistsCommand, readAllKeys, -2, tagRead)
 	registerNormalCommand("del", DelCommand, writeAllKeys, -2, tagWrite)
-	registerNormalCommand("keys", KeysCommand, noPrepare, 2, tagRead)
-	registerNormalCommand("expire", ExpireCommand, writeFirstKey, -3, tagWrite)
-	registerNormalCommand("expireat", ExpireAtCommand, writeFirstKey, -3, tagWrite)
-	registerNormalCommand("pexpire", PExpireCommand, writeFirstKey, -3, tagWrite)
-	registerNormalCommand("pexpireat", PExpireAtCommand, writeFirstKey, -3, tagWrite)
+	registerNormalCommand("keys", KeysCommand, noPrepare, 2, tagRead|tagNoMulti)
+	registerNormalCommand("scan", ScanCommand, noPrepare, -2, tagRead|tagNoMulti)
+	registerNormalCommand("expire", ExpireCommand, writeFirstKey, -3, tagWrite).attachAof(expireToAof)
+	registerNormalCommand("expireat", ExpireAtCommand, writeFirstKey, -3, tagWrite).attachAof(expireToAof)
+	registerNormalCommand("pexpire", PExpireCommand, writeFirstKey, -3, tagWrite).attachAof(expireToAof)
+	registerNormalCommand("pexpireat", PExpireAtCommand, writeFirstKey, -3, tagWrite).attachAof(expireToAof)
 	registerNormalCommand("expiretime", ExpireTimeCommand, readFirstKey, 2, tagRead)
+	registerNormalCommand("persist", PersistCommand, writeFirstKey, 2, tagWrite)
 	registerNormalCommand("pexpiretime", PExpireTimeCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("ttl", TTLCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("pttl", PTTLCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("type", TypeCommand, readFirstKey, 2, tagRead)
+	registerNormalCommand("flushdb", FlushDBCommand, noPrepare, -1, tagWrite|tagNoMulti)
+	registerNormalCommand("dbsize", DBSizeCommand, noPrepare, 1, tagRead)
+	registerNormalCommand("object", ObjectCommand, prepareObject, -2, tagRead)
 }
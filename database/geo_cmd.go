@@ -0,0 +1,448 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"zedis/datastruct/sortedset"
+	"zedis/interface/redis"
+	"zedis/lib/geohash"
+	"zedis/redis/protocol"
+)
+
+// 地理位置保存在有序集合中，score为经纬度的52位geohash，因此也可以使用ZRANGE、ZREM等命令操作
+
+// parseLonLat 解析经度和纬度，超出geohash能够表示的范围时返回错误
+func parseLonLat(lonArg, latArg []byte) (float64, float64, redis.Reply) {
+	lon, err := parseScore(lonArg)
+	if err != nil {
+		return 0, 0, protocol.ErrorNotValidFloatReply
+	}
+	lat, err := parseScore(latArg)
+	if err != nil {
+		return 0, 0, protocol.ErrorNotValidFloatReply
+	}
+	if !geohash.Valid(lon, lat) {
+		return 0, 0, protocol.NewErrorReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", lon, lat))
+	}
+	return lon, lat, nil
+}
+
+// parseDistanceUnit 解析距离单位，返回每单位对应的米数
+func parseDistanceUnit(arg []byte) (float64, redis.Reply) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, protocol.NewErrorReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// formatCoordinate 格式化经纬度，保留17位小数并去掉末尾的0，与Redis一致
+func formatCoordinate(value float64) []byte {
+	s := strconv.FormatFloat(value, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return []byte(s)
+}
+
+// formatDistance 格式化距离，保留4位小数
+func formatDistance(distance float64) []byte {
+	return []byte(strconv.FormatFloat(distance, 'f', 4, 64))
+}
+
+// GeoAddCommand 添加地理位置，转换为ZADD执行，返回值与ZADD相同
+// GEOADD key [NX | XX] [CH] longitude latitude member [longitude latitude member ...]
+func GeoAddCommand(d *DB, args [][]byte) redis.Reply {
+	zaddArgs := [][]byte{args[0]}
+	nx, xx := false, false
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "NX" {
+			nx = true
+		} else if opt == "XX" {
+			xx = true
+		} else if opt != "CH" {
+			break
+		}
+		zaddArgs = append(zaddArgs, args[i])
+	}
+	if (len(args)-i)%3 != 0 || i == len(args) || (nx && xx) {
+		return protocol.ErrorSyntaxReply
+	}
+	for ; i < len(args); i += 3 {
+		lon, lat, errReply := parseLonLat(args[i], args[i+1])
+		if errReply != nil {
+			return errReply
+		}
+		score, _ := geohash.EncodeWGS84(lon, lat)
+		zaddArgs = append(zaddArgs, []byte(strconv.FormatUint(score, 10)), args[i+2])
+	}
+	return ZAddCommand(d, zaddArgs)
+}
+
+// GeoPosCommand 返回member的经纬度，member不存在时对应位置为nil
+// GEOPOS key [member [member ...]]
+func GeoPosCommand(d *DB, args [][]byte) redis.Reply {
+	zset, errReply := d.getEntityAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	res := make([]redis.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if zset == nil {
+			res = append(res, protocol.NullMultiBulkReply)
+			continue
+		}
+		element, ok := zset.Get(string(member))
+		if !ok {
+			res = append(res, protocol.NullMultiBulkReply)
+			continue
+		}
+		lon, lat := geohash.DecodeWGS84(uint64(element.Score))
+		res = append(res, protocol.NewMultiBulkReply([][]byte{formatCoordinate(lon), formatCoordinate(lat)}))
+	}
+	return protocol.NewArrayReply(res)
+}
+
+// GeoDistCommand 返回两个member之间的距离，默认单位为米，任意一个member不存在时返回nil
+// GEODIST key member1 member2 [M | KM | FT | MI]
+func GeoDistCommand(d *DB, args [][]byte) redis.Reply {
+	if len(args) > 4 {
+		return protocol.ErrorSyntaxReply
+	}
+	unit := 1.0
+	if len(args) == 4 {
+		var errReply redis.Reply
+		unit, errReply = parseDistanceUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
+	}
+	zset, errReply := d.getEntityAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.NullBulkReply
+	}
+	e1, ok1 := zset.Get(string(args[1]))
+	e2, ok2 := zset.Get(string(args[2]))
+	if !ok1 || !ok2 {
+		return protocol.NullBulkReply
+	}
+	lon1, lat1 := geohash.DecodeWGS84(uint64(e1.Score))
+	lon2, lat2 := geohash.DecodeWGS84(uint64(e2.Score))
+	return protocol.NewBulkReply(formatDistance(geohash.Distance(lon1, lat1, lon2, lat2) / unit))
+}
+
+// GeoHashCommand 返回member位置的11位标准geohash字符串，member不存在时对应位置为nil
+// GEOHASH key [member [member ...]]
+func GeoHashCommand(d *DB, args [][]byte) redis.Reply {
+	zset, errReply := d.getEntityAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	res := make([]redis.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if zset == nil {
+			res = append(res, protocol.NullBulkReply)
+			continue
+		}
+		element, ok := zset.Get(string(member))
+		if !ok {
+			res = append(res, protocol.NullBulkReply)
+			continue
+		}
+		res = append(res, protocol.NewBulkReply([]byte(geohash.ToString(uint64(element.Score)))))
+	}
+	return protocol.NewArrayReply(res)
+}
+
+// geoPoint 搜索到的位置
+type geoPoint struct {
+	member   string
+	score    float64
+	lon      float64
+	lat      float64
+	distance float64
+}
+
+// geoSearchOptions GEOSEARCH和GEOSEARCHSTORE的参数
+type geoSearchOptions struct {
+	shape geohash.Shape
+	// 距离单位对应的米数
+	unit      float64
+	count     int64
+	any       bool
+	sort      int
+	withDist  bool
+	withHash  bool
+	withCoord bool
+	storeDist bool
+}
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+// parseGeoSearch 解析GEOSEARCH的参数，args从FROMMEMBER等选项开始，zset为源有序集合，用于查找FROMMEMBER的位置
+func parseGeoSearch(args [][]byte, zset *sortedset.SortedSet, cmdName string, store bool) (*geoSearchOptions, redis.Reply) {
+	opts := &geoSearchOptions{}
+	fromMember, fromLonLat, byRadius, byBox := false, false, false, false
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		remaining := len(args) - i - 1
+		switch {
+		case arg == "WITHDIST":
+			opts.withDist = true
+		case arg == "WITHHASH":
+			opts.withHash = true
+		case arg == "WITHCOORD":
+			opts.withCoord = true
+		case arg == "ANY":
+			opts.any = true
+		case arg == "ASC":
+			opts.sort = geoSortAsc
+		case arg == "DESC":
+			opts.sort = geoSortDesc
+		case arg == "COUNT" && remaining > 0:
+			count, err := parseInt64(args[i+1])
+			if err != nil {
+				return nil, protocol.ErrorNotIntegerReply
+			}
+			if count <= 0 {
+				return nil, protocol.NewErrorReply("ERR COUNT must be > 0")
+			}
+			opts.count = count
+			i++
+		case arg == "STOREDIST" && store:
+			opts.storeDist = true
+		case arg == "FROMMEMBER" && remaining > 0 && !fromMember:
+			var element *sortedset.Element
+			ok := false
+			if zset != nil {
+				element, ok = zset.Get(string(args[i+1]))
+			}
+			if !ok {
+				return nil, protocol.NewErrorReply("ERR could not decode requested zset member")
+			}
+			opts.shape.Lon, opts.shape.Lat = geohash.DecodeWGS84(uint64(element.Score))
+			fromMember = true
+			i++
+		case arg == "FROMLONLAT" && remaining > 1 && !fromLonLat:
+			lon, lat, errReply := parseLonLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.shape.Lon, opts.shape.Lat = lon, lat
+			fromLonLat = true
+			i += 2
+		case arg == "BYRADIUS" && remaining > 1 && !byRadius:
+			radius, err := parseScore(args[i+1])
+			if err != nil {
+				return nil, protocol.ErrorNotValidFloatReply
+			}
+			if radius < 0 {
+				return nil, protocol.NewErrorReply("ERR radius cannot be negative")
+			}
+			unit, errReply := parseDistanceUnit(args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.unit = unit
+			opts.shape.Radius = radius * unit
+			byRadius = true
+			i += 2
+		case arg == "BYBOX" && remaining > 2 && !byBox:
+			width, err1 := parseScore(args[i+1])
+			height, err2 := parseScore(args[i+2])
+			if err1 != nil || err2 != nil {
+				return nil, protocol.ErrorNotValidFloatReply
+			}
+			if width < 0 || height < 0 {
+				return nil, protocol.NewErrorReply("ERR height or width cannot be negative")
+			}
+			unit, errReply := parseDistanceUnit(args[i+3])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.unit = unit
+			opts.shape.IsBox = true
+			opts.shape.Width, opts.shape.Height = width*unit, height*unit
+			byBox = true
+			i += 3
+		default:
+			return nil, protocol.ErrorSyntaxReply
+		}
+	}
+
+	if store && (opts.withDist || opts.withHash || opts.withCoord) {
+		return nil, protocol.NewErrorReply("ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	if fromMember == fromLonLat {
+		return nil, protocol.NewErrorReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+	}
+	if byRadius == byBox {
+		return nil, protocol.NewErrorReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+	}
+	if opts.any && opts.count == 0 {
+		return nil, protocol.NewErrorReply("ERR the ANY argument requires COUNT argument")
+	}
+	// 指定COUNT且没有指定ANY时，需要返回最近的count个位置
+	if opts.count > 0 && !opts.any && opts.sort == geoSortNone {
+		opts.sort = geoSortAsc
+	}
+	return opts, nil
+}
+
+// geoSearchPoints 依次扫描中心所在的geohash区域及其相邻区域，返回在搜索范围内的位置
+// 指定ANY时找到count个位置后立即停止，否则返回所有位置并按距离排序后截取前count个
+func geoSearchPoints(zset *sortedset.SortedSet, opts *geoSearchOptions) []*geoPoint {
+	var limit int
+	if opts.any {
+		limit = int(opts.count)
+	}
+	points := make([]*geoPoint, 0)
+	for _, r := range opts.shape.SearchRanges() {
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		min := &sortedset.ScoreBorder{Value: float64(r.Min)}
+		max := &sortedset.ScoreBorder{Value: float64(r.Max), Exclude: true}
+		zset.ForEach(min, max, 0, -1, false, func(element *sortedset.Element) bool {
+			lon, lat := geohash.DecodeWGS84(uint64(element.Score))
+			if distance, ok := opts.shape.Contains(lon, lat); ok {
+				points = append(points, &geoPoint{
+					member:   element.Member,
+					score:    element.Score,
+					lon:      lon,
+					lat:      lat,
+					distance: distance,
+				})
+			}
+			return limit == 0 || len(points) < limit
+		})
+	}
+
+	switch opts.sort {
+	case geoSortAsc:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].distance < points[j].distance
+		})
+	case geoSortDesc:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].distance > points[j].distance
+		})
+	}
+	if opts.count > 0 && int64(len(points)) > opts.count {
+		points = points[:opts.count]
+	}
+	return points
+}
+
+// GeoSearchCommand 返回位于圆形或矩形范围内的位置
+// GEOSEARCH key <FROMMEMBER member | FROMLONLAT longitude latitude>
+// <BYRADIUS radius <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>>
+// [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+// 指定WITH选项时，每个位置返回一个数组，依次为member、距离、geohash、经纬度
+func GeoSearchCommand(d *DB, args [][]byte) redis.Reply {
+	zset, errReply := d.getEntityAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseGeoSearch(args[1:], zset, "geosearch", false)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.EmptyMultiBulkReply
+	}
+
+	points := geoSearchPoints(zset, opts)
+	if !opts.withDist && !opts.withHash && !opts.withCoord {
+		res := make([][]byte, 0, len(points))
+		for _, p := range points {
+			res = append(res, []byte(p.member))
+		}
+		return protocol.NewMultiBulkReply(res)
+	}
+	res := make([]redis.Reply, 0, len(points))
+	for _, p := range points {
+		item := []redis.Reply{protocol.NewBulkReply([]byte(p.member))}
+		if opts.withDist {
+			item = append(item, protocol.NewBulkReply(formatDistance(p.distance/opts.unit)))
+		}
+		if opts.withHash {
+			item = append(item, protocol.NewIntReply(int64(p.score)))
+		}
+		if opts.withCoord {
+			item = append(item, protocol.NewMultiBulkReply([][]byte{formatCoordinate(p.lon), formatCoordinate(p.lat)}))
+		}
+		res = append(res, protocol.NewArrayReply(item))
+	}
+	return protocol.NewArrayReply(res)
+}
+
+// GeoSearchStoreCommand 与GEOSEARCH相同，但是将结果保存到destination中，返回保存的位置数量
+// 默认保存位置的geohash，指定STOREDIST时保存距离，结果为空时删除destination
+// GEOSEARCHSTORE destination source <FROMMEMBER member | FROMLONLAT longitude latitude>
+// <BYRADIUS radius <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>>
+// [ASC | DESC] [COUNT count [ANY]] [STOREDIST]
+func GeoSearchStoreCommand(d *DB, args [][]byte) redis.Reply {
+	destKey := string(args[0])
+	zset, errReply := d.getEntityAsSortedSet(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseGeoSearch(args[2:], zset, "geosearchstore", true)
+	if errReply != nil {
+		return errReply
+	}
+
+	var points []*geoPoint
+	if zset != nil {
+		points = geoSearchPoints(zset, opts)
+	}
+	if len(points) == 0 {
+		if _, deleted := d.Remove(destKey); deleted > 0 {
+			d.notify(notifyGeneric, "del", destKey)
+		}
+		return protocol.ZeroReply
+	}
+
+	dest := sortedset.NewSortedSet()
+	for _, p := range points {
+		score := p.score
+		if opts.storeDist {
+			score = p.distance / opts.unit
+		}
+		dest.Add(p.member, score)
+	}
+	if d.PutEntity(destKey, buildSortedSetEntity(dest)) == 0 {
+		d.Persist(destKey)
+	}
+	d.notify(notifyZset, "geosearchstore", destKey)
+	return protocol.NewIntReply(int64(len(points)))
+}
+
+func init() {
+	registerNormalCommand("geoadd", GeoAddCommand, writeFirstKey, -5, tagWrite)
+	registerNormalCommand("geopos", GeoPosCommand, readFirstKey, -2, tagRead)
+	registerNormalCommand("geodist", GeoDistCommand, readFirstKey, -4, tagRead)
+	registerNormalCommand("geohash", GeoHashCommand, readFirstKey, -2, tagRead)
+	registerNormalCommand("geosearch", GeoSearchCommand, readFirstKey, -7, tagRead)
+	registerNormalCommand("geosearchstore", GeoSearchStoreCommand, prepareGeoSearchStore, -8, tagWrite)
+}
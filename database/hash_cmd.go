@@ -56,6 +56,7 @@ func HSetCommand(d *DB, args [][]byte) redis.Reply {
 	if !keyExists {
 		d.PutEntity(key, buildHashEntity(hash))
 	}
+	d.notify(notifyHash, "hset", key)
 	return protocol.NewIntReply(int64(insertedCount))
 }
 
@@ -79,6 +80,7 @@ func HSetNXCommand(d *DB, args [][]byte) redis.Reply {
 		return protocol.ZeroReply
 	}
 	hash.Put(field, args[2])
+	d.notify(notifyHash, "hset", key)
 	return protocol.NewIntReply(1)
 }
 
@@ -101,7 +103,7 @@ func HGetCommand(d *DB, args [][]byte) redis.Reply {
 	return protocol.NewBulkReply(v.([]byte))
 }
 
-// HGetAllCommand 获取hash所有key-value键值对；返回格式为数组，一个key后跟对应value；所以数组长度是hash键值对个数的二倍
+// HGetAllCommand 获取hash所有key-value键值对；RESP2下返回格式为数组，一个key后跟对应value，数组长度是hash键值对个数的二倍；RESP3下返回映射
 // HGETALL key
 func HGetAllCommand(d *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
@@ -110,14 +112,14 @@ func HGetAllCommand(d *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if hash == nil {
-		return protocol.EmptyMultiBulkReply
+		return protocol.NewMapReply(nil)
 	}
 	res := make([][]byte, 0)
 	hash.ForEach(func(key string, val any) bool {
 		res = append(res, []byte(key), val.([]byte))
 		return true
 	})
-	return protocol.NewMultiBulkReply(res)
+	return protocol.NewMapReply(protocol.BulkReplies(res))
 }
 
 // HExistsCommand 返回hash中是否存在某个field；不存在返回0，存在返回1
@@ -172,6 +174,35 @@ func HKeysCommand(d *DB, args [][]byte) redis.Reply {
 	return protocol.NewMultiBulkReply(keys)
 }
 
+// HScanCommand 使用游标增量遍历hash中的field和value，用法与SCAN相同，指定NOVALUES时只返回field
+// 遍历开始到结束期间一直存在的field一定会被返回，但hash扩容或缩容后可能返回重复的field
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func HScanCommand(d *DB, args [][]byte) redis.Reply {
+	cursor, opts, errReply := parseScanArgs("hscan", args[1], args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	hash, errReply := d.getEntityAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return scanReply(0, nil)
+	}
+	res := make([][]byte, 0)
+	cursor = hash.Scan(cursor, opts.count, func(field string, val any) bool {
+		if !opts.match(field) {
+			return true
+		}
+		res = append(res, []byte(field))
+		if !opts.noValues {
+			res = append(res, val.([]byte))
+		}
+		return true
+	})
+	return scanReply(cursor, res)
+}
+
 // HDelCommand 删除hash中多个field，如果field不存在，则忽略，返回实际删除的field数量
 // HDEL key field [field ...]
 // 删除完成后，如果hash中没有元素了，则删除该hash
@@ -190,8 +221,12 @@ func HDelCommand(d *DB, args [][]byte) redis.Reply {
 		_, res := hash.Remove(field)
 		deletedCount += res
 	}
+	if deletedCount > 0 {
+		d.notify(notifyHash, "hdel", key)
+	}
 	if hash.Len() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
 	}
 	return protocol.NewIntReply(int64(deletedCount))
 }
@@ -214,6 +249,7 @@ func HIncrByCommand(d *DB, args [][]byte) redis.Reply {
 		hash = dict.NewSimpleDict()
 		hash.Put(field, []byte(strconv.Itoa(increment)))
 		d.PutEntity(key, buildHashEntity(hash))
+		d.notify(notifyHash, "hincrby", key)
 		return protocol.NewIntReply(int64(increment))
 	}
 	var oldValue int
@@ -228,6 +264,7 @@ func HIncrByCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	oldValue += increment
 	hash.Put(field, []byte(strconv.Itoa(oldValue)))
+	d.notify(notifyHash, "hincrby", key)
 	return protocol.NewIntReply(int64(oldValue))
 }
 
@@ -249,6 +286,7 @@ func HIncrByFloatCommand(d *DB, args [][]byte) redis.Reply {
 		hash = dict.NewSimpleDict()
 		hash.Put(field, []byte(increment.String()))
 		d.PutEntity(key, buildHashEntity(hash))
+		d.notify(notifyHash, "hincrbyfloat", key)
 		return protocol.NewBulkReply([]byte(increment.String()))
 	}
 	var oldValue decimal.Decimal
@@ -263,6 +301,7 @@ func HIncrByFloatCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	oldValue = oldValue.Add(increment)
 	hash.Put(field, []byte(oldValue.String()))
+	d.notify(notifyHash, "hincrbyfloat", key)
 	return protocol.NewBulkReply([]byte(oldValue.String()))
 }
 
@@ -401,7 +440,7 @@ func init() {
 	registerNormalCommand("hstrlen", HStrLenCommand, readFirstKey, 3, tagRead)
 	registerNormalCommand("hvals", HValsCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("hrandfield", HRandFieldCommand, readFirstKey, -2, tagRead)
+	registerNormalCommand("hscan", HScanCommand, readFirstKey, -3, tagRead)
 
-	// HScan有点复杂，暂不实现
 	// HMSET 已废弃，HSET可实现相同功能
 }
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"zedis/config"
+	"zedis/datastruct/hll"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+var (
+	errInvalidHLLReply   = protocol.NewErrorReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errCorruptedHLLReply = protocol.NewErrorReply("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// getAsHLL 将key对应的字符串作为HyperLogLog，key不存在时返回nil
+// forWrite为true时复制一份数据，避免修改已经返回给其他客户端的字符串
+func (d *DB) getAsHLL(key string, forWrite bool) (*hll.HLL, protocol.ErrorReply) {
+	data, errReply := d.getEntityAsString(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if data == nil {
+		return nil, nil
+	}
+	if forWrite {
+		data = append([]byte(nil), data...)
+	}
+	h, ok := hll.Load(data, config.Config.HllSparseMaxBytes)
+	if !ok {
+		return nil, errInvalidHLLReply
+	}
+	return h, nil
+}
+
+// PFAddCommand 将元素添加到HyperLogLog中，key不存在时创建，近似基数改变时返回1，否则返回0
+// PFADD key [element [element ...]]
+func PFAddCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	h, errReply := d.getAsHLL(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	updated := false
+	if h == nil {
+		h = hll.New(config.Config.HllSparseMaxBytes)
+		updated = true
+	}
+	for _, element := range args[1:] {
+		changed, err := h.Add(element)
+		if err != nil {
+			return errCorruptedHLLReply
+		}
+		updated = updated || changed
+	}
+	if !updated {
+		return protocol.ZeroReply
+	}
+	d.PutEntity(key, BuildStringEntity(h.Bytes()))
+	d.notify(notifyString, "pfadd", key)
+	return protocol.NewIntReply(1)
+}
+
+// PFCountCommand 返回HyperLogLog的近似基数，多个key时返回它们合并后的近似基数，不存在的key视为空集合
+// 命令在读锁下执行，因此不回写基数缓存，缓存有效时（如从Redis导入的数据）直接使用
+// PFCOUNT key [key ...]
+func PFCountCommand(d *DB, args [][]byte) redis.Reply {
+	if len(args) == 1 {
+		h, errReply := d.getAsHLL(string(args[0]), false)
+		if errReply != nil {
+			return errReply
+		}
+		if h == nil {
+			return protocol.ZeroReply
+		}
+		count, err := h.Count()
+		if err != nil {
+			return errCorruptedHLLReply
+		}
+		return protocol.NewIntReply(int64(count))
+	}
+
+	regs := make([]uint8, hll.Registers)
+	for _, arg := range args {
+		h, errReply := d.getAsHLL(string(arg), false)
+		if errReply != nil {
+			return errReply
+		}
+		if h == nil {
+			continue
+		}
+		if err := h.MergeTo(regs); err != nil {
+			return errCorruptedHLLReply
+		}
+	}
+	return protocol.NewIntReply(int64(hll.CountRegisters(regs)))
+}
+
+// PFMergeCommand 将所有HyperLogLog合并后保存到destkey，destkey已存在时也参与合并
+// 任意一个HyperLogLog使用稠密编码时，结果使用稠密编码
+// PFMERGE destkey [sourcekey [sourcekey ...]]
+func PFMergeCommand(d *DB, args [][]byte) redis.Reply {
+	regs := make([]uint8, hll.Registers)
+	useDense := false
+	for _, arg := range args {
+		h, errReply := d.getAsHLL(string(arg), false)
+		if errReply != nil {
+			return errReply
+		}
+		if h == nil {
+			continue
+		}
+		useDense = useDense || h.IsDense()
+		if err := h.MergeTo(regs); err != nil {
+			return errCorruptedHLLReply
+		}
+	}
+
+	destKey := string(args[0])
+	dest, _ := d.getAsHLL(destKey, true)
+	if dest == nil {
+		dest = hll.New(config.Config.HllSparseMaxBytes)
+	}
+	if useDense {
+		if err := dest.ToDense(); err != nil {
+			return errCorruptedHLLReply
+		}
+	}
+	if err := dest.SetRegisters(regs); err != nil {
+		return errCorruptedHLLReply
+	}
+	d.PutEntity(destKey, BuildStringEntity(dest.Bytes()))
+	d.notify(notifyString, "pfadd", destKey)
+	return protocol.OKReply
+}
+
+func init() {
+	registerNormalCommand("pfadd", PFAddCommand, writeFirstKey, -2, tagWrite)
+	registerNormalCommand("pfcount", PFCountCommand, readAllKeys, -2, tagRead)
+	registerNormalCommand("pfmerge", PFMergeCommand, preparePFMerge, -2, tagWrite)
+}
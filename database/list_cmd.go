@@ -3,26 +3,17 @@ package database
 import (
 	"errors"
 	"github.com/duke-git/lancet/v2/mathutil"
+	"math"
+	"strconv"
 	"strings"
 	"time"
+	"zedis/config"
 	"zedis/datastruct/list"
 	"zedis/interface/db"
 	"zedis/interface/redis"
 	"zedis/redis/protocol"
 )
 
-// KeyValue 用于阻塞命令 的channel数据传输
-type KeyValue struct {
-	key   []byte
-	value []byte
-}
-
-// KeyValues 用于阻塞命令 的channel数据传输
-type KeyValues struct {
-	key    []byte
-	values [][]byte
-}
-
 func buildListEntity(data list.List) *db.DataEntity {
 	return &db.DataEntity{
 		Data: data,
@@ -41,6 +32,48 @@ func (d *DB) getEntityAsList(key string) (list.List, redis.Reply) {
 	return entity.Data.(list.List), nil
 }
 
+// newList 新建列表，元素数量和长度都不超过阈值时使用ziplist编码，否则使用quicklist编码
+func newList(values [][]byte) list.List {
+	if fitZipList(len(values), values) {
+		return list.NewZipList(values)
+	}
+	return newQuickList(values)
+}
+
+func newQuickList(values [][]byte) *list.QuickList {
+	return list.NewQuickList(values, config.Config.ListMaxZiplistSize, config.Config.ListCompressDepth)
+}
+
+// fitZipList 判断长度为length、包含values的列表能否使用ziplist编码
+func fitZipList(length int, values [][]byte) bool {
+	if length > config.Config.ListMaxZiplistEntries {
+		return false
+	}
+	for _, value := range values {
+		if len(value) > config.Config.ListMaxZiplistValue {
+			return false
+		}
+	}
+	return true
+}
+
+// convertListIfNeeded 向列表写入values之前调用，写入后列表长度为length
+// ziplist写入后超过阈值时，转换为quicklist编码并替换key对应的列表，返回转换后的列表；列表不会再转换回ziplist
+func (d *DB) convertListIfNeeded(key string, l list.List, length int, values [][]byte) list.List {
+	zipList, ok := l.(*list.ZipList)
+	if !ok || fitZipList(length, values) {
+		return l
+	}
+	quickList := newQuickList(nil)
+	zipList.ForEach(func(index int, v []byte) bool {
+		quickList.AddLast(v)
+		return true
+	})
+	entity, _ := d.GetEntity(key)
+	entity.Data = quickList
+	return quickList
+}
+
 // LPushCommand 向列表头插入元素，返回插入后的列表长度
 // LPUSH key element [element ...]
 // key不存在，创建一个空列表
@@ -52,13 +85,14 @@ func LPushCommand(d *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if l == nil {
-		l = list.NewList(args[1:])
+		l = newList(nil)
 		d.PutEntity(key, buildListEntity(l))
-	} else {
-		for _, arg := range args[1:] {
-			l.AddFirst(arg)
-		}
 	}
+	l = d.convertListIfNeeded(key, l, l.Length()+len(args)-1, args[1:])
+	for _, arg := range args[1:] {
+		l.AddFirst(arg)
+	}
+	d.notify(notifyList, "lpush", key)
 	return protocol.NewIntReply(int64(l.Length()))
 }
 
@@ -74,11 +108,12 @@ func LPushXCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	if l == nil {
 		return protocol.ZeroReply
-	} else {
-		for _, arg := range args[1:] {
-			l.AddFirst(arg)
-		}
 	}
+	l = d.convertListIfNeeded(key, l, l.Length()+len(args)-1, args[1:])
+	for _, arg := range args[1:] {
+		l.AddFirst(arg)
+	}
+	d.notify(notifyList, "lpush", key)
 	return protocol.NewIntReply(int64(l.Length()))
 }
 
@@ -93,13 +128,14 @@ func RPushCommand(d *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if l == nil {
-		l = list.NewList(args[1:])
+		l = newList(nil)
 		d.PutEntity(key, buildListEntity(l))
-	} else {
-		for _, arg := range args[1:] {
-			l.AddLast(arg)
-		}
 	}
+	l = d.convertListIfNeeded(key, l, l.Length()+len(args)-1, args[1:])
+	for _, arg := range args[1:] {
+		l.AddLast(arg)
+	}
+	d.notify(notifyList, "rpush", key)
 	return protocol.NewIntReply(int64(l.Length()))
 }
 
@@ -115,11 +151,12 @@ func RPushXCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	if l == nil {
 		return protocol.ZeroReply
-	} else {
-		for _, arg := range args[1:] {
-			l.AddLast(arg)
-		}
 	}
+	l = d.convertListIfNeeded(key, l, l.Length()+len(args)-1, args[1:])
+	for _, arg := range args[1:] {
+		l.AddLast(arg)
+	}
+	d.notify(notifyList, "rpush", key)
 	return protocol.NewIntReply(int64(l.Length()))
 }
 
@@ -155,92 +192,19 @@ func LPopCommand(d *DB, args [][]byte) redis.Reply {
 	for i := 0; i < count; i++ {
 		values = append(values, l.RemoveFirst())
 	}
+	d.notify(notifyList, "lpop", key)
 	if l.Length() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
 	}
 	return protocol.NewMultiBulkReply(values)
 }
 
 // BLPopCommand 从第一个非空列表的表头弹出元素，并返回一个数组，第一个元素是非空的key，第二个是弹出的元素值；如果所有key都不存在，则阻塞该客户端的连接
-// 直到某一个key对应list插入了元素；最后一个参数指定以秒为单位的超时时间，如果是0，则表示不设置超时时间
+// 直到某一个key对应list插入了元素；最后一个参数指定以秒为单位的超时时间，可以是小数，如果是0，则表示永久阻塞
 // BLPOP key [key ...] timeout
 func BLPopCommand(d *DB, args [][]byte) redis.Reply {
-	timeout, err := parseInt(args[len(args)-1])
-	if err != nil {
-		return protocol.NewErrorReply("ERR timeout is not an integer or out of range")
-	}
-	if timeout < 0 {
-		return protocol.NewErrorReply("ERR timeout is negative")
-	}
-
-	numKeys := len(args) - 1
-	keys := make([]string, 0)
-	for i := 0; i < numKeys; i++ {
-		key := string(args[i])
-		l, errReply := d.getEntityAsList(key)
-		if errReply != nil {
-			return errReply
-		}
-		if l != nil {
-			val := l.RemoveFirst()
-			if l.Length() == 0 {
-				d.Remove(key)
-			}
-			return protocol.NewMultiBulkReply([][]byte{args[i], val})
-		}
-		keys = append(keys, key)
-	}
-
-	kvChan := make(chan *KeyValue)
-
-	go func(d *DB, kvChan chan *KeyValue) {
-		timeTicker := time.Tick(time.Duration(timeout) * time.Second)
-		idx := 0
-		for {
-			select {
-			case <-timeTicker:
-				kvChan <- nil
-				return
-			default:
-				if idx == len(keys) {
-					idx = 0
-				}
-
-				kv := func() *KeyValue {
-					key := keys[idx]
-
-					d.RWLocks([]string{key}, nil)
-					defer d.RWUnLocks([]string{key}, nil)
-
-					l, _ := d.getEntityAsList(key)
-					if l != nil {
-						val := l.RemoveFirst()
-						if l.Length() == 0 {
-							d.Remove(key)
-						}
-						return &KeyValue{key: []byte(key), value: val}
-					}
-					return nil
-
-				}()
-
-				if kv != nil {
-					kvChan <- kv
-					return
-				}
-
-				idx++
-			}
-		}
-
-	}(d, kvChan)
-
-	kv := <-kvChan
-	if kv == nil {
-		return protocol.NullBulkReply
-	} else {
-		return protocol.NewMultiBulkReply([][]byte{kv.key, kv.value})
-	}
+	return blockingPop(d, args, true)
 }
 
 // RPopCommand 从列表末尾弹出元素并返回，count指定弹出的数量
@@ -275,92 +239,48 @@ func RPopCommand(d *DB, args [][]byte) redis.Reply {
 	for i := 0; i < count; i++ {
 		values = append(values, l.RemoveLast())
 	}
+	d.notify(notifyList, "rpop", key)
 	if l.Length() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
 	}
 	return protocol.NewMultiBulkReply(values)
 }
 
 // BRPopCommand 从第一个非空列表的末尾弹出元素，并返回一个数组，第一个元素是非空的key，第二个是弹出的元素值；如果所有key都不存在，则阻塞该客户端的连接
-// 直到某一个key对应list插入了元素；最后一个参数指定以秒为单位的超时时间，如果是0，则表示不设置超时时间
+// 直到某一个key对应list插入了元素；最后一个参数指定以秒为单位的超时时间，可以是小数，如果是0，则表示永久阻塞
 // BRPOP key [key ...] timeout
 func BRPopCommand(d *DB, args [][]byte) redis.Reply {
-	timeout, err := parseInt(args[len(args)-1])
-	if err != nil {
-		return protocol.NewErrorReply("ERR timeout is not an integer or out of range")
-	}
-	if timeout < 0 {
-		return protocol.NewErrorReply("ERR timeout is negative")
+	return blockingPop(d, args, false)
+}
+
+// blockingPop BLPOP、BRPOP的实现，left表示从表头弹出
+// 所有key都不存在时返回blockReply，由DB挂起客户端，有元素插入时重新执行
+func blockingPop(d *DB, args [][]byte, left bool) redis.Reply {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
 	}
 
-	numKeys := len(args) - 1
-	keys := make([]string, 0)
-	for i := 0; i < numKeys; i++ {
-		key := string(args[i])
+	keys := make([]string, 0, len(args)-1)
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
 		l, errReply := d.getEntityAsList(key)
 		if errReply != nil {
 			return errReply
 		}
 		if l != nil {
-			val := l.RemoveFirst()
+			val := popFromList(l, left)
+			d.notify(notifyList, popEvent(left), key)
 			if l.Length() == 0 {
 				d.Remove(key)
+				d.notify(notifyGeneric, "del", key)
 			}
-			return protocol.NewMultiBulkReply([][]byte{args[i], val})
+			return protocol.NewMultiBulkReply([][]byte{arg, val})
 		}
 		keys = append(keys, key)
 	}
-
-	kvChan := make(chan *KeyValue)
-
-	go func(d *DB, kvChan chan *KeyValue) {
-		timeTicker := time.Tick(time.Duration(timeout) * time.Second)
-		idx := 0
-		for {
-			select {
-			case <-timeTicker:
-				kvChan <- nil
-				return
-			default:
-				if idx == len(keys) {
-					idx = 0
-				}
-
-				kv := func() *KeyValue {
-					key := keys[idx]
-
-					d.RWLocks([]string{key}, nil)
-					defer d.RWUnLocks([]string{key}, nil)
-
-					l, _ := d.getEntityAsList(key)
-					if l != nil {
-						val := l.RemoveLast()
-						if l.Length() == 0 {
-							d.Remove(key)
-						}
-						return &KeyValue{key: []byte(key), value: val}
-					}
-					return nil
-
-				}()
-
-				if kv != nil {
-					kvChan <- kv
-					return
-				}
-
-				idx++
-			}
-		}
-
-	}(d, kvChan)
-
-	kv := <-kvChan
-	if kv == nil {
-		return protocol.NullBulkReply
-	} else {
-		return protocol.NewMultiBulkReply([][]byte{kv.key, kv.value})
-	}
+	return newBlockReply(keys, timeout, protocol.NullMultiBulkReply)
 }
 
 // LLenCommand 返回list长度
@@ -491,11 +411,13 @@ func LInsertCommand(d *DB, args [][]byte) redis.Reply {
 	if index == -1 {
 		return protocol.NewIntReply(-1)
 	}
+	l = d.convertListIfNeeded(key, l, l.Length()+1, [][]byte{element})
 	if location == "after" {
 		l.Insert(index+1, element)
 	} else {
 		l.Insert(index, element)
 	}
+	d.notify(notifyList, "linsert", key)
 
 	return protocol.NewIntReply(int64(l.Length()))
 }
@@ -526,8 +448,12 @@ func LRemCommand(d *DB, args [][]byte) redis.Reply {
 	} else {
 		deletedCount = l.RemoveByValFromTail(element, -count)
 	}
+	if deletedCount > 0 {
+		d.notify(notifyList, "lrem", key)
+	}
 	if l.Length() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
 	}
 	return protocol.NewIntReply(int64(deletedCount))
 }
@@ -556,7 +482,9 @@ func LSetCommand(d *DB, args [][]byte) redis.Reply {
 	if index < 0 || index > l.Length()-1 {
 		return protocol.ErrorIndexOutOfRangeReply
 	}
+	l = d.convertListIfNeeded(key, l, l.Length(), [][]byte{element})
 	l.Set(index, element)
+	d.notify(notifyList, "lset", key)
 	return protocol.OKReply
 }
 
@@ -589,6 +517,8 @@ func LTrimCommand(d *DB, args [][]byte) redis.Reply {
 	start, err = adjustIndex(length, start)
 	if err != nil {
 		d.Remove(key)
+		d.notify(notifyList, "ltrim", key)
+		d.notify(notifyGeneric, "del", key)
 		return protocol.OKReply
 	}
 	stop, err = adjustIndex(length, stop)
@@ -597,6 +527,8 @@ func LTrimCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	if start > stop {
 		d.Remove(key)
+		d.notify(notifyList, "ltrim", key)
+		d.notify(notifyGeneric, "del", key)
 		return protocol.OKReply
 	}
 
@@ -606,6 +538,7 @@ func LTrimCommand(d *DB, args [][]byte) redis.Reply {
 	for i := 0; i < length-1-stop; i++ {
 		l.RemoveLast()
 	}
+	d.notify(notifyList, "ltrim", key)
 	return protocol.OKReply
 }
 
@@ -614,21 +547,26 @@ func LTrimCommand(d *DB, args [][]byte) redis.Reply {
 // 如果source 不存在，返回nil
 // source和destination可以为同一个key
 func LMoveCommand(d *DB, args [][]byte) redis.Reply {
-	source := string(args[0])
-	dest := string(args[1])
-
 	sourceLoc := strings.ToLower(string(args[2]))
 	destLoc := strings.ToLower(string(args[3]))
-	if sourceLoc != "left" && sourceLoc != "right" {
+	if (sourceLoc != "left" && sourceLoc != "right") || (destLoc != "left" && destLoc != "right") {
 		return protocol.ErrorSyntaxReply
 	}
-	if destLoc != "left" && destLoc != "right" {
-		return protocol.ErrorSyntaxReply
+	val, errReply := d.moveListElement(string(args[0]), string(args[1]), sourceLoc == "left", destLoc == "left")
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return protocol.NullBulkReply
 	}
+	return protocol.NewBulkReply(val)
+}
 
+// moveListElement 将source的第一个/最后一个元素移动到destination的头/尾，source不存在时返回nil
+func (d *DB) moveListElement(source, dest string, fromLeft, toLeft bool) ([]byte, redis.Reply) {
 	sourceList, errReply := d.getEntityAsList(source)
 	if errReply != nil {
-		return errReply
+		return nil, errReply
 	}
 	var destList list.List
 	if source == dest {
@@ -636,41 +574,39 @@ func LMoveCommand(d *DB, args [][]byte) redis.Reply {
 	} else {
 		destList, errReply = d.getEntityAsList(dest)
 		if errReply != nil {
-			return errReply
+			return nil, errReply
 		}
 	}
 
 	if sourceList == nil {
-		return protocol.NullBulkReply
+		return nil, nil
 	}
 	if destList == nil {
-		destList = list.NewEmptyList()
+		destList = newList(nil)
 		d.PutEntity(dest, buildListEntity(destList))
 	}
 
-	var val []byte
-	if sourceLoc == "left" {
-		val = sourceList.RemoveFirst()
-	} else {
-		val = sourceList.RemoveLast()
-	}
-
-	if sourceList.Length() == 0 {
+	val := popFromList(sourceList, fromLeft)
+	d.notify(notifyList, popEvent(fromLeft), source)
+	// source和destination相同时，元素会被放回列表，不能删除key
+	if sourceList.Length() == 0 && source != dest {
 		d.Remove(source)
+		d.notify(notifyGeneric, "del", source)
 	}
+	destList = d.convertListIfNeeded(dest, destList, destList.Length()+1, [][]byte{val})
 
-	if destLoc == "left" {
+	if toLeft {
 		destList.AddFirst(val)
 	} else {
 		destList.AddLast(val)
 	}
-
-	return protocol.NewBulkReply(val)
+	d.notify(notifyList, pushEvent(toLeft), dest)
+	return val, nil
 }
 
 // BLMoveCommand 将source的第一个/最后一个 拿出来，放到destination 列表头/尾，返回操作的元素值
 // BLMOVE source destination <LEFT | RIGHT> <LEFT | RIGHT> timeout
-// 如果source 不存在，返回nil
+// 如果source 不存在，则阻塞直到source插入了元素，超时返回nil
 // source和destination可以为同一个key
 func BLMoveCommand(d *DB, args [][]byte) redis.Reply {
 	source := string(args[0])
@@ -681,114 +617,17 @@ func BLMoveCommand(d *DB, args [][]byte) redis.Reply {
 	if (sourceLoc != "left" && sourceLoc != "right") || (destLoc != "left" && destLoc != "right") {
 		return protocol.ErrorSyntaxReply
 	}
-	timeout, err := parseInt(args[4])
-	if err != nil {
-		return protocol.ErrorSyntaxReply
-	}
-	if timeout < 0 {
-		return protocol.NewErrorReply("ERR timeout is negative")
-	}
-
-	d.RWLocks([]string{source, dest}, nil)
-
-	sourceList, errReply := d.getEntityAsList(source)
+	timeout, errReply := parseBlockTimeout(args[4])
 	if errReply != nil {
 		return errReply
 	}
-	var destList list.List
-	if source == dest {
-		destList = sourceList
-	} else {
-		destList, errReply = d.getEntityAsList(dest)
-		if errReply != nil {
-			return errReply
-		}
-	}
-
-	if sourceList != nil {
-		if destList == nil {
-			destList = list.NewEmptyList()
-			d.PutEntity(dest, buildListEntity(destList))
-		}
 
-		var val []byte
-		if sourceLoc == "left" {
-			val = sourceList.RemoveFirst()
-		} else {
-			val = sourceList.RemoveLast()
-		}
-
-		if sourceList.Length() == 0 {
-			d.Remove(source)
-		}
-
-		if destLoc == "left" {
-			destList.AddFirst(val)
-		} else {
-			destList.AddLast(val)
-		}
-
-		d.RWUnLocks([]string{source, dest}, nil)
-		return protocol.NewBulkReply(val)
-	} else {
-		d.RWUnLocks([]string{source, dest}, nil)
+	val, errReply := d.moveListElement(source, dest, sourceLoc == "left", destLoc == "left")
+	if errReply != nil {
+		return errReply
 	}
-
-	valChan := make(chan []byte)
-	go func(d *DB, valChan chan []byte) {
-		timeTicker := time.Tick(time.Duration(timeout) * time.Second)
-		for {
-			select {
-			case <-timeTicker:
-				valChan <- nil
-				return
-			default:
-				val := func() []byte {
-					d.RWLocks([]string{source}, nil)
-					defer d.RWUnLocks([]string{source}, nil)
-
-					l, _ := d.getEntityAsList(source)
-					if l == nil {
-						return nil
-					}
-
-					var val []byte
-					if sourceLoc == "left" {
-						val = l.RemoveFirst()
-					} else {
-						val = l.RemoveLast()
-					}
-					if l.Length() == 0 {
-						d.Remove(source)
-					}
-					return val
-				}()
-				if val != nil {
-					valChan <- val
-					return
-				}
-
-			}
-		}
-
-	}(d, valChan)
-
-	val := <-valChan
 	if val == nil {
-		return protocol.NullBulkReply
-	}
-
-	d.RWLocks([]string{dest}, nil)
-	defer d.RWUnLocks([]string{dest}, nil)
-
-	if destList == nil {
-		destList = list.NewEmptyList()
-		d.PutEntity(dest, buildListEntity(destList))
-	}
-	if destLoc == "left" {
-		destList.AddFirst(val)
-	} else {
-		destList.AddLast(val)
+		return newBlockReply([]string{source}, timeout, protocol.NullBulkReply)
 	}
 	return protocol.NewBulkReply(val)
 }
@@ -798,201 +637,126 @@ func BLMoveCommand(d *DB, args [][]byte) redis.Reply {
 // 返回格式为：第一行为非空列表对应的key； 后面是弹出的元素列表
 // 注意：虽然给定了多个key，但是只从第一个遇到的非空列表弹出，后面的key就不再处理
 func LMPopCommand(d *DB, args [][]byte) redis.Reply {
-	numkeys, err := parseInt(args[0])
-	if err != nil {
-		return protocol.ErrorSyntaxReply
+	keys, left, count, errReply := parseMPopArgs(args)
+	if errReply != nil {
+		return errReply
 	}
-	if numkeys <= 0 {
-		return protocol.NullBulkReply
+	reply := d.popFromFirstList(keys, left, count)
+	if reply == nil {
+		return protocol.NullMultiBulkReply
 	}
-	if len(args) < 2+numkeys || len(args) > 4+numkeys {
-		return protocol.ErrorSyntaxReply
+	return reply
+}
+
+// parseMPopArgs 解析LMPOP、BLMPOP的参数: numkeys key [key ...] <LEFT | RIGHT> [COUNT count]
+func parseMPopArgs(args [][]byte) (keys []string, left bool, count int, errReply redis.Reply) {
+	numKeys, err := parseInt(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, protocol.NewErrorReply("ERR numkeys should be greater than 0")
+	}
+	if len(args) != 2+numKeys && len(args) != 4+numKeys {
+		return nil, false, 0, protocol.ErrorSyntaxReply
+	}
+	for _, arg := range args[1 : 1+numKeys] {
+		keys = append(keys, string(arg))
 	}
-	loc := strings.ToLower(string(args[1+numkeys]))
+	loc := strings.ToLower(string(args[1+numKeys]))
 	if loc != "left" && loc != "right" {
-		return protocol.ErrorSyntaxReply
+		return nil, false, 0, protocol.ErrorSyntaxReply
 	}
-	count := 0
-	if len(args) == 3+numkeys {
-		count, err = parseInt(args[2+numkeys])
-		if err != nil {
-			return protocol.ErrorSyntaxReply
+	count = 1
+	if len(args) == 4+numKeys {
+		if strings.ToLower(string(args[2+numKeys])) != "count" {
+			return nil, false, 0, protocol.ErrorSyntaxReply
 		}
-		if count <= 0 {
-			return protocol.NullBulkReply
+		count, err = parseInt(args[3+numKeys])
+		if err != nil || count <= 0 {
+			return nil, false, 0, protocol.NewErrorReply("ERR count should be greater than 0")
 		}
 	}
+	return keys, loc == "left", count, nil
+}
 
-	var l list.List
-	var key string
-	var errReply redis.Reply
-	for i := 1; i < numkeys+1; i++ {
-		l, errReply = d.getEntityAsList(string(args[i]))
+// popFromFirstList 从第一个非空列表弹出最多count个元素，所有列表都不存在时返回nil
+func (d *DB) popFromFirstList(keys []string, left bool, count int) redis.Reply {
+	for _, key := range keys {
+		l, errReply := d.getEntityAsList(key)
 		if errReply != nil {
 			return errReply
 		}
-		if l != nil {
-			key = string(args[i])
-			break
+		if l == nil {
+			continue
 		}
-	}
-
-	if l == nil {
-		return protocol.NullBulkReply
-	}
-
-	writeKeys := []string{key}
-	d.RWLocks(writeKeys, nil)
-	defer d.RWUnLocks(writeKeys, nil)
-
-	count = mathutil.Min(count, l.Length())
-
-	values := make([][]byte, 0)
-	for i := 0; i < count; i++ {
-		if loc == "left" {
-			values = append(values, l.RemoveFirst())
-		} else {
-			values = append(values, l.RemoveLast())
+		count = mathutil.Min(count, l.Length())
+		values := make([][]byte, 0, count)
+		for i := 0; i < count; i++ {
+			values = append(values, popFromList(l, left))
 		}
+		d.notify(notifyList, popEvent(left), key)
+		if l.Length() == 0 {
+			d.Remove(key)
+			d.notify(notifyGeneric, "del", key)
+		}
+		return protocol.NewArrayReply([]redis.Reply{protocol.NewBulkReply([]byte(key)), protocol.NewMultiBulkReply(values)})
 	}
-	if l.Length() == 0 {
-		d.Remove(key)
-	}
-
-	res := protocol.NewArrayReply([]redis.Reply{protocol.NewBulkReply([]byte(key)), protocol.NewMultiBulkReply(values)})
-	return res
+	return nil
 }
 
-// BLMPopCommand 根据传递的参数，从第一个非空列表的左侧或者右侧弹出元素，弹出的数量是count(默认为1)和列表长度的较小值；如果所有key的list都为空，则阻塞至超时或领一个客户端向其中一个key push元素
+// BLMPopCommand 根据传递的参数，从第一个非空列表的左侧或者右侧弹出元素，弹出的数量是count(默认为1)和列表长度的较小值；如果所有key的list都为空，则阻塞至超时或另一个客户端向其中一个key push元素
 // BLMPOP timeout numkeys key [key ...] <LEFT | RIGHT> [COUNT count]
 // 返回格式为：第一行为非空列表对应的key； 后面是弹出的元素列表
 // 注意：虽然给定了多个key，但是只从第一个遇到的非空列表弹出，后面的key就不再处理
 // 如果timeout超时，则返回null
 func BLMPopCommand(d *DB, args [][]byte) redis.Reply {
-	timeout, err := parseInt(args[0])
-	if err != nil {
-		return protocol.ErrorSyntaxReply
-	}
-	if timeout < 0 {
-		return protocol.NewErrorReply("ERR timeout is negative")
-	}
-	numkeys, err := parseInt(args[1])
-	if err != nil {
-		return protocol.ErrorSyntaxReply
-	}
-	if numkeys <= 0 {
-		return protocol.NullBulkReply
+	timeout, errReply := parseBlockTimeout(args[0])
+	if errReply != nil {
+		return errReply
 	}
-	if len(args) < 3+numkeys || len(args) > 5+numkeys {
-		return protocol.ErrorSyntaxReply
+	keys, left, count, errReply := parseMPopArgs(args[1:])
+	if errReply != nil {
+		return errReply
 	}
-	loc := strings.ToLower(string(args[2+numkeys]))
-	if loc != "left" && loc != "right" {
-		return protocol.ErrorSyntaxReply
-	}
-	count := 1
-	if len(args) == 5+numkeys {
-		count, err = parseInt(args[4+numkeys])
-		if err != nil {
-			return protocol.ErrorSyntaxReply
-		}
-		if count <= 0 {
-			return protocol.NullBulkReply
-		}
+	reply := d.popFromFirstList(keys, left, count)
+	if reply == nil {
+		return newBlockReply(keys, timeout, protocol.NullMultiBulkReply)
 	}
+	return reply
+}
 
-	var l list.List
-	var key string
-	var errReply redis.Reply
-	keys := make([]string, 0)
-	for i := 2; i < numkeys+2; i++ {
-		l, errReply = d.getEntityAsList(string(args[i]))
-		if errReply != nil {
-			return errReply
-		}
-		if l != nil {
-			key = string(args[i])
-			break
-		}
-		keys = append(keys, string(args[i]))
+// popFromList 从列表头或尾弹出一个元素
+func popFromList(l list.List, left bool) []byte {
+	if left {
+		return l.RemoveFirst()
 	}
+	return l.RemoveLast()
+}
 
-	if l != nil {
-		writeKeys := []string{key}
-		d.RWLocks(writeKeys, nil)
-		defer d.RWUnLocks(writeKeys, nil)
-
-		count = mathutil.Min(count, l.Length())
-
-		values := make([][]byte, 0)
-		for i := 0; i < count; i++ {
-			if loc == "left" {
-				values = append(values, l.RemoveFirst())
-			} else {
-				values = append(values, l.RemoveLast())
-			}
-		}
-		if l.Length() == 0 {
-			d.Remove(key)
-		}
-
-		res := protocol.NewArrayReply([]redis.Reply{protocol.NewBulkReply([]byte(key)), protocol.NewMultiBulkReply(values)})
-		return res
+// popEvent 从列表头或尾弹出元素的键空间通知事件名
+func popEvent(left bool) string {
+	if left {
+		return "lpop"
 	}
+	return "rpop"
+}
 
-	// 如果所有key都不存在，则开始进行异步、阻塞逻辑
-	kvChan := make(chan *KeyValues)
-	go func(d *DB, kvChan chan *KeyValues) {
-		timeTicker := time.Tick(time.Duration(timeout) * time.Second)
-		idx := 0
-		for {
-			select {
-			case <-timeTicker:
-				kvChan <- nil
-				return
-			default:
-				if idx == len(keys) {
-					idx = 0
-				}
-				kvFunc := func() *KeyValues {
-					key := keys[idx]
-					d.RWLocks([]string{key}, nil)
-					defer d.RWUnLocks([]string{key}, nil)
-
-					l, _ := d.getEntityAsList(key)
-					if l != nil {
-						values := make([][]byte, 0)
-						count := mathutil.Min(count, l.Length())
-						for len(values) < count {
-							if loc == "left" {
-								values = append(values, l.RemoveFirst())
-							} else {
-								values = append(values, l.RemoveLast())
-							}
-						}
-						if l.Length() == 0 {
-							d.Remove(key)
-						}
-						return &KeyValues{key: []byte(key), values: values}
-					}
-					return nil
-				}
-				kv := kvFunc()
-				if kv != nil {
-					kvChan <- kv
-					return
-				}
-				idx++
-
-			}
-		}
-	}(d, kvChan)
+// pushEvent 向列表头或尾插入元素的键空间通知事件名
+func pushEvent(left bool) string {
+	if left {
+		return "lpush"
+	}
+	return "rpush"
+}
 
-	kv := <-kvChan
-	if kv == nil {
-		return protocol.NullBulkReply
+// parseBlockTimeout 解析阻塞命令以秒为单位的超时时间，可以是小数，0表示永久阻塞
+func parseBlockTimeout(arg []byte) (time.Duration, redis.Reply) {
+	timeout, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, protocol.NewErrorReply("ERR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, protocol.NewErrorReply("ERR timeout is negative")
 	}
-	return protocol.NewArrayReply([]redis.Reply{protocol.NewBulkReply(kv.key), protocol.NewMultiBulkReply(kv.values)})
+	return time.Duration(timeout * float64(time.Second)), nil
 }
 
 func init() {
@@ -1001,9 +765,9 @@ func init() {
 	registerNormalCommand("rpush", RPushCommand, writeFirstKey, -2, tagWrite)
 	registerNormalCommand("rpushx", RPushXCommand, writeFirstKey, -2, tagWrite)
 	registerNormalCommand("lpop", LPopCommand, writeFirstKey, -2, tagWrite)
-	registerNormalCommand("blpop", BLPopCommand, nil, -3, tagWrite)
+	registerNormalCommand("blpop", BLPopCommand, writeAllKeysExceptLast, -3, tagWrite).attachAof(bPopToAof("lpop"))
 	registerNormalCommand("rpop", RPopCommand, writeFirstKey, -2, tagWrite)
-	registerNormalCommand("brpop", BRPopCommand, nil, -3, tagWrite)
+	registerNormalCommand("brpop", BRPopCommand, writeAllKeysExceptLast, -3, tagWrite).attachAof(bPopToAof("rpop"))
 	registerNormalCommand("llen", LLenCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("lindex", LIndexCommand, readFirstKey, 3, tagRead)
 	registerNormalCommand("lrange", LRangeCommand, readFirstKey, 4, tagRead)
@@ -1012,9 +776,9 @@ func init() {
 	registerNormalCommand("lset", LSetCommand, writeFirstKey, 4, tagWrite)
 	registerNormalCommand("ltrim", LTrimCommand, writeFirstKey, 4, tagWrite)
 	registerNormalCommand("lmove", LMoveCommand, prepareLmove, 5, tagWrite)
-	registerNormalCommand("blmove", BLMoveCommand, nil, 6, tagWrite)
-	registerNormalCommand("lmpop", LMPopCommand, nil, -2, tagWrite)
-	registerNormalCommand("blmpop", BLMPopCommand, nil, -5, tagWrite)
+	registerNormalCommand("blmove", BLMoveCommand, prepareLmove, 6, tagWrite).attachAof(blMoveToAof)
+	registerNormalCommand("lmpop", LMPopCommand, prepareLMPop, -4, tagWrite)
+	registerNormalCommand("blmpop", BLMPopCommand, prepareBLMPop, -5, tagWrite).attachAof(blmPopToAof)
 
 	// RPOPLPUSH, BRPOPLPUSH  已废弃
 	// LPOS 有点麻烦，后续实现
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"zedis/interface/redis"
+	"zedis/rdb"
+	"zedis/redis/client"
+	"zedis/redis/protocol"
+)
+
+// DumpCommand 将key的值序列化为与Redis兼容的格式，可以使用RESTORE还原，key不存在时返回nil
+// DUMP key
+func DumpCommand(d *DB, args [][]byte) redis.Reply {
+	entity, exists := d.GetEntity(string(args[0]))
+	if !exists {
+		return protocol.NullBulkReply
+	}
+	payload, err := rdb.Dump(entityToObject(entity))
+	if err != nil {
+		return protocol.NewErrorReply("ERR " + err.Error())
+	}
+	return protocol.NewBulkReply(payload)
+}
+
+// RestoreCommand 使用DUMP的结果创建key，ttl为0时不设置过期时间，设置ABSTTL时ttl为毫秒级的Unix时间戳
+// IDLETIME和FREQ用于淘汰策略，只检查参数格式
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func RestoreCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ttl, err := parseInt64(args[1])
+	if err != nil {
+		return protocol.ErrorNotIntegerReply
+	}
+	if ttl < 0 {
+		return protocol.NewErrorReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME", "FREQ":
+			if i+1 >= len(args) {
+				return protocol.ErrorSyntaxReply
+			}
+			if value, err := parseInt64(args[i+1]); err != nil || value < 0 {
+				return protocol.NewErrorReply("ERR Invalid " + strings.ToUpper(string(args[i])) + " value, must be >= 0")
+			}
+			i++
+		default:
+			return protocol.ErrorSyntaxReply
+		}
+	}
+	if !replace && d.Exists(key) {
+		return protocol.NewErrorReply("BUSYKEY Target key name already exists.")
+	}
+	object, err := rdb.Restore(args[2])
+	if errors.Is(err, rdb.ErrBadDumpPayload) {
+		return protocol.NewErrorReply("ERR " + err.Error())
+	} else if err != nil {
+		return protocol.NewErrorReply("ERR Bad data format")
+	}
+	entity := objectToEntity(object)
+	if entity == nil {
+		return protocol.NewErrorReply("ERR Bad data format")
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.UnixMilli(ttl)
+		} else {
+			expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		// 已经过期的key不需要创建，与Redis一致
+		if expireAt.Before(time.Now()) {
+			d.Remove(key)
+			return protocol.OKReply
+		}
+	}
+	d.Remove(key)
+	d.PutEntity(key, entity)
+	if ttl > 0 {
+		d.ExpireByTime(key, expireAt)
+	}
+	d.notify(notifyGeneric, "restore", key)
+	return protocol.OKReply
+}
+
+// restoreToAof RESTORE使用的相对过期时间在重放时已经不准确，统一转换为使用ABSTTL的RESTORE命令
+func restoreToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	var expireAt int64
+	if t, exists := d.getExpireTime(string(args[0])); exists {
+		expireAt = t.UnixMilli()
+	}
+	return []CmdLine{{
+		[]byte("restore"), args[0], []byte(strconv.FormatInt(expireAt, 10)), args[2],
+		[]byte("REPLACE"), []byte("ABSTTL"),
+	}}
+}
+
+// migrateOptions MIGRATE命令的参数
+type migrateOptions struct {
+	addr     string
+	dbIndex  int
+	timeout  time.Duration
+	copy     bool
+	replace  bool
+	password string
+	keys     []string
+}
+
+// parseMigrateArgs 解析MIGRATE的参数，返回的keys可能包含不存在的key
+func parseMigrateArgs(args [][]byte) (*migrateOptions, redis.Reply) {
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, protocol.ErrorNotIntegerReply
+	}
+	opts := &migrateOptions{
+		addr: net.JoinHostPort(string(args[0]), strconv.Itoa(port)),
+	}
+	if opts.dbIndex, err = strconv.Atoi(string(args[3])); err != nil {
+		return nil, protocol.ErrorNotIntegerReply
+	}
+	timeout, err := parseInt64(args[4])
+	if err != nil {
+		return nil, protocol.ErrorNotIntegerReply
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	opts.timeout = time.Duration(timeout) * time.Millisecond
+	if len(args[2]) > 0 {
+		opts.keys = []string{string(args[2])}
+	}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COPY":
+			opts.copy = true
+		case "REPLACE":
+			opts.replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return nil, protocol.ErrorSyntaxReply
+			}
+			opts.password = string(args[i+1])
+			i++
+		case "AUTH2":
+			// 不支持ACL，忽略用户名
+			if i+2 >= len(args) {
+				return nil, protocol.ErrorSyntaxReply
+			}
+			opts.password = string(args[i+2])
+			i += 2
+		case "KEYS":
+			if len(args[2]) > 0 {
+				return nil, protocol.NewErrorReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				opts.keys = append(opts.keys, string(key))
+			}
+			i = len(args)
+		default:
+			return nil, protocol.ErrorSyntaxReply
+		}
+	}
+	return opts, nil
+}
+
+// MigrateCommand 将key原子地迁移到另一个实例，过期时间一起迁移，迁移成功后删除本地的key，设置COPY时保留
+// 使用RESTORE-ASKING写入目标实例，目标节点正在导入key所在的槽时也可以写入
+// 没有key需要迁移时返回NOKEY
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+func MigrateCommand(d *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseMigrateArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, 0, len(opts.keys))
+	payloads := make([][]byte, 0, len(opts.keys))
+	ttls := make([]int64, 0, len(opts.keys))
+	for _, key := range opts.keys {
+		entity, exists := d.GetEntity(key)
+		if !exists {
+			continue
+		}
+		payload, err := rdb.Dump(entityToObject(entity))
+		if err != nil {
+			return protocol.NewErrorReply("ERR " + err.Error())
+		}
+		var ttl int64
+		if expireAt, ok := d.getExpireTime(key); ok {
+			ttl = time.Until(expireAt).Milliseconds()
+			if ttl <= 0 {
+				continue
+			}
+		}
+		keys = append(keys, key)
+		payloads = append(payloads, payload)
+		ttls = append(ttls, ttl)
+	}
+	if len(keys) == 0 {
+		return protocol.NewSingleReply("NOKEY")
+	}
+
+	c, err := client.MakeClient(opts.addr, opts.password, opts.timeout)
+	if err != nil {
+		return protocol.NewErrorReply("IOERR error or timeout connecting to the client: " + err.Error())
+	}
+	defer c.Close()
+	send := func(cmdLine CmdLine) redis.Reply {
+		reply, err := c.Send(cmdLine)
+		if err != nil {
+			return protocol.NewErrorReply("IOERR error or timeout writing to target instance: " + err.Error())
+		}
+		if protocol.IsErrorReply(reply) {
+			msg := strings.TrimSuffix(strings.TrimPrefix(string(reply.ToBytes()), "-"), "\r\n")
+			return protocol.NewErrorReply("ERR Target instance replied with error: " + msg)
+		}
+		return nil
+	}
+	if errReply := send(CmdLine{[]byte("SELECT"), []byte(strconv.Itoa(opts.dbIndex))}); errReply != nil {
+		return errReply
+	}
+	for i, key := range keys {
+		cmdLine := CmdLine{[]byte("RESTORE-ASKING"), []byte(key), []byte(strconv.FormatInt(ttls[i], 10)), payloads[i]}
+		if opts.replace {
+			cmdLine = append(cmdLine, []byte("REPLACE"))
+		}
+		if errReply := send(cmdLine); errReply != nil {
+			return errReply
+		}
+	}
+	if !opts.copy {
+		for _, key := range keys {
+			if _, exists := d.Remove(key); exists > 0 {
+				d.notify(notifyGeneric, "del", key)
+			}
+		}
+	}
+	return protocol.OKReply
+}
+
+// prepareMigrate MIGRATE命令的prepare，key参数为空字符串时，KEYS之后的参数都是key
+func prepareMigrate(args [][]byte) ([]string, []string) {
+	if len(args[2]) > 0 {
+		return []string{string(args[2])}, nil
+	}
+	for i := 5; i < len(args); i++ {
+		if strings.ToUpper(string(args[i])) == "KEYS" {
+			writeKeys := make([]string, 0, len(args)-i-1)
+			for _, arg := range args[i+1:] {
+				writeKeys = append(writeKeys, string(arg))
+			}
+			return writeKeys, nil
+		}
+	}
+	return nil, nil
+}
+
+// migrateToAof 迁移成功后转换为删除key的DEL命令，COPY时不修改本地数据
+func migrateToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	if !protocol.IsOKReply(reply) {
+		return nil
+	}
+	opts, errReply := parseMigrateArgs(args)
+	if errReply != nil || opts.copy {
+		return nil
+	}
+	line := CmdLine{[]byte("del")}
+	for _, key := range opts.keys {
+		line = append(line, []byte(key))
+	}
+	return []CmdLine{line}
+}
+
+func init() {
+	registerNormalCommand("dump", DumpCommand, readFirstKey, 2, tagRead)
+	registerNormalCommand("restore", RestoreCommand, writeFirstKey, -4, tagWrite).attachAof(restoreToAof)
+	registerNormalCommand("restore-asking", RestoreCommand, writeFirstKey, -4, tagWrite).attachAof(restoreToAof)
+	registerNormalCommand("migrate", MigrateCommand, prepareMigrate, -6, tagWrite).attachAof(migrateToAof)
+}
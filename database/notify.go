@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"zedis/interface/db"
+	"zedis/pubsub"
+)
+
+// 键空间通知的事件类型，与NotifyKeyspaceEvents配置中的字符对应
+const (
+	notifyKeyspace = 1 << iota // K，发布到 __keyspace@<db>__:<key>，消息为事件名
+	notifyKeyevent             // E，发布到 __keyevent@<db>__:<event>，消息为key
+	notifyGeneric              // g，DEL、EXPIRE、PERSIST等与类型无关的命令
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyStream               // t
+	notifyExpired              // x，key过期被删除
+	notifyEvicted              // e，key因内存不足被淘汰，zedis没有淘汰策略，不会产生该事件
+
+	// A，除K、E以外的所有事件类型
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset | notifyStream | notifyExpired | notifyEvicted
+)
+
+// parseKeyspaceEvents 解析NotifyKeyspaceEvents配置，例如"KEA"、"Elg"
+// 没有K和E时不会发布任何通知
+func parseKeyspaceEvents(s string) (int, error) {
+	flags := 0
+	for _, c := range s {
+		switch c {
+		case 'A':
+			flags |= notifyAll
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZset
+		case 't':
+			flags |= notifyStream
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		default:
+			return 0, fmt.Errorf("invalid notify keyspace events: %s", s)
+		}
+	}
+	if flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return 0, nil
+	}
+	return flags, nil
+}
+
+// notify 发布键空间通知，调用方持有key的锁
+// 事件发生后key仍然存在时，说明key被插入或修改，同时调用insertCallback
+func (d *DB) notify(class int, event string, key string) {
+	if cb := d.insertCallback; cb != nil {
+		if raw, ok := d.data.Get(key); ok {
+			cb(d.getIndex(), key, raw.(*db.DataEntity))
+		}
+	}
+	flags := d.notifyFlags
+	if flags&class == 0 {
+		return
+	}
+	index := strconv.Itoa(d.getIndex())
+	if flags&notifyKeyspace > 0 {
+		pubsub.Publish(d.hub, [][]byte{[]byte("__keyspace@" + index + "__:" + key), []byte(event)})
+	}
+	if flags&notifyKeyevent > 0 {
+		pubsub.Publish(d.hub, [][]byte{[]byte("__keyevent@" + index + "__:" + event), []byte(key)})
+	}
+}
@@ -1,6 +1,9 @@
 package database
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 func noPrepare(args [][]byte) ([]string, []string) {
 	return nil, nil
@@ -30,6 +33,24 @@ func writeAllKeys(args [][]byte) ([]string, []string) {
 	return writeKeys, nil
 }
 
+// writeAllKeysExceptLast 最后一个参数不是key的命令，例如BLPOP key [key ...] timeout
+func writeAllKeysExceptLast(args [][]byte) ([]string, []string) {
+	writeKeys := make([]string, 0, len(args)-1)
+	for _, arg := range args[:len(args)-1] {
+		writeKeys = append(writeKeys, string(arg))
+	}
+	return writeKeys, nil
+}
+
+// prepareMSet MSET、MSETNX命令的prepare，参数为key value交替出现
+func prepareMSet(args [][]byte) ([]string, []string) {
+	writeKeys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		writeKeys = append(writeKeys, string(args[i]))
+	}
+	return writeKeys, nil
+}
+
 // prepareSetStore Set集合求差、并、交集并存入到新key中的prepare
 func prepareSetStore(args [][]byte) ([]string, []string) {
 	writeKeys := []string{string(args[0])}
@@ -66,6 +87,24 @@ func prepareLmove(args [][]byte) ([]string, []string) {
 	return writeKeys, nil
 }
 
+// prepareLMPop lmpop命令的prepare，numkeys不合法时不加锁，由命令返回错误
+func prepareLMPop(args [][]byte) ([]string, []string) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 || numKeys >= len(args) {
+		return nil, nil
+	}
+	writeKeys := make([]string, 0, numKeys)
+	for _, arg := range args[1 : 1+numKeys] {
+		writeKeys = append(writeKeys, string(arg))
+	}
+	return writeKeys, nil
+}
+
+// prepareBLMPop blmpop命令的prepare，第一个参数是timeout
+func prepareBLMPop(args [][]byte) ([]string, []string) {
+	return prepareLMPop(args[1:])
+}
+
 // prepareBitOp BITOP命令的prepare
 func prepareBitOp(args [][]byte) ([]string, []string) {
 	writeKeys := []string{string(args[1])}
@@ -75,3 +114,48 @@ func prepareBitOp(args [][]byte) ([]string, []string) {
 	}
 	return writeKeys, readKeys
 }
+
+// preparePFMerge PFMERGE命令的prepare，destkey也作为源参与合并
+func preparePFMerge(args [][]byte) ([]string, []string) {
+	readKeys := make([]string, 0, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		readKeys = append(readKeys, string(args[i]))
+	}
+	return []string{string(args[0])}, readKeys
+}
+
+// prepareGeoSearchStore GEOSEARCHSTORE命令的prepare，第一个参数是destination，第二个参数是源key
+func prepareGeoSearchStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+// prepareObject OBJECT命令的prepare，第一个参数是子命令
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+// prepareXRead XREAD命令的prepare，key在STREAMS之后
+func prepareXRead(args [][]byte) ([]string, []string) {
+	return nil, streamReadKeys(args)
+}
+
+// prepareXReadGroup XREADGROUP命令的prepare，读取消息会修改消费者组
+func prepareXReadGroup(args [][]byte) ([]string, []string) {
+	return streamReadKeys(args), nil
+}
+
+// prepareXGroup XGROUP命令的prepare，第一个参数是子命令
+func prepareXGroup(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{string(args[1])}, nil
+}
+
+// prepareXInfo XINFO命令的prepare，第一个参数是子命令
+func prepareXInfo(args [][]byte) ([]string, []string) {
+	return prepareObject(args)
+}
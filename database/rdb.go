@@ -0,0 +1,324 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"zedis/config"
+	"zedis/datastruct/dict"
+	"zedis/datastruct/list"
+	setds "zedis/datastruct/set"
+	"zedis/datastruct/sortedset"
+	"zedis/datastruct/stream"
+	"zedis/interface/db"
+	"zedis/logger"
+	"zedis/rdb"
+)
+
+// ErrBgSaveInProgress 已有后台保存正在进行
+var ErrBgSaveInProgress = errors.New("background save already in progress")
+
+func getRDBFilename() string {
+	return filepath.Join(config.Config.Dir, config.Config.DBFilename)
+}
+
+// SaveRDB 将数据库保存到RDB文件，先写入临时文件，完成后替换旧文件
+func (e *Engine) SaveRDB() error {
+	tmpFile, err := os.CreateTemp(config.Config.Dir, "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	writer := bufio.NewWriter(tmpFile)
+	if err = e.writeRDB(rdb.NewEncoder(writer)); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), getRDBFilename()); err != nil {
+		return err
+	}
+	e.lastSave.Store(time.Now().Unix())
+	logger.Infof("db saved on disk: %s", getRDBFilename())
+	return nil
+}
+
+// BgSaveRDB 在后台保存RDB文件
+func (e *Engine) BgSaveRDB() error {
+	if !e.bgSaving.CompareAndSwap(false, true) {
+		return ErrBgSaveInProgress
+	}
+	go func() {
+		defer e.bgSaving.Store(false)
+		if err := e.SaveRDB(); err != nil {
+			logger.Errorf("background save failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (e *Engine) writeRDB(enc *rdb.Encoder) error {
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	for _, code := range e.functions.codes() {
+		if err := enc.WriteFunction(code); err != nil {
+			return err
+		}
+	}
+	for i, holder := range e.dbSet {
+		d := holder.Load()
+		keyCount := uint64(d.data.Len())
+		if keyCount == 0 {
+			continue
+		}
+		if err := enc.WriteDBHeader(i, keyCount, uint64(d.ttlMap.Len())); err != nil {
+			return err
+		}
+		var err error
+		e.ForEach(i, func(key string, entity *db.DataEntity, expiration *time.Time) bool {
+			err = writeEntityToRDB(enc, key, entity, expiration)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return enc.WriteEnd()
+}
+
+// writeEntityToRDB 将DataEntity按类型编码为RDB对象
+func writeEntityToRDB(enc *rdb.Encoder, key string, entity *db.DataEntity, expiration *time.Time) error {
+	object := entityToObject(entity)
+	if object == nil {
+		return nil
+	}
+	switch object.Type {
+	case rdb.StringObject:
+		return enc.WriteStringObject(key, object.Value.([]byte), expiration)
+	case rdb.ListObject:
+		return enc.WriteListObject(key, object.Value.([][]byte), expiration)
+	case rdb.SetObject:
+		return enc.WriteSetObject(key, object.Value.([][]byte), expiration)
+	case rdb.HashObject:
+		return enc.WriteHashObject(key, object.Value.(map[string][]byte), expiration)
+	case rdb.ZSetObject:
+		return enc.WriteZSetObject(key, object.Value.([]*rdb.ZSetEntry), expiration)
+	case rdb.StreamObject:
+		return enc.WriteStreamObject(key, object.Value.(*rdb.StreamData), expiration)
+	}
+	return nil
+}
+
+// entityToObject 将DataEntity转换为RDB对象，不包含key和过期时间，objectToEntity的逆操作
+func entityToObject(entity *db.DataEntity) *rdb.Object {
+	switch entity.Type {
+	case db.StringType:
+		return &rdb.Object{Type: rdb.StringObject, Value: entity.Data.([]byte)}
+	case db.ListType:
+		l := entity.Data.(list.List)
+		values := make([][]byte, 0, l.Length())
+		l.ForEach(func(index int, v []byte) bool {
+			values = append(values, v)
+			return true
+		})
+		return &rdb.Object{Type: rdb.ListObject, Value: values}
+	case db.SetType:
+		set := entity.Data.(setds.Set)
+		members := make([][]byte, 0, set.Len())
+		set.ForEach(func(member string) bool {
+			members = append(members, []byte(member))
+			return true
+		})
+		return &rdb.Object{Type: rdb.SetObject, Value: members}
+	case db.HashType:
+		hash := entity.Data.(dict.Dict)
+		fields := make(map[string][]byte, hash.Len())
+		hash.ForEach(func(field string, val any) bool {
+			fields[field] = val.([]byte)
+			return true
+		})
+		return &rdb.Object{Type: rdb.HashObject, Value: fields}
+	case db.SortedType:
+		zset := entity.Data.(*sortedset.SortedSet)
+		entries := make([]*rdb.ZSetEntry, 0, zset.Len())
+		zset.ForEachByRank(0, zset.Len(), false, func(element *sortedset.Element) bool {
+			entries = append(entries, &rdb.ZSetEntry{
+				Member: element.Member,
+				Score:  element.Score,
+			})
+			return true
+		})
+		return &rdb.Object{Type: rdb.ZSetObject, Value: entries}
+	case db.StreamType:
+		return &rdb.Object{Type: rdb.StreamObject, Value: streamToData(entity.Data.(*stream.Stream))}
+	}
+	return nil
+}
+
+// streamToData 将消息流及其消费者组转换为RDB对象的值
+func streamToData(s *stream.Stream) *rdb.StreamData {
+	data := &rdb.StreamData{
+		LastID:       rdb.StreamID(s.LastID()),
+		MaxDeletedID: rdb.StreamID(s.MaxDeletedID()),
+		EntriesAdded: s.EntriesAdded(),
+	}
+	s.ForEach(stream.MinID, stream.MaxID, func(entry *stream.Entry) bool {
+		data.Entries = append(data.Entries, &rdb.StreamEntry{ID: rdb.StreamID(entry.ID), Fields: entry.Fields})
+		return true
+	})
+	for _, g := range s.Groups() {
+		group := &rdb.StreamGroup{
+			Name:        g.Name,
+			LastID:      rdb.StreamID(g.LastID),
+			EntriesRead: g.EntriesRead,
+		}
+		g.Pending(stream.MinID, stream.MaxID, func(pe *stream.PendingEntry) bool {
+			group.Pending = append(group.Pending, &rdb.StreamPendingEntry{
+				ID:            rdb.StreamID(pe.ID),
+				DeliveryTime:  pe.DeliveryTime,
+				DeliveryCount: uint64(pe.DeliveryCount),
+			})
+			return true
+		})
+		for _, c := range g.Consumers() {
+			consumer := &rdb.StreamConsumer{Name: c.Name, SeenTime: c.SeenTime, ActiveTime: c.ActiveTime}
+			for _, id := range c.PendingIDs() {
+				consumer.Pending = append(consumer.Pending, rdb.StreamID(id))
+			}
+			group.Consumers = append(group.Consumers, consumer)
+		}
+		data.Groups = append(data.Groups, group)
+	}
+	return data
+}
+
+// loadRDB 从RDB文件加载数据，文件不存在时直接返回
+func (e *Engine) loadRDB() error {
+	file, err := os.Open(getRDBFilename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	if err = e.loadRDBFrom(file); err != nil {
+		return err
+	}
+	logger.Infof("load rdb file %s finished", getRDBFilename())
+	return nil
+}
+
+// loadRDBFrom 从reader中读取RDB格式的数据并加载到数据库
+func (e *Engine) loadRDBFrom(reader io.Reader) error {
+	now := time.Now()
+	return rdb.NewDecoder(reader).Parse(func(object *rdb.Object) bool {
+		if object.Type == rdb.FunctionObject {
+			if _, errReply := e.functions.load(object.Value.([]byte), true); errReply != nil {
+				logger.Warnf("load function library failed: %s", strings.TrimSpace(string(errReply.ToBytes())))
+			}
+			return true
+		}
+		if object.Expiration != nil && object.Expiration.Before(now) {
+			return true
+		}
+		d, errReply := e.selectDB(object.DBIndex)
+		if errReply != nil {
+			logger.Warnf("skip key %s: db %d is out of range", object.Key, object.DBIndex)
+			return true
+		}
+		entity := objectToEntity(object)
+		if entity == nil {
+			return true
+		}
+		d.PutEntity(object.Key, entity)
+		if object.Expiration != nil {
+			d.ExpireByTime(object.Key, *object.Expiration)
+		}
+		return true
+	})
+}
+
+// objectToEntity 将RDB对象转换为DataEntity
+func objectToEntity(object *rdb.Object) *db.DataEntity {
+	switch object.Type {
+	case rdb.StringObject:
+		return BuildStringEntity(object.Value.([]byte))
+	case rdb.ListObject:
+		return buildListEntity(newList(object.Value.([][]byte)))
+	case rdb.SetObject:
+		set := setds.NewSet()
+		for _, member := range object.Value.([][]byte) {
+			set.Add(string(member))
+		}
+		return buildSetEntity(set)
+	case rdb.HashObject:
+		hash := dict.NewSimpleDict()
+		for field, value := range object.Value.(map[string][]byte) {
+			hash.Put(field, value)
+		}
+		return buildHashEntity(hash)
+	case rdb.ZSetObject:
+		zset := sortedset.NewSortedSet()
+		for _, entry := range object.Value.([]*rdb.ZSetEntry) {
+			zset.Add(entry.Member, entry.Score)
+		}
+		return buildSortedSetEntity(zset)
+	case rdb.StreamObject:
+		return buildStreamEntity(dataToStream(object.Value.(*rdb.StreamData)))
+	}
+	return nil
+}
+
+// dataToStream streamToData的逆操作，消费者组已读的数量未知时根据消息流估算
+func dataToStream(data *rdb.StreamData) *stream.Stream {
+	s := stream.NewStream()
+	for _, entry := range data.Entries {
+		s.Add(stream.ID(entry.ID), entry.Fields)
+	}
+	s.SetLastID(stream.ID(data.LastID))
+	s.SetMaxDeletedID(stream.ID(data.MaxDeletedID))
+	s.SetEntriesAdded(data.EntriesAdded)
+	for _, group := range data.Groups {
+		g := s.CreateGroup(group.Name, stream.ID(group.LastID), group.EntriesRead)
+		if g == nil {
+			continue
+		}
+		if g.EntriesRead < 0 {
+			g.EntriesRead = s.EstimateEntriesRead(g.LastID)
+		}
+		pending := make(map[rdb.StreamID]*rdb.StreamPendingEntry, len(group.Pending))
+		for _, pe := range group.Pending {
+			pending[pe.ID] = pe
+		}
+		for _, c := range group.Consumers {
+			consumer := g.CreateConsumer(c.Name, c.SeenTime)
+			if consumer == nil {
+				continue
+			}
+			consumer.ActiveTime = c.ActiveTime
+			for _, id := range c.Pending {
+				pe := g.AddPending(stream.ID(id), consumer)
+				if saved, ok := pending[id]; ok {
+					pe.DeliveryTime = saved.DeliveryTime
+					pe.DeliveryCount = int64(saved.DeliveryCount)
+				}
+			}
+		}
+	}
+	return s
+}
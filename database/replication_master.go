@@ -0,0 +1,446 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zedis/config"
+	"zedis/interface/redis"
+	"zedis/logger"
+	"zedis/rdb"
+	"zedis/redis/protocol"
+)
+
+// 主节点定期向从节点发送PING的间隔，从节点据此判断与主节点的连接是否超时
+const replPingInterval = 10 * time.Second
+
+var pingCmdBytes = protocol.NewMultiBulkReply(CmdLine{[]byte("PING")}).ToBytes()
+
+// replBacklog 复制积压缓冲区，保存最近写入从节点的命令，从节点断线重连后可以从中补发缺失的命令
+// 使用固定大小的环形缓冲区，写满后覆盖最早的数据
+type replBacklog struct {
+	buf []byte
+	// 下一个字节在buf中的写入位置
+	pos int
+	// buf中有效数据的长度
+	histLen int
+	// 复制偏移量，即主节点发送给从节点的字节总数
+	offset int64
+}
+
+func newReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{
+		buf:    make([]byte, size),
+		offset: offset,
+	}
+}
+
+func (b *replBacklog) write(data []byte) {
+	b.offset += int64(len(data))
+	size := len(b.buf)
+	if len(data) > size {
+		data = data[len(data)-size:]
+	}
+	n := copy(b.buf[b.pos:], data)
+	copy(b.buf, data[n:])
+	b.pos = (b.pos + len(data)) % size
+	b.histLen += len(data)
+	if b.histLen > size {
+		b.histLen = size
+	}
+}
+
+// firstByteOffset 返回缓冲区中最早的数据对应的复制偏移量
+func (b *replBacklog) firstByteOffset() int64 {
+	return b.offset - int64(b.histLen)
+}
+
+// readFrom 返回从偏移量offset开始的所有数据，offset对应的数据已被覆盖或超出当前偏移量时返回false
+func (b *replBacklog) readFrom(offset int64) ([]byte, bool) {
+	if offset < b.firstByteOffset() || offset > b.offset {
+		return nil, false
+	}
+	n := int(b.offset - offset)
+	size := len(b.buf)
+	start := (b.pos - n + size) % size
+	res := make([]byte, n)
+	copied := copy(res, b.buf[start:])
+	if copied < n {
+		copy(res[copied:], b.buf)
+	}
+	return res, true
+}
+
+// replicaInfo 主节点记录的从节点信息
+type replicaInfo struct {
+	conn redis.Connection
+	// REPLCONF上报的地址和端口
+	ip   string
+	port int
+	// 执行PSYNC后为true
+	online bool
+	// 下一个要发送给从节点的字节的复制偏移量，只由发送协程访问
+	sendOffset int64
+	// 从节点通过REPLCONF ACK上报的已处理的复制偏移量
+	ackOffset atomic.Int64
+	// 上一次收到ACK的时间，Unix时间戳
+	ackTime atomic.Int64
+	// 有新数据写入积压缓冲区时通知发送协程，缓冲为1
+	notify chan struct{}
+}
+
+// masterStatus 主节点的复制状态
+type masterStatus struct {
+	mu sync.Mutex
+	// 复制id，从节点用它和复制偏移量请求部分重同步，初始为RunId，从主节点全量同步后重新生成
+	replID string
+	// 第一个从节点连接前为nil，此时不记录复制偏移量
+	backlog *replBacklog
+	// 最后写入积压缓冲区的命令所在的数据库，为-1时下一条命令之前需要写入SELECT
+	selectedDB int
+	// 所有从节点，包括已执行REPLCONF但还没有执行PSYNC的连接
+	replicas map[redis.Connection]*replicaInfo
+	// 引擎关闭时关闭
+	stop chan struct{}
+}
+
+func newMasterStatus() *masterStatus {
+	return &masterStatus{
+		replID:     config.Config.RunId,
+		selectedDB: -1,
+		replicas:   make(map[redis.Connection]*replicaInfo),
+		stop:       make(chan struct{}),
+	}
+}
+
+// feed 将写命令写入积压缓冲区并通知所有从节点，没有积压缓冲区时不做任何事
+func (m *masterStatus) feed(dbIndex int, cmdLine CmdLine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.backlog == nil {
+		return
+	}
+	if dbIndex != m.selectedDB {
+		selectCmd := CmdLine{[]byte("SELECT"), []byte(strconv.Itoa(dbIndex))}
+		m.backlog.write(protocol.NewMultiBulkReply(selectCmd).ToBytes())
+		m.selectedDB = dbIndex
+	}
+	m.backlog.write(protocol.NewMultiBulkReply(cmdLine).ToBytes())
+	m.notifyReplicas()
+}
+
+// notifyReplicas 通知所有在线的从节点有新数据，调用方需要持有锁
+func (m *masterStatus) notifyReplicas() {
+	for _, r := range m.replicas {
+		if !r.online {
+			continue
+		}
+		select {
+		case r.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// createBacklog 第一个从节点请求同步时创建积压缓冲区，并开始定期向从节点发送PING，调用方需要持有锁
+func (m *masterStatus) createBacklog() {
+	if m.backlog != nil {
+		return
+	}
+	size := config.Config.ReplBacklogSize
+	if size <= 0 {
+		size = 1024 * 1024
+	}
+	m.backlog = newReplBacklog(size, 0)
+	go m.pingReplicas(m.backlog)
+}
+
+// pingReplicas 定期向从节点发送PING，PING同样写入积压缓冲区，计入复制偏移量
+// 积压缓冲区被丢弃后退出
+func (m *masterStatus) pingReplicas(backlog *replBacklog) {
+	ticker := time.NewTicker(replPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			if m.backlog != backlog {
+				m.mu.Unlock()
+				return
+			}
+			if m.onlineCount() > 0 {
+				m.backlog.write(pingCmdBytes)
+				m.notifyReplicas()
+			}
+			m.mu.Unlock()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *masterStatus) onlineCount() int {
+	count := 0
+	for _, r := range m.replicas {
+		if r.online {
+			count++
+		}
+	}
+	return count
+}
+
+// getReplica 返回连接对应的从节点信息，不存在时创建
+func (m *masterStatus) getReplica(c redis.Connection) *replicaInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.replicas[c]
+	if !ok {
+		r = &replicaInfo{
+			conn:   c,
+			ip:     strings.Split(c.RemoteAddr(), ":")[0],
+			notify: make(chan struct{}, 1),
+		}
+		m.replicas[c] = r
+	}
+	return r
+}
+
+// startSending 从节点同步完成后，从复制偏移量offset开始持续发送积压缓冲区中的命令
+func (m *masterStatus) startSending(r *replicaInfo, offset int64) {
+	m.mu.Lock()
+	r.online = true
+	r.sendOffset = offset
+	r.ackOffset.Store(offset)
+	r.ackTime.Store(time.Now().Unix())
+	m.mu.Unlock()
+	r.notify <- struct{}{}
+	go m.sendToReplica(r)
+}
+
+// sendToReplica 发送协程，每个从节点一个，从节点断开或引擎关闭时退出
+func (m *masterStatus) sendToReplica(r *replicaInfo) {
+	for {
+		select {
+		case <-r.notify:
+		case <-r.conn.Done():
+			return
+		case <-m.stop:
+			return
+		}
+		m.mu.Lock()
+		var data []byte
+		ok := false
+		if m.backlog != nil {
+			data, ok = m.backlog.readFrom(r.sendOffset)
+		}
+		m.mu.Unlock()
+		if !ok {
+			// 积压缓冲区中未发送的数据已被覆盖，断开连接，从节点重连后会重新全量同步
+			logger.Warnf("replica %s lagged behind the replication backlog, disconnecting", r.conn.RemoteAddr())
+			_ = r.conn.Close()
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if _, err := r.conn.Write(data); err != nil {
+			return
+		}
+		r.sendOffset += int64(len(data))
+	}
+}
+
+// removeReplica 从节点连接关闭时调用
+func (m *masterStatus) removeReplica(c redis.Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.replicas, c)
+}
+
+// reset 从新的主节点全量同步后调用，断开所有从节点，丢弃积压缓冲区并生成新的复制id
+// 从节点重连时复制id不匹配，只能全量同步
+func (m *masterStatus) reset() {
+	m.mu.Lock()
+	replicas := make([]redis.Connection, 0, len(m.replicas))
+	for c := range m.replicas {
+		replicas = append(replicas, c)
+	}
+	m.replID = config.GenRandomRunID(40)
+	m.backlog = nil
+	m.selectedDB = -1
+	m.mu.Unlock()
+	for _, c := range replicas {
+		_ = c.Close()
+	}
+}
+
+// PSync 命令，从节点请求同步
+// 复制id与当前复制id相同且偏移量还在积压缓冲区中时，回复+CONTINUE，只补发缺失的命令；否则回复+FULLRESYNC，发送RDB快照
+// 同步的响应直接写入连接，之后由发送协程持续发送写命令
+// PSYNC replicationid offset
+func PSync(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.NewArgNumErrReply("psync")
+	}
+	if engine.slave.isReplica() && !engine.slave.isLinkUp() {
+		return protocol.NewErrorReply("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	m := engine.master
+	r := m.getReplica(c)
+	m.mu.Lock()
+	online := r.online
+	m.mu.Unlock()
+	if online {
+		return protocol.NewErrorReply("ERR Replica is already synchronized")
+	}
+	// 从节点发送的偏移量是下一个需要的字节，从1开始计数，与Redis一致
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err == nil {
+		offset--
+		m.mu.Lock()
+		canContinue := string(args[0]) == m.replID && m.backlog != nil &&
+			offset >= m.backlog.firstByteOffset() && offset <= m.backlog.offset
+		replID := m.replID
+		m.mu.Unlock()
+		if canContinue {
+			if _, err := c.Write([]byte("+CONTINUE " + replID + "\r\n")); err != nil {
+				return protocol.NoReply
+			}
+			m.startSending(r, offset)
+			logger.Infof("partial resynchronization with replica %s accepted, offset %d", c.RemoteAddr(), offset)
+			return protocol.NoReply
+		}
+	}
+
+	replID, offset, snapshot, err := engine.replicationSnapshot()
+	if err != nil {
+		logger.Errorf("generate snapshot for replica failed: %v", err)
+		return protocol.NewErrorReply("ERR " + err.Error())
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("+FULLRESYNC %s %d\r\n", replID, offset))
+	buf.WriteString(fmt.Sprintf("$%d\r\n", len(snapshot)))
+	buf.Write(snapshot)
+	if _, err := c.Write(buf.Bytes()); err != nil {
+		return protocol.NoReply
+	}
+	m.startSending(r, offset)
+	logger.Infof("full resynchronization with replica %s finished, offset %d", c.RemoteAddr(), offset)
+	return protocol.NoReply
+}
+
+// replicationSnapshot 生成全量同步的RDB快照，返回快照对应的复制id和复制偏移量
+// 生成快照期间暂停执行写命令，保证快照恰好包含该偏移量之前的所有写命令
+func (e *Engine) replicationSnapshot() (string, int64, []byte, error) {
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	m := e.master
+	m.mu.Lock()
+	m.createBacklog()
+	// 从节点加载快照后使用0号数据库，下一条命令之前需要写入SELECT
+	m.selectedDB = -1
+	replID, offset := m.replID, m.backlog.offset
+	m.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := e.writeRDB(rdb.NewEncoder(&buf)); err != nil {
+		return "", 0, nil, err
+	}
+	return replID, offset, buf.Bytes(), nil
+}
+
+// ReplConf 命令，从节点上报自身信息和已处理的复制偏移量
+// REPLCONF listening-port <port> | ip-address <ip> | capa <capability> | ACK <offset>
+func ReplConf(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args)%2 != 0 {
+		return protocol.ErrorSyntaxReply
+	}
+	r := engine.master.getReplica(c)
+	for i := 0; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		value := string(args[i+1])
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			r.port = port
+		case "ip-address":
+			r.ip = value
+		case "capa":
+		case "ack":
+			// ACK不需要回复
+			if offset, err := strconv.ParseInt(value, 10, 64); err == nil {
+				r.ackOffset.Store(offset)
+				r.ackTime.Store(time.Now().Unix())
+			}
+			return protocol.NoReply
+		default:
+			return protocol.NewErrorReply("ERR Unrecognized REPLCONF option: " + option)
+		}
+	}
+	return protocol.OKReply
+}
+
+// masterReplicationInfo 返回INFO replication中主节点相关的信息
+func (m *masterStatus) masterReplicationInfo() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("connected_slaves:%d\r\n", m.onlineCount()))
+	i := 0
+	for _, r := range m.replicas {
+		if !r.online {
+			continue
+		}
+		lag := time.Now().Unix() - r.ackTime.Load()
+		buf.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d\r\n",
+			i, r.ip, r.port, r.ackOffset.Load(), lag))
+		i++
+	}
+	buf.WriteString(fmt.Sprintf("master_replid:%s\r\n", m.replID))
+	if m.backlog == nil {
+		buf.WriteString("master_repl_offset:0\r\n")
+		buf.WriteString("repl_backlog_active:0\r\n")
+		buf.WriteString(fmt.Sprintf("repl_backlog_size:%d\r\n", config.Config.ReplBacklogSize))
+		return buf.String()
+	}
+	buf.WriteString(fmt.Sprintf("master_repl_offset:%d\r\n", m.backlog.offset))
+	buf.WriteString("repl_backlog_active:1\r\n")
+	buf.WriteString(fmt.Sprintf("repl_backlog_size:%d\r\n", len(m.backlog.buf)))
+	buf.WriteString(fmt.Sprintf("repl_backlog_first_byte_offset:%d\r\n", m.backlog.firstByteOffset()+1))
+	buf.WriteString(fmt.Sprintf("repl_backlog_histlen:%d\r\n", m.backlog.histLen))
+	return buf.String()
+}
+
+// masterRole 返回ROLE命令中主节点的信息：[master, 复制偏移量, [[ip, port, 偏移量] ...]]
+func (m *masterStatus) masterRole() redis.Reply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var offset int64
+	if m.backlog != nil {
+		offset = m.backlog.offset
+	}
+	replicas := make([]redis.Reply, 0, len(m.replicas))
+	for _, r := range m.replicas {
+		if !r.online {
+			continue
+		}
+		replicas = append(replicas, protocol.NewMultiBulkReply([][]byte{
+			[]byte(r.ip),
+			[]byte(strconv.Itoa(r.port)),
+			[]byte(strconv.FormatInt(r.ackOffset.Load(), 10)),
+		}))
+	}
+	return protocol.NewArrayReply([]redis.Reply{
+		protocol.NewBulkReply([]byte("master")),
+		protocol.NewIntReply(offset),
+		protocol.NewArrayReply(replicas),
+	})
+}
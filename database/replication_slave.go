@@ -0,0 +1,474 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zedis/aof"
+	"zedis/config"
+	"zedis/interface/db"
+	"zedis/interface/redis"
+	"zedis/logger"
+	"zedis/redis/connection"
+	"zedis/redis/parser"
+	"zedis/redis/protocol"
+)
+
+// ackInterval 从节点向主节点上报复制偏移量的间隔
+const ackInterval = time.Second
+
+var errReadOnlyReplica = protocol.NewErrorReply("READONLY You can't write against a read only replica.")
+
+// slaveStatus 从节点的复制状态
+type slaveStatus struct {
+	mu sync.Mutex
+	// 主节点地址，为空表示当前是主节点
+	masterHost string
+	masterPort int
+	// 停止与当前主节点的同步
+	cancel context.CancelFunc
+	// 执行主节点发送的命令的伪连接，每次连接主节点时重新创建，只有它可以在从节点上执行写命令
+	masterConn redis.Connection
+	// 主节点的复制id和已处理的复制偏移量，断线重连后用于部分重同步
+	replID string
+	offset atomic.Int64
+	// 主节点发送的命令所在的数据库，部分重同步时主节点不会重新发送SELECT，需要保留
+	dbIndex int
+
+	linkUp  atomic.Bool
+	syncing atomic.Bool
+	// 上一次收到主节点数据的时间，Unix时间戳
+	lastIOTime atomic.Int64
+}
+
+func (s *slaveStatus) isReplica() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.masterHost != ""
+}
+
+func (s *slaveStatus) isLinkUp() bool {
+	return s.linkUp.Load()
+}
+
+// isMasterConn 判断是否是执行主节点命令的连接
+func (s *slaveStatus) isMasterConn(c redis.Connection) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.masterConn != nil && s.masterConn == c
+}
+
+// stop 停止同步，调用方需要持有锁
+func (s *slaveStatus) stop() {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	s.masterHost = ""
+	s.masterPort = 0
+	s.masterConn = nil
+	s.linkUp.Store(false)
+	s.syncing.Store(false)
+}
+
+// ReplicaOf 命令，成为指定主节点的从节点，或者使用NO ONE重新成为主节点
+// 成为从节点后，先从主节点全量同步，再持续接收主节点的写命令；从节点只读
+// REPLICAOF host port | NO ONE
+func ReplicaOf(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.NewArgNumErrReply("replicaof")
+	}
+	s := engine.slave
+	if strings.ToLower(string(args[0])) == "no" && strings.ToLower(string(args[1])) == "one" {
+		s.mu.Lock()
+		if s.masterHost != "" {
+			logger.Infof("stop replicating from %s:%d, becoming master", s.masterHost, s.masterPort)
+		}
+		s.stop()
+		// 成为主节点后数据可能被修改，之后再成为从节点时需要全量同步
+		s.replID = ""
+		s.mu.Unlock()
+		return protocol.OKReply
+	}
+
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return protocol.NewErrorReply("ERR Invalid master port")
+	}
+	s.mu.Lock()
+	if s.masterHost == host && s.masterPort == port {
+		s.mu.Unlock()
+		return protocol.NewSingleReply("OK Already connected to specified master")
+	}
+	s.stop()
+	s.masterHost = host
+	s.masterPort = port
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	// 原有的从节点需要从新的数据重新同步
+	engine.master.reset()
+	go engine.replicationLoop(ctx, host, port)
+	logger.Infof("replica of %s:%d enabled", host, port)
+	return protocol.OKReply
+}
+
+// replicationLoop 与主节点同步，连接断开后每秒重试一次，直到执行REPLICAOF NO ONE或其他REPLICAOF
+func (e *Engine) replicationLoop(ctx context.Context, host string, port int) {
+	for {
+		err := e.syncWithMaster(ctx, host, port)
+		e.slave.linkUp.Store(false)
+		e.slave.syncing.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warnf("replication with master %s:%d failed: %v, retry in 1s", host, port, err)
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// timeoutReader 每次读取前设置超时时间，超过ReplTimeout没有收到主节点的数据时返回错误
+type timeoutReader struct {
+	conn    net.Conn
+	timeout time.Duration
+	// 读取到数据时更新
+	lastIOTime *atomic.Int64
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	_ = r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	n, err := r.conn.Read(p)
+	if n > 0 {
+		r.lastIOTime.Store(time.Now().Unix())
+	}
+	return n, err
+}
+
+func getReplTimeout() time.Duration {
+	if config.Config.ReplTimeout <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(config.Config.ReplTimeout) * time.Second
+}
+
+// syncWithMaster 连接主节点并完成握手、同步，之后持续执行主节点发送的命令，直到连接断开
+func (e *Engine) syncWithMaster(ctx context.Context, host string, port int) error {
+	timeout := getReplTimeout()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return err
+	}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(&timeoutReader{conn: conn, timeout: timeout, lastIOTime: &e.slave.lastIOTime})
+	request := func(args ...string) (string, error) {
+		cmdLine := make(CmdLine, 0, len(args))
+		for _, arg := range args {
+			cmdLine = append(cmdLine, []byte(arg))
+		}
+		if _, err := conn.Write(protocol.NewMultiBulkReply(cmdLine).ToBytes()); err != nil {
+			return "", err
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		if strings.HasPrefix(line, "-") {
+			return "", fmt.Errorf("%s replied: %s", args[0], line[1:])
+		}
+		return line, nil
+	}
+
+	if config.Config.MasterAuth != "" {
+		if _, err := request("AUTH", config.Config.MasterAuth); err != nil {
+			return err
+		}
+	}
+	if _, err := request("PING"); err != nil {
+		return err
+	}
+	if _, err := request("REPLCONF", "listening-port", strconv.Itoa(config.Config.Port)); err != nil {
+		return err
+	}
+	if config.Config.AnnounceHost != "" {
+		if _, err := request("REPLCONF", "ip-address", config.Config.AnnounceHost); err != nil {
+			return err
+		}
+	}
+
+	s := e.slave
+	s.syncing.Store(true)
+	replID, psyncOffset := "?", "-1"
+	s.mu.Lock()
+	if s.replID != "" {
+		replID, psyncOffset = s.replID, strconv.FormatInt(s.offset.Load()+1, 10)
+	}
+	s.mu.Unlock()
+	reply, err := request("PSYNC", replID, psyncOffset)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC reply: %s", reply)
+		}
+		if err = e.loadSnapshotFromMaster(reader); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.replID = fields[1]
+		s.offset.Store(offset)
+		s.dbIndex = 0
+		s.mu.Unlock()
+		logger.Infof("full resynchronization with master %s:%d finished, offset %d", host, port, offset)
+	case len(fields) > 0 && fields[0] == "+CONTINUE":
+		if len(fields) > 1 {
+			s.mu.Lock()
+			s.replID = fields[1]
+			s.mu.Unlock()
+		}
+		logger.Infof("partial resynchronization with master %s:%d accepted, offset %d", host, port, s.offset.Load())
+	default:
+		return fmt.Errorf("unexpected PSYNC reply: %s", reply)
+	}
+	s.syncing.Store(false)
+
+	masterConn := connection.NewFakeConn()
+	masterConn.SetPassword(config.Config.RequirePass)
+	masterConn.SelectDB(s.dbIndex)
+	s.mu.Lock()
+	if ctx.Err() != nil {
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+	s.masterConn = masterConn
+	s.mu.Unlock()
+	s.linkUp.Store(true)
+
+	go e.sendAck(conn, stopped)
+	return e.receiveFromMaster(reader, masterConn)
+}
+
+// loadSnapshotFromMaster 读取主节点发送的RDB快照，清空所有数据库后加载
+func (e *Engine) loadSnapshotFromMaster(reader *bufio.Reader) error {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if !strings.HasPrefix(line, "$") {
+		return fmt.Errorf("invalid snapshot header: %s", line)
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid snapshot header: %s", line)
+	}
+	// 先完整接收快照，传输中断时不会清空已有数据
+	snapshot := make([]byte, size)
+	if _, err = io.ReadFull(reader, snapshot); err != nil {
+		return err
+	}
+
+	e.dbSetMu.Lock()
+	defer e.dbSetMu.Unlock()
+	for _, holder := range e.dbSet {
+		holder.Load().Flush()
+	}
+	e.functions.flush()
+	if err = e.loadRDBFrom(bytes.NewReader(snapshot)); err != nil {
+		return err
+	}
+	// 原有的从节点需要从新的数据重新同步
+	e.master.reset()
+	e.rewriteAofAfterSync()
+	return nil
+}
+
+// rewriteAofAfterSync 全量同步后，AOF文件中的数据已经过时，用同步后的数据重新生成AOF命令
+func (e *Engine) rewriteAofAfterSync() {
+	if e.persister == nil {
+		return
+	}
+	e.persister.SaveCmdLine(0, CmdLine{[]byte("FLUSHALL")})
+	e.persister.SaveCmdLine(0, CmdLine{[]byte("FUNCTION"), []byte("FLUSH")})
+	for _, code := range e.functions.codes() {
+		e.persister.SaveCmdLine(0, aof.MakeFunctionLoadCmd(code))
+	}
+	for i := range e.dbSet {
+		e.ForEach(i, func(key string, entity *db.DataEntity, expiration *time.Time) bool {
+			for _, cmdLine := range aof.EntityToCmds(key, entity) {
+				e.persister.SaveCmdLine(i, cmdLine)
+			}
+			if expiration != nil {
+				e.persister.SaveCmdLine(i, aof.MakeExpireCmd(key, *expiration))
+			}
+			return true
+		})
+	}
+}
+
+// receiveFromMaster 持续执行主节点发送的命令，并更新复制偏移量
+func (e *Engine) receiveFromMaster(reader io.Reader, masterConn redis.Connection) error {
+	s := e.slave
+	ch := parser.ParseRequestStream(reader)
+	// 返回后连接被关闭，解析协程还会发送一个错误，需要读完channel避免协程泄漏
+	defer func() {
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	for payload := range ch {
+		if payload.Error != nil {
+			return payload.Error
+		}
+		r, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok {
+			continue
+		}
+		reply := e.Exec(masterConn, r.Texts)
+		if protocol.IsErrorReply(reply) {
+			logger.Errorf("exec command from master error: %s", string(reply.ToBytes()))
+		}
+		s.offset.Add(int64(len(r.ToBytes())))
+		s.dbIndex = masterConn.GetDBIndex()
+	}
+	return errors.New("connection closed")
+}
+
+// sendAck 每秒向主节点上报已处理的复制偏移量
+func (e *Engine) sendAck(conn net.Conn, stopped <-chan struct{}) {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ack := CmdLine{[]byte("REPLCONF"), []byte("ACK"), []byte(strconv.FormatInt(e.slave.offset.Load(), 10))}
+			if _, err := conn.Write(protocol.NewMultiBulkReply(ack).ToBytes()); err != nil {
+				return
+			}
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// isReadOnly 从节点不能执行普通客户端的写命令
+func (e *Engine) isReadOnly(c redis.Connection, cmdName string) bool {
+	if !e.slave.isReplica() || e.slave.isMasterConn(c) {
+		return false
+	}
+	switch cmdName {
+	case "flushall", "swapdb", "move":
+		return true
+	}
+	cmd, ok := cmdTable[cmdName]
+	return ok && cmd.tags&tagWrite > 0
+}
+
+// slaveReplicationInfo 返回INFO replication中从节点相关的信息
+func (s *slaveStatus) slaveReplicationInfo() string {
+	s.mu.Lock()
+	host, port := s.masterHost, s.masterPort
+	s.mu.Unlock()
+	linkStatus := "down"
+	if s.isLinkUp() {
+		linkStatus = "up"
+	}
+	syncing := 0
+	if s.syncing.Load() {
+		syncing = 1
+	}
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("master_host:%s\r\n", host))
+	buf.WriteString(fmt.Sprintf("master_port:%d\r\n", port))
+	buf.WriteString(fmt.Sprintf("master_link_status:%s\r\n", linkStatus))
+	buf.WriteString(fmt.Sprintf("master_last_io_seconds_ago:%d\r\n", time.Now().Unix()-s.lastIOTime.Load()))
+	buf.WriteString(fmt.Sprintf("master_sync_in_progress:%d\r\n", syncing))
+	buf.WriteString(fmt.Sprintf("slave_repl_offset:%d\r\n", s.offset.Load()))
+	buf.WriteString("slave_read_only:1\r\n")
+	return buf.String()
+}
+
+// slaveRole 返回ROLE命令中从节点的信息：[slave, 主节点host, 主节点port, 连接状态, 复制偏移量]
+func (s *slaveStatus) slaveRole() redis.Reply {
+	s.mu.Lock()
+	host, port := s.masterHost, s.masterPort
+	s.mu.Unlock()
+	state := "connect"
+	if s.syncing.Load() {
+		state = "sync"
+	} else if s.isLinkUp() {
+		state = "connected"
+	}
+	return protocol.NewArrayReply([]redis.Reply{
+		protocol.NewBulkReply([]byte("slave")),
+		protocol.NewBulkReply([]byte(host)),
+		protocol.NewIntReply(int64(port)),
+		protocol.NewBulkReply([]byte(state)),
+		protocol.NewIntReply(s.offset.Load()),
+	})
+}
+
+// Role 命令，返回当前节点在复制中的角色
+// ROLE
+func Role(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("role")
+	}
+	if engine.slave.isReplica() {
+		return engine.slave.slaveRole()
+	}
+	return engine.master.masterRole()
+}
+
+// replicationInfo 返回INFO replication的内容
+func replicationInfo(engine *Engine) string {
+	if engine.slave.isReplica() {
+		return "role:slave\r\n" + engine.slave.slaveReplicationInfo() + engine.master.masterReplicationInfo()
+	}
+	return "role:master\r\n" + engine.master.masterReplicationInfo()
+}
+
+// startReplicationFromConfig 启动时配置了主节点地址时，作为从节点开始同步
+func (e *Engine) startReplicationFromConfig() {
+	if config.Config.ReplicaOf == "" {
+		return
+	}
+	args := strings.Fields(config.Config.ReplicaOf)
+	if len(args) != 2 {
+		logger.Warnf("invalid ReplicaOf config: %s", config.Config.ReplicaOf)
+		return
+	}
+	reply := ReplicaOf(e, connection.NewFakeConn(), [][]byte{[]byte(args[0]), []byte(args[1])})
+	if protocol.IsErrorReply(reply) {
+		logger.Warnf("invalid ReplicaOf config: %s", string(reply.ToBytes()))
+	}
+}
@@ -0,0 +1,439 @@
+package database
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"zedis/interface/redis"
+	"zedis/logger"
+	"zedis/redis/connection"
+	"zedis/redis/protocol"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+/*
+Lua脚本
+
+EVAL执行前获取快照锁的读锁，并将KEYS中的所有key加写锁，脚本执行期间其他客户端无法读写这些key，保证脚本原子地执行
+脚本只能通过redis.call、redis.pcall访问KEYS中声明的key，访问其他key会返回错误：
+执行过程中再为其他key加锁可能与另一个脚本互相等待而死锁
+
+redis.call执行的命令与事务中的命令相同，直接调用execWithLock，写命令执行成功后各自写入AOF并发送给从节点，
+AOF和从节点中不会出现EVAL，重放结果与脚本中的TIME、随机数等无关
+
+gopher-lua的LState不是并发安全的，操作不同key的脚本可以同时执行，因此使用sync.Pool复用多个LState
+脚本编译后按SHA1缓存，EVALSHA和SCRIPT LOAD使用同一个缓存
+*/
+
+// luaScript 编译后的脚本
+type luaScript struct {
+	sha   string
+	proto *lua.FunctionProto
+}
+
+// scriptCache 脚本缓存，SHA1 -> 编译后的脚本
+type scriptCache struct {
+	mu      sync.RWMutex
+	scripts map[string]*luaScript
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[string]*luaScript)}
+}
+
+// sha1Hex 返回脚本内容的SHA1，小写十六进制
+func sha1Hex(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// load 编译脚本并放入缓存，已经缓存时直接返回
+func (sc *scriptCache) load(body []byte) (*luaScript, redis.Reply) {
+	sha := sha1Hex(body)
+	if script, ok := sc.get(sha); ok {
+		return script, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(string(body)), "user_script")
+	if err != nil {
+		return nil, protocol.NewErrorReply("ERR Error compiling script (new function): " + strings.TrimSpace(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return nil, protocol.NewErrorReply("ERR Error compiling script (new function): " + strings.TrimSpace(err.Error()))
+	}
+	script := &luaScript{sha: sha, proto: proto}
+	sc.mu.Lock()
+	sc.scripts[sha] = script
+	sc.mu.Unlock()
+	return script, nil
+}
+
+func (sc *scriptCache) get(sha string) (*luaScript, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	script, ok := sc.scripts[strings.ToLower(sha)]
+	return script, ok
+}
+
+func (sc *scriptCache) flush() {
+	sc.mu.Lock()
+	sc.scripts = make(map[string]*luaScript)
+	sc.mu.Unlock()
+}
+
+// scriptRun 一次脚本执行的上下文，redis.call通过它执行命令
+type scriptRun struct {
+	engine *Engine
+	d      *DB
+	c      redis.Connection
+	// 传给execWithLock的伪连接，处于事务状态，脚本中的阻塞命令与事务中相同，不阻塞
+	fakeConn redis.Connection
+	// KEYS中声明的key
+	keys map[string]struct{}
+	// 只读的脚本不能执行写命令
+	readOnly bool
+}
+
+// scriptVM 一个Lua虚拟机，执行脚本期间run不为空
+// EVAL使用vmPool中的虚拟机，每个函数库使用自己的虚拟机
+type scriptVM struct {
+	L   *lua.LState
+	run *scriptRun
+}
+
+var vmPool = sync.Pool{
+	New: func() any {
+		return newScriptVM()
+	},
+}
+
+// newScriptVM 创建Lua虚拟机，只加载base、table、string、math库，并注册redis库
+// 脚本不能创建全局变量，也不能访问不存在的全局变量，避免在复用的虚拟机之间留下状态
+func newScriptVM() *scriptVM {
+	vm := &scriptVM{}
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redisLib := L.NewTable()
+	L.SetFuncs(redisLib, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return vm.call(L, true)
+		},
+		"pcall": func(L *lua.LState) int {
+			return vm.call(L, false)
+		},
+		"error_reply":  luaErrorReply,
+		"status_reply": luaStatusReply,
+		"sha1hex":      luaSha1Hex,
+		"log":          luaLog,
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redisLib.RawSetString(level, lua.LNumber(i))
+	}
+	L.SetGlobal("redis", redisLib)
+
+	globalMeta := L.NewTable()
+	globalMeta.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to create global variable '%s'", L.ToString(2))
+		return 0
+	}))
+	globalMeta.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.ToString(2))
+		return 0
+	}))
+	L.SetMetatable(L.G.Global, globalMeta)
+
+	vm.L = L
+	return vm
+}
+
+// newScriptRun 创建一次脚本执行的上下文，readOnly为true时脚本不能执行写命令
+func (e *Engine) newScriptRun(d *DB, c redis.Connection, keys []string, readOnly bool) *scriptRun {
+	fakeConn := connection.NewFakeConn()
+	fakeConn.SelectDB(d.getIndex())
+	fakeConn.SetMultiState(true)
+	run := &scriptRun{
+		engine:   e,
+		d:        d,
+		c:        c,
+		fakeConn: fakeConn,
+		keys:     make(map[string]struct{}, len(keys)),
+		readOnly: readOnly,
+	}
+	for _, key := range keys {
+		run.keys[key] = struct{}{}
+	}
+	return run
+}
+
+// runScript 执行EVAL的脚本，调用方需要持有快照锁的读锁和所有key的写锁
+func (e *Engine) runScript(d *DB, c redis.Connection, script *luaScript, keys []string, args [][]byte) redis.Reply {
+	vm := vmPool.Get().(*scriptVM)
+	defer vmPool.Put(vm)
+	L := vm.L
+	L.G.Global.RawSetString("KEYS", keysTable(L, keys))
+	L.G.Global.RawSetString("ARGV", argvTable(L, args))
+	run := e.newScriptRun(d, c, keys, false)
+	return vm.invoke(run, "f_"+script.sha, L.NewFunctionFromProto(script.proto))
+}
+
+// invoke 以run为上下文调用fn，返回值转换为回复，name是出错时回复中的函数名
+func (vm *scriptVM) invoke(run *scriptRun, name string, fn *lua.LFunction, args ...lua.LValue) redis.Reply {
+	vm.run = run
+	L := vm.L
+	defer func() {
+		vm.run = nil
+		L.SetTop(0)
+	}()
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	if err := L.PCall(len(args), 1, nil); err != nil {
+		// redis.call执行命令出错时抛出{err=...}，直接返回命令的错误
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+				if msg, ok := tbl.RawGetString("err").(lua.LString); ok {
+					return protocol.NewErrorReply(string(msg))
+				}
+			}
+			return protocol.NewErrorReply(fmt.Sprintf("ERR Error running script (call to %s): %s", name, apiErr.Object.String()))
+		}
+		return protocol.NewErrorReply(fmt.Sprintf("ERR Error running script (call to %s): %v", name, err))
+	}
+	return luaToReply(L.Get(-1))
+}
+
+// keysTable 将KEYS转换为Lua数组
+func keysTable(L *lua.LState, keys []string) *lua.LTable {
+	tbl := L.CreateTable(len(keys), 0)
+	for _, key := range keys {
+		tbl.Append(lua.LString(key))
+	}
+	return tbl
+}
+
+// argvTable 将ARGV转换为Lua数组
+func argvTable(L *lua.LState, args [][]byte) *lua.LTable {
+	tbl := L.CreateTable(len(args), 0)
+	for _, arg := range args {
+		tbl.Append(lua.LString(arg))
+	}
+	return tbl
+}
+
+// call 实现redis.call和redis.pcall，raise为true时命令出错抛出Lua错误，否则返回{err=...}
+func (vm *scriptVM) call(L *lua.LState, raise bool) int {
+	// 加载函数库时没有执行上下文
+	if vm.run == nil {
+		L.RaiseError("Please use redis.call only inside a function")
+		return 0
+	}
+	reply := vm.run.exec(L)
+	if errReply, ok := reply.(protocol.ErrorReply); ok && raise {
+		tbl := L.NewTable()
+		tbl.RawSetString("err", lua.LString(errReply.Error()))
+		L.Error(tbl, 1)
+		return 0
+	}
+	L.Push(replyToLua(L, reply))
+	return 1
+}
+
+// exec 执行redis.call的参数组成的命令，参数只能是字符串或数字
+func (run *scriptRun) exec(L *lua.LState) redis.Reply {
+	n := L.GetTop()
+	if n == 0 {
+		return protocol.NewErrorReply("ERR Please specify at least one argument for this redis lib call")
+	}
+	cmdLine := make([][]byte, n)
+	for i := 1; i <= n; i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			cmdLine[i-1] = []byte(arg)
+		case lua.LNumber:
+			cmdLine[i-1] = []byte(arg.String())
+		default:
+			return protocol.NewErrorReply("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmdArgs := cmdLine[1:]
+	if cmdName == "ping" {
+		return Ping(run.c, cmdArgs)
+	}
+	cmd, errReply := lookupCommand(cmdName, cmdArgs)
+	if errReply != nil {
+		return errReply
+	}
+	if cmd.tags&tagNoMulti > 0 {
+		return protocol.NewErrorReply("ERR This Redis command is not allowed from script")
+	}
+	if run.readOnly && cmd.tags&tagWrite > 0 {
+		return protocol.NewErrorReply("ERR Write commands are not allowed from read-only scripts.")
+	}
+	if run.engine.isReadOnly(run.c, cmdName) {
+		return errReadOnlyReplica
+	}
+	var writeKeys, readKeys []string
+	if cmd.prepare != nil {
+		writeKeys, readKeys = cmd.prepare(cmdArgs)
+	}
+	for _, keys := range [][]string{writeKeys, readKeys} {
+		for _, key := range keys {
+			if _, ok := run.keys[key]; !ok {
+				return protocol.NewErrorReply(fmt.Sprintf("ERR Script attempted to access key '%s' not declared in KEYS", key))
+			}
+		}
+	}
+	return run.d.execWithLock(run.fakeConn, cmd, cmdArgs, writeKeys, readKeys)
+}
+
+// replyToLua 将命令的回复转换为Lua值
+// 整数 -> number，字符串 -> string，空值 -> false，数组 -> table，状态 -> {ok=...}，错误 -> {err=...}
+func replyToLua(L *lua.LState, reply redis.Reply) lua.LValue {
+	switch r := reply.(type) {
+	case *protocol.IntReply:
+		return lua.LNumber(r.Number())
+	case *protocol.BulkReply:
+		if r.Text == nil {
+			return lua.LFalse
+		}
+		return lua.LString(r.Text)
+	case *protocol.MultiBulkReply:
+		tbl := L.CreateTable(len(r.Texts), 0)
+		for _, text := range r.Texts {
+			if text == nil {
+				tbl.Append(lua.LFalse)
+			} else {
+				tbl.Append(lua.LString(text))
+			}
+		}
+		return tbl
+	case *protocol.ArrayReply:
+		tbl := L.CreateTable(len(r.Replies), 0)
+		for _, sub := range r.Replies {
+			tbl.Append(replyToLua(L, sub))
+		}
+		return tbl
+	case *protocol.MapReply:
+		// 脚本中按RESP2转换，映射展开为键值交替的数组
+		return replyToLua(L, r.Resp2())
+	case *protocol.SetReply:
+		return replyToLua(L, protocol.NewArrayReply(r.Members))
+	case *protocol.DoubleReply:
+		return replyToLua(L, r.Resp2())
+	case protocol.ErrorReply:
+		tbl := L.NewTable()
+		tbl.RawSetString("err", lua.LString(r.Error()))
+		return tbl
+	}
+	// OK、PONG、空数组等常量回复
+	raw := string(reply.ToBytes())
+	switch {
+	case strings.HasPrefix(raw, "+"):
+		tbl := L.NewTable()
+		tbl.RawSetString("ok", lua.LString(strings.TrimSuffix(raw[1:], protocol.CRLF)))
+		return tbl
+	case strings.HasPrefix(raw, ":"):
+		n, _ := strconv.ParseInt(strings.TrimSuffix(raw[1:], protocol.CRLF), 10, 64)
+		return lua.LNumber(n)
+	case strings.HasPrefix(raw, "*0"):
+		return L.NewTable()
+	}
+	return lua.LFalse
+}
+
+// luaToReply 将脚本的返回值转换为回复
+// number -> 整数(截断小数部分)，string -> 字符串，true -> 1，false、nil -> 空值，table按数组转换直到第一个nil
+func luaToReply(lv lua.LValue) redis.Reply {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		return protocol.NewIntReply(int64(v))
+	case lua.LString:
+		return protocol.NewBulkReply([]byte(v))
+	case lua.LBool:
+		if v {
+			return protocol.NewIntReply(1)
+		}
+		return protocol.NullBulkReply
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return protocol.NewErrorReply(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return protocol.NewSingleReply(string(msg))
+		}
+		replies := make([]redis.Reply, 0)
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			replies = append(replies, luaToReply(item))
+		}
+		return protocol.NewArrayReply(replies)
+	}
+	return protocol.NullBulkReply
+}
+
+// luaErrorReply redis.error_reply(msg)，返回{err=msg}
+func luaErrorReply(L *lua.LState) int {
+	tbl := L.NewTable()
+	tbl.RawSetString("err", lua.LString(L.CheckString(1)))
+	L.Push(tbl)
+	return 1
+}
+
+// luaStatusReply redis.status_reply(msg)，返回{ok=msg}
+func luaStatusReply(L *lua.LState) int {
+	tbl := L.NewTable()
+	tbl.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(tbl)
+	return 1
+}
+
+// luaSha1Hex redis.sha1hex(s)
+func luaSha1Hex(L *lua.LState) int {
+	L.Push(lua.LString(sha1Hex([]byte(L.CheckString(1)))))
+	return 1
+}
+
+// luaLog redis.log(level, msg ...)，写入服务端日志
+func luaLog(L *lua.LState) int {
+	level := L.CheckInt(1)
+	parts := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	msg := "script: " + strings.Join(parts, " ")
+	switch level {
+	case 0, 1:
+		logger.Debug(msg)
+	case 2:
+		logger.Info(msg)
+	default:
+		logger.Warn(msg)
+	}
+	return 0
+}
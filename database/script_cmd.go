@@ -0,0 +1,158 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+var errNoScript = protocol.NewErrorReply("NOSCRIPT No matching script. Please use EVAL.")
+
+// parseEvalKeys 解析EVAL、EVALSHA的numkeys，返回KEYS和ARGV
+// EVAL script numkeys [key [key ...]] [arg [arg ...]]
+func parseEvalKeys(args [][]byte) ([]string, [][]byte, redis.Reply) {
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, nil, protocol.ErrorNotIntegerReply
+	}
+	if numKeys < 0 {
+		return nil, nil, protocol.NewErrorReply("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-2 {
+		return nil, nil, protocol.NewErrorReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[2+i])
+	}
+	return keys, args[2+numKeys:], nil
+}
+
+// isScriptCommand 判断是否是执行脚本或函数的命令，它们的参数格式相同
+func isScriptCommand(cmdName string) bool {
+	switch cmdName {
+	case "eval", "evalsha", "fcall", "fcall_ro":
+		return true
+	}
+	return false
+}
+
+// prepareEval EVAL、EVALSHA、FCALL、FCALL_RO的prepare，KEYS中的key都加写锁
+func prepareEval(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	keys, _, errReply := parseEvalKeys(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+// lookupScript EVAL时编译并缓存脚本，EVALSHA时从缓存中查找脚本
+func lookupScript(engine *Engine, cmdName string, body []byte) (*luaScript, redis.Reply) {
+	if cmdName == "evalsha" {
+		script, ok := engine.scripts.get(string(body))
+		if !ok {
+			return nil, errNoScript
+		}
+		return script, nil
+	}
+	return engine.scripts.load(body)
+}
+
+// Eval EVAL和EVALSHA命令，原子地执行Lua脚本
+// 执行前锁住KEYS中所有的key，check不为空时在持有锁之后、执行脚本之前调用，用于集群模式下的重定向
+// EVAL script numkeys [key [key ...]] [arg [arg ...]]
+// EVALSHA sha1 numkeys [key [key ...]] [arg [arg ...]]
+func Eval(engine *Engine, c redis.Connection, cmdName string, args [][]byte, check func() redis.Reply) redis.Reply {
+	if len(args) < 2 {
+		return protocol.NewArgNumErrReply(cmdName)
+	}
+	keys, argv, errReply := parseEvalKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	script, errReply := lookupScript(engine, cmdName, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	d, dbErr := engine.selectDB(c.GetDBIndex())
+	if dbErr != nil {
+		return dbErr
+	}
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	d.RWLocks(keys, nil)
+	defer d.RWUnLocks(keys, nil)
+	if check != nil {
+		if reply := check(); reply != nil {
+			return reply
+		}
+	}
+	return engine.runScript(d, c, script, keys, argv)
+}
+
+// execQueuedScript 执行事务中的EVAL、EVALSHA、FCALL、FCALL_RO，调用方已经持有所有key的锁
+func execQueuedScript(engine *Engine, d *DB, c redis.Connection, cmdName string, args [][]byte) redis.Reply {
+	if cmdName == "fcall" || cmdName == "fcall_ro" {
+		return execQueuedFCall(engine, d, c, cmdName, args)
+	}
+	keys, argv, errReply := parseEvalKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	script, errReply := lookupScript(engine, cmdName, args[0])
+	if errReply != nil {
+		return errReply
+	}
+	return engine.runScript(d, c, script, keys, argv)
+}
+
+// Script SCRIPT命令，管理脚本缓存
+// SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH [ASYNC|SYNC]
+func Script(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("script")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "load":
+		if len(args) != 2 {
+			return protocol.NewArgNumErrReply("script|load")
+		}
+		script, errReply := engine.scripts.load(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		return protocol.NewBulkReply([]byte(script.sha))
+	case "exists":
+		if len(args) < 2 {
+			return protocol.NewArgNumErrReply("script|exists")
+		}
+		replies := make([]redis.Reply, 0, len(args)-1)
+		for _, sha := range args[1:] {
+			if _, ok := engine.scripts.get(string(sha)); ok {
+				replies = append(replies, protocol.NewIntReply(1))
+			} else {
+				replies = append(replies, protocol.NewIntReply(0))
+			}
+		}
+		return protocol.NewArrayReply(replies)
+	case "flush":
+		if len(args) > 2 {
+			return protocol.NewArgNumErrReply("script|flush")
+		}
+		if len(args) == 2 {
+			mode := strings.ToLower(string(args[1]))
+			if mode != "async" && mode != "sync" {
+				return protocol.NewErrorReply("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+			}
+		}
+		engine.scripts.flush()
+		return protocol.OKReply
+	}
+	return protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try SCRIPT HELP.", string(args[0])))
+}
@@ -47,10 +47,13 @@ func SAddCommand(d *DB, args [][]byte) redis.Reply {
 	if !keyExists {
 		d.PutEntity(key, buildSetEntity(set))
 	}
+	if count > 0 {
+		d.notify(notifySet, "sadd", key)
+	}
 	return protocol.NewIntReply(int64(count))
 }
 
-// SMembersCommand 返回集合中所有元素； key不存在，返回空数组；key为其他类型，返回错误
+// SMembersCommand 返回集合中所有元素，RESP3下为集合类型； key不存在，返回空集合；key为其他类型，返回错误
 func SMembersCommand(d *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	set, errReply := d.getEntityAsSet(key)
@@ -58,7 +61,7 @@ func SMembersCommand(d *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if set == nil {
-		return protocol.EmptyMultiBulkReply
+		return protocol.NewSetReply(nil)
 	}
 
 	members := make([][]byte, set.Len())
@@ -67,7 +70,32 @@ func SMembersCommand(d *DB, args [][]byte) redis.Reply {
 		members[idx] = []byte(member)
 		idx++
 	}
-	return protocol.NewMultiBulkReply(members)
+	return protocol.NewSetReply(protocol.BulkReplies(members))
+}
+
+// SScanCommand 使用游标增量遍历集合中的元素，用法与SCAN相同
+// 遍历开始到结束期间一直存在的元素一定会被返回，但集合扩容或缩容后可能返回重复的元素
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func SScanCommand(d *DB, args [][]byte) redis.Reply {
+	cursor, opts, errReply := parseScanArgs("sscan", args[1], args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	set, errReply := d.getEntityAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return scanReply(0, nil)
+	}
+	res := make([][]byte, 0)
+	cursor = set.Scan(cursor, opts.count, func(member string) bool {
+		if opts.match(member) {
+			res = append(res, []byte(member))
+		}
+		return true
+	})
+	return scanReply(cursor, res)
 }
 
 // SCardCommand 返回集合元素的数量，key不存在返回0；类型不对返回错误
@@ -98,8 +126,12 @@ func SRemCommand(d *DB, args [][]byte) redis.Reply {
 	for i := 1; i < len(args); i++ {
 		deletedCount += set.Remove(string(args[i]))
 	}
+	if deletedCount > 0 {
+		d.notify(notifySet, "srem", key)
+	}
 	if set.Len() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
 	}
 	return protocol.NewIntReply(int64(deletedCount))
 }
@@ -141,7 +173,7 @@ func SDiffCommand(d *DB, args [][]byte) redis.Reply {
 		ret[idx] = []byte(member)
 		idx++
 	}
-	return protocol.NewMultiBulkReply(ret)
+	return protocol.NewSetReply(protocol.BulkReplies(ret))
 }
 
 // SDiffStoreCommand 与sdiff命令类似，但是会将取差集后的元素存入到新key中，并返回差集元素的个数
@@ -158,6 +190,7 @@ func SDiffStoreCommand(d *DB, args [][]byte) redis.Reply {
 	if ret == 0 {
 		d.Persist(newKey)
 	}
+	d.notify(notifySet, "sdiffstore", newKey)
 	return protocol.NewIntReply(int64(diffSet.Len()))
 }
 
@@ -173,7 +206,7 @@ func SUnionCommand(d *DB, args [][]byte) redis.Reply {
 		ret[idx] = []byte(member)
 		idx++
 	}
-	return protocol.NewMultiBulkReply(ret)
+	return protocol.NewSetReply(protocol.BulkReplies(ret))
 }
 
 func SUnionStoreCommand(d *DB, args [][]byte) redis.Reply {
@@ -188,6 +221,7 @@ func SUnionStoreCommand(d *DB, args [][]byte) redis.Reply {
 	if ret == 0 {
 		d.Persist(newKey)
 	}
+	d.notify(notifySet, "sunionstore", newKey)
 	return protocol.NewIntReply(int64(diffSet.Len()))
 }
 
@@ -204,7 +238,7 @@ func SInterCommand(d *DB, args [][]byte) redis.Reply {
 		ret[idx] = []byte(member)
 		idx++
 	}
-	return protocol.NewMultiBulkReply(ret)
+	return protocol.NewSetReply(protocol.BulkReplies(ret))
 }
 
 // SInterStoreCommand 多个key对应集合取交集，并存储到newKey上
@@ -221,6 +255,7 @@ func SInterStoreCommand(d *DB, args [][]byte) redis.Reply {
 	if ret == 0 {
 		d.Persist(newKey)
 	}
+	d.notify(notifySet, "sinterstore", newKey)
 	return protocol.NewIntReply(int64(diffSet.Len()))
 }
 
@@ -336,8 +371,10 @@ func SMoveCommand(d *DB, args [][]byte) redis.Reply {
 	}
 
 	sourceSet.Remove(member)
+	d.notify(notifySet, "srem", source)
 	if sourceSet.Len() == 0 {
 		d.Remove(source)
+		d.notify(notifyGeneric, "del", source)
 	}
 	if destSet == nil {
 		destSet = setds.NewSet(member)
@@ -345,6 +382,7 @@ func SMoveCommand(d *DB, args [][]byte) redis.Reply {
 	} else {
 		destSet.Add(member)
 	}
+	d.notify(notifySet, "sadd", dest)
 	return protocol.NewIntReply(1)
 }
 
@@ -420,8 +458,12 @@ func SPopCommand(d *DB, args [][]byte) redis.Reply {
 		memberBytes[idx] = []byte(member)
 		idx++
 	}
+	if len(members) > 0 {
+		d.notify(notifySet, "spop", key)
+	}
 	if set.Len() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
 	}
 
 	return protocol.NewMultiBulkReply(memberBytes)
@@ -430,6 +472,7 @@ func SPopCommand(d *DB, args [][]byte) redis.Reply {
 func init() {
 	registerNormalCommand("sadd", SAddCommand, writeFirstKey, -2, tagWrite)
 	registerNormalCommand("smembers", SMembersCommand, readFirstKey, 2, tagRead)
+	registerNormalCommand("sscan", SScanCommand, readFirstKey, -3, tagRead)
 	registerNormalCommand("scard", SCardCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("srem", SRemCommand, writeFirstKey, -2, tagWrite)
 	registerNormalCommand("sdiff", SDiffCommand, readAllKeys, -2, tagRead)
@@ -443,6 +486,6 @@ func init() {
 	registerNormalCommand("smismember", SMIsMemberCommand, readFirstKey, -3, tagRead)
 	registerNormalCommand("smove", SMoveCommand, prepareSMove, 4, tagWrite)
 	registerNormalCommand("srandmember", SRandMemberCommand, readFirstKey, -2, tagRead)
-	registerNormalCommand("spop", SPopCommand, writeFirstKey, -2, tagWrite)
+	registerNormalCommand("spop", SPopCommand, writeFirstKey, -2, tagWrite).attachAof(sPopToAof)
 	// sscan 这个命令比较复杂，暂不实现 https://www.lixueduan.com/posts/redis/redis-scan/
 }
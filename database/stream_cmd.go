@@ -0,0 +1,1613 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"zedis/datastruct/stream"
+	"zedis/interface/db"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+var (
+	errInvalidStreamIDReply = protocol.NewErrorReply("ERR Invalid stream ID specified as stream command argument")
+	errStreamIDTooSmall     = protocol.NewErrorReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errXGroupKeyRequired    = protocol.NewErrorReply("ERR The XGROUP subcommand requires the key to exist. " +
+		"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+)
+
+// streamApproxTrimLimit 近似裁剪没有指定LIMIT时最多删除的消息数量，与Redis默认的 100 * stream-node-max-entries 相同
+const streamApproxTrimLimit = 10000
+
+func (d *DB) getEntityAsStream(key string) (*stream.Stream, redis.Reply) {
+	entity, exists := d.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	if entity.Type != db.StreamType {
+		return nil, protocol.ErrorWrongTypeReply
+	}
+	return entity.Data.(*stream.Stream), nil
+}
+
+func buildStreamEntity(s *stream.Stream) *db.DataEntity {
+	return &db.DataEntity{
+		Data: s,
+		Type: db.StreamType,
+	}
+}
+
+// getStreamGroup 返回消息流和消费者组，key或消费者组不存在时返回NOGROUP错误
+func (d *DB) getStreamGroup(key, group string) (*stream.Stream, *stream.Group, redis.Reply) {
+	s, errReply := d.getEntityAsStream(key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s == nil || s.GetGroup(group) == nil {
+		return nil, nil, protocol.NewErrorReply(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+	}
+	return s, s.GetGroup(group), nil
+}
+
+// getOrCreateConsumer 返回消费者组中的消费者，不存在时创建，并更新最后一次读取的时间
+func (d *DB) getOrCreateConsumer(key string, g *stream.Group, name string, now int64) *stream.Consumer {
+	consumer := g.GetConsumer(name)
+	if consumer == nil {
+		consumer = g.CreateConsumer(name, now)
+		d.notify(notifyStream, "xgroup-createconsumer", key)
+	}
+	consumer.SeenTime = now
+	return consumer
+}
+
+// parseStreamID 解析 ms-seq 或 ms 格式的ID，不接受 - 和 +
+func parseStreamID(arg []byte) (stream.ID, redis.Reply) {
+	if s := string(arg); s == "-" || s == "+" {
+		return stream.ID{}, errInvalidStreamIDReply
+	}
+	id, err := stream.ParseID(string(arg), 0)
+	if err != nil {
+		return stream.ID{}, errInvalidStreamIDReply
+	}
+	return id, nil
+}
+
+// parseStreamRangeID 解析范围查询的起始或结束ID，支持 - 和 +，只有ms时起始ID的序号为0，结束ID的序号为最大值
+// 以 ( 开头时表示不包含该ID
+func parseStreamRangeID(arg []byte, isStart bool) (stream.ID, redis.Reply) {
+	s := string(arg)
+	if strings.HasPrefix(s, "(") {
+		id, errReply := parseStreamID(arg[1:])
+		if errReply != nil {
+			return id, errReply
+		}
+		var ok bool
+		if isStart {
+			if id, ok = id.Incr(); !ok {
+				return id, protocol.NewErrorReply("ERR invalid start ID for the interval")
+			}
+		} else if id, ok = id.Decr(); !ok {
+			return id, protocol.NewErrorReply("ERR invalid end ID for the interval")
+		}
+		return id, nil
+	}
+	var defaultSeq uint64
+	if !isStart {
+		defaultSeq = math.MaxUint64
+	}
+	id, err := stream.ParseID(s, defaultSeq)
+	if err != nil {
+		return id, errInvalidStreamIDReply
+	}
+	return id, nil
+}
+
+// streamIDReply 将ID转换为字符串响应
+func streamIDReply(id stream.ID) redis.Reply {
+	return protocol.NewBulkReply([]byte(id.String()))
+}
+
+// streamEntryReply 将消息转换为 [id, [field value ...]] 格式的响应
+func streamEntryReply(entry *stream.Entry) redis.Reply {
+	return protocol.NewArrayReply([]redis.Reply{
+		streamIDReply(entry.ID),
+		protocol.NewMultiBulkReply(entry.Fields),
+	})
+}
+
+func streamEntriesReply(entries []*stream.Entry) *protocol.ArrayReply {
+	replies := make([]redis.Reply, 0, len(entries))
+	for _, entry := range entries {
+		replies = append(replies, streamEntryReply(entry))
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// nullableIntReply 为负数时返回空值，用于消费者组中未知的已读数量和lag
+func nullableIntReply(n int64, ok bool) redis.Reply {
+	if !ok || n < 0 {
+		return protocol.NullBulkReply
+	}
+	return protocol.NewIntReply(n)
+}
+
+/* ---- 裁剪参数 ---- */
+
+// streamTrimArgs XADD、XTRIM的裁剪参数
+type streamTrimArgs struct {
+	// "maxlen"或"minid"，为空表示不裁剪
+	strategy string
+	approx   bool
+	maxLen   int
+	minID    stream.ID
+	// 近似裁剪时最多删除的消息数量，0表示不限制
+	limit      int
+	limitGiven bool
+}
+
+// parseStreamTrimOption 解析args[i]开始的 MAXLEN|MINID [=|~] threshold 或 LIMIT count，返回最后一个被解析的参数的位置
+// args[i]不是裁剪参数时返回-1
+func parseStreamTrimOption(args [][]byte, i int, trim *streamTrimArgs) (int, redis.Reply) {
+	opt := strings.ToLower(string(args[i]))
+	switch opt {
+	case "maxlen", "minid":
+		if i+1 >= len(args) {
+			return i, protocol.ErrorSyntaxReply
+		}
+		trim.strategy = opt
+		i++
+		if mode := string(args[i]); mode == "=" || mode == "~" {
+			trim.approx = mode == "~"
+			i++
+			if i >= len(args) {
+				return i, protocol.ErrorSyntaxReply
+			}
+		}
+		if opt == "maxlen" {
+			maxLen, err := parseInt(args[i])
+			if err != nil {
+				return i, protocol.ErrorNotIntegerReply
+			}
+			if maxLen < 0 {
+				return i, protocol.NewErrorReply("ERR The MAXLEN argument must be >= 0.")
+			}
+			trim.maxLen = maxLen
+		} else {
+			minID, errReply := parseStreamID(args[i])
+			if errReply != nil {
+				return i, errReply
+			}
+			trim.minID = minID
+		}
+		return i, nil
+	case "limit":
+		if i+1 >= len(args) {
+			return i, protocol.ErrorSyntaxReply
+		}
+		limit, err := parseInt(args[i+1])
+		if err != nil {
+			return i, protocol.ErrorNotIntegerReply
+		}
+		if limit < 0 {
+			return i, protocol.NewErrorReply("ERR The LIMIT argument must be >= 0.")
+		}
+		trim.limit = limit
+		trim.limitGiven = true
+		return i + 1, nil
+	}
+	return -1, nil
+}
+
+// check 检查裁剪参数的组合，并设置近似裁剪默认的LIMIT
+func (trim *streamTrimArgs) check() redis.Reply {
+	if trim.limitGiven && !trim.approx {
+		return protocol.NewErrorReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if trim.approx && !trim.limitGiven {
+		trim.limit = streamApproxTrimLimit
+	}
+	return nil
+}
+
+// apply 裁剪消息流，返回删除的消息数量
+func (trim *streamTrimArgs) apply(s *stream.Stream) int {
+	switch trim.strategy {
+	case "maxlen":
+		return s.TrimByMaxLen(trim.maxLen, trim.approx, trim.limit)
+	case "minid":
+		return s.TrimByMinID(trim.minID, trim.approx, trim.limit)
+	}
+	return 0
+}
+
+/* ---- 基本命令 ---- */
+
+// xAddArgs XADD命令的参数，idIndex为ID参数的位置
+type xAddArgs struct {
+	noMkStream bool
+	trim       streamTrimArgs
+	idIndex    int
+}
+
+func parseXAddArgs(args [][]byte) (*xAddArgs, redis.Reply) {
+	res := &xAddArgs{}
+	i := 1
+	for ; i < len(args); i++ {
+		if strings.ToUpper(string(args[i])) == "NOMKSTREAM" {
+			res.noMkStream = true
+			continue
+		}
+		next, errReply := parseStreamTrimOption(args, i, &res.trim)
+		if errReply != nil {
+			return nil, errReply
+		}
+		if next < 0 {
+			break
+		}
+		i = next
+	}
+	if errReply := res.trim.check(); errReply != nil {
+		return nil, errReply
+	}
+	// ID之后至少有一对field value
+	fieldCount := len(args) - i - 1
+	if fieldCount <= 0 || fieldCount%2 != 0 {
+		return nil, protocol.NewArgNumErrReply("xadd")
+	}
+	res.idIndex = i
+	return res, nil
+}
+
+// generateStreamID 根据XADD的ID参数生成新消息的ID，参数为 * 时根据当前时间生成，为 ms-* 时自动生成序号
+func generateStreamID(s *stream.Stream, arg string) (stream.ID, redis.Reply) {
+	lastID := s.LastID()
+	if arg == "*" {
+		id, ok := s.NextID(uint64(time.Now().UnixMilli()))
+		if !ok {
+			return id, protocol.NewErrorReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return stream.ID{}, errInvalidStreamIDReply
+		}
+		if ms < lastID.Ms || (ms == lastID.Ms && lastID.Seq == math.MaxUint64) {
+			return stream.ID{}, errStreamIDTooSmall
+		}
+		if ms == lastID.Ms {
+			return stream.ID{Ms: ms, Seq: lastID.Seq + 1}, nil
+		}
+		return stream.ID{Ms: ms}, nil
+	}
+	id, errReply := parseStreamID([]byte(arg))
+	if errReply != nil {
+		return id, errReply
+	}
+	if id.IsZero() {
+		return id, protocol.NewErrorReply("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if !lastID.Less(id) {
+		return id, errStreamIDTooSmall
+	}
+	return id, nil
+}
+
+// XAddCommand 向消息流末尾添加消息，返回消息的ID；key不存在时创建消息流，指定NOMKSTREAM时不创建并返回nil
+// XADD key [NOMKSTREAM] [<MAXLEN | MINID> [= | ~] threshold [LIMIT count]] <* | id> field value [field value ...]
+// ID为 * 时根据当前毫秒时间戳自动生成，新消息的ID必须大于最后添加的消息的ID
+// MAXLEN、MINID: 添加后从头部删除消息，直到消息数量不超过threshold，或者ID不小于threshold
+// ~ 表示近似裁剪，只删除整个节点，LIMIT限制最多删除的消息数量
+func XAddCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	opts, errReply := parseXAddArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	s, errReply := d.getEntityAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	keyExists := s != nil
+	if !keyExists {
+		if opts.noMkStream {
+			return protocol.NullBulkReply
+		}
+		s = stream.NewStream()
+	}
+	id, errReply := generateStreamID(s, string(args[opts.idIndex]))
+	if errReply != nil {
+		return errReply
+	}
+	if !keyExists {
+		d.PutEntity(key, buildStreamEntity(s))
+	}
+	s.Add(id, args[opts.idIndex+1:])
+	d.notify(notifyStream, "xadd", key)
+	if opts.trim.apply(s) > 0 {
+		d.notify(notifyStream, "xtrim", key)
+	}
+	return streamIDReply(id)
+}
+
+// xAddToAof 自动生成的ID替换为实际的ID，裁剪替换为精确裁剪到执行后的长度，重放时结果与执行时相同
+func xAddToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	r, ok := reply.(*protocol.BulkReply)
+	if !ok || r.Text == nil {
+		return nil
+	}
+	opts, _ := parseXAddArgs(args)
+	line := CmdLine{[]byte("xadd"), args[0]}
+	if opts.trim.strategy != "" {
+		s, _ := d.getEntityAsStream(string(args[0]))
+		line = append(line, []byte("MAXLEN"), []byte("="), []byte(strconv.Itoa(s.Len())))
+	}
+	line = append(line, r.Text)
+	line = append(line, args[opts.idIndex+1:]...)
+	return []CmdLine{line}
+}
+
+// XTrimCommand 从头部删除消息，返回删除的消息数量
+// XTRIM key <MAXLEN | MINID> [= | ~] threshold [LIMIT count]
+func XTrimCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	var trim streamTrimArgs
+	for i := 1; i < len(args); i++ {
+		next, errReply := parseStreamTrimOption(args, i, &trim)
+		if errReply != nil {
+			return errReply
+		}
+		if next < 0 {
+			return protocol.ErrorSyntaxReply
+		}
+		i = next
+	}
+	if trim.strategy == "" {
+		return protocol.ErrorSyntaxReply
+	}
+	if errReply := trim.check(); errReply != nil {
+		return errReply
+	}
+	s, errReply := d.getEntityAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.ZeroReply
+	}
+	removed := trim.apply(s)
+	if removed > 0 {
+		d.notify(notifyStream, "xtrim", key)
+	}
+	return protocol.NewIntReply(int64(removed))
+}
+
+// xTrimToAof 转换为精确裁剪到执行后的长度
+func xTrimToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	if r, ok := reply.(*protocol.IntReply); !ok || r.Number() == 0 {
+		return nil
+	}
+	s, _ := d.getEntityAsStream(string(args[0]))
+	return []CmdLine{{[]byte("xtrim"), args[0], []byte("MAXLEN"), []byte("="), []byte(strconv.Itoa(s.Len()))}}
+}
+
+// XRangeCommand 按ID从小到大返回ID在[start, end]范围内的消息
+// XRANGE key start end [COUNT count]
+// - 和 + 表示最小和最大的ID，ID前加 ( 表示不包含该ID
+func XRangeCommand(d *DB, args [][]byte) redis.Reply {
+	return streamRange(d, args, false)
+}
+
+// XRevRangeCommand 按ID从大到小返回ID在[start, end]范围内的消息
+// XREVRANGE key end start [COUNT count]
+func XRevRangeCommand(d *DB, args [][]byte) redis.Reply {
+	return streamRange(d, args, true)
+}
+
+func streamRange(d *DB, args [][]byte, rev bool) redis.Reply {
+	key := string(args[0])
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseStreamRangeID(startArg, true)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseStreamRangeID(endArg, false)
+	if errReply != nil {
+		return errReply
+	}
+	count := -1
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+			return protocol.ErrorSyntaxReply
+		}
+		n, err := parseInt(args[4])
+		if err != nil {
+			return protocol.ErrorNotIntegerReply
+		}
+		count = n
+		if count < 0 {
+			count = 0
+		}
+	}
+	s, errReply := d.getEntityAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil || count == 0 {
+		return protocol.NewArrayReply(nil)
+	}
+	return streamEntriesReply(s.Range(start, end, count, rev))
+}
+
+// XLenCommand 返回消息流中的消息数量，key不存在时返回0
+// XLEN key
+func XLenCommand(d *DB, args [][]byte) redis.Reply {
+	s, errReply := d.getEntityAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.ZeroReply
+	}
+	return protocol.NewIntReply(int64(s.Len()))
+}
+
+// XDelCommand 删除指定ID的消息，返回删除的消息数量，删除后消息流为空时不会删除key
+// XDEL key id [id ...]
+func XDelCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ids := make([]stream.ID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+	s, errReply := d.getEntityAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.ZeroReply
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		d.notify(notifyStream, "xdel", key)
+	}
+	return protocol.NewIntReply(int64(deleted))
+}
+
+// XSetIDCommand 修改消息流最后添加的消息ID，以及添加过的消息数量和被删除的最大ID，用于复制和AOF重写
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func XSetIDCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	lastID, errReply := parseStreamID(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID stream.ID
+	maxDeletedGiven := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.ErrorSyntaxReply
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "ENTRIESADDED":
+			n, err := parseInt64(args[i+1])
+			if err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			if n < 0 {
+				return protocol.NewErrorReply("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			maxDeletedID, errReply = parseStreamID(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			maxDeletedGiven = true
+		default:
+			return protocol.ErrorSyntaxReply
+		}
+	}
+	if lastID.Less(maxDeletedID) {
+		return protocol.NewErrorReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	}
+	s, errReply := d.getEntityAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.ErrorNoSuchKeyReply
+	}
+	if entriesAdded >= 0 && int64(s.Len()) > entriesAdded {
+		return protocol.NewErrorReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if last := s.LastEntry(); last != nil && lastID.Less(last.ID) {
+		return protocol.NewErrorReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.SetLastID(lastID)
+	if entriesAdded >= 0 {
+		s.SetEntriesAdded(uint64(entriesAdded))
+	}
+	if maxDeletedGiven {
+		s.SetMaxDeletedID(maxDeletedID)
+	}
+	d.notify(notifyStream, "xsetid", key)
+	return protocol.OKReply
+}
+
+/* ---- XREAD、XREADGROUP ---- */
+
+// streamReadArgs XREAD、XREADGROUP的参数
+type streamReadArgs struct {
+	group    string
+	consumer string
+	// 每个消息流最多返回的消息数量，0表示不限制
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	// 每个key对应的ID参数
+	ids [][]byte
+	// 第一个ID参数的位置
+	idIndex int
+}
+
+// findStreamsIndex 返回STREAMS参数的位置，跳过COUNT、BLOCK、GROUP等选项的值，不存在时返回-1
+func findStreamsIndex(args [][]byte) int {
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "STREAMS":
+			return i
+		case "COUNT", "BLOCK":
+			i++
+		case "GROUP":
+			i += 2
+		}
+	}
+	return -1
+}
+
+// streamReadKeys 返回XREAD、XREADGROUP中STREAMS之后的key，参数不合法时返回nil，由命令返回错误
+func streamReadKeys(args [][]byte) []string {
+	i := findStreamsIndex(args)
+	if i < 0 || (len(args)-i-1)%2 != 0 {
+		return nil
+	}
+	n := (len(args) - i - 1) / 2
+	keys := make([]string, 0, n)
+	for _, arg := range args[i+1 : i+1+n] {
+		keys = append(keys, string(arg))
+	}
+	return keys
+}
+
+func parseStreamReadArgs(args [][]byte, withGroup bool) (*streamReadArgs, redis.Reply) {
+	cmdName := "xread"
+	if withGroup {
+		cmdName = "xreadgroup"
+	}
+	res := &streamReadArgs{}
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "STREAMS" {
+			break
+		}
+		switch {
+		case opt == "COUNT" && i+1 < len(args):
+			count, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, protocol.ErrorNotIntegerReply
+			}
+			if count < 0 {
+				count = 0
+			}
+			res.count = count
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			ms, err := parseInt64(args[i+1])
+			if err != nil {
+				return nil, protocol.NewErrorReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return nil, protocol.NewErrorReply("ERR timeout is negative")
+			}
+			res.block = true
+			res.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case opt == "GROUP" && withGroup && i+2 < len(args):
+			res.group = string(args[i+1])
+			res.consumer = string(args[i+2])
+			i += 2
+		case opt == "NOACK" && withGroup:
+			res.noAck = true
+		default:
+			return nil, protocol.ErrorSyntaxReply
+		}
+	}
+	if i == len(args) {
+		return nil, protocol.ErrorSyntaxReply
+	}
+	if withGroup && res.group == "" {
+		return nil, protocol.NewErrorReply("ERR Missing GROUP option for XREADGROUP")
+	}
+	rest := args[i+1:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return nil, protocol.NewErrorReply(fmt.Sprintf("ERR Unbalanced '%s' list of streams: "+
+			"for each stream key an ID or '$' must be specified.", cmdName))
+	}
+	n := len(rest) / 2
+	for _, arg := range rest[:n] {
+		res.keys = append(res.keys, string(arg))
+	}
+	res.ids = rest[n:]
+	res.idIndex = i + 1 + n
+	return res, nil
+}
+
+// XReadCommand 从一个或多个消息流中读取ID大于指定ID的消息，返回每个有消息的key和消息
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// ID为 $ 时表示只读取之后添加的消息；指定BLOCK时，如果没有消息，则阻塞至有新消息或超时，0表示永久阻塞
+// RESP2下返回 [[key, [entry ...]] ...]，RESP3下返回映射；没有消息时返回nil
+func XReadCommand(d *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseStreamReadArgs(args, false)
+	if errReply != nil {
+		return errReply
+	}
+	streams := make([]*stream.Stream, len(opts.keys))
+	ids := make([]stream.ID, len(opts.keys))
+	hasLastID := false
+	for i, key := range opts.keys {
+		s, errReply := d.getEntityAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		streams[i] = s
+		switch string(opts.ids[i]) {
+		case "$":
+			if s != nil {
+				ids[i] = s.LastID()
+			}
+			hasLastID = true
+		case ">":
+			return protocol.NewErrorReply("ERR The > ID can be specified only when calling XREADGROUP " +
+				"using the GROUP <group> <consumer> option.")
+		default:
+			if ids[i], errReply = parseStreamID(opts.ids[i]); errReply != nil {
+				return errReply
+			}
+		}
+	}
+
+	pairs := make([]redis.Reply, 0)
+	for i, s := range streams {
+		if s == nil {
+			continue
+		}
+		start, ok := ids[i].Incr()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, stream.MaxID, opts.count, false)
+		if len(entries) > 0 {
+			pairs = append(pairs, protocol.NewBulkReply([]byte(opts.keys[i])), streamEntriesReply(entries))
+		}
+	}
+	if len(pairs) > 0 {
+		return protocol.NewNestedMapReply(pairs)
+	}
+	if !opts.block {
+		return protocol.NullMultiBulkReply
+	}
+	block := newBlockReply(opts.keys, opts.timeout, protocol.NullMultiBulkReply)
+	if hasLastID {
+		// 被唤醒后读取阻塞时最后一条消息之后的消息，而不是唤醒时的
+		block.args = make([][]byte, len(args))
+		copy(block.args, args)
+		for i, id := range ids {
+			block.args[opts.idIndex+i] = []byte(id.String())
+		}
+	}
+	return block
+}
+
+// XReadGroupCommand 以消费者组中消费者的身份读取消息
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+// ID为 > 时读取组内还没有发送给任何消费者的消息，并加入消费者的待确认消息(PEL)，指定NOACK时不加入
+// 其他ID读取发送给该消费者、ID大于指定ID且还没有确认的消息，已经被删除的消息返回 [id, nil]
+// 消费者不存在时自动创建；只有所有ID都为 > 时才会阻塞
+func XReadGroupCommand(d *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseStreamReadArgs(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	streams := make([]*stream.Stream, len(opts.keys))
+	ids := make([]stream.ID, len(opts.keys))
+	onlyNew := make([]bool, len(opts.keys))
+	for i, key := range opts.keys {
+		s, errReply := d.getEntityAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		if s == nil || s.GetGroup(opts.group) == nil {
+			return protocol.NewErrorReply(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' "+
+				"in XREADGROUP with GROUP option", key, opts.group))
+		}
+		streams[i] = s
+		switch string(opts.ids[i]) {
+		case ">":
+			onlyNew[i] = true
+		case "$":
+			return protocol.NewErrorReply("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, " +
+				"or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			if ids[i], errReply = parseStreamID(opts.ids[i]); errReply != nil {
+				return errReply
+			}
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	pairs := make([]redis.Reply, 0)
+	for i, s := range streams {
+		key := opts.keys[i]
+		g := s.GetGroup(opts.group)
+		consumer := d.getOrCreateConsumer(key, g, opts.consumer, now)
+		var reply redis.Reply
+		if onlyNew[i] {
+			reply = readNewEntries(s, g, consumer, opts.count, opts.noAck, now)
+		} else {
+			reply = readConsumerHistory(s, g, consumer, ids[i], opts.count, now)
+		}
+		if reply != nil {
+			pairs = append(pairs, protocol.NewBulkReply([]byte(key)), reply)
+		}
+	}
+	if len(pairs) > 0 {
+		return protocol.NewNestedMapReply(pairs)
+	}
+	if !opts.block {
+		return protocol.NullMultiBulkReply
+	}
+	return newBlockReply(opts.keys, opts.timeout, protocol.NullMultiBulkReply)
+}
+
+// readNewEntries 读取组内还没有发送过的消息，没有消息时返回nil
+func readNewEntries(s *stream.Stream, g *stream.Group, consumer *stream.Consumer, count int, noAck bool, now int64) redis.Reply {
+	start, ok := g.LastID.Incr()
+	if !ok {
+		return nil
+	}
+	entries := s.Range(start, stream.MaxID, count, false)
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, entry := range entries {
+		s.Deliver(g, entry.ID)
+		if !noAck {
+			pe := g.AddPending(entry.ID, consumer)
+			pe.DeliveryTime = now
+			pe.DeliveryCount = 1
+		}
+	}
+	consumer.ActiveTime = now
+	return streamEntriesReply(entries)
+}
+
+// readConsumerHistory 读取发送给消费者、ID大于start且还没有确认的消息，并更新发送时间和次数
+func readConsumerHistory(s *stream.Stream, g *stream.Group, consumer *stream.Consumer, start stream.ID, count int, now int64) redis.Reply {
+	replies := make([]redis.Reply, 0)
+	for _, id := range consumer.PendingIDs() {
+		if count > 0 && len(replies) >= count {
+			break
+		}
+		if !start.Less(id) {
+			continue
+		}
+		entry := s.Get(id)
+		if entry == nil {
+			replies = append(replies, protocol.NewArrayReply([]redis.Reply{streamIDReply(id), protocol.NullMultiBulkReply}))
+			continue
+		}
+		pe := g.GetPending(id)
+		pe.DeliveryTime = now
+		pe.DeliveryCount++
+		replies = append(replies, streamEntryReply(entry))
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// xReadGroupToAof 去掉BLOCK参数，重放时不会阻塞
+func xReadGroupToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	line := CmdLine{[]byte("xreadgroup")}
+	streamsIndex := findStreamsIndex(args)
+	for i := 0; i < len(args); i++ {
+		if i < streamsIndex && strings.ToUpper(string(args[i])) == "BLOCK" {
+			i++
+			continue
+		}
+		line = append(line, args[i])
+	}
+	return []CmdLine{line}
+}
+
+/* ---- 消费者组 ---- */
+
+// parseEntriesRead 解析XGROUP的ENTRIESREAD参数，-1表示未知
+func parseEntriesRead(arg []byte) (int64, redis.Reply) {
+	n, err := parseInt64(arg)
+	if err != nil {
+		return 0, protocol.ErrorNotIntegerReply
+	}
+	if n < -1 {
+		return 0, protocol.NewErrorReply("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// XGroupCommand 管理消费者组
+// XGROUP CREATE key group <id | $> [MKSTREAM] [ENTRIESREAD entries-read]: 创建消费者组，$ 表示只读取之后添加的消息
+// XGROUP SETID key group <id | $> [ENTRIESREAD entries-read]: 修改消费者组最后发送的消息ID
+// XGROUP DESTROY key group: 删除消费者组，返回删除的数量
+// XGROUP CREATECONSUMER key group consumer: 创建消费者，返回创建的数量
+// XGROUP DELCONSUMER key group consumer: 删除消费者，返回它还没有确认的消息数量
+func XGroupCommand(d *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	argNumErr := protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. "+
+		"Try XGROUP HELP.", subCmd))
+	var minArgs, maxArgs int
+	switch subCmd {
+	case "create":
+		minArgs, maxArgs = 4, 7
+	case "setid":
+		minArgs, maxArgs = 4, 6
+	case "destroy":
+		minArgs, maxArgs = 3, 3
+	case "createconsumer", "delconsumer":
+		minArgs, maxArgs = 4, 4
+	default:
+		return protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try XGROUP HELP.", subCmd))
+	}
+	if len(args) < minArgs || len(args) > maxArgs {
+		return argNumErr
+	}
+
+	key, groupName := string(args[1]), string(args[2])
+	mkStream := false
+	entriesRead := int64(-1)
+	if subCmd == "create" || subCmd == "setid" {
+		for i := 4; i < len(args); i++ {
+			opt := strings.ToUpper(string(args[i]))
+			if opt == "MKSTREAM" && subCmd == "create" {
+				mkStream = true
+			} else if opt == "ENTRIESREAD" && i+1 < len(args) {
+				var errReply redis.Reply
+				if entriesRead, errReply = parseEntriesRead(args[i+1]); errReply != nil {
+					return errReply
+				}
+				i++
+			} else {
+				return protocol.ErrorSyntaxReply
+			}
+		}
+	}
+
+	s, errReply := d.getEntityAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil && !mkStream {
+		return errXGroupKeyRequired
+	}
+	var id stream.ID
+	if subCmd == "create" || subCmd == "setid" {
+		if string(args[3]) == "$" {
+			if s != nil {
+				id = s.LastID()
+			}
+		} else if id, errReply = parseStreamID(args[3]); errReply != nil {
+			return errReply
+		}
+	}
+
+	if subCmd == "create" {
+		if s == nil {
+			s = stream.NewStream()
+			d.PutEntity(key, buildStreamEntity(s))
+		}
+		if s.CreateGroup(groupName, id, entriesRead) == nil {
+			return protocol.NewErrorReply("BUSYGROUP Consumer Group name already exists")
+		}
+		d.notify(notifyStream, "xgroup-create", key)
+		return protocol.OKReply
+	}
+	if subCmd == "destroy" {
+		if !s.DestroyGroup(groupName) {
+			return protocol.ZeroReply
+		}
+		d.notify(notifyStream, "xgroup-destroy", key)
+		return protocol.NewIntReply(1)
+	}
+
+	g := s.GetGroup(groupName)
+	if g == nil {
+		return protocol.NewErrorReply(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, key))
+	}
+	switch subCmd {
+	case "setid":
+		g.LastID = id
+		g.EntriesRead = entriesRead
+		d.notify(notifyStream, "xgroup-setid", key)
+		return protocol.OKReply
+	case "createconsumer":
+		if g.CreateConsumer(string(args[3]), time.Now().UnixMilli()) == nil {
+			return protocol.ZeroReply
+		}
+		d.notify(notifyStream, "xgroup-createconsumer", key)
+		return protocol.NewIntReply(1)
+	}
+	pending := g.DeleteConsumer(string(args[3]))
+	if pending < 0 {
+		return protocol.ZeroReply
+	}
+	d.notify(notifyStream, "xgroup-delconsumer", key)
+	return protocol.NewIntReply(int64(pending))
+}
+
+// xGroupToAof CREATE、SETID的 $ 替换为实际的ID，并记录已读的消息数量
+func xGroupToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd != "create" && subCmd != "setid" {
+		return []CmdLine{toCmdLine("xgroup", args)}
+	}
+	s, _ := d.getEntityAsStream(string(args[1]))
+	g := s.GetGroup(string(args[2]))
+	line := CmdLine{
+		[]byte("xgroup"), []byte(subCmd), args[1], args[2], []byte(g.LastID.String()),
+		[]byte("ENTRIESREAD"), []byte(strconv.FormatInt(g.EntriesRead, 10)),
+	}
+	if subCmd == "create" {
+		line = append(line, []byte("MKSTREAM"))
+	}
+	return []CmdLine{line}
+}
+
+// XAckCommand 确认消息，将消息从消费者组的待确认消息中删除，返回确认的消息数量
+// XACK key group id [id ...]
+func XAckCommand(d *DB, args [][]byte) redis.Reply {
+	ids := make([]stream.ID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+	s, errReply := d.getEntityAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.ZeroReply
+	}
+	g := s.GetGroup(string(args[1]))
+	if g == nil {
+		return protocol.ZeroReply
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			acked++
+		}
+	}
+	return protocol.NewIntReply(int64(acked))
+}
+
+// XPendingCommand 查看消费者组中待确认的消息
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+// 只有key和group时返回概要：待确认的消息数量、最小和最大的ID、每个消费者待确认的消息数量
+// 否则返回ID在[start, end]范围内的最多count条待确认消息：[id, 消费者, 距离上次发送的毫秒数, 发送次数]
+func XPendingCommand(d *DB, args [][]byte) redis.Reply {
+	key, groupName := string(args[0]), string(args[1])
+	extended := len(args) > 2
+	var minIdle int64
+	var start, end stream.ID
+	count := 0
+	consumerName := ""
+	if extended {
+		i := 2
+		if strings.ToUpper(string(args[i])) == "IDLE" {
+			if len(args) < 4 {
+				return protocol.ErrorSyntaxReply
+			}
+			var err error
+			if minIdle, err = parseInt64(args[3]); err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			i = 4
+		}
+		if len(args) != i+3 && len(args) != i+4 {
+			return protocol.ErrorSyntaxReply
+		}
+		var errReply redis.Reply
+		if start, errReply = parseStreamRangeID(args[i], true); errReply != nil {
+			return errReply
+		}
+		if end, errReply = parseStreamRangeID(args[i+1], false); errReply != nil {
+			return errReply
+		}
+		n, err := parseInt(args[i+2])
+		if err != nil {
+			return protocol.ErrorNotIntegerReply
+		}
+		count = n
+		if len(args) == i+4 {
+			consumerName = string(args[i+3])
+		}
+	}
+
+	_, g, errReply := d.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	if !extended {
+		return pendingSummary(g)
+	}
+
+	now := time.Now().UnixMilli()
+	replies := make([]redis.Reply, 0)
+	if count <= 0 || end.Less(start) {
+		return protocol.NewArrayReply(replies)
+	}
+	g.Pending(start, end, func(pe *stream.PendingEntry) bool {
+		if consumerName != "" && pe.Consumer.Name != consumerName {
+			return true
+		}
+		idle := now - pe.DeliveryTime
+		if idle < minIdle {
+			return true
+		}
+		replies = append(replies, protocol.NewArrayReply([]redis.Reply{
+			streamIDReply(pe.ID),
+			protocol.NewBulkReply([]byte(pe.Consumer.Name)),
+			protocol.NewIntReply(idle),
+			protocol.NewIntReply(pe.DeliveryCount),
+		}))
+		return len(replies) < count
+	})
+	return protocol.NewArrayReply(replies)
+}
+
+// pendingSummary XPENDING的概要格式
+func pendingSummary(g *stream.Group) redis.Reply {
+	if g.PendingCount() == 0 {
+		return protocol.NewArrayReply([]redis.Reply{
+			protocol.ZeroReply, protocol.NullBulkReply, protocol.NullBulkReply, protocol.NullMultiBulkReply,
+		})
+	}
+	var first, last *stream.PendingEntry
+	g.Pending(stream.MinID, stream.MaxID, func(pe *stream.PendingEntry) bool {
+		if first == nil {
+			first = pe
+		}
+		last = pe
+		return true
+	})
+	consumers := make([]redis.Reply, 0)
+	for _, consumer := range g.Consumers() {
+		if consumer.PendingCount() == 0 {
+			continue
+		}
+		consumers = append(consumers, protocol.NewMultiBulkReply([][]byte{
+			[]byte(consumer.Name),
+			[]byte(strconv.Itoa(consumer.PendingCount())),
+		}))
+	}
+	return protocol.NewArrayReply([]redis.Reply{
+		protocol.NewIntReply(int64(g.PendingCount())),
+		streamIDReply(first.ID),
+		streamIDReply(last.ID),
+		protocol.NewArrayReply(consumers),
+	})
+}
+
+// claimPending 将待确认消息转移给消费者，并设置发送时间；retryCount小于0时发送次数加1，justID为true时不变
+func claimPending(g *stream.Group, id stream.ID, consumer *stream.Consumer, deliveryTime, retryCount int64, justID bool) {
+	pe := g.AddPending(id, consumer)
+	pe.DeliveryTime = deliveryTime
+	if retryCount >= 0 {
+		pe.DeliveryCount = retryCount
+	} else if !justID {
+		pe.DeliveryCount++
+	}
+}
+
+// XClaimCommand 将距离上次发送超过min-idle-time毫秒的待确认消息转移给指定消费者，返回转移的消息
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+// IDLE、TIME: 设置转移后的发送时间，默认为当前时间
+// RETRYCOUNT: 设置发送次数，默认加1
+// FORCE: 消息不在待确认消息中时也创建，消息必须存在于消息流中
+// JUSTID: 只返回ID，不增加发送次数
+// LASTID: 大于消费者组最后发送的ID时更新
+// 已经从消息流中删除的消息会从待确认消息中删除
+func XClaimCommand(d *DB, args [][]byte) redis.Reply {
+	key, groupName, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, err := parseInt64(args[3])
+	if err != nil || minIdle < 0 {
+		return protocol.NewErrorReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	ids := make([]stream.ID, 0)
+	i := 4
+	for ; i < len(args); i++ {
+		id, errReply := parseStreamID(args[i])
+		if errReply != nil {
+			if i == 4 {
+				return errReply
+			}
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now().UnixMilli()
+	deliveryTime, retryCount := int64(-1), int64(-1)
+	force, justID := false, false
+	var lastID stream.ID
+	lastIDGiven := false
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		hasValue := i+1 < len(args)
+		switch {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case opt == "IDLE" && hasValue:
+			idle, err := parseInt64(args[i+1])
+			if err != nil {
+				return protocol.NewErrorReply("ERR Invalid IDLE option argument for XCLAIM")
+			}
+			deliveryTime = now - idle
+			i++
+		case opt == "TIME" && hasValue:
+			t, err := parseInt64(args[i+1])
+			if err != nil {
+				return protocol.NewErrorReply("ERR Invalid TIME option argument for XCLAIM")
+			}
+			deliveryTime = t
+			i++
+		case opt == "RETRYCOUNT" && hasValue:
+			n, err := parseInt64(args[i+1])
+			if err != nil || n < 0 {
+				return protocol.NewErrorReply("ERR Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			retryCount = n
+			i++
+		case opt == "LASTID" && hasValue:
+			var errReply redis.Reply
+			if lastID, errReply = parseStreamID(args[i+1]); errReply != nil {
+				return errReply
+			}
+			lastIDGiven = true
+			i++
+		default:
+			return protocol.NewErrorReply(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+		}
+	}
+	// 客户端的时钟可能与服务端不一致，不合理的发送时间使用当前时间
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	s, g, errReply := d.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	if lastIDGiven && g.LastID.Less(lastID) {
+		g.LastID = lastID
+	}
+	consumer := d.getOrCreateConsumer(key, g, consumerName, now)
+	replies := make([]redis.Reply, 0)
+	for _, id := range ids {
+		pe := g.GetPending(id)
+		entry := s.Get(id)
+		if pe == nil {
+			// 新建的待确认消息距离上次发送的时间为0
+			if !force || entry == nil || minIdle > 0 {
+				continue
+			}
+			pe = g.AddPending(id, consumer)
+			pe.DeliveryCount = 1
+		} else {
+			if entry == nil {
+				g.Ack(id)
+				continue
+			}
+			if minIdle > 0 && now-pe.DeliveryTime < minIdle {
+				continue
+			}
+		}
+		claimPending(g, id, consumer, deliveryTime, retryCount, justID)
+		consumer.ActiveTime = now
+		if justID {
+			replies = append(replies, streamIDReply(id))
+		} else {
+			replies = append(replies, streamEntryReply(entry))
+		}
+	}
+	return protocol.NewArrayReply(replies)
+}
+
+// xClaimToAof 转换为明确指定发送时间和次数的XCLAIM
+func xClaimToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	ids := make([]stream.ID, 0)
+	i := 4
+	for ; i < len(args); i++ {
+		id, errReply := parseStreamID(args[i])
+		if errReply != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	lines := claimToAof(d, args[0], args[1], args[2], ids)
+	for ; i < len(args); i++ {
+		if strings.ToUpper(string(args[i])) != "LASTID" {
+			continue
+		}
+		// LASTID可能修改了消费者组最后发送的ID
+		s, _ := d.getEntityAsStream(string(args[0]))
+		g := s.GetGroup(string(args[1]))
+		lines = append(lines, CmdLine{
+			[]byte("xgroup"), []byte("setid"), args[0], args[1], []byte(g.LastID.String()),
+			[]byte("ENTRIESREAD"), []byte(strconv.FormatInt(g.EntriesRead, 10)),
+		})
+		break
+	}
+	return lines
+}
+
+// claimToAof 认领的结果转换为AOF命令：仍属于consumer的待确认消息转换为 XCLAIM ... TIME RETRYCOUNT FORCE JUSTID，
+// 不再是待确认消息的转换为XACK，重放时不受当前时间和空闲时间的影响
+func claimToAof(d *DB, key, group, consumer []byte, ids []stream.ID) []CmdLine {
+	s, _ := d.getEntityAsStream(string(key))
+	g := s.GetGroup(string(group))
+	lines := []CmdLine{{[]byte("xgroup"), []byte("createconsumer"), key, group, consumer}}
+	ack := CmdLine{[]byte("xack"), key, group}
+	for _, id := range ids {
+		pe := g.GetPending(id)
+		if pe == nil {
+			ack = append(ack, []byte(id.String()))
+			continue
+		}
+		if pe.Consumer.Name != string(consumer) {
+			continue
+		}
+		lines = append(lines, CmdLine{
+			[]byte("xclaim"), key, group, consumer, []byte("0"), []byte(id.String()),
+			[]byte("TIME"), []byte(strconv.FormatInt(pe.DeliveryTime, 10)),
+			[]byte("RETRYCOUNT"), []byte(strconv.FormatInt(pe.DeliveryCount, 10)),
+			[]byte("FORCE"), []byte("JUSTID"),
+		})
+	}
+	if len(ack) > 3 {
+		lines = append(lines, ack)
+	}
+	return lines
+}
+
+// XAutoClaimCommand 从start开始扫描待确认消息，将距离上次发送超过min-idle-time毫秒的消息转移给指定消费者
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// 最多转移count条消息，默认为100，最多扫描count*10条待确认消息
+// 返回 [下一次扫描的起始ID, 转移的消息, 已经从消息流中删除的消息ID]，起始ID为0-0表示扫描完成
+func XAutoClaimCommand(d *DB, args [][]byte) redis.Reply {
+	key, groupName, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, err := parseInt64(args[3])
+	if err != nil {
+		return protocol.NewErrorReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	start, errReply := parseStreamRangeID(args[4], true)
+	if errReply != nil {
+		return errReply
+	}
+	count := 100
+	justID := false
+	for i := 5; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "JUSTID" {
+			justID = true
+		} else if opt == "COUNT" && i+1 < len(args) {
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			if n < 1 || n > math.MaxInt32 {
+				return protocol.NewErrorReply("ERR COUNT must be > 0")
+			}
+			count = n
+			i++
+		} else {
+			return protocol.ErrorSyntaxReply
+		}
+	}
+
+	s, g, errReply := d.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	now := time.Now().UnixMilli()
+	consumer := d.getOrCreateConsumer(key, g, consumerName, now)
+
+	// 先收集需要扫描的待确认消息，认领时会修改待确认消息
+	attempts := count * 10
+	candidates := make([]*stream.PendingEntry, 0)
+	g.Pending(start, stream.MaxID, func(pe *stream.PendingEntry) bool {
+		candidates = append(candidates, pe)
+		return len(candidates) <= attempts
+	})
+	next := stream.MinID
+	claimed := make([]redis.Reply, 0)
+	deleted := make([][]byte, 0)
+	for _, pe := range candidates {
+		if attempts == 0 || count == 0 {
+			next = pe.ID
+			break
+		}
+		attempts--
+		if now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		entry := s.Get(pe.ID)
+		if entry == nil {
+			g.Ack(pe.ID)
+			deleted = append(deleted, []byte(pe.ID.String()))
+			continue
+		}
+		claimPending(g, pe.ID, consumer, now, -1, justID)
+		consumer.ActiveTime = now
+		count--
+		if justID {
+			claimed = append(claimed, streamIDReply(pe.ID))
+		} else {
+			claimed = append(claimed, streamEntryReply(entry))
+		}
+	}
+	return protocol.NewArrayReply([]redis.Reply{
+		streamIDReply(next),
+		protocol.NewArrayReply(claimed),
+		protocol.NewMultiBulkReply(deleted),
+	})
+}
+
+// xAutoClaimToAof 根据响应中被转移和被删除的消息ID转换为XCLAIM和XACK
+func xAutoClaimToAof(d *DB, args [][]byte, reply redis.Reply) []CmdLine {
+	r, ok := reply.(*protocol.ArrayReply)
+	if !ok || len(r.Replies) != 3 {
+		return nil
+	}
+	ids := make([]stream.ID, 0)
+	for _, item := range r.Replies[1].(*protocol.ArrayReply).Replies {
+		if entry, ok := item.(*protocol.ArrayReply); ok {
+			item = entry.Replies[0]
+		}
+		id, _ := stream.ParseID(string(item.(*protocol.BulkReply).Text), 0)
+		ids = append(ids, id)
+	}
+	for _, text := range r.Replies[2].(*protocol.MultiBulkReply).Texts {
+		id, _ := stream.ParseID(string(text), 0)
+		ids = append(ids, id)
+	}
+	return claimToAof(d, args[0], args[1], args[2], ids)
+}
+
+/* ---- XINFO ---- */
+
+// XInfoCommand 查看消息流、消费者组和消费者的信息
+// XINFO STREAM key [FULL [COUNT count]]
+// XINFO GROUPS key
+// XINFO CONSUMERS key group
+func XInfoCommand(d *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	argNumErr := protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. "+
+		"Try XINFO HELP.", subCmd))
+	switch subCmd {
+	case "stream":
+		if len(args) < 2 {
+			return argNumErr
+		}
+	case "groups":
+		if len(args) != 2 {
+			return argNumErr
+		}
+	case "consumers":
+		if len(args) != 3 {
+			return argNumErr
+		}
+	default:
+		return protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try XINFO HELP.", subCmd))
+	}
+
+	s, errReply := d.getEntityAsStream(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.ErrorNoSuchKeyReply
+	}
+	now := time.Now().UnixMilli()
+	switch subCmd {
+	case "stream":
+		return xInfoStream(s, args[2:], now)
+	case "groups":
+		groups := make([]redis.Reply, 0)
+		for _, g := range s.Groups() {
+			lag, ok := s.Lag(g)
+			groups = append(groups, protocol.NewMapReply([]redis.Reply{
+				protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(g.Name)),
+				protocol.NewBulkReply([]byte("consumers")), protocol.NewIntReply(int64(g.ConsumerCount())),
+				protocol.NewBulkReply([]byte("pending")), protocol.NewIntReply(int64(g.PendingCount())),
+				protocol.NewBulkReply([]byte("last-delivered-id")), streamIDReply(g.LastID),
+				protocol.NewBulkReply([]byte("entries-read")), nullableIntReply(g.EntriesRead, true),
+				protocol.NewBulkReply([]byte("lag")), nullableIntReply(lag, ok),
+			}))
+		}
+		return protocol.NewArrayReply(groups)
+	}
+	g := s.GetGroup(string(args[2]))
+	if g == nil {
+		return protocol.NewErrorReply(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", args[2], args[1]))
+	}
+	consumers := make([]redis.Reply, 0)
+	for _, consumer := range g.Consumers() {
+		inactive := int64(-1)
+		if consumer.ActiveTime >= 0 {
+			inactive = now - consumer.ActiveTime
+		}
+		consumers = append(consumers, protocol.NewMapReply([]redis.Reply{
+			protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(consumer.Name)),
+			protocol.NewBulkReply([]byte("pending")), protocol.NewIntReply(int64(consumer.PendingCount())),
+			protocol.NewBulkReply([]byte("idle")), protocol.NewIntReply(now - consumer.SeenTime),
+			protocol.NewBulkReply([]byte("inactive")), protocol.NewIntReply(inactive),
+		}))
+	}
+	return protocol.NewArrayReply(consumers)
+}
+
+// xInfoStream XINFO STREAM，FULL返回所有消息和消费者组的详细信息，COUNT限制返回的消息和待确认消息数量，默认为10，0表示不限制
+func xInfoStream(s *stream.Stream, args [][]byte, now int64) redis.Reply {
+	full := false
+	count := 10
+	if len(args) > 0 {
+		if strings.ToUpper(string(args[0])) != "FULL" {
+			return protocol.ErrorSyntaxReply
+		}
+		full = true
+		if len(args) > 1 {
+			if len(args) != 3 || strings.ToUpper(string(args[1])) != "COUNT" {
+				return protocol.ErrorSyntaxReply
+			}
+			n, err := parseInt(args[2])
+			if err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			count = n
+			if count < 0 {
+				count = 0
+			}
+		}
+	}
+
+	pairs := []redis.Reply{
+		protocol.NewBulkReply([]byte("length")), protocol.NewIntReply(int64(s.Len())),
+		protocol.NewBulkReply([]byte("radix-tree-keys")), protocol.NewIntReply(int64(s.NodeCount())),
+		protocol.NewBulkReply([]byte("radix-tree-nodes")), protocol.NewIntReply(int64(s.NodeCount())),
+		protocol.NewBulkReply([]byte("last-generated-id")), streamIDReply(s.LastID()),
+		protocol.NewBulkReply([]byte("max-deleted-entry-id")), streamIDReply(s.MaxDeletedID()),
+		protocol.NewBulkReply([]byte("entries-added")), protocol.NewIntReply(int64(s.EntriesAdded())),
+		protocol.NewBulkReply([]byte("recorded-first-entry-id")), streamIDReply(s.FirstID()),
+	}
+	if !full {
+		entryOrNil := func(entry *stream.Entry) redis.Reply {
+			if entry == nil {
+				return protocol.NullBulkReply
+			}
+			return streamEntryReply(entry)
+		}
+		pairs = append(pairs,
+			protocol.NewBulkReply([]byte("groups")), protocol.NewIntReply(int64(len(s.Groups()))),
+			protocol.NewBulkReply([]byte("first-entry")), entryOrNil(s.FirstEntry()),
+			protocol.NewBulkReply([]byte("last-entry")), entryOrNil(s.LastEntry()),
+		)
+		return protocol.NewMapReply(pairs)
+	}
+
+	groups := make([]redis.Reply, 0)
+	for _, g := range s.Groups() {
+		pending := make([]redis.Reply, 0)
+		g.Pending(stream.MinID, stream.MaxID, func(pe *stream.PendingEntry) bool {
+			pending = append(pending, protocol.NewArrayReply([]redis.Reply{
+				streamIDReply(pe.ID),
+				protocol.NewBulkReply([]byte(pe.Consumer.Name)),
+				protocol.NewIntReply(pe.DeliveryTime),
+				protocol.NewIntReply(pe.DeliveryCount),
+			}))
+			return count == 0 || len(pending) < count
+		})
+		consumers := make([]redis.Reply, 0)
+		for _, consumer := range g.Consumers() {
+			consumerPending := make([]redis.Reply, 0)
+			for _, id := range consumer.PendingIDs() {
+				if count > 0 && len(consumerPending) >= count {
+					break
+				}
+				pe := g.GetPending(id)
+				consumerPending = append(consumerPending, protocol.NewArrayReply([]redis.Reply{
+					streamIDReply(id),
+					protocol.NewIntReply(pe.DeliveryTime),
+					protocol.NewIntReply(pe.DeliveryCount),
+				}))
+			}
+			consumers = append(consumers, protocol.NewMapReply([]redis.Reply{
+				protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(consumer.Name)),
+				protocol.NewBulkReply([]byte("seen-time")), protocol.NewIntReply(consumer.SeenTime),
+				protocol.NewBulkReply([]byte("active-time")), protocol.NewIntReply(consumer.ActiveTime),
+				protocol.NewBulkReply([]byte("pel-count")), protocol.NewIntReply(int64(consumer.PendingCount())),
+				protocol.NewBulkReply([]byte("pending")), protocol.NewArrayReply(consumerPending),
+			}))
+		}
+		lag, ok := s.Lag(g)
+		groups = append(groups, protocol.NewMapReply([]redis.Reply{
+			protocol.NewBulkReply([]byte("name")), protocol.NewBulkReply([]byte(g.Name)),
+			protocol.NewBulkReply([]byte("last-delivered-id")), streamIDReply(g.LastID),
+			protocol.NewBulkReply([]byte("entries-read")), nullableIntReply(g.EntriesRead, true),
+			protocol.NewBulkReply([]byte("lag")), nullableIntReply(lag, ok),
+			protocol.NewBulkReply([]byte("pel-count")), protocol.NewIntReply(int64(g.PendingCount())),
+			protocol.NewBulkReply([]byte("pending")), protocol.NewArrayReply(pending),
+			protocol.NewBulkReply([]byte("consumers")), protocol.NewArrayReply(consumers),
+		}))
+	}
+	pairs = append(pairs,
+		protocol.NewBulkReply([]byte("entries")), streamEntriesReply(s.Range(stream.MinID, stream.MaxID, count, false)),
+		protocol.NewBulkReply([]byte("groups")), protocol.NewArrayReply(groups),
+	)
+	return protocol.NewMapReply(pairs)
+}
+
+func init() {
+	registerNormalCommand("xadd", XAddCommand, writeFirstKey, -5, tagWrite).attachAof(xAddToAof)
+	registerNormalCommand("xtrim", XTrimCommand, writeFirstKey, -4, tagWrite).attachAof(xTrimToAof)
+	registerNormalCommand("xrange", XRangeCommand, readFirstKey, -4, tagRead)
+	registerNormalCommand("xrevrange", XRevRangeCommand, readFirstKey, -4, tagRead)
+	registerNormalCommand("xlen", XLenCommand, readFirstKey, 2, tagRead)
+	registerNormalCommand("xdel", XDelCommand, writeFirstKey, -3, tagWrite)
+	registerNormalCommand("xsetid", XSetIDCommand, writeFirstKey, -3, tagWrite)
+	registerNormalCommand("xread", XReadCommand, prepareXRead, -4, tagRead)
+	registerNormalCommand("xreadgroup", XReadGroupCommand, prepareXReadGroup, -7, tagWrite).attachAof(xReadGroupToAof)
+	registerNormalCommand("xgroup", XGroupCommand, prepareXGroup, -2, tagWrite).attachAof(xGroupToAof)
+	registerNormalCommand("xack", XAckCommand, writeFirstKey, -4, tagWrite)
+	registerNormalCommand("xpending", XPendingCommand, readFirstKey, -3, tagRead)
+	registerNormalCommand("xclaim", XClaimCommand, writeFirstKey, -6, tagWrite).attachAof(xClaimToAof)
+	registerNormalCommand("xautoclaim", XAutoClaimCommand, writeFirstKey, -6, tagWrite).attachAof(xAutoClaimToAof)
+	registerNormalCommand("xinfo", XInfoCommand, prepareXInfo, -2, tagRead)
+}
@@ -132,9 +132,11 @@ func SetCommand(d *DB, cmdArgs [][]byte) redis.Reply {
 	}
 
 	if result > 0 {
+		d.notify(notifyString, "set", key)
 		if ttl != unlimitedTTL {
 			logger.Infof("expire in second: %d", int(time.Duration(ttl).Seconds()))
 			d.Expire(key, time.Duration(ttl))
+			d.notify(notifyGeneric, "expire", key)
 		} else {
 			d.Persist(key)
 		}
@@ -183,6 +185,7 @@ func AppendCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	bytes = append(bytes, valueBytes...)
 	d.PutEntity(key, BuildStringEntity(bytes))
+	d.notify(notifyString, "append", key)
 	return protocol.NewIntReply(int64(len(bytes)))
 }
 
@@ -199,6 +202,7 @@ func MSetCommand(d *DB, args [][]byte) redis.Reply {
 			// 如果覆盖掉原key，则到期时间重置
 			d.Persist(key)
 		}
+		d.notify(notifyString, "set", key)
 	}
 	return protocol.OKReply
 }
@@ -220,6 +224,7 @@ func MSetNXCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	for k, v := range kvMap {
 		d.PutEntity(k, BuildStringEntity(v))
+		d.notify(notifyString, "set", k)
 	}
 	return protocol.NewIntReply(1)
 }
@@ -247,6 +252,7 @@ func GetDelCommand(d *DB, args [][]byte) redis.Reply {
 	}
 
 	d.Remove(key)
+	d.notify(notifyGeneric, "del", key)
 	return protocol.NewBulkReply(bytes)
 }
 
@@ -266,6 +272,7 @@ func IncrCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	parsedInt += 1
 	d.PutEntity(key, BuildStringEntity([]byte(strconv.FormatInt(parsedInt, 10))))
+	d.notify(notifyString, "incrby", key)
 	return protocol.NewIntReply(parsedInt)
 }
 
@@ -289,6 +296,7 @@ func IncrByCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	parsedInt += number
 	d.PutEntity(key, BuildStringEntity([]byte(strconv.FormatInt(parsedInt, 10))))
+	d.notify(notifyString, "incrby", key)
 	return protocol.NewIntReply(parsedInt)
 }
 
@@ -313,6 +321,7 @@ func IncrByFloatCommand(d *DB, args [][]byte) redis.Reply {
 	curNumber = decimal.Sum(floatArg, curNumber)
 	curStr := []byte(curNumber.String())
 	d.PutEntity(key, BuildStringEntity(curStr))
+	d.notify(notifyString, "incrbyfloat", key)
 	return protocol.NewBulkReply(curStr)
 }
 
@@ -332,6 +341,7 @@ func DecrCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	parsedInt -= 1
 	d.PutEntity(key, BuildStringEntity([]byte(strconv.FormatInt(parsedInt, 10))))
+	d.notify(notifyString, "incrby", key)
 	return protocol.NewIntReply(parsedInt)
 }
 
@@ -355,6 +365,7 @@ func DecrByCommand(d *DB, args [][]byte) redis.Reply {
 	}
 	parsedInt -= number
 	d.PutEntity(key, BuildStringEntity([]byte(strconv.FormatInt(parsedInt, 10))))
+	d.notify(notifyString, "incrby", key)
 	return protocol.NewIntReply(parsedInt)
 }
 
@@ -406,6 +417,7 @@ func GetExCommand(d *DB, args [][]byte) redis.Reply {
 
 		if ttl != unlimitedTTL {
 			d.Expire(key, time.Duration(ttl))
+			d.notify(notifyGeneric, "expire", key)
 		}
 
 	} else if len(args) == 2 {
@@ -414,6 +426,7 @@ func GetExCommand(d *DB, args [][]byte) redis.Reply {
 			return protocol.ErrorSyntaxReply
 		}
 		d.Persist(key)
+		d.notify(notifyGeneric, "persist", key)
 	}
 
 	return protocol.NewBulkReply(bytes)
@@ -454,6 +467,7 @@ func SetRangeCommand(d *DB, args [][]byte) redis.Reply {
 	}
 
 	d.PutEntity(key, BuildStringEntity(bytes))
+	d.notify(notifyString, "setrange", key)
 	return protocol.NewIntReply(int64(len(bytes)))
 }
 
@@ -506,19 +520,19 @@ func GetRangeCommand(d *DB, args [][]byte) redis.Reply {
 }
 
 func init() {
-	registerNormalCommand("set", SetCommand, writeFirstKey, -3, tagWrite)
+	registerNormalCommand("set", SetCommand, writeFirstKey, -3, tagWrite).attachAof(setToAof)
 	registerNormalCommand("get", GetCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("strlen", StrLenCommand, readFirstKey, 2, tagRead)
 	registerNormalCommand("append", AppendCommand, readFirstKey, 3, tagWrite)
-	registerNormalCommand("mset", MSetCommand, writeAllKeys, -3, tagWrite)
-	registerNormalCommand("msetnx", MSetNXCommand, writeAllKeys, -3, tagWrite)
+	registerNormalCommand("mset", MSetCommand, prepareMSet, -3, tagWrite)
+	registerNormalCommand("msetnx", MSetNXCommand, prepareMSet, -3, tagWrite)
 	registerNormalCommand("mget", MGetCommand, readAllKeys, -2, tagRead)
 	registerNormalCommand("getdel", GetDelCommand, writeFirstKey, 2, tagWrite)
 	registerNormalCommand("incr", IncrCommand, writeFirstKey, 2, tagWrite)
 	registerNormalCommand("decr", DecrCommand, writeFirstKey, 2, tagWrite)
 	registerNormalCommand("incrby", IncrByCommand, writeFirstKey, 3, tagWrite)
 	registerNormalCommand("decrby", DecrByCommand, writeFirstKey, 3, tagWrite)
-	registerNormalCommand("getex", GetExCommand, readFirstKey, -2, tagRead)
+	registerNormalCommand("getex", GetExCommand, writeFirstKey, -2, tagWrite).attachAof(getExToAof)
 	registerNormalCommand("setrange", SetRangeCommand, writeFirstKey, 4, tagWrite)
 	registerNormalCommand("getrange", GetRangeCommand, readFirstKey, 4, tagRead)
 	registerNormalCommand("incrbyfloat", IncrByFloatCommand, writeFirstKey, 3, tagWrite)
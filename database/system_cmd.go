@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 	"zedis/config"
 	"zedis/interface/redis"
+	"zedis/logger"
 	"zedis/redis/protocol"
 	"zedis/tcp"
 )
@@ -48,6 +50,68 @@ func isAuthenticated(c redis.Connection) bool {
 	return c.GetPassword() == config.Config.RequirePass
 }
 
+// Hello 命令，协商RESP协议版本，可以同时认证和设置连接名称，返回服务端信息
+// 没有用户系统，AUTH的用户名只能为default
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func Hello(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	version := c.GetProtocol()
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return protocol.NewErrorReply("ERR Protocol version is not an integer or out of range")
+		}
+		if v != protocol.RESP2 && v != protocol.RESP3 {
+			return protocol.NewErrorReply("NOPROTO unsupported protocol version")
+		}
+		version = v
+	}
+	var username, password, clientName []byte
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		if option == "auth" && i+2 < len(args) {
+			username, password = args[i+1], args[i+2]
+			i += 2
+		} else if option == "setname" && i+1 < len(args) {
+			clientName = args[i+1]
+			i++
+		} else {
+			return protocol.NewErrorReply(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", string(args[i])))
+		}
+	}
+	if password != nil {
+		if string(username) != "default" || config.Config.RequirePass != string(password) {
+			return protocol.NewErrorReply("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		c.SetPassword(string(password))
+	}
+	if !isAuthenticated(c) {
+		return protocol.NewErrorReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	if clientName != nil {
+		if bytes.ContainsAny(clientName, " \r\n") {
+			return protocol.NewErrorReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.SetClientName(string(clientName))
+	}
+	c.SetProtocol(version)
+
+	role := "master"
+	if engine.slave.isReplica() {
+		role = "replica"
+	}
+	return protocol.NewMapReply([]redis.Reply{
+		protocol.NewBulkReply([]byte("server")), protocol.NewBulkReply([]byte("redis")),
+		protocol.NewBulkReply([]byte("version")), protocol.NewBulkReply([]byte(zedisVersion)),
+		protocol.NewBulkReply([]byte("proto")), protocol.NewIntReply(int64(version)),
+		protocol.NewBulkReply([]byte("id")), protocol.NewIntReply(c.ID()),
+		protocol.NewBulkReply([]byte("mode")), protocol.NewBulkReply([]byte(getZedisRunningMode())),
+		protocol.NewBulkReply([]byte("role")), protocol.NewBulkReply([]byte(role)),
+		protocol.NewBulkReply([]byte("modules")), protocol.EmptyMultiBulkReply,
+	})
+}
+
 // Info 命令
 func Info(engine *Engine, args [][]byte) redis.Reply {
 	infoCommandList := make([]string, 0)
@@ -55,14 +119,14 @@ func Info(engine *Engine, args [][]byte) redis.Reply {
 		return protocol.NewArgNumErrReply("info")
 	}
 	if len(args) == 0 {
-		infoCommandList = []string{"server", "client", "cluster", "keyspace"}
+		infoCommandList = []string{"server", "client", "persistence", "replication", "cluster", "keyspace"}
 	} else if len(args) == 1 {
 		section := strings.ToLower(string(args[0]))
 		switch section {
-		case "server", "client", "cluster", "keyspace":
+		case "server", "client", "persistence", "replication", "cluster", "keyspace":
 			infoCommandList = append(infoCommandList, section)
 		case "all", "default":
-			infoCommandList = append(infoCommandList, "server", "client", "cluster", "keyspace")
+			infoCommandList = append(infoCommandList, "server", "client", "persistence", "replication", "cluster", "keyspace")
 		default:
 			return protocol.NewErrorReply("Invalid section for 'info' command")
 		}
@@ -76,6 +140,227 @@ func Info(engine *Engine, args [][]byte) redis.Reply {
 	return protocol.NewBulkReply(buf.Bytes())
 }
 
+// BGRewriteAOF 命令，在后台重写AOF文件
+func BGRewriteAOF(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("bgrewriteaof")
+	}
+	if engine.persister == nil {
+		return protocol.NewErrorReply("ERR Background append only file rewriting is not possible, AOF is disabled")
+	}
+	if engine.persister.IsRewriting() {
+		return protocol.NewErrorReply("ERR Background append only file rewriting already in progress")
+	}
+	go func() {
+		if err := engine.persister.Rewrite(); err != nil {
+			logger.Errorf("rewrite aof failed: %v", err)
+		}
+	}()
+	return protocol.NewSingleReply("Background append only file rewriting started")
+}
+
+// Save 命令，在前台保存RDB文件
+func Save(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("save")
+	}
+	if engine.bgSaving.Load() {
+		return protocol.NewErrorReply("ERR Background save already in progress")
+	}
+	if err := engine.SaveRDB(); err != nil {
+		logger.Errorf("save rdb failed: %v", err)
+		return protocol.NewErrorReply("ERR " + err.Error())
+	}
+	return protocol.OKReply
+}
+
+// BGSave 命令，在后台保存RDB文件
+func BGSave(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("bgsave")
+	}
+	if err := engine.BgSaveRDB(); err != nil {
+		return protocol.NewErrorReply("ERR Background save already in progress")
+	}
+	return protocol.NewSingleReply("Background saving started")
+}
+
+// LastSave 命令，返回上一次成功保存RDB文件的Unix时间戳
+func LastSave(engine *Engine, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("lastsave")
+	}
+	return protocol.NewIntReply(engine.lastSave.Load())
+}
+
+// Select 命令，切换当前连接使用的数据库
+func Select(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.NewArgNumErrReply("select")
+	}
+	dbIndex, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.ErrorNotIntegerReply
+	}
+	if engine.cluster != nil && dbIndex != 0 {
+		return protocol.NewErrorReply("ERR SELECT is not allowed in cluster mode")
+	}
+	if _, errReply := engine.selectDB(dbIndex); errReply != nil {
+		return errReply
+	}
+	c.SelectDB(dbIndex)
+	return protocol.OKReply
+}
+
+// SwapDB 命令，交换两个数据库中的数据，连接到这两个数据库的客户端会立即看到交换后的数据
+// SWAPDB index1 index2
+func SwapDB(engine *Engine, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	// 独占快照锁，等待正在执行的写命令完成，避免它们在SWAPDB之后以交换前的编号写入AOF
+	engine.snapshotMu.Lock()
+	defer engine.snapshotMu.Unlock()
+	return swapDB(engine, c, cmdLine)
+}
+
+// swapDB 执行SWAPDB，调用方需要持有快照锁的写锁
+func swapDB(engine *Engine, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	args := cmdLine[1:]
+	if len(args) != 2 {
+		return protocol.NewArgNumErrReply("swapdb")
+	}
+	if engine.cluster != nil {
+		return protocol.NewErrorReply("ERR SWAPDB is not allowed in cluster mode")
+	}
+	index1, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.NewErrorReply("ERR invalid first DB index")
+	}
+	index2, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return protocol.NewErrorReply("ERR invalid second DB index")
+	}
+
+	engine.dbSetMu.Lock()
+	defer engine.dbSetMu.Unlock()
+	db1, errReply := engine.selectDB(index1)
+	if errReply != nil {
+		return errReply
+	}
+	db2, errReply := engine.selectDB(index2)
+	if errReply != nil {
+		return errReply
+	}
+	engine.dbSet[index1].Store(db2)
+	engine.dbSet[index2].Store(db1)
+	db1.index.Store(int64(index2))
+	db2.index.Store(int64(index1))
+	// WATCH的key与数据库编号对应，随编号交换
+	db1.watched, db2.watched = db2.watched, db1.watched
+	// 任意一个数据库不为空时，两个编号下的key对于WATCH和客户端缓存都相当于被修改了
+	if db1.data.Len() > 0 || db2.data.Len() > 0 {
+		db1.touchAll()
+		db2.touchAll()
+	}
+	engine.propagate(c.GetDBIndex(), cmdLine)
+	return protocol.OKReply
+}
+
+// Move 命令，将当前数据库中的key移动到指定的数据库，过期时间一起移动
+// 当前数据库不存在该key，或者目标数据库已存在该key时，不移动，返回0
+// MOVE key db
+func Move(engine *Engine, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	return move(engine, c, cmdLine)
+}
+
+// move 执行MOVE，调用方需要持有快照锁
+func move(engine *Engine, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	args := cmdLine[1:]
+	if len(args) != 2 {
+		return protocol.NewArgNumErrReply("move")
+	}
+	if engine.cluster != nil {
+		return protocol.NewErrorReply("ERR MOVE is not allowed in cluster mode")
+	}
+	key := string(args[0])
+	dstIndex, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return protocol.ErrorNotIntegerReply
+	}
+	srcIndex := c.GetDBIndex()
+	if srcIndex == dstIndex {
+		return protocol.NewErrorReply("ERR source and destination objects are the same")
+	}
+
+	engine.dbSetMu.Lock()
+	defer engine.dbSetMu.Unlock()
+	src, errReply := engine.selectDB(srcIndex)
+	if errReply != nil {
+		return errReply
+	}
+	dst, errReply := engine.selectDB(dstIndex)
+	if errReply != nil {
+		return errReply
+	}
+	keys := []string{key}
+	src.RWLocks(keys, nil)
+	defer src.RWUnLocks(keys, nil)
+	dst.RWLocks(keys, nil)
+	defer dst.RWUnLocks(keys, nil)
+	src.removeExpired(keys)
+	dst.removeExpired(keys)
+
+	entity, exists := src.GetEntity(key)
+	if !exists || dst.Exists(key) {
+		return protocol.ZeroReply
+	}
+	expireAt, hasTTL := src.getExpireTime(key)
+	src.Remove(key)
+	dst.PutEntity(key, entity)
+	dst.addVersion(key)
+	engine.tracking.invalidate(c, []string{key})
+	dst.signalKeys(keys)
+	if hasTTL {
+		dst.ExpireByTime(key, expireAt)
+	}
+	src.notify(notifyGeneric, "move_from", key)
+	dst.notify(notifyGeneric, "move_to", key)
+	engine.propagate(srcIndex, cmdLine)
+	return protocol.NewIntReply(1)
+}
+
+// FlushAll 命令，清空所有数据库
+// FLUSHALL [ASYNC | SYNC]
+func FlushAll(engine *Engine, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	return flushAll(engine, c, cmdLine)
+}
+
+// flushAll 执行FLUSHALL，调用方需要持有快照锁
+func flushAll(engine *Engine, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	args := cmdLine[1:]
+	if len(args) > 1 {
+		return protocol.NewArgNumErrReply("flushall")
+	}
+	if len(args) == 1 && !isFlushMode(args[0]) {
+		return protocol.ErrorSyntaxReply
+	}
+	engine.dbSetMu.Lock()
+	defer engine.dbSetMu.Unlock()
+	for _, holder := range engine.dbSet {
+		holder.Load().Flush()
+	}
+	engine.propagate(c.GetDBIndex(), cmdLine)
+	return protocol.OKReply
+}
+
+// isFlushMode FLUSHDB、FLUSHALL的可选参数，数据都是同步清空的
+func isFlushMode(arg []byte) bool {
+	mode := strings.ToUpper(string(arg))
+	return mode == "ASYNC" || mode == "SYNC"
+}
+
 func GenZedisInfo(section string, engine *Engine) []byte {
 	startUpTimeFromNow := getZedisRunningTime()
 	var buf bytes.Buffer
@@ -97,15 +382,43 @@ func GenZedisInfo(section string, engine *Engine) []byte {
 		buf.WriteString("# Client\r\n")
 		buf.WriteString(fmt.Sprintf("connected_clients:%d\r\n", tcp.ClientCounter))
 		buf.WriteString(fmt.Sprintf("maxclients:%d\r\n", config.Config.MaxClients))
+	case "persistence":
+		buf.WriteString("# Persistence\r\n")
+		bgSaving := 0
+		if engine.bgSaving.Load() {
+			bgSaving = 1
+		}
+		buf.WriteString(fmt.Sprintf("rdb_bgsave_in_progress:%d\r\n", bgSaving))
+		buf.WriteString(fmt.Sprintf("rdb_last_save_time:%d\r\n", engine.lastSave.Load()))
+		aofEnabled := 0
+		if engine.persister != nil {
+			aofEnabled = 1
+		}
+		buf.WriteString(fmt.Sprintf("aof_enabled:%d\r\n", aofEnabled))
+		aofRewriting := 0
+		if engine.persister != nil && engine.persister.IsRewriting() {
+			aofRewriting = 1
+		}
+		buf.WriteString(fmt.Sprintf("aof_rewrite_in_progress:%d\r\n", aofRewriting))
+	case "replication":
+		buf.WriteString("# Replication\r\n")
+		buf.WriteString(replicationInfo(engine))
 	case "cluster":
 		buf.WriteString("# Cluster\r\n")
-		buf.WriteString("cluster_enabled:0\n")
+		clusterEnabled := 0
+		if engine.cluster != nil {
+			clusterEnabled = 1
+		}
+		buf.WriteString(fmt.Sprintf("cluster_enabled:%d\r\n", clusterEnabled))
 	}
 
 	return buf.Bytes()
 }
 
 func getZedisRunningMode() string {
+	if config.Config.ClusterEnabled {
+		return "cluster"
+	}
 	return "standalone"
 }
 
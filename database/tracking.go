@@ -0,0 +1,310 @@
+package database
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+var (
+	invalidateBytes        = []byte("invalidate")
+	invalidateChannelBytes = []byte("__redis__:invalidate")
+	redirBrokenBytes       = []byte("tracking-redir-broken")
+	messageBytes           = []byte("message")
+)
+
+// trackingClient 开启了客户端缓存(CLIENT TRACKING ON)的连接的状态
+type trackingClient struct {
+	conn redis.Connection
+	// 失效消息发送给该ID的连接，为0时发送给自己
+	redirect int64
+	// 广播模式下不记录读取的key，修改的key匹配任意前缀时都发送失效消息，没有前缀时匹配所有key
+	bcast    bool
+	prefixes []string
+	optIn    bool
+	optOut   bool
+	// 不接收自己修改的key的失效消息
+	noLoop bool
+	// 默认模式下记录的key，关闭客户端缓存时从trackingTable.keys中删除
+	keys map[string]struct{}
+	// CLIENT CACHING设置的值，只对下一条命令有效: 1表示yes，-1表示no
+	nextCaching int8
+	caching     int8
+}
+
+// shouldTrack 默认模式下是否记录当前命令读取的key
+func (tc *trackingClient) shouldTrack() bool {
+	if tc.bcast {
+		return false
+	}
+	if tc.optIn {
+		return tc.caching > 0
+	}
+	if tc.optOut {
+		return tc.caching >= 0
+	}
+	return true
+}
+
+// trackingTable 记录开启了客户端缓存的连接，以及它们读取过的key
+// key不区分数据库，与Redis相同
+type trackingTable struct {
+	mu sync.Mutex
+	// 所有连接: id -> 连接，用于查找重定向的目标
+	conns map[int64]redis.Connection
+	// 开启了客户端缓存的连接: id -> 状态
+	clients map[int64]*trackingClient
+	// 默认模式下被读取过的key: key -> 读取过该key的连接id，key被修改或连接关闭客户端缓存后删除
+	keys map[string]map[int64]struct{}
+	// 开启了客户端缓存的连接数量，为0时读写命令不需要加锁
+	count atomic.Int32
+}
+
+func newTrackingTable() *trackingTable {
+	return &trackingTable{
+		conns:   make(map[int64]redis.Connection),
+		clients: make(map[int64]*trackingClient),
+		keys:    make(map[string]map[int64]struct{}),
+	}
+}
+
+func (t *trackingTable) addConn(c redis.Connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns[c.ID()] = c
+}
+
+// removeConn 连接关闭时移除，重定向到该连接的客户端在下次发送失效消息时收到tracking-redir-broken
+func (t *trackingTable) removeConn(c redis.Connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c.ID())
+	t.disableLocked(c.ID())
+}
+
+// enable 开启或修改客户端缓存，已经开启时不能切换BCAST、OPTIN、OPTOUT模式，前缀追加到已有的前缀中
+func (t *trackingTable) enable(c redis.Connection, opts *trackingClient) redis.Reply {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if opts.redirect != 0 {
+		if _, ok := t.conns[opts.redirect]; !ok {
+			return protocol.NewErrorReply("ERR The client ID you want redirect to does not exist")
+		}
+	}
+	tc, ok := t.clients[c.ID()]
+	if !ok {
+		opts.conn = c
+		t.clients[c.ID()] = opts
+		t.count.Add(1)
+		return protocol.OKReply
+	}
+	if tc.bcast != opts.bcast {
+		return protocol.NewErrorReply("ERR You can't switch BCAST mode on/off before disabling tracking " +
+			"for this client, and then re-enabling it with a different mode.")
+	}
+	if tc.optIn != opts.optIn || tc.optOut != opts.optOut {
+		return protocol.NewErrorReply("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking " +
+			"for this client, and then re-enabling it with a different mode.")
+	}
+	tc.redirect = opts.redirect
+	tc.noLoop = opts.noLoop
+	for _, prefix := range opts.prefixes {
+		if !containsString(tc.prefixes, prefix) {
+			tc.prefixes = append(tc.prefixes, prefix)
+		}
+	}
+	return protocol.OKReply
+}
+
+// disable 关闭客户端缓存，同时删除该连接记录的key
+func (t *trackingTable) disable(c redis.Connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.disableLocked(c.ID())
+}
+
+func (t *trackingTable) disableLocked(id int64) {
+	tc, ok := t.clients[id]
+	if !ok {
+		return
+	}
+	for key := range tc.keys {
+		ids := t.keys[key]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(t.keys, key)
+		}
+	}
+	delete(t.clients, id)
+	t.count.Add(-1)
+}
+
+// get 返回连接的客户端缓存状态的副本，没有开启时返回nil
+func (t *trackingTable) get(c redis.Connection) *trackingClient {
+	if t.count.Load() == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc, ok := t.clients[c.ID()]
+	if !ok {
+		return nil
+	}
+	snapshot := *tc
+	snapshot.prefixes = append([]string(nil), tc.prefixes...)
+	snapshot.keys = nil
+	return &snapshot
+}
+
+// setCaching CLIENT CACHING yes|no，作用于下一条命令
+func (t *trackingTable) setCaching(c redis.Connection, caching int8) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tc, ok := t.clients[c.ID()]; ok {
+		tc.nextCaching = caching
+	}
+}
+
+// beforeCommand 在每条命令执行前调用，使CLIENT CACHING设置的值只对这条命令有效
+func (t *trackingTable) beforeCommand(c redis.Connection) {
+	if t.count.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tc, ok := t.clients[c.ID()]; ok {
+		tc.caching = tc.nextCaching
+		tc.nextCaching = 0
+	}
+}
+
+// trackRead 记录开启了客户端缓存的连接读取的key，调用方持有key的锁
+func (t *trackingTable) trackRead(c redis.Connection, keys []string) {
+	if t.count.Load() == 0 || len(keys) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc, ok := t.clients[c.ID()]
+	if !ok || !tc.shouldTrack() {
+		return
+	}
+	for _, key := range keys {
+		ids, ok := t.keys[key]
+		if !ok {
+			ids = make(map[int64]struct{})
+			t.keys[key] = ids
+		}
+		ids[tc.conn.ID()] = struct{}{}
+		if tc.keys == nil {
+			tc.keys = make(map[string]struct{})
+		}
+		tc.keys[key] = struct{}{}
+	}
+}
+
+// invalidate 向读取过这些key的连接，以及前缀匹配的广播模式的连接发送失效消息
+// src为修改key的连接，过期删除时为nil
+func (t *trackingTable) invalidate(src redis.Connection, keys []string) {
+	if t.count.Load() == 0 || len(keys) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	targets := make(map[int64][][]byte)
+	for _, key := range keys {
+		for id := range t.keys[key] {
+			targets[id] = append(targets[id], []byte(key))
+			if tc, ok := t.clients[id]; ok {
+				delete(tc.keys, key)
+			}
+		}
+		delete(t.keys, key)
+		for id, tc := range t.clients {
+			if tc.bcast && matchPrefixes(tc.prefixes, key) {
+				targets[id] = append(targets[id], []byte(key))
+			}
+		}
+	}
+	for id, invalidated := range targets {
+		tc, ok := t.clients[id]
+		if !ok || (tc.noLoop && src != nil && src.ID() == id) {
+			continue
+		}
+		t.sendLocked(tc, invalidated)
+	}
+}
+
+// invalidateAll 清空数据库时向所有开启了客户端缓存的连接发送keys为空值的失效消息
+func (t *trackingTable) invalidateAll() {
+	if t.count.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys = make(map[string]map[int64]struct{})
+	for _, tc := range t.clients {
+		tc.keys = nil
+		t.sendLocked(tc, nil)
+	}
+}
+
+// sendLocked 发送失效消息，keys为nil表示所有key都失效
+// RESP3的连接接收推送消息: [invalidate, keys]
+// 重定向时，RESP2的目标连接需要订阅__redis__:invalidate频道，接收该频道的消息: [message, __redis__:invalidate, keys]
+func (t *trackingTable) sendLocked(tc *trackingClient, keys [][]byte) {
+	var keysReply redis.Reply = protocol.NullMultiBulkReply
+	if keys != nil {
+		keysReply = protocol.NewMultiBulkReply(keys)
+	}
+	target := tc.conn
+	if tc.redirect != 0 {
+		var ok bool
+		target, ok = t.conns[tc.redirect]
+		if !ok {
+			if tc.conn.GetProtocol() == protocol.RESP3 {
+				msg := protocol.NewPushReply([]redis.Reply{
+					protocol.NewBulkReply(redirBrokenBytes),
+					protocol.NewIntReply(tc.redirect),
+				})
+				tc.conn.Push(protocol.Encode(msg, protocol.RESP3))
+			}
+			return
+		}
+	}
+	if target.GetProtocol() == protocol.RESP3 {
+		msg := protocol.NewPushReply([]redis.Reply{protocol.NewBulkReply(invalidateBytes), keysReply})
+		target.Push(protocol.Encode(msg, protocol.RESP3))
+	} else if tc.redirect != 0 && target.IsSubscribed(string(invalidateChannelBytes)) {
+		msg := protocol.NewArrayReply([]redis.Reply{
+			protocol.NewBulkReply(messageBytes),
+			protocol.NewBulkReply(invalidateChannelBytes),
+			keysReply,
+		})
+		target.Push(msg.ToBytes())
+	}
+}
+
+// matchPrefixes 没有前缀时匹配所有key
+func matchPrefixes(prefixes []string, key string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
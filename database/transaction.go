@@ -0,0 +1,303 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"zedis/interface/redis"
+	"zedis/pubsub"
+	"zedis/redis/protocol"
+)
+
+var (
+	queuedReply          = protocol.NewSingleReply("QUEUED")
+	errNotAllowedInMulti = protocol.NewErrorReply("ERR Command not allowed inside a transaction")
+)
+
+// Multi 命令，开启事务，之后的命令进入队列，直到执行EXEC或DISCARD
+// MULTI
+func Multi(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("multi")
+	}
+	if c.InMultiState() {
+		return protocol.NewErrorReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return protocol.OKReply
+}
+
+// Discard 命令，放弃事务，同时取消所有WATCH
+// DISCARD
+func Discard(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("discard")
+	}
+	if !c.InMultiState() {
+		return protocol.NewErrorReply("ERR DISCARD without MULTI")
+	}
+	c.SetMultiState(false)
+	unwatchAll(engine, c)
+	return protocol.OKReply
+}
+
+// Watch 命令，记录key当前的版本号，EXEC时任意一个key的版本号改变，则放弃执行事务
+// WATCH key [key ...]
+func Watch(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("watch")
+	}
+	if c.InMultiState() {
+		return protocol.NewErrorReply("ERR WATCH inside MULTI is not allowed")
+	}
+	// 持有快照锁，避免SWAPDB同时交换WATCH表
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	d, errReply := engine.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	watching := c.GetWatching()[c.GetDBIndex()]
+	for _, arg := range args {
+		key := string(arg)
+		// 重复WATCH同一个key不改变记录的版本号
+		if _, ok := watching[key]; ok {
+			continue
+		}
+		c.Watch(c.GetDBIndex(), key, d.watched.watch(key))
+	}
+	return protocol.OKReply
+}
+
+// UnWatch 命令，取消所有WATCH
+// UNWATCH
+func UnWatch(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("unwatch")
+	}
+	unwatchAll(engine, c)
+	return protocol.OKReply
+}
+
+// unwatchAll 取消连接的所有WATCH，没有客户端WATCH的key从WATCH表中删除
+func unwatchAll(engine *Engine, c redis.Connection) {
+	watching := c.GetWatching()
+	if len(watching) == 0 {
+		return
+	}
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	for dbIndex, keys := range watching {
+		d, errReply := engine.selectDB(dbIndex)
+		if errReply != nil {
+			continue
+		}
+		for key := range keys {
+			d.watched.unwatch(key)
+		}
+	}
+	c.ClearWatching()
+}
+
+// EnqueueCmd 将事务中的命令放入队列
+// 命令不存在、参数数量错误或不能在事务中执行时，记录错误，EXEC时放弃执行事务
+func EnqueueCmd(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	var errReply protocol.ErrorReply
+	switch cmdName {
+	case "ping", "unwatch":
+	case "publish":
+		if len(cmdLine) != 3 {
+			errReply = protocol.NewArgNumErrReply(cmdName)
+		}
+	case "eval", "evalsha", "fcall", "fcall_ro":
+		if len(cmdLine) < 3 {
+			errReply = protocol.NewArgNumErrReply(cmdName)
+		}
+	case "select", "swapdb", "move", "flushall":
+		if !validateArity(engineCmdArity[cmdName], len(cmdLine)) {
+			errReply = protocol.NewArgNumErrReply(cmdName)
+		}
+	default:
+		if isEngineCommand(cmdName) {
+			errReply = errNotAllowedInMulti
+			break
+		}
+		_, errReply = lookupCommand(cmdName, cmdLine[1:])
+	}
+	if errReply != nil {
+		c.AddTxError(errors.New(errReply.Error()))
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return queuedReply
+}
+
+// engineCmdArity 可以放入事务的Engine命令的参数数量限制，与command.arity含义相同
+var engineCmdArity = map[string]int{
+	"select":   2,
+	"swapdb":   3,
+	"move":     3,
+	"flushall": -1,
+}
+
+// isEngineCommand 判断命令是否由Engine直接执行，除engineCmdArity中的命令外，这些命令不能放入事务
+func isEngineCommand(cmdName string) bool {
+	switch cmdName {
+	case "auth", "info", "client", "bgrewriteaof", "save", "bgsave", "lastsave",
+		"select", "swapdb", "move", "flushall",
+		"replicaof", "slaveof", "psync", "replconf", "role", "cluster", "script", "function",
+		"subscribe", "unsubscribe", "psubscribe", "punsubscribe", "pubsub":
+		return true
+	}
+	return false
+}
+
+// ExecMulti 命令，原子地执行事务中的所有命令
+// 执行前一次性锁住所有命令涉及的key，执行过程中不会有其他客户端的命令修改这些key
+// 事务中有SELECT、KEYS、FLUSHDB等访问整个数据库的命令时，无法预先锁住所有key，改为独占快照锁执行，见execMultiExclusive
+// WATCH的key被修改过时放弃执行事务，返回空数组；命令执行出错不会回滚，与Redis一致
+// EXEC
+func ExecMulti(engine *Engine, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return protocol.NewErrorReply("ERR EXEC without MULTI")
+	}
+	defer func() {
+		c.SetMultiState(false)
+		unwatchAll(engine, c)
+	}()
+	if len(c.GetTxErrors()) > 0 {
+		return protocol.NewErrorReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	d, errReply := engine.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+
+	cmdLines := c.GetQueuedCmdLine()
+	for _, cmdLine := range cmdLines {
+		if isWholeDBCommand(strings.ToLower(string(cmdLine[0]))) {
+			return execMultiExclusive(engine, c, cmdLines)
+		}
+	}
+	writeKeys := make([]string, 0)
+	readKeys := make([]string, 0)
+	for _, cmdLine := range cmdLines {
+		w, r := queuedCmdKeys(cmdLine)
+		writeKeys = append(writeKeys, w...)
+		readKeys = append(readKeys, r...)
+	}
+	for key := range c.GetWatching()[c.GetDBIndex()] {
+		readKeys = append(readKeys, key)
+	}
+	engine.snapshotMu.RLock()
+	defer engine.snapshotMu.RUnlock()
+	d.RWLocks(writeKeys, readKeys)
+	defer d.RWUnLocks(writeKeys, readKeys)
+
+	if isWatchingChanged(engine, c) {
+		return protocol.NullMultiBulkReply
+	}
+	results := make([]redis.Reply, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		results = append(results, execQueuedCmd(engine, d, c, cmdLine))
+	}
+	return protocol.NewArrayReply(results)
+}
+
+// execMultiExclusive 执行包含访问整个数据库的命令的事务
+// 独占快照锁，其他客户端的写命令需要等待事务执行完成，每条命令执行时再各自获取key的锁
+// SELECT之后的命令在新选择的数据库中执行，事务结束后连接仍然使用新选择的数据库，与Redis一致
+func execMultiExclusive(engine *Engine, c redis.Connection, cmdLines []CmdLine) redis.Reply {
+	engine.snapshotMu.Lock()
+	defer engine.snapshotMu.Unlock()
+
+	if isWatchingChanged(engine, c) {
+		return protocol.NullMultiBulkReply
+	}
+	results := make([]redis.Reply, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		d, errReply := engine.selectDB(c.GetDBIndex())
+		if errReply != nil {
+			results = append(results, errReply)
+			continue
+		}
+		writeKeys, readKeys := queuedCmdKeys(cmdLine)
+		d.RWLocks(writeKeys, readKeys)
+		results = append(results, execQueuedCmd(engine, d, c, cmdLine))
+		d.RWUnLocks(writeKeys, readKeys)
+	}
+	return protocol.NewArrayReply(results)
+}
+
+// isWholeDBCommand 判断命令是否切换数据库、修改整个数据库或者需要遍历所有key
+func isWholeDBCommand(cmdName string) bool {
+	if _, ok := engineCmdArity[cmdName]; ok {
+		return true
+	}
+	cmd, ok := cmdTable[cmdName]
+	return ok && cmd.tags&tagNoMulti > 0
+}
+
+// queuedCmdKeys 返回事务中的一条命令需要加锁的key
+func queuedCmdKeys(cmdLine CmdLine) ([]string, []string) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if isScriptCommand(cmdName) {
+		writeKeys, _ := prepareEval(cmdLine[1:])
+		return writeKeys, nil
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok || cmd.prepare == nil {
+		return nil, nil
+	}
+	return cmd.prepare(cmdLine[1:])
+}
+
+// execQueuedCmd 执行事务中的一条命令，调用方已经持有所有key的锁
+// SELECT、SWAPDB等Engine命令只出现在execMultiExclusive中，此时调用方持有快照锁的写锁
+func execQueuedCmd(engine *Engine, d *DB, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmdArgs := cmdLine[1:]
+	switch cmdName {
+	case "ping":
+		return Ping(c, cmdArgs)
+	case "unwatch":
+		return protocol.OKReply
+	case "publish":
+		return pubsub.Publish(engine.hub, cmdArgs)
+	case "eval", "evalsha", "fcall", "fcall_ro":
+		return execQueuedScript(engine, d, c, cmdName, cmdArgs)
+	case "select":
+		return Select(engine, c, cmdArgs)
+	case "swapdb":
+		return swapDB(engine, c, cmdLine)
+	case "move":
+		return move(engine, c, cmdLine)
+	case "flushall":
+		return flushAll(engine, c, cmdLine)
+	}
+	cmd := cmdTable[cmdName]
+	var writeKeys, readKeys []string
+	if cmd.prepare != nil {
+		writeKeys, readKeys = cmd.prepare(cmdArgs)
+	}
+	return d.execWithLock(c, cmd, cmdArgs, writeKeys, readKeys)
+}
+
+// isWatchingChanged 检查WATCH的key的版本号是否改变
+func isWatchingChanged(engine *Engine, c redis.Connection) bool {
+	for dbIndex, keys := range c.GetWatching() {
+		d, errReply := engine.selectDB(dbIndex)
+		if errReply != nil {
+			continue
+		}
+		for key, version := range keys {
+			if d.GetVersion(key) != version {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// versionGenerator 用于生成key的版本号，所有数据库共用，SWAPDB后不同数据库的版本号也不会相同
+var versionGenerator atomic.Uint64
+
+// watchTable 记录一个数据库中被WATCH的key的版本号，只保存当前有客户端WATCH的key
+// 没有客户端WATCH时，写命令只需要读取一次watched
+type watchTable struct {
+	mu   sync.Mutex
+	keys map[string]*watchedKey
+	// 被WATCH的key的数量
+	watched atomic.Int32
+	// FLUSHDB、SWAPDB等修改整个数据库的命令执行时更新，所有key的版本号都不小于epoch
+	epoch atomic.Uint64
+}
+
+type watchedKey struct {
+	version uint64
+	// WATCH该key的客户端数量，为0时删除
+	refs int
+}
+
+func newWatchTable() *watchTable {
+	return &watchTable{keys: make(map[string]*watchedKey)}
+}
+
+// watch 增加key的WATCH计数，返回key当前的版本号
+func (w *watchTable) watch(key string) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wk, ok := w.keys[key]
+	if !ok {
+		wk = &watchedKey{}
+		w.keys[key] = wk
+		w.watched.Add(1)
+	}
+	wk.refs++
+	return w.versionLocked(wk)
+}
+
+// unwatch 减少key的WATCH计数，没有客户端WATCH时删除
+func (w *watchTable) unwatch(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wk, ok := w.keys[key]
+	if !ok {
+		return
+	}
+	wk.refs--
+	if wk.refs <= 0 {
+		delete(w.keys, key)
+		w.watched.Add(-1)
+	}
+}
+
+// touch 更新被WATCH的key的版本号，表示key被修改过
+func (w *watchTable) touch(keys ...string) {
+	if w.watched.Load() == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		if wk, ok := w.keys[key]; ok {
+			wk.version = versionGenerator.Add(1)
+		}
+	}
+}
+
+// touchAll 更新epoch，相当于所有key都被修改过
+func (w *watchTable) touchAll() {
+	w.epoch.Store(versionGenerator.Add(1))
+}
+
+// version 返回key的版本号，没有被WATCH的key返回epoch
+func (w *watchTable) version(key string) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wk, ok := w.keys[key]
+	if !ok {
+		return w.epoch.Load()
+	}
+	return w.versionLocked(wk)
+}
+
+func (w *watchTable) versionLocked(wk *watchedKey) uint64 {
+	epoch := w.epoch.Load()
+	if wk.version > epoch {
+		return wk.version
+	}
+	return epoch
+}
@@ -0,0 +1,556 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"zedis/datastruct/sortedset"
+	"zedis/interface/db"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+func (d *DB) getEntityAsSortedSet(key string) (*sortedset.SortedSet, redis.Reply) {
+	entity, exists := d.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	if entity.Type != db.SortedType {
+		return nil, protocol.ErrorWrongTypeReply
+	}
+	return entity.Data.(*sortedset.SortedSet), nil
+}
+
+func buildSortedSetEntity(zset *sortedset.SortedSet) *db.DataEntity {
+	return &db.DataEntity{
+		Data: zset,
+		Type: db.SortedType,
+	}
+}
+
+// parseScore 解析score，支持inf、+inf、-inf，不允许NaN
+func parseScore(arg []byte) (float64, error) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, protocol.ErrorNotValidFloatReply
+	}
+	return score, nil
+}
+
+// formatScore 将score格式化为字符串，与Redis保持一致，例如 1、1.5、inf、-inf
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	} else if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// elementsToReply 将元素列表转换为响应，withScores为true时每个member后跟随score
+// score为浮点数响应，RESP2下编码为字符串
+func elementsToReply(elements []*sortedset.Element, withScores bool) redis.Reply {
+	if !withScores {
+		res := make([][]byte, 0, len(elements))
+		for _, element := range elements {
+			res = append(res, []byte(element.Member))
+		}
+		return protocol.NewMultiBulkReply(res)
+	}
+	res := make([]redis.Reply, 0, 2*len(elements))
+	for _, element := range elements {
+		res = append(res, protocol.NewBulkReply([]byte(element.Member)), protocol.NewDoubleReply(element.Score))
+	}
+	return protocol.NewArrayReply(res)
+}
+
+// ZAddCommand 向有序集合添加元素，如果member已存在，则更新score
+// ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+// NX: 只添加新元素，不更新已存在元素
+// XX: 只更新已存在元素，不添加新元素
+// GT/LT: 只有新score大于/小于原score时才更新，不影响添加新元素
+// CH: 返回值由新添加的元素数量变为新添加和被更新的元素数量之和
+// INCR: 与ZINCRBY行为一致，只能指定一对score member，返回更新后的score；如果因为选项限制未执行，返回nil
+func ZAddCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	nx, xx, gt, lt, ch, incr := false, false, false, false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		if arg == "NX" {
+			nx = true
+		} else if arg == "XX" {
+			xx = true
+		} else if arg == "GT" {
+			gt = true
+		} else if arg == "LT" {
+			lt = true
+		} else if arg == "CH" {
+			ch = true
+		} else if arg == "INCR" {
+			incr = true
+		} else {
+			break
+		}
+	}
+
+	pairArgs := args[i:]
+	if len(pairArgs) == 0 || len(pairArgs)%2 != 0 {
+		return protocol.ErrorSyntaxReply
+	}
+	if nx && xx {
+		return protocol.NewErrorReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (gt && nx) || (lt && nx) {
+		return protocol.NewErrorReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairArgs) > 2 {
+		return protocol.NewErrorReply("ERR INCR option supports a single increment-element pair")
+	}
+
+	elements := make([]*sortedset.Element, 0, len(pairArgs)/2)
+	for j := 0; j < len(pairArgs); j += 2 {
+		score, err := parseScore(pairArgs[j])
+		if err != nil {
+			return protocol.ErrorNotValidFloatReply
+		}
+		elements = append(elements, &sortedset.Element{
+			Member: string(pairArgs[j+1]),
+			Score:  score,
+		})
+	}
+
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	keyExists := zset != nil
+	if !keyExists {
+		if xx {
+			if incr {
+				return protocol.NullBulkReply
+			}
+			return protocol.ZeroReply
+		}
+		zset = sortedset.NewSortedSet()
+	}
+
+	var added, changed int64 = 0, 0
+	var incrResult redis.Reply = protocol.NullBulkReply
+	for _, element := range elements {
+		oldElement, exists := zset.Get(element.Member)
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		newScore := element.Score
+		if incr && exists {
+			newScore = oldElement.Score + element.Score
+			if math.IsNaN(newScore) {
+				return protocol.NewErrorReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists {
+			if (gt && newScore <= oldElement.Score) || (lt && newScore >= oldElement.Score) {
+				continue
+			}
+			if newScore != oldElement.Score {
+				zset.Add(element.Member, newScore)
+				changed++
+			}
+		} else {
+			zset.Add(element.Member, newScore)
+			added++
+		}
+		if incr {
+			incrResult = protocol.NewDoubleReply(newScore)
+		}
+	}
+
+	if !keyExists && zset.Len() > 0 {
+		d.PutEntity(key, buildSortedSetEntity(zset))
+	}
+	if added+changed > 0 {
+		if incr {
+			d.notify(notifyZset, "zincr", key)
+		} else {
+			d.notify(notifyZset, "zadd", key)
+		}
+	}
+
+	if incr {
+		return incrResult
+	}
+	if ch {
+		return protocol.NewIntReply(added + changed)
+	}
+	return protocol.NewIntReply(added)
+}
+
+// ZIncrByCommand 给有序集合中member的score加上increment，如果member不存在，视为score为0；如果key不存在，则新建有序集合
+// 返回更新后的score
+// ZINCRBY key increment member
+func ZIncrByCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	increment, err := parseScore(args[1])
+	if err != nil {
+		return protocol.ErrorNotValidFloatReply
+	}
+	member := string(args[2])
+
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		zset = sortedset.NewSortedSet()
+		d.PutEntity(key, buildSortedSetEntity(zset))
+	}
+
+	score := increment
+	element, exists := zset.Get(member)
+	if exists {
+		score += element.Score
+		if math.IsNaN(score) {
+			return protocol.NewErrorReply("ERR resulting score is not a number (NaN)")
+		}
+	}
+	zset.Add(member, score)
+	d.notify(notifyZset, "zincr", key)
+	return protocol.NewDoubleReply(score)
+}
+
+// ZScoreCommand 返回member的score，如果key或member不存在，返回nil
+// ZSCORE key member
+func ZScoreCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.NullBulkReply
+	}
+	element, exists := zset.Get(string(args[1]))
+	if !exists {
+		return protocol.NullBulkReply
+	}
+	return protocol.NewDoubleReply(element.Score)
+}
+
+// ZCardCommand 返回有序集合元素数量，key不存在返回0
+// ZCARD key
+func ZCardCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.ZeroReply
+	}
+	return protocol.NewIntReply(zset.Len())
+}
+
+// ZRemCommand 删除有序集合中的多个member，返回实际删除的数量；删除后集合为空，则删除key
+// ZREM key member [member ...]
+func ZRemCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.ZeroReply
+	}
+	var deleted int64 = 0
+	for _, member := range args[1:] {
+		if zset.Remove(string(member)) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		d.notify(notifyZset, "zrem", key)
+	}
+	if zset.Len() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
+	}
+	return protocol.NewIntReply(deleted)
+}
+
+// zRank ZRANK、ZREVRANK的实现，desc为true时按score从大到小排名
+func zRank(d *DB, args [][]byte, desc bool, cmdName string) redis.Reply {
+	if len(args) > 3 {
+		return protocol.NewArgNumErrReply(cmdName)
+	}
+	withScore := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHSCORE" {
+			return protocol.ErrorSyntaxReply
+		}
+		withScore = true
+	}
+
+	key := string(args[0])
+	member := string(args[1])
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.NullBulkReply
+	}
+	rank, exists := zset.GetRank(member, desc)
+	if !exists {
+		return protocol.NullBulkReply
+	}
+	if withScore {
+		element, _ := zset.Get(member)
+		return protocol.NewArrayReply([]redis.Reply{
+			protocol.NewIntReply(rank),
+			protocol.NewDoubleReply(element.Score),
+		})
+	}
+	return protocol.NewIntReply(rank)
+}
+
+// ZRankCommand 返回member按score从小到大的排名，从0开始；key或member不存在返回nil
+// ZRANK key member [WITHSCORE]
+func ZRankCommand(d *DB, args [][]byte) redis.Reply {
+	return zRank(d, args, false, "zrank")
+}
+
+// ZRevRankCommand 返回member按score从大到小的排名，从0开始；key或member不存在返回nil
+// ZREVRANK key member [WITHSCORE]
+func ZRevRankCommand(d *DB, args [][]byte) redis.Reply {
+	return zRank(d, args, true, "zrevrank")
+}
+
+// ZCountCommand 返回score位于 [min, max] 范围内的元素数量，min和max可以是 -inf、+inf，以及 ( 开头表示不包含
+// ZCOUNT key min max
+func ZCountCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	min, err := sortedset.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return protocol.NewErrorReply(err.Error())
+	}
+	max, err := sortedset.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return protocol.NewErrorReply(err.Error())
+	}
+
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.ZeroReply
+	}
+	return protocol.NewIntReply(zset.RangeCount(min, max))
+}
+
+// ZScanCommand 使用游标增量遍历有序集合中的元素和score，用法与SCAN相同
+// 遍历开始到结束期间一直存在的元素一定会被返回，但有序集合扩容或缩容后可能返回重复的元素
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func ZScanCommand(d *DB, args [][]byte) redis.Reply {
+	cursor, opts, errReply := parseScanArgs("zscan", args[1], args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	zset, errReply := d.getEntityAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return scanReply(0, nil)
+	}
+	res := make([][]byte, 0)
+	cursor = zset.Scan(cursor, opts.count, func(element *sortedset.Element) bool {
+		if opts.match(element.Member) {
+			res = append(res, []byte(element.Member), []byte(formatScore(element.Score)))
+		}
+		return true
+	})
+	return scanReply(cursor, res)
+}
+
+// ZRangeCommand 返回指定范围内的元素
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// 默认按排名(索引)范围查询，索引可以为负数，表示从末尾往前数
+// BYSCORE: 按score范围查询，start和stop为score边界
+// BYLEX: 按member字典序范围查询，要求所有元素score相同，start和stop为字典序边界
+// REV: 倒序返回，此时BYSCORE、BYLEX的start为较大的边界，stop为较小的边界
+// LIMIT: 只能和BYSCORE、BYLEX一起使用，跳过offset个元素，最多返回count个，count为负数表示返回全部
+func ZRangeCommand(d *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	byScore, byLex, rev, withScores, hasLimit := false, false, false, false, false
+	var offset, limit int64 = 0, -1
+	for i := 3; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "BYSCORE":
+			byScore = true
+		case "BYLEX":
+			byLex = true
+		case "REV":
+			rev = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return protocol.ErrorSyntaxReply
+			}
+			var err error
+			offset, err = parseInt64(args[i+1])
+			if err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			limit, err = parseInt64(args[i+2])
+			if err != nil {
+				return protocol.ErrorNotIntegerReply
+			}
+			hasLimit = true
+			i += 2
+		default:
+			return protocol.ErrorSyntaxReply
+		}
+	}
+	if byScore && byLex {
+		return protocol.ErrorSyntaxReply
+	}
+	if hasLimit && !byScore && !byLex {
+		return protocol.NewErrorReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && byLex {
+		return protocol.NewErrorReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	if byScore || byLex {
+		// REV时，start为上界，stop为下界
+		minArg, maxArg := string(args[1]), string(args[2])
+		if rev {
+			minArg, maxArg = maxArg, minArg
+		}
+		parseBorder := sortedset.ParseScoreBorder
+		if byLex {
+			parseBorder = sortedset.ParseLexBorder
+		}
+		min, err := parseBorder(minArg)
+		if err != nil {
+			return protocol.NewErrorReply(err.Error())
+		}
+		max, err := parseBorder(maxArg)
+		if err != nil {
+			return protocol.NewErrorReply(err.Error())
+		}
+
+		zset, errReply := d.getEntityAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if zset == nil {
+			return protocol.EmptyMultiBulkReply
+		}
+		return elementsToReply(zset.Range(min, max, offset, limit, rev), withScores)
+	}
+
+	start, err := parseInt64(args[1])
+	if err != nil {
+		return protocol.ErrorNotIntegerReply
+	}
+	stop, err := parseInt64(args[2])
+	if err != nil {
+		return protocol.ErrorNotIntegerReply
+	}
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.EmptyMultiBulkReply
+	}
+
+	size := zset.Len()
+	if start < 0 {
+		start += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start >= size || start > stop {
+		return protocol.EmptyMultiBulkReply
+	}
+	return elementsToReply(zset.RangeByRank(start, stop+1, rev), withScores)
+}
+
+// zPop ZPOPMIN、ZPOPMAX的实现
+func zPop(d *DB, args [][]byte, max bool, cmdName string) redis.Reply {
+	if len(args) > 2 {
+		return protocol.NewArgNumErrReply(cmdName)
+	}
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		var err error
+		count, err = parseInt(args[1])
+		if err != nil || count < 0 {
+			return protocol.NewErrorReply("ERR value is out of range, must be positive")
+		}
+	}
+
+	zset, errReply := d.getEntityAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil || count == 0 {
+		return protocol.EmptyMultiBulkReply
+	}
+
+	var removed []*sortedset.Element
+	if max {
+		removed = zset.PopMax(count)
+	} else {
+		removed = zset.PopMin(count)
+	}
+	if len(removed) > 0 {
+		d.notify(notifyZset, cmdName, key)
+	}
+	if zset.Len() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, "del", key)
+	}
+	return elementsToReply(removed, true)
+}
+
+// ZPopMinCommand 删除并返回score最小的count个元素，默认为1个，返回格式为 member score member score ...
+// ZPOPMIN key [count]
+func ZPopMinCommand(d *DB, args [][]byte) redis.Reply {
+	return zPop(d, args, false, "zpopmin")
+}
+
+// ZPopMaxCommand 删除并返回score最大的count个元素，默认为1个，返回格式为 member score member score ...
+// ZPOPMAX key [count]
+func ZPopMaxCommand(d *DB, args [][]byte) redis.Reply {
+	return zPop(d, args, true, "zpopmax")
+}
+
+func init() {
+	registerNormalCommand("zadd", ZAddCommand, writeFirstKey, -4, tagWrite)
+	registerNormalCommand("zincrby", ZIncrByCommand, writeFirstKey, 4, tagWrite)
+	registerNormalCommand("zscore", ZScoreCommand, readFirstKey, 3, tagRead)
+	registerNormalCommand("zcard", ZCardCommand, readFirstKey, 2, tagRead)
+	registerNormalCommand("zrem", ZRemCommand, writeFirstKey, -3, tagWrite)
+	registerNormalCommand("zrank", ZRankCommand, readFirstKey, -3, tagRead)
+	registerNormalCommand("zrevrank", ZRevRankCommand, readFirstKey, -3, tagRead)
+	registerNormalCommand("zcount", ZCountCommand, readFirstKey, 4, tagRead)
+	registerNormalCommand("zrange", ZRangeCommand, readFirstKey, -4, tagRead)
+	registerNormalCommand("zscan", ZScanCommand, readFirstKey, -3, tagRead)
+	registerNormalCommand("zpopmin", ZPopMinCommand, writeFirstKey, -2, tagWrite)
+	registerNormalCommand("zpopmax", ZPopMaxCommand, writeFirstKey, -2, tagWrite)
+}
@@ -44,6 +44,30 @@ func (b *BitMap) SetBit(offset int64, value bool) {
 	}
 }
 
+// GetBits 读取从offset开始的width个bit，第一个bit作为最高位，超出长度的部分视为0，width最大为64
+func (b *BitMap) GetBits(offset int64, width int) uint64 {
+	var value uint64
+	for i := 0; i < width; i++ {
+		value <<= 1
+		if b.GetBit(offset + int64(i)) {
+			value |= 1
+		}
+	}
+	return value
+}
+
+// SetBits 将value的低width位写入从offset开始的width个bit，最高位在前，长度不够时自动扩容
+func (b *BitMap) SetBits(offset int64, width int, value uint64) {
+	if width <= 0 {
+		return
+	}
+	b.grow(offset + int64(width) - 1)
+	for i := 0; i < width; i++ {
+		bit := value>>(width-1-i)&1 == 1
+		b.SetBit(offset+int64(i), bit)
+	}
+}
+
 // ForEachBit 遍历bit，begin和end是bit索引(begin、end都包括)，范围是[0, len(data) * 8)
 func (b *BitMap) ForEachBit(begin int64, end int64, consumer BitConsumer) {
 	if b == nil {
@@ -20,3 +20,21 @@ func TestBitMap(t *testing.T) {
 	fmt.Printf("%v\n", bitmap.GetBit(12))
 	fmt.Printf("%v\n", bitmap.GetBit(100))
 }
+
+func TestBits(t *testing.T) {
+	bitmap := NewEmptyBitMap()
+	bitmap.SetBits(5, 8, 0xab)
+	if bitmap.ByteSize() != 2 || (*bitmap)[0] != 0x05 || (*bitmap)[1] != 0x58 {
+		t.Fatalf("unexpected bytes: %x", *bitmap)
+	}
+	if v := bitmap.GetBits(5, 8); v != 0xab {
+		t.Errorf("expected 0xab, actual %x", v)
+	}
+	if v := bitmap.GetBits(13, 16); v != 0 {
+		t.Errorf("bits out of range should be 0, actual %x", v)
+	}
+	bitmap.SetBits(0, 64, 1<<63|1)
+	if v := bitmap.GetBits(0, 64); v != 1<<63|1 {
+		t.Errorf("expected %x, actual %x", uint64(1<<63|1), v)
+	}
+}
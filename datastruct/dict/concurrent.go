@@ -298,8 +298,14 @@ func (c *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 	return nil
 }
 
+// Clear 逐个分片加锁清空，不替换分片，保证其他协程持有的分片锁仍然有效
 func (c *ConcurrentDict) Clear() {
-	*c = *NewConcurrentDict(c.shardCount)
+	for _, s := range c.table {
+		s.mutex.Lock()
+		atomic.AddInt32(&c.count, -int32(len(s.m)))
+		s.m = make(map[string]any)
+		s.mutex.Unlock()
+	}
 }
 
 func (c *ConcurrentDict) addCount() int32 {
@@ -323,7 +329,7 @@ func (c *ConcurrentDict) toLockIndices(keys []string, reverse bool) []uint32 {
 		indices = append(indices, index)
 	}
 
-	sort.SliceIsSorted(indices, func(i, j int) bool {
+	sort.Slice(indices, func(i, j int) bool {
 		if reverse {
 			return indices[i] > indices[j]
 		}
@@ -334,7 +340,7 @@ func (c *ConcurrentDict) toLockIndices(keys []string, reverse bool) []uint32 {
 
 // RWLocks 根据写key和读key，分别lock 写锁和读锁，允许重复key
 func (c *ConcurrentDict) RWLocks(writeKeys []string, readKeys []string) {
-	keys := append(writeKeys, readKeys...)
+	keys := append(writeKeys[:len(writeKeys):len(writeKeys)], readKeys...)
 	indices := c.toLockIndices(keys, false)
 	writeIndexSet := make(map[uint32]struct{})
 	for _, key := range writeKeys {
@@ -356,7 +362,7 @@ func (c *ConcurrentDict) RWLocks(writeKeys []string, readKeys []string) {
 
 // RWUnLocks 根据写key和读key，分别unlock 写锁和读锁，允许重复key
 func (c *ConcurrentDict) RWUnLocks(writeKeys []string, readKeys []string) {
-	keys := append(writeKeys, readKeys...)
+	keys := append(writeKeys[:len(writeKeys):len(writeKeys)], readKeys...)
 	indices := c.toLockIndices(keys, false)
 	writeIndexSet := make(map[uint32]struct{})
 	for _, key := range writeKeys {
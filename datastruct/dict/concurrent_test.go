@@ -23,3 +23,47 @@ func TestConcurrentDict(t *testing.T) {
 	}
 
 }
+
+func TestConcurrentDictClear(t *testing.T) {
+	dict := NewConcurrentDict(16)
+	for i := 0; i < 100; i++ {
+		dict.Put(fmt.Sprintf("key%d", i), i)
+	}
+	dict.Clear()
+	if dict.Len() != 0 {
+		t.Fatalf("expected empty dict, got %d keys", dict.Len())
+	}
+	if _, exists := dict.Get("key1"); exists {
+		t.Fatal("key1 should be cleared")
+	}
+}
+
+func TestSimpleDict(t *testing.T) {
+	dict := NewSimpleDict()
+	for i := 0; i < 1000; i++ {
+		dict.Put(fmt.Sprintf("key%d", i), i)
+	}
+	dict.Put("key1", "updated")
+	if dict.Len() != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", dict.Len())
+	}
+	if val, _ := dict.Get("key1"); val != "updated" {
+		t.Fatalf("expected updated, got %v", val)
+	}
+	if keys := dict.RandomDistinctKeys(2000); len(keys) != 1000 {
+		t.Fatalf("expected 1000 distinct keys, got %d", len(keys))
+	}
+	for i := 0; i < 990; i++ {
+		if _, ret := dict.Remove(fmt.Sprintf("key%d", i)); ret != 1 {
+			t.Fatalf("remove key%d failed", i)
+		}
+	}
+	if dict.Len() != 10 || dict.Exists("key1") || !dict.Exists("key999") {
+		t.Fatalf("unexpected dict after remove, len %d", dict.Len())
+	}
+	for _, key := range dict.RandomKeys(20) {
+		if !dict.Exists(key) {
+			t.Fatalf("random key %s not exists", key)
+		}
+	}
+}
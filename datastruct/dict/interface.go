@@ -16,5 +16,7 @@ type Dict interface {
 	Keys() []string
 	RandomKeys(limit int) []string // 随机返回limit个key
 	RandomDistinctKeys(limit int) []string
+	// Scan 按游标增量遍历，返回下一次遍历的游标，为0表示遍历结束
+	Scan(cursor uint64, count int, consumer Consumer) uint64
 	Clear() // 情况
 }
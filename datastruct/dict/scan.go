@@ -0,0 +1,63 @@
+package dict
+
+import "math/bits"
+
+/*
+SCAN系列命令使用与Redis相同的反向二进制游标：
+游标的低位对应分片（SimpleDict中为桶）索引，每次对游标的反向二进制表示加1，即从高位开始进位，依次访问分片
+这样访问过的分片在分片数量变为2倍或一半后，仍然对应新分片数组中的一段前缀，
+因此在两次调用之间分片数量改变时，遍历开始到结束期间一直存在的key一定会被返回，但可能返回重复的key
+*/
+
+// nextCursor 在mask表示的分片数量下，返回cursor之后要访问的分片对应的游标，返回0表示遍历结束
+func nextCursor(cursor uint64, mask uint64) uint64 {
+	// 将不属于分片索引的高位置1，使加1时的进位越过这些位
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// Scan 从cursor对应的分片开始，依次遍历分片中的所有key，直到遍历的key不少于count个，返回下一次遍历的游标，为0时表示遍历结束
+// 空分片只需加一次读锁，不计入count，因此key很少时一次调用就能遍历完所有分片
+func (c *ConcurrentDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	if c == nil {
+		panic("dict is nil")
+	}
+	mask := uint64(len(c.table) - 1)
+	visited := 0
+	for {
+		s := c.table[cursor&mask]
+		s.mutex.RLock()
+		for k, v := range s.m {
+			visited++
+			if !consumer(k, v) {
+				break
+			}
+		}
+		s.mutex.RUnlock()
+		cursor = nextCursor(cursor, mask)
+		if cursor == 0 || visited >= count {
+			return cursor
+		}
+	}
+}
+
+// Scan 从cursor对应的桶开始，依次遍历桶中的所有key，直到遍历的key不少于count个，返回下一次遍历的游标，为0时表示遍历结束
+// 元素数量不少于桶数量的1/8，因此一次调用访问的桶数量与count成正比
+func (s *SimpleDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	mask := uint64(len(s.table) - 1)
+	visited := 0
+	for {
+		for _, e := range s.table[cursor&mask] {
+			visited++
+			if !consumer(e.key, e.val) {
+				break
+			}
+		}
+		cursor = nextCursor(cursor, mask)
+		if cursor == 0 || visited >= count {
+			return cursor
+		}
+	}
+}
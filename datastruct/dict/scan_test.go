@@ -0,0 +1,91 @@
+package dict
+
+import (
+	"fmt"
+	"testing"
+)
+
+func makeDict(shardCount, n int) *ConcurrentDict {
+	dict := NewConcurrentDict(shardCount)
+	for i := 0; i < n; i++ {
+		dict.Put(fmt.Sprintf("key%d", i), i)
+	}
+	return dict
+}
+
+func TestScan(t *testing.T) {
+	dict := makeDict(64, 1000)
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for {
+		cursor = dict.Scan(cursor, 10, func(key string, val any) bool {
+			seen[key]++
+			return true
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 1000 {
+		t.Fatalf("expected 1000 keys, actual %d", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("key %s returned %d times", key, n)
+		}
+	}
+}
+
+// TestScanResize 遍历过程中分片数量变化时，所有key仍然会被返回
+func TestScanResize(t *testing.T) {
+	for _, sizes := range [][2]int{{16, 256}, {256, 16}} {
+		small, large := makeDict(sizes[0], 1000), makeDict(sizes[1], 1000)
+		seen := make(map[string]bool)
+		consumer := func(key string, val any) bool {
+			seen[key] = true
+			return true
+		}
+		cursor := uint64(0)
+		for i := 0; i < 5; i++ {
+			cursor = small.Scan(cursor, 10, consumer)
+		}
+		for cursor != 0 {
+			cursor = large.Scan(cursor, 10, consumer)
+		}
+		if len(seen) != 1000 {
+			t.Errorf("resize %v: expected 1000 keys, actual %d", sizes, len(seen))
+		}
+	}
+}
+
+// TestSimpleDictScan 遍历期间删除一半的key触发缩容，再添加新的key触发扩容，一直存在的key仍然会被返回
+func TestSimpleDictScan(t *testing.T) {
+	dict := NewSimpleDict()
+	for i := 0; i < 500; i++ {
+		dict.Put(fmt.Sprintf("member%d", i), i)
+	}
+	seen := make(map[string]int)
+	consumer := func(key string, val any) bool {
+		seen[key]++
+		return true
+	}
+	cursor := dict.Scan(0, 7, consumer)
+	for i := 250; i < 500; i++ {
+		dict.Remove(fmt.Sprintf("member%d", i))
+	}
+	for i := 0; i < 2; i++ {
+		cursor = dict.Scan(cursor, 7, consumer)
+	}
+	for i := 0; i < 2000; i++ {
+		dict.Put(fmt.Sprintf("new%d", i), i)
+	}
+	for cursor != 0 {
+		cursor = dict.Scan(cursor, 7, consumer)
+	}
+	for i := 0; i < 250; i++ {
+		key := fmt.Sprintf("member%d", i)
+		if seen[key] == 0 {
+			t.Errorf("key %s not returned", key)
+		}
+	}
+}
@@ -1,86 +1,148 @@
 package dict
 
+import "math/rand"
+
+// SimpleDict 非并发安全的hash表，使用链地址法解决冲突
+// 不直接使用map是为了能按桶的反向二进制游标增量遍历，见Scan
 type SimpleDict struct {
-	m map[string]any
+	table [][]entry
+	count int
+}
+
+type entry struct {
+	key string
+	val any
 }
 
+// minTableSize 桶数量的最小值，桶数量始终是2的幂
+const minTableSize = 4
+
 func NewSimpleDict() *SimpleDict {
-	return &SimpleDict{m: make(map[string]any)}
+	return &SimpleDict{table: make([][]entry, minTableSize)}
+}
+
+// find 返回key所在的桶以及在桶中的下标，不存在时下标为-1
+func (s *SimpleDict) find(key string) (bucket int, idx int) {
+	bucket = int(fnv32(key) & uint32(len(s.table)-1))
+	for i, e := range s.table[bucket] {
+		if e.key == key {
+			return bucket, i
+		}
+	}
+	return bucket, -1
+}
+
+// resize 将所有元素重新分配到size个桶中
+func (s *SimpleDict) resize(size int) {
+	table := make([][]entry, size)
+	mask := uint32(size - 1)
+	for _, b := range s.table {
+		for _, e := range b {
+			i := fnv32(e.key) & mask
+			table[i] = append(table[i], e)
+		}
+	}
+	s.table = table
+}
+
+// insert 在桶中追加新的key，元素数量超过桶数量时扩容为2倍
+func (s *SimpleDict) insert(bucket int, key string, val any) {
+	s.table[bucket] = append(s.table[bucket], entry{key: key, val: val})
+	s.count++
+	if s.count > len(s.table) {
+		s.resize(len(s.table) * 2)
+	}
 }
 
 func (s *SimpleDict) Get(key string) (val any, exists bool) {
-	v, ok := s.m[key]
-	return v, ok
+	b, i := s.find(key)
+	if i < 0 {
+		return nil, false
+	}
+	return s.table[b][i].val, true
 }
 
 func (s *SimpleDict) Exists(key string) bool {
-	_, ok := s.m[key]
-	return ok
+	_, i := s.find(key)
+	return i >= 0
 }
 
 func (s *SimpleDict) Len() int {
-	if s.m == nil {
-		panic("map is nil")
+	if s.table == nil {
+		panic("dict is nil")
 	}
-	return len(s.m)
+	return s.count
 }
 
 // Put 将key value存入map，如果key已存在，则更新；返回新建kv的数量
 func (s *SimpleDict) Put(key string, val any) (result int) {
-	_, existed := s.m[key]
-	s.m[key] = val
-	if existed {
+	b, i := s.find(key)
+	if i >= 0 {
+		s.table[b][i].val = val
 		return 0
 	}
+	s.insert(b, key, val)
 	return 1
 }
 
 // PutIfAbsent 在key不存在的情况下，才贵存入value，并返回更新key-value键值对数量
 func (s *SimpleDict) PutIfAbsent(key string, val any) (result int) {
-	_, existed := s.m[key]
-	if existed {
+	b, i := s.find(key)
+	if i >= 0 {
 		return 0
 	}
-	s.m[key] = val
+	s.insert(b, key, val)
 	return 1
 }
 
 // PutIfExists 在key存在的情况下，更新value，并返回更新数量
 func (s *SimpleDict) PutIfExists(key string, val any) (result int) {
-	_, existed := s.m[key]
-	if !existed {
+	b, i := s.find(key)
+	if i < 0 {
 		return 0
 	}
-	s.m[key] = val
+	s.table[b][i].val = val
 	return 1
 }
 
 // Remove 移除key-value键值对，并返回被删除的value以及删除数量
+// 元素数量少于桶数量的1/8时缩容为一半
 func (s *SimpleDict) Remove(key string) (val any, result int) {
-	val, existed := s.m[key]
-	delete(s.m, key)
-	if existed {
-		return val, 1
+	b, i := s.find(key)
+	if i < 0 {
+		return nil, 0
+	}
+	bucket := s.table[b]
+	val = bucket[i].val
+	last := len(bucket) - 1
+	bucket[i] = bucket[last]
+	bucket[last] = entry{}
+	s.table[b] = bucket[:last]
+	s.count--
+	if len(s.table) > minTableSize && s.count < len(s.table)/8 {
+		s.resize(len(s.table) / 2)
 	}
-	return nil, 0
+	return val, 1
 }
 
 // ForEach 遍历map，如果consumer返回false，终止遍历
 func (s *SimpleDict) ForEach(consumer Consumer) {
-	for k, v := range s.m {
-		if !consumer(k, v) {
-			break
+	for _, b := range s.table {
+		for _, e := range b {
+			if !consumer(e.key, e.val) {
+				return
+			}
 		}
 	}
 }
 
 // Keys 返回所有key
 func (s *SimpleDict) Keys() []string {
-	result := make([]string, len(s.m))
-	idx := 0
-	for k := range s.m {
-		result[idx] = k
-		idx++
+	result := make([]string, 0, s.count)
+	for _, b := range s.table {
+		for _, e := range b {
+			result = append(result, e.key)
+		}
 	}
 	return result
 }
@@ -88,29 +150,36 @@ func (s *SimpleDict) Keys() []string {
 // RandomKeys 随机返回给定数量的key，因为可以重复，所以limit可以比len(dict)大，无非就是有多个重复的key
 func (s *SimpleDict) RandomKeys(limit int) []string {
 	result := make([]string, limit)
+	if s.count == 0 {
+		return result
+	}
 	for i := 0; i < limit; i++ {
-		for k := range s.m {
-			result[i] = k
-			break
+		// 元素数量不少于桶数量的1/8，随机选到非空桶的期望次数不超过8次
+		b := s.table[rand.Intn(len(s.table))]
+		for len(b) == 0 {
+			b = s.table[rand.Intn(len(s.table))]
 		}
+		result[i] = b[rand.Intn(len(b))].key
 	}
 	return result
 }
 
 // RandomDistinctKeys 随机返回给定数量的无重复key，所以limit要比len(dict)小于或等于
+// 从随机的桶开始依次取出key
 func (s *SimpleDict) RandomDistinctKeys(limit int) []string {
 	size := limit
-	if size > len(s.m) {
-		size = len(s.m)
-	}
-	result := make([]string, size)
-	i := 0
-	for k := range s.m {
-		if i == size {
-			break
+	if size > s.count {
+		size = s.count
+	}
+	result := make([]string, 0, size)
+	start := rand.Intn(len(s.table))
+	for i := 0; len(result) < size; i++ {
+		for _, e := range s.table[(start+i)%len(s.table)] {
+			if len(result) == size {
+				break
+			}
+			result = append(result, e.key)
 		}
-		result[i] = k
-		i++
 	}
 	return result
 }
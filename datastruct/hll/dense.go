@@ -0,0 +1,62 @@
+package hll
+
+// 稠密编码中寄存器从低位开始存放，第i个寄存器从第i*6个bit开始，可能跨越两个字节
+// 与bitmap.BitMap从高位开始的bit顺序不同，因此单独实现读写
+
+// denseGet 读取第index个寄存器
+func denseGet(regs []byte, index int) uint8 {
+	b := index * regBits / 8
+	fb := uint(index * regBits & 7)
+	v := regs[b] >> fb
+	if b+1 < len(regs) {
+		v |= regs[b+1] << (8 - fb)
+	}
+	return v & regMax
+}
+
+// denseSet 当count大于原值时更新第index个寄存器，返回是否更新
+func denseSet(regs []byte, index int, count uint8) bool {
+	if count <= denseGet(regs, index) {
+		return false
+	}
+	b := index * regBits / 8
+	fb := uint(index * regBits & 7)
+	regs[b] &^= byte(regMax) << fb
+	regs[b] |= count << fb
+	if b+1 < len(regs) {
+		regs[b+1] &^= byte(regMax) >> (8 - fb)
+		regs[b+1] |= count >> (8 - fb)
+	}
+	return true
+}
+
+// murmurHash64A 与Redis相同的64位MurmurHash2
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+
+	n := len(key) - len(key)&7
+	for i := 0; i < n; i += 8 {
+		k := uint64(key[i]) | uint64(key[i+1])<<8 | uint64(key[i+2])<<16 | uint64(key[i+3])<<24 |
+			uint64(key[i+4])<<32 | uint64(key[i+5])<<40 | uint64(key[i+6])<<48 | uint64(key[i+7])<<56
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	tail := key[n:]
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
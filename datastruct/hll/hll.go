@@ -0,0 +1,260 @@
+// Package hll 实现了与Redis格式完全相同的HyperLogLog，序列化结果可以直接作为字符串保存
+//
+// 格式为16字节的头部加寄存器数据：
+// 头部依次为魔数"HYLL"、1字节的编码、3字节保留、8字节小端序的基数缓存，缓存最高位为1表示缓存失效
+// 稠密编码使用16384个6bit的寄存器，稀疏编码使用ZERO、XZERO、VAL三种操作码表示连续的寄存器
+package hll
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// P 寄存器索引的位数
+	P = 14
+	// Registers 寄存器数量
+	Registers = 1 << P
+	// q 哈希值中用于计算连续0个数的位数
+	q = 64 - P
+
+	regBits    = 6
+	regMax     = 1<<regBits - 1
+	headerSize = 16
+	denseSize  = headerSize + (Registers*regBits+7)/8
+
+	encodingDense  = 0
+	encodingSparse = 1
+
+	alphaInf = 0.721347520444481703680
+)
+
+// ErrCorrupted 寄存器数据不完整或超出范围
+var ErrCorrupted = errors.New("corrupted hll object")
+
+// HLL 以Redis格式保存的HyperLogLog
+type HLL struct {
+	data []byte
+	// 稀疏编码的最大字节数（包括头部），超过时转换为稠密编码
+	sparseMaxBytes int
+}
+
+// New 创建一个空的HyperLogLog，使用稀疏编码
+func New(sparseMaxBytes int) *HLL {
+	data := make([]byte, headerSize, headerSize+2)
+	copy(data, "HYLL")
+	data[4] = encodingSparse
+	data = appendXZero(data, Registers)
+	return &HLL{data: data, sparseMaxBytes: sparseMaxBytes}
+}
+
+// Load 使用data作为HyperLogLog，data不会被复制，修改会直接作用在data上
+// 头部不合法或稠密编码的长度不正确时返回false
+func Load(data []byte, sparseMaxBytes int) (*HLL, bool) {
+	if len(data) < headerSize || string(data[:4]) != "HYLL" || data[4] > encodingSparse {
+		return nil, false
+	}
+	if data[4] == encodingDense && len(data) != denseSize {
+		return nil, false
+	}
+	return &HLL{data: data, sparseMaxBytes: sparseMaxBytes}, true
+}
+
+// Bytes 返回序列化后的数据，修改后需要重新获取
+func (h *HLL) Bytes() []byte {
+	return h.data
+}
+
+// IsDense 是否使用稠密编码
+func (h *HLL) IsDense() bool {
+	return h.data[4] == encodingDense
+}
+
+// Add 添加元素，返回是否有寄存器被修改，寄存器被修改时基数缓存失效
+func (h *HLL) Add(element []byte) (bool, error) {
+	index, count := patLen(element)
+	changed, err := h.set(index, count)
+	if changed {
+		h.invalidateCache()
+	}
+	return changed, err
+}
+
+// set 将寄存器更新为count，count不大于原值时不修改
+func (h *HLL) set(index int, count uint8) (bool, error) {
+	if h.IsDense() {
+		return denseSet(h.data[headerSize:], index, count), nil
+	}
+	return h.sparseSet(index, count)
+}
+
+// Count 返回估算的基数，基数缓存有效时直接返回缓存
+func (h *HLL) Count() (uint64, error) {
+	if !h.cacheInvalid() {
+		var card uint64
+		for i := 7; i >= 0; i-- {
+			card = card<<8 | uint64(h.data[8+i])
+		}
+		return card, nil
+	}
+	var histo [64]int
+	if h.IsDense() {
+		regs := h.data[headerSize:]
+		for i := 0; i < Registers; i++ {
+			histo[denseGet(regs, i)]++
+		}
+	} else {
+		err := h.forEachRun(func(index, length int, value uint8) {
+			histo[value] += length
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return estimate(&histo), nil
+}
+
+// SetCachedCount 保存基数缓存
+func (h *HLL) SetCachedCount(card uint64) {
+	for i := 0; i < 8; i++ {
+		h.data[8+i] = byte(card >> (8 * i))
+	}
+}
+
+func (h *HLL) cacheInvalid() bool {
+	return h.data[15]&0x80 != 0
+}
+
+func (h *HLL) invalidateCache() {
+	h.data[15] |= 0x80
+}
+
+// MergeTo 将寄存器合并到regs中，regs中每个寄存器取两者的最大值
+func (h *HLL) MergeTo(regs []uint8) error {
+	if h.IsDense() {
+		data := h.data[headerSize:]
+		for i := 0; i < Registers; i++ {
+			if v := denseGet(data, i); v > regs[i] {
+				regs[i] = v
+			}
+		}
+		return nil
+	}
+	return h.forEachRun(func(index, length int, value uint8) {
+		if value == 0 {
+			return
+		}
+		for i := index; i < index+length; i++ {
+			if value > regs[i] {
+				regs[i] = value
+			}
+		}
+	})
+}
+
+// SetRegisters 将每个寄存器更新为它与regs中对应值的较大者，基数缓存失效
+func (h *HLL) SetRegisters(regs []uint8) error {
+	for i, v := range regs {
+		if v == 0 {
+			continue
+		}
+		if _, err := h.set(i, v); err != nil {
+			return err
+		}
+	}
+	h.invalidateCache()
+	return nil
+}
+
+// ToDense 转换为稠密编码，已经是稠密编码时不做处理
+func (h *HLL) ToDense() error {
+	if h.IsDense() {
+		return nil
+	}
+	data := make([]byte, denseSize)
+	copy(data, h.data[:headerSize])
+	data[4] = encodingDense
+	regs := data[headerSize:]
+	err := h.forEachRun(func(index, length int, value uint8) {
+		if value == 0 {
+			return
+		}
+		for i := index; i < index+length; i++ {
+			denseSet(regs, i, value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	h.data = data
+	return nil
+}
+
+// CountRegisters 根据寄存器数组估算基数，用于多个HyperLogLog合并后的计数
+func CountRegisters(regs []uint8) uint64 {
+	var histo [64]int
+	for _, v := range regs {
+		histo[v]++
+	}
+	return estimate(&histo)
+}
+
+// patLen 计算元素对应的寄存器索引，以及哈希值剩余部分从低位开始第一个1的位置
+func patLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & (Registers - 1))
+	hash >>= P
+	hash |= 1 << q
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// estimate 根据寄存器值的分布估算基数，使用与Redis相同的改进估算算法
+// 见 Otmar Ertl, New cardinality estimation algorithms for HyperLogLog sketches
+func estimate(histo *[64]int) uint64 {
+	m := float64(Registers)
+	z := m * tau((m-float64(histo[q+1]))/m)
+	for j := q; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histo[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
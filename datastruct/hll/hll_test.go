@@ -0,0 +1,139 @@
+package hll
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	h := New(3000)
+	expected := []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff")
+	if !bytes.Equal(h.Bytes(), expected) {
+		t.Errorf("expected %q, actual %q", expected, h.Bytes())
+	}
+	if n, err := h.Count(); err != nil || n != 0 {
+		t.Errorf("expected 0, actual %d %v", n, err)
+	}
+}
+
+func TestDenseRegisters(t *testing.T) {
+	regs := make([]byte, denseSize-headerSize)
+	for i := 0; i < Registers; i++ {
+		denseSet(regs, i, uint8(i%63+1))
+	}
+	for i := 0; i < Registers; i++ {
+		if v := denseGet(regs, i); v != uint8(i%63+1) {
+			t.Fatalf("register %d: expected %d, actual %d", i, i%63+1, v)
+		}
+	}
+	if denseSet(regs, 0, 1) {
+		t.Error("smaller value should not be set")
+	}
+}
+
+func TestAddAndCount(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		h := New(3000)
+		for i := 0; i < n; i++ {
+			if _, err := h.Add([]byte("element:" + strconv.Itoa(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		count, err := h.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := math.Abs(float64(count)-float64(n)) / float64(n); e > 0.02 {
+			t.Errorf("added %d elements, count %d", n, count)
+		}
+		if n >= 100000 && !h.IsDense() {
+			t.Errorf("expected dense encoding")
+		}
+	}
+}
+
+func TestSparseAndDenseAgree(t *testing.T) {
+	sparse := New(1 << 20)
+	dense := New(0)
+	if err := dense.ToDense(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5000; i++ {
+		element := []byte(strconv.Itoa(i))
+		c1, err1 := sparse.Add(element)
+		c2, err2 := dense.Add(element)
+		if err1 != nil || err2 != nil || c1 != c2 {
+			t.Fatalf("add %d: sparse %v %v, dense %v %v", i, c1, err1, c2, err2)
+		}
+	}
+	if sparse.IsDense() {
+		t.Fatal("expected sparse encoding")
+	}
+	n1, _ := sparse.Count()
+	n2, _ := dense.Count()
+	if n1 != n2 {
+		t.Errorf("sparse count %d, dense count %d", n1, n2)
+	}
+	if err := sparse.ToDense(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sparse.Bytes(), dense.Bytes()) {
+		t.Error("converted sparse hll differs from dense hll")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(3000), New(3000)
+	for i := 0; i < 3000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 2000)))
+	}
+	regs := make([]uint8, Registers)
+	if err := a.MergeTo(regs); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MergeTo(regs); err != nil {
+		t.Fatal(err)
+	}
+	merged := New(3000)
+	if err := merged.SetRegisters(regs); err != nil {
+		t.Fatal(err)
+	}
+	count, _ := merged.Count()
+	if count != CountRegisters(regs) {
+		t.Errorf("expected %d, actual %d", CountRegisters(regs), count)
+	}
+	if e := math.Abs(float64(count)-5000) / 5000; e > 0.02 {
+		t.Errorf("expected about 5000, actual %d", count)
+	}
+}
+
+func TestCache(t *testing.T) {
+	h := New(3000)
+	h.Add([]byte("a"))
+	if !h.cacheInvalid() {
+		t.Fatal("cache should be invalid after add")
+	}
+	h.SetCachedCount(42)
+	if n, _ := h.Count(); n != 42 {
+		t.Errorf("expected cached count 42, actual %d", n)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if _, ok := Load([]byte("HYLL"), 3000); ok {
+		t.Error("short data should be invalid")
+	}
+	if _, ok := Load([]byte("HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), 3000); ok {
+		t.Error("dense data with wrong length should be invalid")
+	}
+	h, ok := Load([]byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe"), 3000)
+	if !ok {
+		t.Fatal("expected valid header")
+	}
+	if _, err := h.Count(); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted, actual %v", err)
+	}
+}
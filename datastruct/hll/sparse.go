@@ -0,0 +1,226 @@
+package hll
+
+// 稀疏编码的操作码：
+// ZERO  00xxxxxx          连续xxxxxx+1个值为0的寄存器，最多64个
+// XZERO 01xxxxxx yyyyyyyy 连续xxxxxxyyyyyyyy+1个值为0的寄存器，最多16384个
+// VAL   1vvvvvxx          连续xx+1个值为vvvvv+1的寄存器，最多4个，值最大为32
+const (
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+	sparseValMaxValue = 32
+	sparseValMaxLen   = 4
+)
+
+func isZero(op byte) bool {
+	return op&0xc0 == 0
+}
+
+func isXZero(op byte) bool {
+	return op&0xc0 == 0x40
+}
+
+func isVal(op byte) bool {
+	return op&0x80 != 0
+}
+
+func zeroLen(op byte) int {
+	return int(op&0x3f) + 1
+}
+
+func xzeroLen(op, next byte) int {
+	return (int(op&0x3f)<<8 | int(next)) + 1
+}
+
+func valValue(op byte) uint8 {
+	return (op>>2)&0x1f + 1
+}
+
+func valLen(op byte) int {
+	return int(op&0x3) + 1
+}
+
+func valOp(value uint8, length int) byte {
+	return (value-1)<<2 | byte(length-1) | 0x80
+}
+
+func appendXZero(buf []byte, length int) []byte {
+	length--
+	return append(buf, byte(length>>8)|0x40, byte(length&0xff))
+}
+
+// appendZero 用一个ZERO或XZERO操作码表示连续length个为0的寄存器
+func appendZero(buf []byte, length int) []byte {
+	if length > sparseZeroMaxLen {
+		return appendXZero(buf, length)
+	}
+	return append(buf, byte(length-1))
+}
+
+// forEachRun 按顺序遍历稀疏编码中的每个操作码，index为第一个寄存器的索引，length为寄存器数量
+// 操作码不完整或覆盖的寄存器数量不等于Registers时返回ErrCorrupted
+func (h *HLL) forEachRun(consumer func(index, length int, value uint8)) error {
+	data := h.data
+	index := 0
+	for p := headerSize; p < len(data); {
+		op := data[p]
+		switch {
+		case isZero(op):
+			consumer(index, zeroLen(op), 0)
+			index += zeroLen(op)
+			p++
+		case isXZero(op):
+			if p+1 >= len(data) {
+				return ErrCorrupted
+			}
+			length := xzeroLen(op, data[p+1])
+			consumer(index, length, 0)
+			index += length
+			p += 2
+		default:
+			length := valLen(op)
+			if index+length > Registers {
+				return ErrCorrupted
+			}
+			consumer(index, length, valValue(op))
+			index += length
+			p++
+		}
+		if index > Registers {
+			return ErrCorrupted
+		}
+	}
+	if index != Registers {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// sparseSet 当count大于原值时更新第index个寄存器，返回是否更新
+// 与Redis的修改方式相同：拆分覆盖该寄存器的操作码，再合并附近值相同的VAL，因此相同的操作得到的数据完全一致
+// count超过VAL能表示的最大值或者数据长度超过sparseMaxBytes时转换为稠密编码
+func (h *HLL) sparseSet(index int, count uint8) (bool, error) {
+	if count > sparseValMaxValue {
+		return h.promote(index, count)
+	}
+
+	// 找到覆盖第index个寄存器的操作码p，prev为前一个操作码，first为p覆盖的第一个寄存器
+	data := h.data
+	p, prev, first, span := headerSize, -1, 0, 0
+	for p < len(data) {
+		oplen := 1
+		op := data[p]
+		if isZero(op) {
+			span = zeroLen(op)
+		} else if isVal(op) {
+			span = valLen(op)
+		} else {
+			if p+1 >= len(data) {
+				return false, ErrCorrupted
+			}
+			span = xzeroLen(op, data[p+1])
+			oplen = 2
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += oplen
+		first += span
+	}
+	if span == 0 || p >= len(data) {
+		return false, ErrCorrupted
+	}
+
+	op := data[p]
+	if isVal(op) {
+		if valValue(op) >= count {
+			return false, nil
+		}
+		if valLen(op) == 1 {
+			data[p] = valOp(count, 1)
+			h.mergeVals(prev)
+			return true, nil
+		}
+	} else if isZero(op) && zeroLen(op) == 1 {
+		data[p] = valOp(count, 1)
+		h.mergeVals(prev)
+		return true, nil
+	}
+
+	// 将原操作码拆分为最多三段，最长的情况是XZERO-VAL-XZERO共5个字节
+	last := first + span - 1
+	seq := make([]byte, 0, 5)
+	if isVal(op) {
+		value := valValue(op)
+		if index != first {
+			seq = append(seq, valOp(value, index-first))
+		}
+		seq = append(seq, valOp(count, 1))
+		if index != last {
+			seq = append(seq, valOp(value, last-index))
+		}
+	} else {
+		if index != first {
+			seq = appendZero(seq, index-first)
+		}
+		seq = append(seq, valOp(count, 1))
+		if index != last {
+			seq = appendZero(seq, last-index)
+		}
+	}
+
+	oldLen := 1
+	if isXZero(op) {
+		oldLen = 2
+	}
+	if delta := len(seq) - oldLen; delta > 0 && len(data)+delta > h.sparseMaxBytes {
+		return h.promote(index, count)
+	}
+	newData := make([]byte, 0, len(data)+len(seq)-oldLen)
+	newData = append(newData, data[:p]...)
+	newData = append(newData, seq...)
+	newData = append(newData, data[p+oldLen:]...)
+	h.data = newData
+	h.mergeVals(prev)
+	return true, nil
+}
+
+// mergeVals 从start开始最多检查5个操作码，合并相邻的值相同的VAL，start为-1时从头开始
+func (h *HLL) mergeVals(start int) {
+	p := start
+	if p < 0 {
+		p = headerSize
+	}
+	for scan := 5; p < len(h.data) && scan > 0; {
+		scan--
+		op := h.data[p]
+		if isXZero(op) {
+			p += 2
+			continue
+		}
+		if isZero(op) {
+			p++
+			continue
+		}
+		if p+1 < len(h.data) && isVal(h.data[p+1]) {
+			next := h.data[p+1]
+			if value := valValue(op); value == valValue(next) {
+				if length := valLen(op) + valLen(next); length <= sparseValMaxLen {
+					h.data[p+1] = valOp(value, length)
+					h.data = append(h.data[:p], h.data[p+1:]...)
+					// 不移动p，继续尝试与右边的VAL合并
+					continue
+				}
+			}
+		}
+		p++
+	}
+}
+
+// promote 转换为稠密编码后更新寄存器
+func (h *HLL) promote(index int, count uint8) (bool, error) {
+	if err := h.ToDense(); err != nil {
+		return false, err
+	}
+	return denseSet(h.data[headerSize:], index, count), nil
+}
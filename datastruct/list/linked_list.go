@@ -116,7 +116,7 @@ func (l *LinkedList) Insert(index int, val []byte) int {
 		next: n,
 	}
 	n.prev.next = newNode
-	n.prev = n
+	n.prev = newNode
 	l.length++
 	return 1
 }
@@ -196,9 +196,9 @@ func (l *LinkedList) RemoveByValFromHead(val []byte, count int) int {
 		if string(cur.val) == string(val) {
 			l.removeNode(cur)
 			deletedCount++
+			cur.next = nil
+			cur.prev = nil
 		}
-		cur.next = nil
-		cur.prev = nil
 		cur = nex
 	}
 	return deletedCount
@@ -212,9 +212,9 @@ func (l *LinkedList) RemoveByValFromTail(val []byte, count int) int {
 		if string(cur.val) == string(val) {
 			l.removeNode(cur)
 			deletedCount++
+			cur.next = nil
+			cur.prev = nil
 		}
-		cur.next = nil
-		cur.prev = nil
 		cur = pre
 	}
 	return deletedCount
@@ -228,9 +228,9 @@ func (l *LinkedList) RemoveAllByVal(val []byte) int {
 		if string(cur.val) == string(val) {
 			l.removeNode(cur)
 			deletedCount++
+			cur.next = nil
+			cur.prev = nil
 		}
-		cur.next = nil
-		cur.prev = nil
 		cur = nex
 	}
 	return deletedCount
@@ -2,12 +2,14 @@ package list
 
 import (
 	"fmt"
+	"math/rand"
+	"strings"
 	"testing"
 )
 
 func TestConvertByteArray(t *testing.T) {
 	var num int64 = 1000000
-	byteArray := convertToByteArray(int64(uint64(num)))
+	byteArray := convertToByteArray(uint64(num))
 	for _, b := range byteArray {
 		fmt.Printf("%08b ", b)
 	}
@@ -21,7 +23,7 @@ func TestGenerateEntry(t *testing.T) {
 }
 
 func TestLinkedList(t *testing.T) {
-	l := NewEmptyLinkedList()
+	l := NewEmptyList()
 	l.AddLast([]byte("3"))
 	l.AddLast([]byte("4"))
 	l.AddLast([]byte("5"))
@@ -55,3 +57,134 @@ func TestToInt(t *testing.T) {
 
 	fmt.Printf("%v", toInt([]byte{0xF0, 0x0F}))
 }
+
+func assertSameList(t *testing.T, expected List, actual List) {
+	if expected.Length() != actual.Length() {
+		t.Fatalf("length mismatch, expected %d, actual %d", expected.Length(), actual.Length())
+	}
+	expected.ForEach(func(index int, v []byte) bool {
+		if string(actual.Get(index)) != string(v) {
+			t.Fatalf("value mismatch at %d, expected %s, actual %s", index, v, actual.Get(index))
+		}
+		return true
+	})
+}
+
+func TestZipList(t *testing.T) {
+	values := [][]byte{
+		[]byte("a"), []byte("0"), []byte("12"), []byte("13"), []byte("-1"), []byte("127"), []byte("-128"),
+		[]byte("32767"), []byte("-32768"), []byte("8388607"), []byte("-8388608"), []byte("2147483647"),
+		[]byte("-2147483648"), []byte("9223372036854775807"), []byte("-9223372036854775808"),
+		[]byte("007"), []byte("+1"), []byte(""), []byte(strings.Repeat("x", 63)), []byte(strings.Repeat("y", 300)),
+		[]byte(strings.Repeat("z", 20000)),
+	}
+	z := NewZipList(values)
+	l := NewList(values)
+	assertSameList(t, l, z)
+	if string(z.First()) != "a" || string(z.Last()) != strings.Repeat("z", 20000) {
+		t.Fatal("first or last mismatch")
+	}
+
+	assertRandomOps(t, l, z, values, 5000, nil)
+}
+
+// assertRandomOps 对两个列表执行相同的随机操作，每次操作后检查两个列表是否相同
+func assertRandomOps(t *testing.T, expected List, actual List, values [][]byte, times int, check func()) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < times; i++ {
+		val := values[r.Intn(len(values))]
+		length := expected.Length()
+		switch r.Intn(9) {
+		case 0:
+			expected.AddFirst(val)
+			actual.AddFirst(val)
+		case 1:
+			expected.AddLast(val)
+			actual.AddLast(val)
+		case 2:
+			index := r.Intn(length + 2)
+			if expected.Insert(index, val) != actual.Insert(index, val) {
+				t.Fatal("insert result mismatch")
+			}
+		case 3:
+			index := r.Intn(length + 1)
+			if expected.Set(index, val) != actual.Set(index, val) {
+				t.Fatal("set result mismatch")
+			}
+		case 4:
+			index := r.Intn(length + 1)
+			if string(expected.Remove(index)) != string(actual.Remove(index)) {
+				t.Fatal("remove result mismatch")
+			}
+		case 5:
+			if string(expected.RemoveFirst()) != string(actual.RemoveFirst()) || string(expected.RemoveLast()) != string(actual.RemoveLast()) {
+				t.Fatal("remove first or last mismatch")
+			}
+		case 6:
+			if expected.RemoveByValFromHead(val, 2) != actual.RemoveByValFromHead(val, 2) {
+				t.Fatal("remove from head mismatch")
+			}
+		case 7:
+			if expected.RemoveByValFromTail(val, 2) != actual.RemoveByValFromTail(val, 2) {
+				t.Fatal("remove from tail mismatch")
+			}
+		case 8:
+			if expected.RemoveAllByVal(val) != actual.RemoveAllByVal(val) {
+				t.Fatal("remove all mismatch")
+			}
+		}
+		assertSameList(t, expected, actual)
+		if string(expected.Last()) != string(actual.Last()) {
+			t.Fatal("last mismatch")
+		}
+		if check != nil {
+			check()
+		}
+	}
+}
+
+func TestQuickList(t *testing.T) {
+	values := [][]byte{
+		[]byte("a"), []byte("b"), []byte("1"), []byte("-100000"), []byte("999999999999"),
+		[]byte(strings.Repeat("abc", 30)), []byte(strings.Repeat("d", 1000)), []byte(strings.Repeat("e", 20000)),
+	}
+	configs := []struct {
+		fill          int
+		compressDepth int
+	}{
+		{fill: 1}, {fill: 4}, {fill: 4, compressDepth: 1}, {fill: -1, compressDepth: 2}, {fill: -2},
+	}
+	for _, cfg := range configs {
+		initial := make([][]byte, 0, 2000)
+		for i := 0; i < 2000; i++ {
+			initial = append(initial, values[i%len(values)])
+		}
+		l := NewList(initial)
+		q := NewQuickList(initial, cfg.fill, cfg.compressDepth)
+		assertSameList(t, l, q)
+		assertRandomOps(t, l, q, values, 3000, func() {
+			checkQuickList(t, q)
+		})
+	}
+}
+
+// checkQuickList 检查节点元素数量、节点数量，以及两端节点没有被压缩
+func checkQuickList(t *testing.T, q *QuickList) {
+	length, nodeCount := 0, 0
+	for n := q.head; n != nil; n = n.next {
+		if n.count == 0 || n.count != n.getZipList().Length() {
+			t.Fatalf("invalid node count %d", n.count)
+		}
+		if (n.zl == nil) == (n.compressed == nil) {
+			t.Fatal("node should be either compressed or not")
+		}
+		if n.zl == nil && q.inCompressWindow(n) {
+			t.Fatal("node in compress window should not be compressed")
+		}
+		length += n.count
+		nodeCount++
+	}
+	if length != q.length || nodeCount != q.nodeCount {
+		t.Fatalf("length or node count mismatch, %d %d", length, nodeCount)
+	}
+}
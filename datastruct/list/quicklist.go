@@ -0,0 +1,435 @@
+package list
+
+import (
+	"zedis/lib/lzf"
+)
+
+/*
+快速列表，参考Redis的quicklist实现
+快速列表是由ziplist组成的双向链表，每个节点保存一个ziplist，节点大小由fill限制：
+fill为正数时，表示每个节点最多保存的元素个数
+fill为-1 ~ -5时，表示每个节点ziplist最多占用的字节数，分别为4KB、8KB、16KB、32KB、64KB
+
+与每个元素一个节点的LinkedList相比，节点数量少了很多，内存开销更小，根据索引查找时也可以按节点跳过元素
+compressDepth表示两端不压缩的节点数量，其余的中间节点使用LZF压缩，为0时不压缩
+*/
+
+var quickListSizeLimits = [...]int{4096, 8192, 16384, 32768, 65536}
+
+const (
+	// 节点小于该字节数时不压缩
+	minCompressBytes = 48
+	// 压缩后至少要节省的字节数，否则不压缩
+	minCompressImprove = 8
+	// fill为正数时，超过该字节数的节点也不再插入新元素，避免单个节点过大
+	sizeSafetyLimit = 8192
+	// 估算新元素插入ziplist后增加的字节数时，previous_entry_length和encoding最多占用的字节数
+	entryOverhead = 5 + 5
+)
+
+type QuickList struct {
+	head          *quickListNode
+	tail          *quickListNode
+	length        int // 元素总数
+	nodeCount     int // 节点数量
+	fill          int
+	compressDepth int
+}
+
+type quickListNode struct {
+	prev *quickListNode
+	next *quickListNode
+	// 节点被压缩时为nil
+	zl *ZipList
+	// 压缩后的ziplist数据，未压缩时为nil
+	compressed []byte
+	// 压缩前ziplist的字节数
+	rawSize int
+	// 节点中的元素个数，节点被压缩时也可以直接得到
+	count int
+}
+
+func NewQuickList(values [][]byte, fill int, compressDepth int) *QuickList {
+	if fill == 0 || fill < -len(quickListSizeLimits) {
+		fill = -2
+	}
+	if compressDepth < 0 {
+		compressDepth = 0
+	}
+	q := &QuickList{
+		fill:          fill,
+		compressDepth: compressDepth,
+	}
+	for _, value := range values {
+		q.AddLast(value)
+	}
+	return q
+}
+
+// getZipList 返回节点的ziplist，节点被压缩时解压后返回临时的ziplist，不改变节点的压缩状态，用于只读操作
+func (n *quickListNode) getZipList() *ZipList {
+	if n.zl != nil {
+		return n.zl
+	}
+	data, err := lzf.Decompress(n.compressed, n.rawSize)
+	if err != nil {
+		panic(err)
+	}
+	return &ZipList{data: data}
+}
+
+// decompress 解压节点，修改节点前调用
+func (n *quickListNode) decompress() {
+	if n.zl != nil {
+		return
+	}
+	n.zl = n.getZipList()
+	n.compressed = nil
+	n.rawSize = 0
+}
+
+// compress 压缩节点，节点较小或压缩后节省的空间太少时不压缩
+func (n *quickListNode) compress() {
+	if n.zl == nil || len(n.zl.data) < minCompressBytes {
+		return
+	}
+	compressed := lzf.Compress(n.zl.data)
+	if compressed == nil || len(compressed)+minCompressImprove > len(n.zl.data) {
+		return
+	}
+	n.compressed = compressed
+	n.rawSize = len(n.zl.data)
+	n.zl = nil
+}
+
+// allowInsert 判断节点能否再插入val
+func (q *QuickList) allowInsert(n *quickListNode, val []byte) bool {
+	if n == nil {
+		return false
+	}
+	n.decompress()
+	newSize := len(n.zl.data) + len(val) + entryOverhead
+	if q.fill > 0 {
+		return n.count < q.fill && newSize <= sizeSafetyLimit
+	}
+	return newSize <= quickListSizeLimits[-q.fill-1]
+}
+
+// inCompressWindow 判断节点是否在两端compressDepth个节点之内，这些节点不压缩
+func (q *QuickList) inCompressWindow(n *quickListNode) bool {
+	forward, backward := n, n
+	for i := 0; i < q.compressDepth; i++ {
+		if forward == nil || backward == nil {
+			return true
+		}
+		forward = forward.prev
+		backward = backward.next
+	}
+	return forward == nil || backward == nil
+}
+
+// recompress 修改节点后调用，保证两端compressDepth个节点不压缩，中间的节点都被压缩
+// 每次修改最多只有一个节点进入或离开两端的范围，所以只需要处理两端范围内的节点、紧邻两端范围的节点以及被修改的节点
+func (q *QuickList) recompress(n *quickListNode) {
+	if q.compressDepth == 0 {
+		return
+	}
+	forward, backward := q.head, q.tail
+	for i := 0; i < q.compressDepth && forward != nil; i++ {
+		forward.decompress()
+		backward.decompress()
+		forward = forward.next
+		backward = backward.prev
+	}
+	if q.nodeCount > q.compressDepth*2 {
+		forward.compress()
+		backward.compress()
+	}
+	if n != nil && !q.inCompressWindow(n) {
+		n.compress()
+	}
+}
+
+func (q *QuickList) newNode(val []byte) *quickListNode {
+	return &quickListNode{
+		zl:    NewZipList([][]byte{val}),
+		count: 1,
+	}
+}
+
+// insertNodeAfter 在prev之后插入节点，prev为nil时插入到表头
+func (q *QuickList) insertNodeAfter(prev *quickListNode, n *quickListNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = q.head
+		q.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		q.tail = n
+	} else {
+		n.next.prev = n
+	}
+	q.nodeCount++
+}
+
+func (q *QuickList) removeNode(n *quickListNode) {
+	if n.prev == nil {
+		q.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		q.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev = nil
+	n.next = nil
+	q.nodeCount--
+}
+
+// splitNode 将节点从offset处拆分为两个节点，n保留offset之前的元素，返回保存剩余元素的新节点
+func (q *QuickList) splitNode(n *quickListNode, offset int) *quickListNode {
+	n.decompress()
+	values := make([][]byte, 0, n.count-offset)
+	for n.count > offset {
+		values = append(values, n.zl.Remove(offset))
+		n.count--
+	}
+	right := &quickListNode{
+		zl:    NewZipList(values),
+		count: len(values),
+	}
+	q.insertNodeAfter(n, right)
+	return right
+}
+
+// find 返回index所在的节点，以及元素在节点中的偏移量
+func (q *QuickList) find(index int) (*quickListNode, int) {
+	if index < 0 || index >= q.length {
+		return nil, 0
+	}
+	if index <= q.length/2 {
+		n := q.head
+		for index >= n.count {
+			index -= n.count
+			n = n.next
+		}
+		return n, index
+	}
+	n := q.tail
+	// 从表尾开始，元素在节点中从后往前数的位置
+	index = q.length - 1 - index
+	for index >= n.count {
+		index -= n.count
+		n = n.prev
+	}
+	return n, n.count - 1 - index
+}
+
+func (q *QuickList) AddFirst(val []byte) int {
+	if q.allowInsert(q.head, val) {
+		q.head.zl.AddFirst(val)
+		q.head.count++
+	} else {
+		q.insertNodeAfter(nil, q.newNode(val))
+	}
+	q.length++
+	q.recompress(q.head)
+	return 1
+}
+
+func (q *QuickList) AddLast(val []byte) int {
+	if q.allowInsert(q.tail, val) {
+		q.tail.zl.AddLast(val)
+		q.tail.count++
+	} else {
+		q.insertNodeAfter(q.tail, q.newNode(val))
+	}
+	q.length++
+	q.recompress(q.tail)
+	return 1
+}
+
+// Get index从0开始
+func (q *QuickList) Get(index int) (val []byte) {
+	n, offset := q.find(index)
+	if n == nil {
+		return nil
+	}
+	return n.getZipList().Get(offset)
+}
+
+func (q *QuickList) Set(index int, val []byte) int {
+	n, offset := q.find(index)
+	if n == nil {
+		return 0
+	}
+	n.decompress()
+	n.zl.Set(offset, val)
+	q.recompress(n)
+	return 1
+}
+
+func (q *QuickList) Insert(index int, val []byte) int {
+	if index == 0 {
+		return q.AddFirst(val)
+	} else if index == q.length {
+		return q.AddLast(val)
+	}
+	n, offset := q.find(index)
+	if n == nil {
+		return 0
+	}
+	var modified *quickListNode
+	if q.allowInsert(n, val) {
+		n.zl.Insert(offset, val)
+		n.count++
+		modified = n
+	} else if offset == 0 {
+		// 插入到节点的第一个元素之前，可以放到前一个节点的末尾
+		prev := n.prev
+		if q.allowInsert(prev, val) {
+			prev.zl.AddLast(val)
+			prev.count++
+			modified = prev
+		} else {
+			modified = q.newNode(val)
+			q.insertNodeAfter(prev, modified)
+		}
+		q.recompress(prev)
+		q.recompress(n)
+	} else {
+		// 节点已满，从插入位置拆分节点
+		right := q.splitNode(n, offset)
+		if q.allowInsert(n, val) {
+			n.zl.AddLast(val)
+			n.count++
+			modified = n
+		} else if q.allowInsert(right, val) {
+			right.zl.AddFirst(val)
+			right.count++
+			modified = right
+		} else {
+			modified = q.newNode(val)
+			q.insertNodeAfter(n, modified)
+		}
+		q.recompress(n)
+		q.recompress(right)
+	}
+	q.length++
+	q.recompress(modified)
+	return 1
+}
+
+func (q *QuickList) Remove(index int) (val []byte) {
+	n, offset := q.find(index)
+	if n == nil {
+		return nil
+	}
+	n.decompress()
+	val = n.zl.Remove(offset)
+	q.afterRemove(n, 1)
+	return val
+}
+
+// afterRemove 节点删除了deleted个元素后调用，节点为空时删除节点
+func (q *QuickList) afterRemove(n *quickListNode, deleted int) {
+	n.count -= deleted
+	q.length -= deleted
+	if n.count == 0 {
+		q.removeNode(n)
+		q.recompress(nil)
+	} else {
+		q.recompress(n)
+	}
+}
+
+func (q *QuickList) RemoveFirst() (val []byte) {
+	return q.Remove(0)
+}
+
+func (q *QuickList) RemoveLast() (val []byte) {
+	return q.Remove(q.length - 1)
+}
+
+func (q *QuickList) First() (val []byte) {
+	if q.length == 0 {
+		return nil
+	}
+	return q.head.getZipList().First()
+}
+
+func (q *QuickList) Last() (val []byte) {
+	if q.length == 0 {
+		return nil
+	}
+	return q.tail.getZipList().Last()
+}
+
+func (q *QuickList) Length() int {
+	return q.length
+}
+
+func (q *QuickList) ForEach(consumer Consumer) {
+	idx := 0
+	for n := q.head; n != nil; n = n.next {
+		stopped := false
+		n.getZipList().ForEach(func(_ int, v []byte) bool {
+			if !consumer(idx, v) {
+				stopped = true
+				return false
+			}
+			idx++
+			return true
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+func (q *QuickList) Contains(expected Expected) bool {
+	contain := false
+	q.ForEach(func(index int, v []byte) bool {
+		if expected(v) {
+			contain = true
+			return false
+		}
+		return true
+	})
+	return contain
+}
+
+func (q *QuickList) RemoveByValFromHead(val []byte, count int) int {
+	deletedCount := 0
+	for n := q.head; n != nil && deletedCount < count; {
+		next := n.next
+		n.decompress()
+		deleted := n.zl.RemoveByValFromHead(val, count-deletedCount)
+		deletedCount += deleted
+		q.afterRemove(n, deleted)
+		n = next
+	}
+	return deletedCount
+}
+
+func (q *QuickList) RemoveByValFromTail(val []byte, count int) int {
+	deletedCount := 0
+	for n := q.tail; n != nil && deletedCount < count; {
+		prev := n.prev
+		n.decompress()
+		deleted := n.zl.RemoveByValFromTail(val, count-deletedCount)
+		deletedCount += deleted
+		q.afterRemove(n, deleted)
+		n = prev
+	}
+	return deletedCount
+}
+
+func (q *QuickList) RemoveAllByVal(val []byte) int {
+	return q.RemoveByValFromHead(val, q.length)
+}
@@ -1,6 +1,8 @@
 package list
 
 import (
+	"bytes"
+	"encoding/binary"
 	"strconv"
 )
 
@@ -13,6 +15,9 @@ import (
 每个entry的格式为:
 如果是String类型: previous_entry_length | encoding | content
 如果是Int类型: previous_entry_length | encoding
+
+与Redis一致，zlBytes、zlTail、zlLen以及int类型的值都使用小端序，字符串长度使用大端序
+所有元素保存在一段连续的内存中，没有链表节点的指针开销，适合元素较少、元素较短的列表
 */
 
 /* ziplist各部分占用字节长度 */
@@ -21,6 +26,9 @@ const (
 	byteNumOfZlTail  = 4 // 记录了压缩列表尾结点距离压缩列表起始地址有多少字节，用4字节表示
 	byteNumOfZlLen   = 2 // 记录了压缩列表entry节点数量，数量小于uint16_max(65535)时，属性值即为压缩列表节点数量，等于该值时，需遍历列表才能得到真实数量
 	byteBumOfZlEnd   = 1 // 标记压缩列表末尾，用一个字节表示，固定值0xFF(十进制255)
+
+	zlHeaderSize = byteNumOfZlBytes + byteNumOfZlTail + byteNumOfZlLen
+	zlLenUnknown = 1<<16 - 1
 )
 
 // 压缩列表结束符
@@ -69,259 +77,398 @@ type ZipList struct {
 	data []byte
 }
 
+// entry 解析后的entry
 type entry struct {
-	preLen   int64  // 前一个entry长度
-	encoding []byte // 如果存储的是int，则encoding同时保存类型和值；如果是str，则只保存类型和字符串字节数组长度
-	content  []byte // 如果存储的是int，则content为空；如果是str，则保存字符串值
+	preLen     int    // 前一个entry长度
+	preLenSize int    // previous_entry_length字段占用的字节数
+	size       int    // entry占用的总字节数
+	content    []byte // entry保存的值，int类型会转换为十进制字符串
 }
 
 func NewZipList(values [][]byte) *ZipList {
-	return nil
+	z := &ZipList{
+		data: make([]byte, zlHeaderSize, zlHeaderSize+byteBumOfZlEnd),
+	}
+	z.data = append(z.data, zlEndFlag)
+	z.updateZlBytes()
+	z.updateZlTail(zlHeaderSize)
+	for _, value := range values {
+		z.AddLast(value)
+	}
+	return z
 }
 
 func (z *ZipList) updateZlBytes() {
-	byteLen := len(z.data)
-	byteArray := toByteArray(int64(byteLen), byteNumOfZlBytes)
-	for i := 0; i < byteNumOfZlBytes; i++ {
-		z.data[i] = byteArray[i]
-	}
+	binary.LittleEndian.PutUint32(z.data[0:byteNumOfZlBytes], uint32(len(z.data)))
 }
 
-// entry个数加1
-func (z *ZipList) addLength() {
-	oldLenBytes := z.getZlLen()
-	oldLen := toInt(oldLenBytes)
-	newLenBytes := toByteArray(int64(oldLen+1), byteNumOfZlLen)
-	for i := 0; i < byteNumOfZlLen; i++ {
-		z.data[i+byteNumOfZlBytes+byteNumOfZlTail] = newLenBytes[i]
+// 更新entry个数，个数超过uint16能表示的范围时，需遍历才能得到真实数量
+func (z *ZipList) updateLength(length int) {
+	if length > zlLenUnknown {
+		length = zlLenUnknown
 	}
+	binary.LittleEndian.PutUint16(z.data[getZlLenOffset():zlHeaderSize], uint16(length))
 }
 
 // 更新tail值，参数为新的tail值
 func (z *ZipList) updateZlTail(newTail int) {
-	newTailBytes := toByteArray(int64(newTail), byteNumOfZlTail)
-	for i := 0; i < byteNumOfZlTail; i++ {
-		z.data[i+byteNumOfZlBytes+byteNumOfZlLen] = newTailBytes[i]
-	}
+	binary.LittleEndian.PutUint32(z.data[getZlTailOffset():getZlLenOffset()], uint32(newTail))
 }
 
 func (z *ZipList) AddFirst(val []byte) int {
-	//TODO implement me
-	panic("implement me")
+	z.insertAt(zlHeaderSize, val)
+	return 1
 }
 
 func (z *ZipList) AddLast(val []byte) int {
+	z.insertAt(len(z.data)-byteBumOfZlEnd, val)
 	return 1
 }
 
+// Get index从0开始
 func (z *ZipList) Get(index int) (val []byte) {
-	//TODO implement me
-	panic("implement me")
+	offset := z.offsetOf(index)
+	if offset < 0 {
+		return nil
+	}
+	return z.parseEntry(offset).content
 }
 
 func (z *ZipList) Set(index int, val []byte) int {
-	//TODO implement me
-	panic("implement me")
+	offset := z.offsetOf(index)
+	if offset < 0 {
+		return 0
+	}
+	z.deleteAt(offset)
+	z.insertAt(offset, val)
+	return 1
 }
 
 func (z *ZipList) Insert(index int, val []byte) int {
-	//TODO implement me
-	panic("implement me")
+	if index == 0 {
+		return z.AddFirst(val)
+	} else if index == z.Length() {
+		return z.AddLast(val)
+	}
+	offset := z.offsetOf(index)
+	if offset < 0 {
+		return 0
+	}
+	z.insertAt(offset, val)
+	return 1
 }
 
 func (z *ZipList) Remove(index int) (val []byte) {
-	//TODO implement me
-	panic("implement me")
+	offset := z.offsetOf(index)
+	if offset < 0 {
+		return nil
+	}
+	return z.deleteAt(offset)
 }
 
 func (z *ZipList) RemoveFirst() (val []byte) {
-	//TODO implement me
-	panic("implement me")
+	return z.Remove(0)
 }
 
 func (z *ZipList) RemoveLast() (val []byte) {
-	//TODO implement me
-	panic("implement me")
+	return z.Remove(z.Length() - 1)
 }
 
 func (z *ZipList) First() (val []byte) {
-	//TODO implement me
-	panic("implement me")
+	return z.Get(0)
 }
 
 func (z *ZipList) Last() (val []byte) {
-	//TODO implement me
-	panic("implement me")
+	if z.Length() == 0 {
+		return nil
+	}
+	return z.parseEntry(z.getZlTail()).content
 }
 
 func (z *ZipList) Length() int {
-	//TODO implement me
-	panic("implement me")
+	length := int(binary.LittleEndian.Uint16(z.getZlLen()))
+	if length < zlLenUnknown {
+		return length
+	}
+	length = 0
+	for offset := zlHeaderSize; z.data[offset] != zlEndFlag; offset += z.parseEntry(offset).size {
+		length++
+	}
+	return length
 }
 
 func (z *ZipList) ForEach(consumer Consumer) {
-	//TODO implement me
-	panic("implement me")
+	idx := 0
+	for offset := zlHeaderSize; z.data[offset] != zlEndFlag; idx++ {
+		e := z.parseEntry(offset)
+		if !consumer(idx, e.content) {
+			break
+		}
+		offset += e.size
+	}
 }
 
 func (z *ZipList) Contains(expected Expected) bool {
-	//TODO implement me
-	panic("implement me")
+	contain := false
+	z.ForEach(func(index int, v []byte) bool {
+		if expected(v) {
+			contain = true
+			return false
+		}
+		return true
+	})
+	return contain
+}
+
+func (z *ZipList) RemoveByValFromHead(val []byte, count int) int {
+	deletedCount := 0
+	offset := zlHeaderSize
+	for z.data[offset] != zlEndFlag && deletedCount < count {
+		e := z.parseEntry(offset)
+		if bytes.Equal(e.content, val) {
+			// 删除后offset指向下一个entry
+			z.deleteAt(offset)
+			deletedCount++
+			continue
+		}
+		offset += e.size
+	}
+	return deletedCount
+}
+
+func (z *ZipList) RemoveByValFromTail(val []byte, count int) int {
+	deletedCount := 0
+	length := z.Length()
+	offset := z.getZlTail()
+	for i := length - 1; i >= 0 && deletedCount < count; i-- {
+		e := z.parseEntry(offset)
+		// 删除entry不会影响前面的entry
+		prevOffset := offset - e.preLen
+		if bytes.Equal(e.content, val) {
+			z.deleteAt(offset)
+			deletedCount++
+		}
+		offset = prevOffset
+	}
+	return deletedCount
 }
 
-// buildEntry 给定上一个entry长度，和本次entry要存储的字节数组值，返回一个entry
-func buildEntry(preLen int64, value []byte) *entry {
-	// 长度大于等于64的字符串，应该转换成linked_list
-	if len(value) >= 64 {
-		return nil
+func (z *ZipList) RemoveAllByVal(val []byte) int {
+	return z.RemoveByValFromHead(val, z.Length())
+}
+
+// offsetOf 返回index对应entry的偏移量，index越界返回-1
+// index在前半部分时从表头向后遍历，否则从表尾根据previous_entry_length向前遍历
+func (z *ZipList) offsetOf(index int) int {
+	length := z.Length()
+	if index < 0 || index >= length {
+		return -1
 	}
-	e := &entry{
-		preLen:   preLen,
-		encoding: nil,
-		content:  nil,
+	if index <= length/2 {
+		offset := zlHeaderSize
+		for i := 0; i < index; i++ {
+			offset += z.parseEntry(offset).size
+		}
+		return offset
 	}
-
-	e.encoding = getEncoding(value)
-	if entryIsStr(e.encoding[0]) {
-		e.content = value
+	offset := z.getZlTail()
+	for i := length - 1; i > index; i-- {
+		offset -= z.parseEntry(offset).preLen
 	}
-	return e
+	return offset
 }
 
-func (z *ZipList) parseEntry(offset int) *entry {
-	e := &entry{
-		preLen:   0,
-		encoding: nil,
-		content:  nil,
+// insertAt 在offset处插入entry，offset可以是某个entry的偏移量，或者结束符的偏移量
+func (z *ZipList) insertAt(offset int, val []byte) {
+	length := z.Length()
+	preLen := 0
+	if offset != zlHeaderSize {
+		if z.data[offset] == zlEndFlag {
+			preLen = z.parseEntry(z.getZlTail()).size
+		} else {
+			preLen = z.parseEntry(offset).preLen
+		}
 	}
+	e := buildEntry(preLen, val)
+	z.data = splice(z.data, offset, 0, e)
+	z.cascadeUpdate(offset+len(e), len(e))
+	z.updateLength(length + 1)
+	z.updateHeader()
+}
+
+// deleteAt 删除offset处的entry，返回entry的值
+func (z *ZipList) deleteAt(offset int) []byte {
+	length := z.Length()
+	e := z.parseEntry(offset)
+	z.data = splice(z.data, offset, e.size, nil)
+	z.cascadeUpdate(offset, e.preLen)
+	z.updateLength(length - 1)
+	z.updateHeader()
+	return e.content
+}
+
+// cascadeUpdate 前一个entry长度变为preLen后，更新offset处entry的previous_entry_length
+// previous_entry_length占用的字节数变化时，该entry的长度也随之变化，需要继续更新后面的entry，即连锁更新
+func (z *ZipList) cascadeUpdate(offset int, preLen int) {
+	for z.data[offset] != zlEndFlag {
+		e := z.parseEntry(offset)
+		if e.preLen == preLen {
+			return
+		}
+		preLenBytes := buildPreLenBytes(preLen)
+		if len(preLenBytes) == e.preLenSize {
+			copy(z.data[offset:], preLenBytes)
+			return
+		}
+		z.data = splice(z.data, offset, e.preLenSize, preLenBytes)
+		preLen = e.size - e.preLenSize + len(preLenBytes)
+		offset += preLen
+	}
+}
 
-	preLenByteNum := 1
-	if z.data[0] == fiveByteOfPreviousEntryLengthFlag {
-		preLenByteNum = 5
+// updateHeader 更新zlBytes和zlTail
+func (z *ZipList) updateHeader() {
+	z.updateZlBytes()
+	tail := zlHeaderSize
+	for offset := zlHeaderSize; z.data[offset] != zlEndFlag; offset += z.parseEntry(offset).size {
+		tail = offset
 	}
+	z.updateZlTail(tail)
+}
 
-	newOffset := preLenByteNum + offset
-	e.preLen = int64(toInt(z.data[offset:newOffset]))
-	//e.encoding, e.content = z.parseEncodingAndValue(newOffset)
-	return e
+// splice 删除data中从offset开始的removeLen个字节，并在该位置插入insert
+func splice(data []byte, offset int, removeLen int, insert []byte) []byte {
+	res := make([]byte, 0, len(data)-removeLen+len(insert))
+	res = append(res, data[:offset]...)
+	res = append(res, insert...)
+	return append(res, data[offset+removeLen:]...)
 }
 
-//func (z *ZipList) parseEncodingAndValue(offset int) (encoding []byte, value []byte) {
-//	if entryIsStr(z.data[offset]) {
-//		strByteLen := 0
-//		newOffset := 0
-//		switch z.data[offset] & ZipListStrMask {
-//		case string6EncodingMask:
-//			newOffset = offset + 1
-//			encoding = append(encoding, z.data[offset:newOffset]...)
-//			strByteLen = int(z.data[offset]&(^ZipListStrMask))
-//		case string14EncodingMask:
-//			newOffset = offset + 2
-//			encoding = append(encoding, z.data[offset:newOffset]...)
-//			strByteLen = toInt(encoding) & int(^ZipListStrMask)
-//		case string32EncodingMask
-//		}
-//
-//
-//
-//	}
-//}
+// buildEntry 给定上一个entry长度，和本次entry要存储的字节数组值，返回entry的字节数组
+func buildEntry(preLen int, value []byte) []byte {
+	return append(buildPreLenBytes(preLen), getEncoding(value)...)
+}
 
-func getEncoding(val []byte) (encoding []byte) {
-	number, err := strconv.ParseInt(string(val), 10, 64)
-	if err == nil && number >= 0 {
-		if number <= 12 {
-			encoding = []byte{byte(0b11110000 | number + 1)}
-		} else {
-			numByteArray := convertToByteArray(uint64(number))
-			switch len(numByteArray) {
-			case 1:
-				encoding = append(encoding, int8Encoding)
-			case 2:
-				encoding = append(encoding, int8Encoding)
-			case 3:
-				encoding = append(encoding, int8Encoding)
-			case 4:
-				encoding = append(encoding, int8Encoding)
-			case 8:
-				encoding = append(encoding, int8Encoding)
-			}
-			encoding = append(encoding, numByteArray...)
-		}
+// parseEntry 解析offset处的entry
+func (z *ZipList) parseEntry(offset int) *entry {
+	e := &entry{}
+	if z.data[offset] == fiveByteOfPreviousEntryLengthFlag {
+		e.preLen = int(binary.LittleEndian.Uint32(z.data[offset+1 : offset+5]))
+		e.preLenSize = 5
 	} else {
-		stringLength := 0
-		valLen := len(val)
-		if valLen <= 63 {
-			stringLength = int(string6EncodingMask) | valLen
-		} else if valLen <= 1<<14-1 {
-			stringLength = int(string14EncodingMask) | valLen
+		e.preLen = int(z.data[offset])
+		e.preLenSize = 1
+	}
+
+	pos := offset + e.preLenSize
+	enc := z.data[pos]
+	if entryIsInt(enc) {
+		size := getIntContentSize(enc)
+		var value int64
+		if size == 0 {
+			value = int64(enc&ZipListIntImmediateMask) - 1
 		} else {
-			stringLength = int(string32EncodingMask) | valLen
+			value = readIntLE(z.data[pos+1 : pos+1+size])
 		}
+		e.content = []byte(strconv.FormatInt(value, 10))
+		e.size = e.preLenSize + 1 + size
+		return e
+	}
 
-		encoding = append(encoding, convertToByteArray(uint64(int64(stringLength)))...)
+	var strLen, encodingSize int
+	switch enc & ZipListStrMask {
+	case string6EncodingMask:
+		strLen, encodingSize = int(enc&^ZipListStrMask), 1
+	case string14EncodingMask:
+		strLen, encodingSize = int(enc&^ZipListStrMask)<<8|int(z.data[pos+1]), 2
+	default:
+		strLen, encodingSize = int(binary.BigEndian.Uint32(z.data[pos+1:pos+5])), 5
 	}
-	return
+	pos += encodingSize
+	// 复制一份，ziplist修改时底层数组会变化
+	e.content = make([]byte, strLen)
+	copy(e.content, z.data[pos:pos+strLen])
+	e.size = e.preLenSize + encodingSize + strLen
+	return e
 }
 
-func buildPreLenBytes(previousEntryLength int) []byte {
-	res := make([]byte, 0)
-	previousEntryBytes := convertToByteArray(uint64(int64(previousEntryLength)))
-	if previousEntryLength >= byteNumOfPreviousEntryLengthBorder {
-		res = append(res, fiveByteOfPreviousEntryLengthFlag)
-		for i := 0; i < 4-len(previousEntryBytes); i++ {
-			res = append(res, byte(0))
+// getEncoding 返回值的encoding和content，能无损转换为整数的值使用int编码
+func getEncoding(val []byte) (encoding []byte) {
+	if number, ok := parseZipListInt(val); ok {
+		switch {
+		case number >= 0 && number <= 12:
+			return []byte{ZipListIntImmediateMin + byte(number)}
+		case number >= -1<<7 && number < 1<<7:
+			return []byte{int8Encoding, byte(number)}
+		case number >= -1<<15 && number < 1<<15:
+			return binary.LittleEndian.AppendUint16([]byte{int16Encoding}, uint16(number))
+		case number >= -1<<23 && number < 1<<23:
+			buf := binary.LittleEndian.AppendUint32([]byte{int24Encoding}, uint32(number))
+			return buf[:4]
+		case number >= -1<<31 && number < 1<<31:
+			return binary.LittleEndian.AppendUint32([]byte{int32Encoding}, uint32(number))
+		default:
+			return binary.LittleEndian.AppendUint64([]byte{int64Encoding}, uint64(number))
 		}
 	}
-	res = append(res, previousEntryBytes...)
-	return res
+
+	valLen := len(val)
+	if valLen <= 1<<6-1 {
+		encoding = []byte{string6EncodingMask | byte(valLen)}
+	} else if valLen <= 1<<14-1 {
+		encoding = []byte{string14EncodingMask | byte(valLen>>8), byte(valLen)}
+	} else {
+		encoding = binary.BigEndian.AppendUint32([]byte{string32EncodingMask}, uint32(valLen))
+	}
+	return append(encoding, val...)
 }
 
-func getEncodingByteLen(encoding byte) int {
-	if encoding == int16Encoding || encoding == int24Encoding || encoding == int8Encoding || encoding == int32Encoding || encoding == int64Encoding {
-		return 1
+// parseZipListInt 值转换为整数后再转换回字符串与原值相同时，才能使用int编码
+func parseZipListInt(val []byte) (int64, bool) {
+	if len(val) == 0 || len(val) > 20 {
+		return 0, false
 	}
-	if encoding >= ZipListIntImmediateMin && encoding <= ZipListIntImmediateMax {
-		return 1
+	number, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil || strconv.FormatInt(number, 10) != string(val) {
+		return 0, false
 	}
-	if encoding == string6EncodingMask {
-		return 1
+	return number, true
+}
+
+func buildPreLenBytes(previousEntryLength int) []byte {
+	if previousEntryLength < byteNumOfPreviousEntryLengthBorder {
+		return []byte{byte(previousEntryLength)}
 	}
-	if encoding == string14EncodingMask {
+	return binary.LittleEndian.AppendUint32([]byte{fiveByteOfPreviousEntryLengthFlag}, uint32(previousEntryLength))
+}
+
+// getIntContentSize 返回int编码的值占用的字节数，1111xxxx编码的值保存在encoding中，返回0
+func getIntContentSize(encoding byte) int {
+	switch encoding {
+	case int8Encoding:
+		return 1
+	case int16Encoding:
 		return 2
+	case int24Encoding:
+		return 3
+	case int32Encoding:
+		return 4
+	case int64Encoding:
+		return 8
 	}
-	if encoding == string32EncodingMask {
-		return 5
-	}
-	return int(ZipListEncodingSizeInvalid)
-}
-
-//func getValueFromEntry(e entry) []byte {
-//	var encodingAndContent []byte
-//	if e[0] == fiveByteOfPreviousEntryLengthFlag {
-//		encodingAndContent = e[5:]
-//	} else {
-//		encodingAndContent = e[1:]
-//	}
-//
-//	v := ""
-//	if encodingAndContent[0]>>6 == 0b00000011 {
-//		// value为数字
-//		switch encodingAndContent[0] {
-//		case int8Encoding:
-//
-//		case int16Encoding:
-//		case int24Encoding:
-//		case int32Encoding:
-//		case int64Encoding:
-//
-//		case :
-//
-//		}
-//	} else {
-//
-//	}
-//}
+	return 0
+}
+
+// readIntLE 读取小端序有符号整数
+func readIntLE(buf []byte) int64 {
+	var value uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		value = value<<8 | uint64(buf[i])
+	}
+	// 符号扩展
+	shift := uint(64 - len(buf)*8)
+	return int64(value<<shift) >> shift
+}
 
 // 判断encoding表示的是否为字符串
 func entryIsStr(enc byte) bool {
@@ -329,7 +476,7 @@ func entryIsStr(enc byte) bool {
 }
 
 func entryIsInt(enc byte) bool {
-	return enc>>6 == 0b11000000
+	return enc&ZipListStrMask == ZipListStrMask
 }
 
 func (z *ZipList) getZlBytes() []byte {
@@ -337,11 +484,11 @@ func (z *ZipList) getZlBytes() []byte {
 }
 
 func (z *ZipList) getZlTail() int {
-	return toInt(z.data[byteNumOfZlBytes : byteNumOfZlTail+byteNumOfZlBytes])
+	return int(binary.LittleEndian.Uint32(z.data[getZlTailOffset():getZlLenOffset()]))
 }
 
 func (z *ZipList) getZlLen() []byte {
-	return z.data[byteNumOfZlBytes+byteNumOfZlTail : byteNumOfZlLen+byteNumOfZlTail+byteNumOfZlBytes]
+	return z.data[getZlLenOffset():zlHeaderSize]
 }
 
 func getZlTailOffset() int {
@@ -9,6 +9,8 @@ type Set interface {
 	Len() int
 	Members() []string // 返回所有元素
 	ForEach(consumer Consumer)
+	// Scan 按游标增量遍历，返回下一次遍历的游标，为0表示遍历结束
+	Scan(cursor uint64, count int, consumer Consumer) uint64
 	RandomMembers(limit int) []string
 	RandomDistinctMembers(limit int) []string
 	Clear()
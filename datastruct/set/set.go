@@ -113,6 +113,12 @@ func (s *SimpleSet) ForEach(consumer Consumer) {
 	})
 }
 
+func (s *SimpleSet) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	return s.dict.Scan(cursor, count, func(key string, val any) bool {
+		return consumer(key)
+	})
+}
+
 func (s *SimpleSet) RandomMembers(limit int) []string {
 	return s.dict.RandomKeys(limit)
 }
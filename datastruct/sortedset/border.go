@@ -0,0 +1,175 @@
+package sortedset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+/*
+Border 表示范围查询的边界，用于ZRANGE BYSCORE/BYLEX、ZCOUNT等命令
+score边界格式：1.5 (包含)、(1.5 (不包含)、-inf、+inf
+lex边界格式：[a (包含)、(a (不包含)、- (负无穷)、+ (正无穷)
+*/
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// Border 范围边界
+type Border interface {
+	greater(element *Element) bool // 作为上界时，element是否在边界之内
+	less(element *Element) bool    // 作为下界时，element是否在边界之内
+	isIntersected(max Border) bool // 当前边界作为下界、max作为上界时，范围是否为空
+}
+
+// ScoreBorder 以score为范围的边界
+type ScoreBorder struct {
+	Inf     int8 // 0表示非无穷
+	Value   float64
+	Exclude bool
+}
+
+func (b *ScoreBorder) greater(element *Element) bool {
+	if b.Inf == positiveInf {
+		return true
+	} else if b.Inf == negativeInf {
+		return false
+	}
+	if b.Exclude {
+		return b.Value > element.Score
+	}
+	return b.Value >= element.Score
+}
+
+func (b *ScoreBorder) less(element *Element) bool {
+	if b.Inf == negativeInf {
+		return true
+	} else if b.Inf == positiveInf {
+		return false
+	}
+	if b.Exclude {
+		return b.Value < element.Score
+	}
+	return b.Value <= element.Score
+}
+
+func (b *ScoreBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	if b.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if b.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	minValue := b.Value
+	maxValue := maxBorder.Value
+	return minValue > maxValue || (minValue == maxValue && (b.Exclude || maxBorder.Exclude))
+}
+
+var (
+	// PositiveInfScoreBorder score正无穷边界
+	PositiveInfScoreBorder = &ScoreBorder{Inf: positiveInf}
+	// NegativeInfScoreBorder score负无穷边界
+	NegativeInfScoreBorder = &ScoreBorder{Inf: negativeInf}
+)
+
+// ParseScoreBorder 解析score边界，例如 1、(1、-inf、+inf
+func ParseScoreBorder(s string) (Border, error) {
+	switch s {
+	case "inf", "+inf":
+		return PositiveInfScoreBorder, nil
+	case "-inf":
+		return NegativeInfScoreBorder, nil
+	}
+	exclude := false
+	if len(s) > 0 && s[0] == '(' {
+		exclude = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	if math.IsInf(value, 1) {
+		return PositiveInfScoreBorder, nil
+	} else if math.IsInf(value, -1) {
+		return NegativeInfScoreBorder, nil
+	}
+	return &ScoreBorder{
+		Value:   value,
+		Exclude: exclude,
+	}, nil
+}
+
+// LexBorder 以member字典序为范围的边界
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (b *LexBorder) greater(element *Element) bool {
+	if b.Inf == positiveInf {
+		return true
+	} else if b.Inf == negativeInf {
+		return false
+	}
+	if b.Exclude {
+		return b.Value > element.Member
+	}
+	return b.Value >= element.Member
+}
+
+func (b *LexBorder) less(element *Element) bool {
+	if b.Inf == negativeInf {
+		return true
+	} else if b.Inf == positiveInf {
+		return false
+	}
+	if b.Exclude {
+		return b.Value < element.Member
+	}
+	return b.Value <= element.Member
+}
+
+func (b *LexBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if b.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if b.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	minValue := b.Value
+	maxValue := maxBorder.Value
+	return minValue > maxValue || (minValue == maxValue && (b.Exclude || maxBorder.Exclude))
+}
+
+// ParseLexBorder 解析字典序边界，例如 [a、(a、-、+
+func ParseLexBorder(s string) (Border, error) {
+	if s == "+" {
+		return &LexBorder{Inf: positiveInf}, nil
+	}
+	if s == "-" {
+		return &LexBorder{Inf: negativeInf}, nil
+	}
+	if len(s) == 0 {
+		return nil, errors.New("ERR min or max not valid string range item")
+	}
+	switch s[0] {
+	case '(':
+		return &LexBorder{Value: s[1:], Exclude: true}, nil
+	case '[':
+		return &LexBorder{Value: s[1:], Exclude: false}, nil
+	default:
+		return nil, errors.New("ERR min or max not valid string range item")
+	}
+}
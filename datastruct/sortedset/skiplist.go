@@ -0,0 +1,315 @@
+package sortedset
+
+import "math/rand"
+
+/*
+跳表实现，参考Redis的zskiplist
+元素按照 (score, member) 升序排列，score相同时按member字典序排列
+每一层的level记录了forward指针和span，span表示当前节点到forward节点之间跨越的节点数，用于O(logN)计算排名
+*/
+
+const (
+	maxLevel    = 16   // 跳表最大层数
+	probability = 0.25 // 节点层数每增加一层的概率
+)
+
+// Element 有序集合中的元素
+type Element struct {
+	Member string
+	Score  float64
+}
+
+type level struct {
+	forward *node // 当前层的下一个节点
+	span    int64 // 当前节点到forward节点跨越的节点数
+}
+
+type node struct {
+	Element
+	backward *node    // 第0层的前一个节点
+	levels   []*level // levels[0]为最底层
+}
+
+type skipList struct {
+	header *node
+	tail   *node
+	length int64
+	level  int16 // 当前跳表的最大层数
+}
+
+func makeNode(lvl int16, score float64, member string) *node {
+	n := &node{
+		Element: Element{
+			Member: member,
+			Score:  score,
+		},
+		levels: make([]*level, lvl),
+	}
+	for i := range n.levels {
+		n.levels[i] = new(level)
+	}
+	return n
+}
+
+func makeSkipList() *skipList {
+	return &skipList{
+		header: makeNode(maxLevel, 0, ""),
+		level:  1,
+	}
+}
+
+// randomLevel 随机生成节点层数，层数越高概率越小
+func randomLevel() int16 {
+	lvl := int16(1)
+	for lvl < maxLevel && rand.Float64() < probability {
+		lvl++
+	}
+	return lvl
+}
+
+// lessThan 判断元素(score, member)是否排在节点n之前
+func (n *node) lessThan(score float64, member string) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+// insert 插入一个新节点，调用方需保证member不存在于跳表中
+func (s *skipList) insert(member string, score float64) *node {
+	update := make([]*node, maxLevel) // 每一层中，新节点的前驱节点
+	rank := make([]int64, maxLevel)   // 每一层中，前驱节点的排名
+
+	cur := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		if i == s.level-1 {
+			rank[i] = 0
+		} else {
+			rank[i] = rank[i+1]
+		}
+		for cur.levels[i].forward != nil && cur.levels[i].forward.lessThan(score, member) {
+			rank[i] += cur.levels[i].span
+			cur = cur.levels[i].forward
+		}
+		update[i] = cur
+	}
+
+	lvl := randomLevel()
+	if lvl > s.level {
+		for i := s.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = s.header
+			update[i].levels[i].span = s.length
+		}
+		s.level = lvl
+	}
+
+	n := makeNode(lvl, score, member)
+	for i := int16(0); i < lvl; i++ {
+		n.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = n
+
+		n.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	// 新节点层数以上的各层，跨度加1
+	for i := lvl; i < s.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] == s.header {
+		n.backward = nil
+	} else {
+		n.backward = update[0]
+	}
+	if n.levels[0].forward != nil {
+		n.levels[0].forward.backward = n
+	} else {
+		s.tail = n
+	}
+	s.length++
+	return n
+}
+
+// removeNode 删除节点n，update为每一层中n的前驱节点
+func (s *skipList) removeNode(n *node, update []*node) {
+	for i := int16(0); i < s.level; i++ {
+		if update[i].levels[i].forward == n {
+			update[i].levels[i].span += n.levels[i].span - 1
+			update[i].levels[i].forward = n.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if n.levels[0].forward != nil {
+		n.levels[0].forward.backward = n.backward
+	} else {
+		s.tail = n.backward
+	}
+	for s.level > 1 && s.header.levels[s.level-1].forward == nil {
+		s.level--
+	}
+	s.length--
+}
+
+// remove 删除 (score, member) 对应节点，删除成功返回true
+func (s *skipList) remove(member string, score float64) bool {
+	update := make([]*node, maxLevel)
+	cur := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for cur.levels[i].forward != nil && cur.levels[i].forward.lessThan(score, member) {
+			cur = cur.levels[i].forward
+		}
+		update[i] = cur
+	}
+	cur = cur.levels[0].forward
+	if cur != nil && cur.Score == score && cur.Member == member {
+		s.removeNode(cur, update)
+		return true
+	}
+	return false
+}
+
+// getRank 返回 (score, member) 的排名，从1开始；不存在返回0
+func (s *skipList) getRank(member string, score float64) int64 {
+	var rank int64 = 0
+	cur := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for cur.levels[i].forward != nil && !cur.levels[i].forward.greaterThan(score, member) {
+			rank += cur.levels[i].span
+			cur = cur.levels[i].forward
+		}
+		if cur != s.header && cur.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// greaterThan 判断节点n是否排在元素(score, member)之后
+func (n *node) greaterThan(score float64, member string) bool {
+	return n.Score > score || (n.Score == score && n.Member > member)
+}
+
+// getByRank 根据排名返回节点，排名从1开始
+func (s *skipList) getByRank(rank int64) *node {
+	var traversed int64 = 0
+	cur := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for cur.levels[i].forward != nil && traversed+cur.levels[i].span <= rank {
+			traversed += cur.levels[i].span
+			cur = cur.levels[i].forward
+		}
+		if traversed == rank {
+			return cur
+		}
+	}
+	return nil
+}
+
+// hasInRange 判断跳表中是否存在位于 [min, max] 范围内的元素
+func (s *skipList) hasInRange(min Border, max Border) bool {
+	if min.isIntersected(max) {
+		return false
+	}
+	// min > tail
+	n := s.tail
+	if n == nil || !min.less(&n.Element) {
+		return false
+	}
+	// max < head
+	n = s.header.levels[0].forward
+	if n == nil || !max.greater(&n.Element) {
+		return false
+	}
+	return true
+}
+
+// getFirstInRange 返回第一个位于范围内的节点
+func (s *skipList) getFirstInRange(min Border, max Border) *node {
+	if !s.hasInRange(min, max) {
+		return nil
+	}
+	n := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for n.levels[i].forward != nil && !min.less(&n.levels[i].forward.Element) {
+			n = n.levels[i].forward
+		}
+	}
+	n = n.levels[0].forward
+	if !max.greater(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// getLastInRange 返回最后一个位于范围内的节点
+func (s *skipList) getLastInRange(min Border, max Border) *node {
+	if !s.hasInRange(min, max) {
+		return nil
+	}
+	n := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for n.levels[i].forward != nil && max.greater(&n.levels[i].forward.Element) {
+			n = n.levels[i].forward
+		}
+	}
+	if !min.less(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// removeRange 删除范围内的元素，limit <= 0 表示不限制数量，返回被删除的元素
+func (s *skipList) removeRange(min Border, max Border, limit int) []*Element {
+	update := make([]*node, maxLevel)
+	removed := make([]*Element, 0)
+	n := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for n.levels[i].forward != nil && !min.less(&n.levels[i].forward.Element) {
+			n = n.levels[i].forward
+		}
+		update[i] = n
+	}
+
+	n = n.levels[0].forward
+	for n != nil {
+		if !max.greater(&n.Element) {
+			break
+		}
+		next := n.levels[0].forward
+		element := n.Element
+		removed = append(removed, &element)
+		s.removeNode(n, update)
+		if limit > 0 && len(removed) == limit {
+			break
+		}
+		n = next
+	}
+	return removed
+}
+
+// removeRangeByRank 删除排名位于 [start, stop) 的元素，排名从1开始
+func (s *skipList) removeRangeByRank(start int64, stop int64) []*Element {
+	var i int64 = 0
+	update := make([]*node, maxLevel)
+	removed := make([]*Element, 0)
+
+	n := s.header
+	for lvl := s.level - 1; lvl >= 0; lvl-- {
+		for n.levels[lvl].forward != nil && i+n.levels[lvl].span < start {
+			i += n.levels[lvl].span
+			n = n.levels[lvl].forward
+		}
+		update[lvl] = n
+	}
+
+	i++
+	n = n.levels[0].forward
+	for n != nil && i < stop {
+		next := n.levels[0].forward
+		element := n.Element
+		removed = append(removed, &element)
+		s.removeNode(n, update)
+		n = next
+		i++
+	}
+	return removed
+}
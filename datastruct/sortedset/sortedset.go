@@ -0,0 +1,231 @@
+package sortedset
+
+import "zedis/datastruct/dict"
+
+// SortedSet 有序集合，由dict和跳表组成
+// dict用于O(1)根据member查找score，跳表用于按score排序及范围查询
+type SortedSet struct {
+	dict     *dict.SimpleDict // member -> *Element
+	skipList *skipList
+}
+
+// Consumer 遍历有序集合，返回false时停止遍历
+type Consumer func(element *Element) bool
+
+// NewSortedSet 新建一个空的有序集合
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict:     dict.NewSimpleDict(),
+		skipList: makeSkipList(),
+	}
+}
+
+// Add 添加或更新member的score，如果是新添加的member返回true
+func (s *SortedSet) Add(member string, score float64) bool {
+	element, ok := s.Get(member)
+	s.dict.Put(member, &Element{
+		Member: member,
+		Score:  score,
+	})
+	if ok {
+		if score != element.Score {
+			s.skipList.remove(member, element.Score)
+			s.skipList.insert(member, score)
+		}
+		return false
+	}
+	s.skipList.insert(member, score)
+	return true
+}
+
+// Len 返回元素数量
+func (s *SortedSet) Len() int64 {
+	return int64(s.dict.Len())
+}
+
+// Get 根据member返回元素
+func (s *SortedSet) Get(member string) (element *Element, ok bool) {
+	val, ok := s.dict.Get(member)
+	if !ok {
+		return nil, false
+	}
+	return val.(*Element), true
+}
+
+// Remove 删除member，删除成功返回true
+func (s *SortedSet) Remove(member string) bool {
+	element, ok := s.Get(member)
+	if !ok {
+		return false
+	}
+	s.skipList.remove(member, element.Score)
+	s.dict.Remove(member)
+	return true
+}
+
+// GetRank 返回member的排名，从0开始；desc为true时按score从大到小排名
+func (s *SortedSet) GetRank(member string, desc bool) (rank int64, ok bool) {
+	element, ok := s.Get(member)
+	if !ok {
+		return -1, false
+	}
+	r := s.skipList.getRank(member, element.Score)
+	if desc {
+		r = s.skipList.length - r
+	} else {
+		r--
+	}
+	return r, true
+}
+
+// ForEachByRank 遍历排名在 [start, stop) 范围内的元素，排名从0开始
+func (s *SortedSet) ForEachByRank(start int64, stop int64, desc bool, consumer Consumer) {
+	size := s.Len()
+	if start < 0 || start >= size {
+		return
+	}
+	if stop < start || stop > size {
+		stop = size
+	}
+
+	var n *node
+	if desc {
+		n = s.skipList.tail
+		if start > 0 {
+			n = s.skipList.getByRank(size - start)
+		}
+	} else {
+		n = s.skipList.header.levels[0].forward
+		if start > 0 {
+			n = s.skipList.getByRank(start + 1)
+		}
+	}
+
+	for i := start; i < stop && n != nil; i++ {
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.levels[0].forward
+		}
+	}
+}
+
+// RangeByRank 返回排名在 [start, stop) 范围内的元素，排名从0开始
+func (s *SortedSet) RangeByRank(start int64, stop int64, desc bool) []*Element {
+	elements := make([]*Element, 0)
+	s.ForEachByRank(start, stop, desc, func(element *Element) bool {
+		elements = append(elements, element)
+		return true
+	})
+	return elements
+}
+
+// RangeCount 返回位于 [min, max] 范围内的元素数量
+func (s *SortedSet) RangeCount(min Border, max Border) int64 {
+	var count int64 = 0
+	s.ForEach(min, max, 0, -1, false, func(element *Element) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// Scan 按游标增量遍历所有元素，顺序与score无关，返回下一次遍历的游标，为0表示遍历结束
+func (s *SortedSet) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	return s.dict.Scan(cursor, count, func(key string, val any) bool {
+		return consumer(val.(*Element))
+	})
+}
+
+// ForEach 遍历位于 [min, max] 范围内的元素，跳过前offset个，最多遍历limit个，limit < 0 表示不限制
+func (s *SortedSet) ForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer Consumer) {
+	var n *node
+	if desc {
+		n = s.skipList.getLastInRange(min, max)
+	} else {
+		n = s.skipList.getFirstInRange(min, max)
+	}
+
+	for n != nil && offset > 0 {
+		if desc {
+			n = n.backward
+		} else {
+			n = n.levels[0].forward
+		}
+		offset--
+	}
+
+	for i := int64(0); (i < limit || limit < 0) && n != nil; i++ {
+		if !min.less(&n.Element) || !max.greater(&n.Element) {
+			break
+		}
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.levels[0].forward
+		}
+	}
+}
+
+// Range 返回位于 [min, max] 范围内的元素，跳过前offset个，最多返回limit个，limit < 0 表示不限制
+func (s *SortedSet) Range(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
+	if limit == 0 || offset < 0 {
+		return make([]*Element, 0)
+	}
+	elements := make([]*Element, 0)
+	s.ForEach(min, max, offset, limit, desc, func(element *Element) bool {
+		elements = append(elements, element)
+		return true
+	})
+	return elements
+}
+
+// RemoveRange 删除位于 [min, max] 范围内的元素，返回被删除的元素
+func (s *SortedSet) RemoveRange(min Border, max Border) []*Element {
+	removed := s.skipList.removeRange(min, max, 0)
+	for _, element := range removed {
+		s.dict.Remove(element.Member)
+	}
+	return removed
+}
+
+// PopMin 删除并返回score最小的count个元素
+func (s *SortedSet) PopMin(count int) []*Element {
+	first := s.skipList.header.levels[0].forward
+	if first == nil {
+		return make([]*Element, 0)
+	}
+	border := &ScoreBorder{
+		Value:   first.Score,
+		Exclude: false,
+	}
+	removed := s.skipList.removeRange(border, PositiveInfScoreBorder, count)
+	for _, element := range removed {
+		s.dict.Remove(element.Member)
+	}
+	return removed
+}
+
+// PopMax 删除并返回score最大的count个元素，按score从大到小返回
+func (s *SortedSet) PopMax(count int) []*Element {
+	size := s.Len()
+	if count > int(size) {
+		count = int(size)
+	}
+	start := size - int64(count)
+	removed := s.skipList.removeRangeByRank(start+1, size+1)
+	for _, element := range removed {
+		s.dict.Remove(element.Member)
+	}
+	// removeRangeByRank按升序返回，需要翻转
+	for i, j := 0, len(removed)-1; i < j; i, j = i+1, j-1 {
+		removed[i], removed[j] = removed[j], removed[i]
+	}
+	return removed
+}
@@ -0,0 +1,129 @@
+package sortedset
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSortedSetRank(t *testing.T) {
+	s := NewSortedSet()
+	for i := 0; i < 100; i++ {
+		s.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	if s.Len() != 100 {
+		t.Fatalf("expected length 100, got %d", s.Len())
+	}
+	for i := 0; i < 100; i++ {
+		rank, ok := s.GetRank("m"+strconv.Itoa(i), false)
+		if !ok || rank != int64(i) {
+			t.Fatalf("expected rank %d, got %d", i, rank)
+		}
+		rank, _ = s.GetRank("m"+strconv.Itoa(i), true)
+		if rank != int64(99-i) {
+			t.Fatalf("expected desc rank %d, got %d", 99-i, rank)
+		}
+	}
+
+	// 更新score后排名变化
+	s.Add("m0", 1000)
+	rank, _ := s.GetRank("m0", false)
+	if rank != 99 {
+		t.Fatalf("expected rank 99 after update, got %d", rank)
+	}
+	s.Remove("m0")
+	if _, ok := s.Get("m0"); ok {
+		t.Fatal("m0 should be removed")
+	}
+}
+
+func TestSortedSetRangeByRank(t *testing.T) {
+	s := NewSortedSet()
+	for i := 0; i < 10; i++ {
+		s.Add(strconv.Itoa(i), float64(i))
+	}
+	elements := s.RangeByRank(2, 5, false)
+	if len(elements) != 3 || elements[0].Member != "2" || elements[2].Member != "4" {
+		t.Fatalf("wrong range result: %v", elements)
+	}
+	elements = s.RangeByRank(0, 3, true)
+	if len(elements) != 3 || elements[0].Member != "9" || elements[2].Member != "7" {
+		t.Fatalf("wrong desc range result: %v", elements)
+	}
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	s := NewSortedSet()
+	for i := 0; i < 10; i++ {
+		s.Add(strconv.Itoa(i), float64(i))
+	}
+	min, _ := ParseScoreBorder("(2")
+	max, _ := ParseScoreBorder("5")
+	if count := s.RangeCount(min, max); count != 3 {
+		t.Fatalf("expected count 3, got %d", count)
+	}
+	elements := s.Range(min, max, 1, 1, false)
+	if len(elements) != 1 || elements[0].Member != "4" {
+		t.Fatalf("wrong range with limit: %v", elements)
+	}
+	elements = s.Range(NegativeInfScoreBorder, PositiveInfScoreBorder, 0, -1, true)
+	if len(elements) != 10 || elements[0].Member != "9" {
+		t.Fatalf("wrong desc range: %v", elements)
+	}
+
+	removed := s.RemoveRange(min, max)
+	if len(removed) != 3 || s.Len() != 7 {
+		t.Fatalf("wrong remove range result: %v", removed)
+	}
+}
+
+func TestSortedSetRangeByLex(t *testing.T) {
+	s := NewSortedSet()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		s.Add(member, 0)
+	}
+	min, _ := ParseLexBorder("[b")
+	max, _ := ParseLexBorder("(e")
+	elements := s.Range(min, max, 0, -1, false)
+	if len(elements) != 3 || elements[0].Member != "b" || elements[2].Member != "d" {
+		t.Fatalf("wrong lex range: %v", elements)
+	}
+}
+
+func TestSortedSetPop(t *testing.T) {
+	s := NewSortedSet()
+	for i := 0; i < 10; i++ {
+		s.Add(strconv.Itoa(i), float64(i))
+	}
+	popped := s.PopMin(2)
+	if len(popped) != 2 || popped[0].Member != "0" || popped[1].Member != "1" {
+		t.Fatalf("wrong pop min result: %v", popped)
+	}
+	popped = s.PopMax(3)
+	if len(popped) != 3 || popped[0].Member != "9" || popped[2].Member != "7" {
+		t.Fatalf("wrong pop max result: %v", popped)
+	}
+	if s.Len() != 5 {
+		t.Fatalf("expected length 5, got %d", s.Len())
+	}
+}
+
+func TestSortedSetScan(t *testing.T) {
+	s := NewSortedSet()
+	for i := 0; i < 100; i++ {
+		s.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	seen := make(map[string]float64)
+	cursor := uint64(0)
+	for {
+		cursor = s.Scan(cursor, 10, func(element *Element) bool {
+			seen[element.Member] = element.Score
+			return true
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 100 || seen["m42"] != 42 {
+		t.Fatalf("expected 100 members, got %d", len(seen))
+	}
+}
@@ -0,0 +1,244 @@
+package stream
+
+import (
+	"sort"
+)
+
+// PendingEntry 已经发送给消费者但还没有被确认(XACK)的消息
+type PendingEntry struct {
+	ID       ID
+	Consumer *Consumer
+	// 最后一次发送的时间，毫秒时间戳
+	DeliveryTime int64
+	// 发送的次数
+	DeliveryCount int64
+}
+
+// Consumer 消费者组中的消费者
+type Consumer struct {
+	Name string
+	// 最后一次读取或认领消息的时间，毫秒时间戳
+	SeenTime int64
+	// 最后一次成功读取或认领到消息的时间，从未读取过时为-1
+	ActiveTime int64
+	pending    map[ID]*PendingEntry
+}
+
+// PendingCount 返回发送给该消费者还没有确认的消息数量
+func (c *Consumer) PendingCount() int {
+	return len(c.pending)
+}
+
+// PendingIDs 按ID从小到大返回发送给该消费者还没有确认的消息
+func (c *Consumer) PendingIDs() []ID {
+	ids := make([]ID, 0, len(c.pending))
+	for id := range c.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Less(ids[j])
+	})
+	return ids
+}
+
+// Group 消费者组，记录组内最后发送的消息ID，以及已发送未确认的消息(PEL)
+type Group struct {
+	Name string
+	// 最后发送给组内消费者的消息ID，使用 > 读取时返回大于它的消息
+	LastID ID
+	// 组内已经读取的消息数量，用于计算lag，为-1时表示无法得知
+	EntriesRead int64
+	// 按ID从小到大排列的待确认消息
+	pending   []*PendingEntry
+	consumers map[string]*Consumer
+}
+
+// CreateGroup 创建消费者组，已存在时返回nil
+func (s *Stream) CreateGroup(name string, lastID ID, entriesRead int64) *Group {
+	if _, ok := s.groups[name]; ok {
+		return nil
+	}
+	g := &Group{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		consumers:   make(map[string]*Consumer),
+	}
+	s.groups[name] = g
+	return g
+}
+
+// GetGroup 返回消费者组，不存在时返回nil
+func (s *Stream) GetGroup(name string) *Group {
+	return s.groups[name]
+}
+
+// DestroyGroup 删除消费者组，删除成功返回true
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups 按名称排序返回所有消费者组
+func (s *Stream) Groups() []*Group {
+	groups := make([]*Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Lag 返回组内还没有读取的消息数量，无法得知时第二个返回值为false
+func (s *Stream) Lag(g *Group) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.EntriesRead >= 0 && !s.HasTombstones(g.LastID) {
+		return int64(s.entriesAdded) - g.EntriesRead, true
+	}
+	entriesRead := s.EstimateEntriesRead(g.LastID)
+	if entriesRead < 0 {
+		return 0, false
+	}
+	return int64(s.entriesAdded) - entriesRead, true
+}
+
+// Deliver 使用 > 读取新消息时，将组的LastID更新为id，并更新已读的消息数量
+func (s *Stream) Deliver(g *Group, id ID) {
+	if !g.LastID.Less(id) {
+		return
+	}
+	if g.EntriesRead >= 0 && !s.HasTombstones(id) {
+		g.EntriesRead++
+	} else if s.entriesAdded > 0 {
+		g.EntriesRead = s.EstimateEntriesRead(id)
+	}
+	g.LastID = id
+}
+
+// GetConsumer 返回消费者，不存在时返回nil
+func (g *Group) GetConsumer(name string) *Consumer {
+	return g.consumers[name]
+}
+
+// CreateConsumer 创建消费者，已存在时返回nil，now为毫秒时间戳
+func (g *Group) CreateConsumer(name string, now int64) *Consumer {
+	if _, ok := g.consumers[name]; ok {
+		return nil
+	}
+	c := &Consumer{
+		Name:       name,
+		SeenTime:   now,
+		ActiveTime: -1,
+		pending:    make(map[ID]*PendingEntry),
+	}
+	g.consumers[name] = c
+	return c
+}
+
+// DeleteConsumer 删除消费者以及发送给它的待确认消息，返回删除的待确认消息数量，消费者不存在时返回-1
+func (g *Group) DeleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return -1
+	}
+	count := len(c.pending)
+	for id := range c.pending {
+		g.removePending(id)
+	}
+	delete(g.consumers, name)
+	return count
+}
+
+// Consumers 按名称排序返回所有消费者
+func (g *Group) Consumers() []*Consumer {
+	consumers := make([]*Consumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+// ConsumerCount 返回消费者数量
+func (g *Group) ConsumerCount() int {
+	return len(g.consumers)
+}
+
+// PendingCount 返回组内待确认的消息数量
+func (g *Group) PendingCount() int {
+	return len(g.pending)
+}
+
+// searchPending 返回第一个ID大于等于id的待确认消息的位置
+func (g *Group) searchPending(id ID) int {
+	return sort.Search(len(g.pending), func(i int) bool {
+		return !g.pending[i].ID.Less(id)
+	})
+}
+
+// GetPending 返回待确认的消息，不存在时返回nil
+func (g *Group) GetPending(id ID) *PendingEntry {
+	i := g.searchPending(id)
+	if i < len(g.pending) && g.pending[i].ID == id {
+		return g.pending[i]
+	}
+	return nil
+}
+
+// Pending 按ID从小到大遍历ID在[start, end]范围内的待确认消息，consumer返回false时停止遍历
+func (g *Group) Pending(start, end ID, consumer func(pe *PendingEntry) bool) {
+	for i := g.searchPending(start); i < len(g.pending); i++ {
+		pe := g.pending[i]
+		if end.Less(pe.ID) || !consumer(pe) {
+			return
+		}
+	}
+}
+
+// AddPending 添加发送给消费者c的待确认消息，已存在时转移给c，返回待确认消息
+// 发送时间和次数由调用方设置
+func (g *Group) AddPending(id ID, c *Consumer) *PendingEntry {
+	i := g.searchPending(id)
+	if i < len(g.pending) && g.pending[i].ID == id {
+		pe := g.pending[i]
+		delete(pe.Consumer.pending, id)
+		pe.Consumer = c
+		c.pending[id] = pe
+		return pe
+	}
+	pe := &PendingEntry{ID: id, Consumer: c}
+	g.pending = append(g.pending, nil)
+	copy(g.pending[i+1:], g.pending[i:])
+	g.pending[i] = pe
+	c.pending[id] = pe
+	return pe
+}
+
+// Ack 确认消息，从组和消费者的待确认消息中删除，删除成功返回true
+func (g *Group) Ack(id ID) bool {
+	pe := g.removePending(id)
+	if pe == nil {
+		return false
+	}
+	delete(pe.Consumer.pending, id)
+	return true
+}
+
+func (g *Group) removePending(id ID) *PendingEntry {
+	i := g.searchPending(id)
+	if i == len(g.pending) || g.pending[i].ID != id {
+		return nil
+	}
+	pe := g.pending[i]
+	g.pending = append(g.pending[:i], g.pending[i+1:]...)
+	return pe
+}
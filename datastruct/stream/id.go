@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidID 消息ID格式不正确
+var ErrInvalidID = errors.New("invalid stream id")
+
+// ID 消息ID，由毫秒时间戳和同一毫秒内的序号组成，格式为 ms-seq
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	// MinID 最小的ID 0-0
+	MinID = ID{}
+	// MaxID 最大的ID
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare 比较两个ID，小于、等于、大于other时分别返回-1、0、1
+func (id ID) Compare(other ID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+func (id ID) Less(other ID) bool {
+	return id.Compare(other) < 0
+}
+
+func (id ID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Incr 返回下一个ID，已经是最大的ID时第二个返回值为false
+func (id ID) Incr() (ID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Decr 返回上一个ID，已经是最小的ID时第二个返回值为false
+func (id ID) Decr() (ID, bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseID 解析 ms-seq 或 ms 格式的ID，只有ms时序号为defaultSeq
+// 特殊的ID "-" 和 "+" 分别表示最小和最大的ID
+func ParseID(s string, defaultSeq uint64) (ID, error) {
+	if s == "-" {
+		return MinID, nil
+	}
+	if s == "+" {
+		return MaxID, nil
+	}
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	if !hasSeq {
+		return ID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	return ID{Ms: ms, Seq: seq}, nil
+}
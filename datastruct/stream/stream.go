@@ -0,0 +1,329 @@
+package stream
+
+import (
+	"sort"
+)
+
+// nodeMaxEntries 每个节点最多保存的消息数量，与Redis的stream-node-max-entries默认值相同
+const nodeMaxEntries = 100
+
+// Entry 一条消息，Fields中字段名和值交替排列
+type Entry struct {
+	ID     ID
+	Fields [][]byte
+}
+
+// node 一段ID连续递增的消息，类似Redis中基数树的一个listpack节点
+type node struct {
+	entries []*Entry
+}
+
+func (n *node) lastID() ID {
+	return n.entries[len(n.entries)-1].ID
+}
+
+// Stream 消息流，消息按ID递增的顺序保存在多个节点中
+// 追加消息只需要修改最后一个节点，按ID查找时先二分查找节点，再在节点内二分查找
+type Stream struct {
+	nodes  []*node
+	length int
+	// 最后添加的消息的ID，消息被删除后也不会变小，新消息的ID必须大于它
+	lastID ID
+	// 被XDEL删除的最大ID，用于计算消费者组的lag
+	maxDeletedID ID
+	// 从创建开始一共添加过的消息数量，包括已经删除的
+	entriesAdded uint64
+	groups       map[string]*Group
+}
+
+// EntryConsumer 遍历消息，返回false时停止遍历
+type EntryConsumer func(entry *Entry) bool
+
+// NewStream 新建一个空的消息流
+func NewStream() *Stream {
+	return &Stream{
+		groups: make(map[string]*Group),
+	}
+}
+
+// Len 返回消息数量
+func (s *Stream) Len() int {
+	return s.length
+}
+
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID 修改最后添加的消息的ID，调用方需要保证不小于当前最大的消息ID
+func (s *Stream) SetLastID(id ID) {
+	s.lastID = id
+}
+
+func (s *Stream) MaxDeletedID() ID {
+	return s.maxDeletedID
+}
+
+func (s *Stream) SetMaxDeletedID(id ID) {
+	s.maxDeletedID = id
+}
+
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+func (s *Stream) SetEntriesAdded(n uint64) {
+	s.entriesAdded = n
+}
+
+// NodeCount 返回节点数量
+func (s *Stream) NodeCount() int {
+	return len(s.nodes)
+}
+
+// FirstEntry 返回第一条消息，没有消息时返回nil
+func (s *Stream) FirstEntry() *Entry {
+	if len(s.nodes) == 0 {
+		return nil
+	}
+	return s.nodes[0].entries[0]
+}
+
+// LastEntry 返回最后一条消息，没有消息时返回nil
+func (s *Stream) LastEntry() *Entry {
+	if len(s.nodes) == 0 {
+		return nil
+	}
+	last := s.nodes[len(s.nodes)-1]
+	return last.entries[len(last.entries)-1]
+}
+
+// FirstID 返回第一条消息的ID，没有消息时返回0-0
+func (s *Stream) FirstID() ID {
+	if entry := s.FirstEntry(); entry != nil {
+		return entry.ID
+	}
+	return MinID
+}
+
+// NextID 根据当前的毫秒时间戳生成下一条消息的ID
+// 时钟回拨或同一毫秒内添加多条消息时，使用最后一条消息的ms并递增序号
+func (s *Stream) NextID(ms uint64) (ID, bool) {
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, true
+	}
+	return s.lastID.Incr()
+}
+
+// Add 在末尾追加消息，调用方需要保证id大于LastID
+func (s *Stream) Add(id ID, fields [][]byte) *Entry {
+	entry := &Entry{ID: id, Fields: fields}
+	if len(s.nodes) == 0 || len(s.nodes[len(s.nodes)-1].entries) >= nodeMaxEntries {
+		s.nodes = append(s.nodes, &node{entries: make([]*Entry, 0, 1)})
+	}
+	last := s.nodes[len(s.nodes)-1]
+	last.entries = append(last.entries, entry)
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+	return entry
+}
+
+// seek 返回第一个ID大于等于id的消息所在的节点和在节点中的位置，不存在时节点下标等于len(nodes)
+func (s *Stream) seek(id ID) (int, int) {
+	i := sort.Search(len(s.nodes), func(i int) bool {
+		return !s.nodes[i].lastID().Less(id)
+	})
+	if i == len(s.nodes) {
+		return i, 0
+	}
+	entries := s.nodes[i].entries
+	j := sort.Search(len(entries), func(j int) bool {
+		return !entries[j].ID.Less(id)
+	})
+	return i, j
+}
+
+// Get 根据ID返回消息，不存在时返回nil
+func (s *Stream) Get(id ID) *Entry {
+	i, j := s.seek(id)
+	if i == len(s.nodes) {
+		return nil
+	}
+	entry := s.nodes[i].entries[j]
+	if entry.ID != id {
+		return nil
+	}
+	return entry
+}
+
+// ForEach 按ID从小到大的顺序遍历ID在[start, end]范围内的消息
+func (s *Stream) ForEach(start, end ID, consumer EntryConsumer) {
+	i, j := s.seek(start)
+	for ; i < len(s.nodes); i++ {
+		for ; j < len(s.nodes[i].entries); j++ {
+			entry := s.nodes[i].entries[j]
+			if end.Less(entry.ID) {
+				return
+			}
+			if !consumer(entry) {
+				return
+			}
+		}
+		j = 0
+	}
+}
+
+// ReverseForEach 按ID从大到小的顺序遍历ID在[start, end]范围内的消息
+func (s *Stream) ReverseForEach(start, end ID, consumer EntryConsumer) {
+	// nodes[i]是第一个包含大于end的消息的节点，从它之前最后一条小于等于end的消息开始
+	i := sort.Search(len(s.nodes), func(i int) bool {
+		return end.Less(s.nodes[i].lastID())
+	})
+	var j int
+	if i == len(s.nodes) {
+		i--
+		if i < 0 {
+			return
+		}
+		j = len(s.nodes[i].entries) - 1
+	} else {
+		entries := s.nodes[i].entries
+		j = sort.Search(len(entries), func(j int) bool {
+			return end.Less(entries[j].ID)
+		}) - 1
+	}
+	for i >= 0 {
+		entries := s.nodes[i].entries
+		for ; j >= 0; j-- {
+			if entries[j].ID.Less(start) {
+				return
+			}
+			if !consumer(entries[j]) {
+				return
+			}
+		}
+		i--
+		if i >= 0 {
+			j = len(s.nodes[i].entries) - 1
+		}
+	}
+}
+
+// Range 返回ID在[start, end]范围内的最多count条消息，count小于等于0时不限制数量，rev为true时按ID从大到小返回
+func (s *Stream) Range(start, end ID, count int, rev bool) []*Entry {
+	entries := make([]*Entry, 0)
+	if end.Less(start) {
+		return entries
+	}
+	consumer := func(entry *Entry) bool {
+		entries = append(entries, entry)
+		return count <= 0 || len(entries) < count
+	}
+	if rev {
+		s.ReverseForEach(start, end, consumer)
+	} else {
+		s.ForEach(start, end, consumer)
+	}
+	return entries
+}
+
+// Delete 删除消息，删除成功返回true
+func (s *Stream) Delete(id ID) bool {
+	i, j := s.seek(id)
+	if i == len(s.nodes) || s.nodes[i].entries[j].ID != id {
+		return false
+	}
+	s.removeAt(i, j)
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+func (s *Stream) removeAt(i, j int) {
+	n := s.nodes[i]
+	n.entries = append(n.entries[:j], n.entries[j+1:]...)
+	if len(n.entries) == 0 {
+		s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+	}
+	s.length--
+}
+
+// TrimByMaxLen 从头部删除消息，直到消息数量不超过maxLen，返回删除的数量
+// approx为true时只删除整个节点，剩余的消息数量可能略多于maxLen；limit大于0时最多删除limit条消息
+func (s *Stream) TrimByMaxLen(maxLen int, approx bool, limit int) int {
+	return s.trim(func() bool {
+		return s.length > maxLen
+	}, func(n *node) bool {
+		return s.length-len(n.entries) >= maxLen
+	}, approx, limit)
+}
+
+// TrimByMinID 从头部删除ID小于minID的消息，返回删除的数量，approx和limit的含义与TrimByMaxLen相同
+func (s *Stream) TrimByMinID(minID ID, approx bool, limit int) int {
+	return s.trim(func() bool {
+		return s.FirstID().Less(minID)
+	}, func(n *node) bool {
+		return n.lastID().Less(minID)
+	}, approx, limit)
+}
+
+// trim 删除头部的消息，needTrim判断是否还需要删除，canRemoveNode判断能否删除整个节点
+func (s *Stream) trim(needTrim func() bool, canRemoveNode func(n *node) bool, approx bool, limit int) int {
+	removed := 0
+	for s.length > 0 && needTrim() {
+		first := s.nodes[0]
+		if canRemoveNode(first) {
+			if limit > 0 && removed+len(first.entries) > limit {
+				break
+			}
+			s.nodes = s.nodes[1:]
+			s.length -= len(first.entries)
+			removed += len(first.entries)
+			continue
+		}
+		if approx {
+			break
+		}
+		s.removeAt(0, 0)
+		removed++
+	}
+	return removed
+}
+
+// HasTombstones 在start之后是否有被XDEL删除的消息，用于判断消费者组已读的数量是否准确
+func (s *Stream) HasTombstones(start ID) bool {
+	if s.length == 0 || s.maxDeletedID.IsZero() {
+		return false
+	}
+	return !s.maxDeletedID.Less(start)
+}
+
+// EstimateEntriesRead 估算从第一条消息到id之间添加过的消息数量，无法估算时返回-1
+func (s *Stream) EstimateEntriesRead(id ID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	cmpLast := id.Compare(s.lastID)
+	if s.length == 0 && cmpLast <= 0 {
+		return int64(s.entriesAdded)
+	}
+	if cmpLast == 0 {
+		return int64(s.entriesAdded)
+	}
+	if cmpLast > 0 {
+		return -1
+	}
+	firstID := s.FirstID()
+	if s.maxDeletedID.IsZero() || s.maxDeletedID.Less(firstID) {
+		// 没有被XDEL删除的消息，已读的数量可以根据第一条消息推算
+		switch id.Compare(firstID) {
+		case -1:
+			return int64(s.entriesAdded) - int64(s.length)
+		case 0:
+			return int64(s.entriesAdded) - int64(s.length) + 1
+		}
+	}
+	return -1
+}
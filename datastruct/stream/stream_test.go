@@ -0,0 +1,174 @@
+package stream
+
+import (
+	"strconv"
+	"testing"
+)
+
+func makeStream(n int) *Stream {
+	s := NewStream()
+	for i := 1; i <= n; i++ {
+		s.Add(ID{Ms: uint64(i)}, [][]byte{[]byte("f"), []byte(strconv.Itoa(i))})
+	}
+	return s
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		input string
+		seq   uint64
+		id    ID
+	}{
+		{"1-2", 0, ID{1, 2}},
+		{"5", 0, ID{5, 0}},
+		{"5", 7, ID{5, 7}},
+		{"-", 0, MinID},
+		{"+", 0, MaxID},
+	}
+	for _, tt := range tests {
+		id, err := ParseID(tt.input, tt.seq)
+		if err != nil || id != tt.id {
+			t.Errorf("%s: expected %s, got %s %v", tt.input, tt.id, id, err)
+		}
+	}
+	for _, input := range []string{"", "a", "1-", "-1", "1-a", "1-2-3"} {
+		if _, err := ParseID(input, 0); err == nil {
+			t.Errorf("%q should be invalid", input)
+		}
+	}
+	if id, ok := (ID{1, ^uint64(0)}).Incr(); !ok || id != (ID{2, 0}) {
+		t.Errorf("unexpected incr result %s", id)
+	}
+	if _, ok := MaxID.Incr(); ok {
+		t.Error("max id should not be incremented")
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	s := makeStream(250)
+	if s.Len() != 250 || len(s.nodes) != 3 {
+		t.Fatalf("expected 250 entries in 3 nodes, got %d in %d", s.Len(), len(s.nodes))
+	}
+	entries := s.Range(ID{Ms: 95}, ID{Ms: 105}, 0, false)
+	if len(entries) != 11 || entries[0].ID.Ms != 95 || entries[10].ID.Ms != 105 {
+		t.Fatalf("unexpected range result %v", entries)
+	}
+	entries = s.Range(ID{Ms: 95}, ID{Ms: 105}, 3, true)
+	if len(entries) != 3 || entries[0].ID.Ms != 105 || entries[2].ID.Ms != 103 {
+		t.Fatalf("unexpected reverse range result %v", entries)
+	}
+	entries = s.Range(MinID, MaxID, 0, true)
+	if len(entries) != 250 || entries[0].ID.Ms != 250 || entries[249].ID.Ms != 1 {
+		t.Fatalf("unexpected reverse range result, length %d", len(entries))
+	}
+	if entries = s.Range(ID{Ms: 300}, MaxID, 0, false); len(entries) != 0 {
+		t.Fatalf("expected empty range, got %d", len(entries))
+	}
+	if entries = s.Range(MinID, ID{Ms: 0, Seq: 5}, 0, true); len(entries) != 0 {
+		t.Fatalf("expected empty range, got %d", len(entries))
+	}
+	if s.Get(ID{Ms: 150}) == nil || s.Get(ID{Ms: 150, Seq: 1}) != nil {
+		t.Fatal("unexpected get result")
+	}
+}
+
+func TestStreamDelete(t *testing.T) {
+	s := makeStream(150)
+	for i := 1; i <= 100; i++ {
+		if !s.Delete(ID{Ms: uint64(i)}) {
+			t.Fatalf("delete %d failed", i)
+		}
+	}
+	if s.Delete(ID{Ms: 1}) {
+		t.Fatal("deleted entry should not be deleted again")
+	}
+	if s.Len() != 50 || len(s.nodes) != 1 || s.FirstID() != (ID{Ms: 101}) {
+		t.Fatalf("unexpected stream after delete, length %d, nodes %d", s.Len(), len(s.nodes))
+	}
+	if s.MaxDeletedID() != (ID{Ms: 100}) || s.LastID() != (ID{Ms: 150}) || s.EntriesAdded() != 150 {
+		t.Fatal("unexpected stream metadata after delete")
+	}
+	id, _ := s.NextID(1)
+	if id != (ID{Ms: 150, Seq: 1}) {
+		t.Fatalf("unexpected next id %s", id)
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	s := makeStream(250)
+	// 近似删除只删除整个节点
+	if removed := s.TrimByMaxLen(180, true, 0); removed != 0 {
+		t.Fatalf("expected 0 removed, got %d", removed)
+	}
+	if removed := s.TrimByMaxLen(140, true, 0); removed != 100 || s.Len() != 150 {
+		t.Fatalf("expected 100 removed, got %d", removed)
+	}
+	if removed := s.TrimByMaxLen(140, false, 0); removed != 10 || s.Len() != 140 {
+		t.Fatalf("expected 10 removed, got %d", removed)
+	}
+	if removed := s.TrimByMinID(ID{Ms: 200}, false, 0); removed != 89 || s.FirstID() != (ID{Ms: 200}) {
+		t.Fatalf("expected 89 removed, got %d", removed)
+	}
+	s = makeStream(250)
+	if removed := s.TrimByMinID(ID{Ms: 250}, true, 150); removed != 100 {
+		t.Fatalf("expected 100 removed with limit, got %d", removed)
+	}
+}
+
+func TestGroupPending(t *testing.T) {
+	s := makeStream(10)
+	g := s.CreateGroup("g", MinID, 0)
+	if g == nil || s.CreateGroup("g", MinID, 0) != nil {
+		t.Fatal("unexpected create group result")
+	}
+	alice := g.CreateConsumer("alice", 0)
+	bob := g.CreateConsumer("bob", 0)
+	for _, id := range []uint64{5, 1, 3} {
+		g.AddPending(ID{Ms: id}, alice)
+		s.Deliver(g, ID{Ms: id})
+	}
+	if g.LastID != (ID{Ms: 5}) || g.EntriesRead != 1 {
+		// 只有大于LastID的ID会更新LastID
+		t.Fatalf("unexpected group state %s %d", g.LastID, g.EntriesRead)
+	}
+	g.AddPending(ID{Ms: 3}, bob)
+	if alice.PendingCount() != 2 || bob.PendingCount() != 1 || g.PendingCount() != 3 {
+		t.Fatal("pending entry should be transferred to bob")
+	}
+	ids := make([]uint64, 0)
+	g.Pending(MinID, MaxID, func(pe *PendingEntry) bool {
+		ids = append(ids, pe.ID.Ms)
+		return true
+	})
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 3 || ids[2] != 5 {
+		t.Fatalf("pending entries should be sorted, got %v", ids)
+	}
+	if !g.Ack(ID{Ms: 1}) || g.Ack(ID{Ms: 1}) || alice.PendingCount() != 1 {
+		t.Fatal("unexpected ack result")
+	}
+	if n := g.DeleteConsumer("alice"); n != 1 || g.PendingCount() != 1 || g.GetPending(ID{Ms: 3}) == nil {
+		t.Fatalf("unexpected delete consumer result %d", n)
+	}
+}
+
+func TestGroupLag(t *testing.T) {
+	s := makeStream(10)
+	g := s.CreateGroup("g", MinID, 0)
+	for i := 1; i <= 4; i++ {
+		s.Deliver(g, ID{Ms: uint64(i)})
+	}
+	if lag, ok := s.Lag(g); !ok || lag != 6 {
+		t.Fatalf("expected lag 6, got %d %v", lag, ok)
+	}
+	// 未读的部分有被删除的消息时无法得知lag
+	s.Delete(ID{Ms: 7})
+	if _, ok := s.Lag(g); ok {
+		t.Fatal("lag should be unknown")
+	}
+	for i := 5; i <= 10; i++ {
+		s.Deliver(g, ID{Ms: uint64(i)})
+	}
+	if lag, ok := s.Lag(g); !ok || lag != 0 {
+		t.Fatalf("expected lag 0, got %d %v", lag, ok)
+	}
+}
@@ -4,10 +4,9 @@ go 1.20
 
 require (
 	github.com/duke-git/lancet/v2 v2.3.0
+	github.com/shopspring/decimal v1.3.1
+	github.com/yuin/gopher-lua v1.1.1
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/shopspring/decimal v1.3.1 // indirect
-	golang.org/x/exp v0.0.0-20221208152030-732eee02a75a // indirect
-)
+require golang.org/x/exp v0.0.0-20221208152030-732eee02a75a // indirect
@@ -0,0 +1,15 @@
+package db
+
+import (
+	"time"
+	"zedis/interface/redis"
+)
+
+// DBEngine 是存储引擎的抽象，持久化等模块通过它执行命令，避免直接依赖database包
+type DBEngine interface {
+	Exec(c redis.Connection, cmdLine [][]byte) redis.Reply
+	// ForEach 遍历指定数据库中所有未过期的key，expiration为空表示key没有设置过期时间
+	ForEach(dbIndex int, cb func(key string, entity *DataEntity, expiration *time.Time) bool)
+	// FunctionLibraries 返回所有函数库的源码
+	FunctionLibraries() [][]byte
+}
@@ -2,8 +2,13 @@ package redis
 
 // Connection 表示redis客户端的连接
 type Connection interface {
+	// Write 发送命令的响应，由连接自己的协程调用
 	Write([]byte) (int, error)
+	// Push 发送发布订阅、客户端缓存等推送消息，可以被其他协程调用，不会阻塞
+	Push([]byte)
 	Close() error
+	// 连接断开时关闭，用于释放被阻塞命令挂起的客户端
+	Done() <-chan struct{}
 	RemoteAddr() string
 	SetPassword(string)
 	GetPassword() string
@@ -11,5 +16,47 @@ type Connection interface {
 	SetExceedMaxClients(b bool)
 	CheckExceedMaxClients() bool
 
+	// 当前选择的数据库
+	GetDBIndex() int
+	SelectDB(int)
+
+	// 发布订阅，SubsCount返回订阅的频道和模式总数，大于0时连接处于订阅模式
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	SubsCount() int
+	// IsSubscribed 是否订阅了channel频道，不包括模式
+	IsSubscribed(channel string) bool
+	GetChannels() []string
+	GetPatterns() []string
+
+	// 事务，MULTI之后的命令进入队列，EXEC时一起执行
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	// 命令入队时的错误，存在错误时EXEC会放弃执行事务
+	AddTxError(err error)
+	GetTxErrors() []error
+	// WATCH的key及其版本号: 数据库编号 -> key -> 版本号
+	Watch(dbIndex int, key string, version uint64)
+	GetWatching() map[int]map[string]uint64
+	ClearWatching()
+
+	// 集群模式下执行ASKING后为true，下一条命令可以访问正在导入到本节点的槽
+	SetAsking(bool)
+	IsAsking() bool
+
 	Name() string
+
+	// 连接的唯一ID，从1开始递增
+	ID() int64
+	// 连接使用的RESP协议版本，默认为2，HELLO命令可以切换为3
+	SetProtocol(int)
+	GetProtocol() int
+	// HELLO SETNAME设置的连接名称
+	SetClientName(string)
+	GetClientName() string
 }
@@ -0,0 +1,32 @@
+// Package crc16 实现了Redis集群使用的CRC-16/XMODEM校验算法，用于计算key所在的哈希槽
+package crc16
+
+// xmodemPoly CRC-16/XMODEM的多项式
+const xmodemPoly = 0x1021
+
+var table = makeTable()
+
+func makeTable() [256]uint16 {
+	var t [256]uint16
+	for i := range t {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ xmodemPoly
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// Checksum 计算p的校验和
+func Checksum(p []byte) uint16 {
+	var crc uint16
+	for _, b := range p {
+		crc = crc<<8 ^ table[byte(crc>>8)^b]
+	}
+	return crc
+}
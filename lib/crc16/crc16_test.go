@@ -0,0 +1,13 @@
+package crc16
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// Redis集群规范中crc16的测试用例
+	if sum := Checksum([]byte("123456789")); sum != 0x31c3 {
+		t.Fatalf("wrong checksum: %x", sum)
+	}
+	if sum := Checksum(nil); sum != 0 {
+		t.Fatalf("wrong checksum of empty input: %x", sum)
+	}
+}
@@ -0,0 +1,20 @@
+// Package crc64 实现了Redis使用的CRC-64/Jones校验算法，用于RDB文件和DUMP数据的校验和
+package crc64
+
+import "hash/crc64"
+
+// jonesPoly Jones多项式的反射形式
+const jonesPoly = 0x95AC9329AC4BC9B5
+
+var table = crc64.MakeTable(jonesPoly)
+
+// Update 在crc的基础上继续计算p的校验和
+// Redis的CRC64初始值为0且结果不取反，而标准库在计算前后都会取反，因此需要抵消
+func Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, table, p)
+}
+
+// Checksum 计算p的校验和
+func Checksum(p []byte) uint64 {
+	return Update(0, p)
+}
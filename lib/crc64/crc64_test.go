@@ -0,0 +1,15 @@
+package crc64
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// Redis源码中crc64的测试用例
+	if sum := Checksum([]byte("123456789")); sum != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("wrong checksum: %x", sum)
+	}
+	crc := Update(0, []byte("12345"))
+	crc = Update(crc, []byte("6789"))
+	if crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("wrong incremental checksum: %x", crc)
+	}
+}
@@ -0,0 +1,177 @@
+// Package geohash 实现了与Redis相同的52位geohash编码，以及按圆形或矩形范围搜索时需要扫描的geohash区域
+//
+// 经度和纬度各占26位交错排列，经度在奇数位、纬度在偶数位，编码结果作为有序集合的score保存
+// 纬度范围与Web墨卡托投影一致，为[-85.05112878, 85.05112878]
+package geohash
+
+import "math"
+
+const (
+	// StepMax 经度和纬度各自的最大位数，编码共52位
+	StepMax = 26
+
+	LonMin = -180.0
+	LonMax = 180.0
+	LatMin = -85.05112878
+	LatMax = 85.05112878
+
+	// EarthRadius 计算距离时使用的地球半径，单位为米，与Redis相同
+	EarthRadius = 6372797.560856
+	// mercatorMax 墨卡托投影下赤道长度的一半，单位为米
+	mercatorMax = 20037726.37
+)
+
+// Range 经度或纬度的取值范围
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Bits 以step位精度编码的geohash，step为0表示空区域
+type Bits struct {
+	Bits uint64
+	Step uint8
+}
+
+// Area geohash表示的矩形区域
+type Area struct {
+	Longitude Range
+	Latitude  Range
+}
+
+var (
+	lonRange = Range{LonMin, LonMax}
+	latRange = Range{LatMin, LatMax}
+)
+
+// Valid 经纬度是否在可以编码的范围内
+func Valid(lon, lat float64) bool {
+	return lon >= LonMin && lon <= LonMax && lat >= LatMin && lat <= LatMax
+}
+
+// Encode 在给定的经纬度范围内以step位精度编码，经纬度超出范围时返回false
+func Encode(lonRange, latRange Range, lon, lat float64, step uint8) (Bits, bool) {
+	if lon < lonRange.Min || lon > lonRange.Max || lat < latRange.Min || lat > latRange.Max {
+		return Bits{}, false
+	}
+	latOffset := (lat - latRange.Min) / (latRange.Max - latRange.Min)
+	lonOffset := (lon - lonRange.Min) / (lonRange.Max - lonRange.Min)
+	latOffset *= float64(uint64(1) << step)
+	lonOffset *= float64(uint64(1) << step)
+	return Bits{
+		Bits: interleave(uint32(latOffset), uint32(lonOffset)),
+		Step: step,
+	}, true
+}
+
+// EncodeWGS84 以52位精度编码，结果用作有序集合的score
+func EncodeWGS84(lon, lat float64) (uint64, bool) {
+	hash, ok := Encode(lonRange, latRange, lon, lat, StepMax)
+	return hash.Bits, ok
+}
+
+// Decode 返回geohash在给定经纬度范围内表示的区域
+func Decode(lonRange, latRange Range, hash Bits) Area {
+	latBits, lonBits := deinterleave(hash.Bits)
+	scale := float64(uint64(1) << hash.Step)
+	latScale := latRange.Max - latRange.Min
+	lonScale := lonRange.Max - lonRange.Min
+	return Area{
+		Latitude: Range{
+			Min: latRange.Min + (float64(latBits)/scale)*latScale,
+			Max: latRange.Min + ((float64(latBits)+1)/scale)*latScale,
+		},
+		Longitude: Range{
+			Min: lonRange.Min + (float64(lonBits)/scale)*lonScale,
+			Max: lonRange.Min + ((float64(lonBits)+1)/scale)*lonScale,
+		},
+	}
+}
+
+// DecodeWGS84 将52位的score解码为区域中心的经纬度
+func DecodeWGS84(bits uint64) (lon, lat float64) {
+	area := Decode(lonRange, latRange, Bits{Bits: bits, Step: StepMax})
+	lon = math.Min(math.Max((area.Longitude.Min+area.Longitude.Max)/2, LonMin), LonMax)
+	lat = math.Min(math.Max((area.Latitude.Min+area.Latitude.Max)/2, LatMin), LatMax)
+	return lon, lat
+}
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// ToString 将score转换为标准的11位geohash字符串
+// 标准geohash的纬度范围是[-90, 90]，因此需要先解码再重新编码
+func ToString(bits uint64) string {
+	lon, lat := DecodeWGS84(bits)
+	hash, _ := Encode(Range{-180, 180}, Range{-90, 90}, lon, lat, StepMax)
+	buf := make([]byte, 11)
+	for i := 0; i < 11; i++ {
+		idx := uint64(0)
+		// 52位只够10个字符，最后一个字符固定为0
+		if i < 10 {
+			idx = (hash.Bits >> (52 - (i+1)*5)) & 0x1f
+		}
+		buf[i] = base32[idx]
+	}
+	return string(buf)
+}
+
+// interleave 交错两个32位整数，x在偶数位，y在奇数位
+func interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+// deinterleave interleave的逆运算
+func deinterleave(bits uint64) (x, y uint32) {
+	return squash(bits), squash(bits >> 1)
+}
+
+// spread 将32位整数的每一位依次放到64位整数的偶数位上
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// squash spread的逆运算，取出64位整数偶数位上的值
+func squash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
+
+func degToRad(deg float64) float64 {
+	return deg * (math.Pi / 180.0)
+}
+
+func radToDeg(rad float64) float64 {
+	return rad / (math.Pi / 180.0)
+}
+
+// LatDistance 两个纬度之间沿经线的距离，单位为米
+func LatDistance(lat1, lat2 float64) float64 {
+	return EarthRadius * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// Distance 使用半正矢公式计算两点间的球面距离，单位为米
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	lon1r := degToRad(lon1)
+	lon2r := degToRad(lon2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// 经度相同时只需要计算纬度方向的距离
+	if v == 0 {
+		return LatDistance(lat1, lat2)
+	}
+	lat1r := degToRad(lat1)
+	lat2r := degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * EarthRadius * math.Asin(math.Sqrt(a))
+}
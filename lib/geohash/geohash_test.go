@@ -0,0 +1,98 @@
+package geohash
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 以下期望值来自Redis文档中的示例
+
+func TestEncode(t *testing.T) {
+	bits, ok := EncodeWGS84(13.361389, 38.115556)
+	if !ok || bits != 3479099956230698 {
+		t.Fatalf("expected 3479099956230698, actual %d", bits)
+	}
+	lon, lat := DecodeWGS84(bits)
+	if s := fmt.Sprintf("%.17f %.17f", lon, lat); s != "13.36138933897018433 38.11555639549629859" {
+		t.Errorf("wrong position: %s", s)
+	}
+	if _, ok := EncodeWGS84(13, 86); ok {
+		t.Error("latitude out of range should fail")
+	}
+}
+
+func TestToString(t *testing.T) {
+	tests := []struct {
+		lon, lat float64
+		hash     string
+	}{
+		{13.361389, 38.115556, "sqc8b49rny0"},
+		{15.087269, 37.502669, "sqdtr74hyu0"},
+	}
+	for _, tt := range tests {
+		bits, _ := EncodeWGS84(tt.lon, tt.lat)
+		if s := ToString(bits); s != tt.hash {
+			t.Errorf("expected %s, actual %s", tt.hash, s)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	lon1, lat1 := DecodeWGS84(3479099956230698)
+	bits, _ := EncodeWGS84(15.087269, 37.502669)
+	lon2, lat2 := DecodeWGS84(bits)
+	if s := fmt.Sprintf("%.4f", Distance(lon1, lat1, lon2, lat2)); s != "166274.1516" {
+		t.Errorf("expected 166274.1516, actual %s", s)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	hash, _ := Encode(lonRange, latRange, 15, 37, 10)
+	area := Decode(lonRange, latRange, hash)
+	around := neighbors(hash)
+	n := Decode(lonRange, latRange, around[north])
+	if n.Latitude.Min != area.Latitude.Max || n.Longitude != area.Longitude {
+		t.Errorf("north neighbor is not adjacent: %v %v", area, n)
+	}
+	w := Decode(lonRange, latRange, around[west])
+	if w.Longitude.Max != area.Longitude.Min || w.Latitude != area.Latitude {
+		t.Errorf("west neighbor is not adjacent: %v %v", area, w)
+	}
+}
+
+func TestSearchRanges(t *testing.T) {
+	points := [][2]float64{
+		{13.361389, 38.115556},
+		{15.087269, 37.502669},
+		{12.758489, 38.788135},
+		{17.241510, 38.788135},
+	}
+	shapes := []*Shape{
+		{Lon: 15, Lat: 37, Radius: 200 * 1000},
+		{Lon: 15, Lat: 37, IsBox: true, Width: 400 * 1000, Height: 400 * 1000},
+	}
+	expected := []int{2, 4}
+	for i, shape := range shapes {
+		ranges := shape.SearchRanges()
+		found := 0
+		for _, p := range points {
+			bits, _ := EncodeWGS84(p[0], p[1])
+			lon, lat := DecodeWGS84(bits)
+			covered := false
+			for _, r := range ranges {
+				if bits >= r.Min && bits < r.Max {
+					covered = true
+				}
+			}
+			if _, ok := shape.Contains(lon, lat); ok {
+				if !covered {
+					t.Errorf("point %v in shape %d is not covered by search ranges", p, i)
+				}
+				found++
+			}
+		}
+		if found != expected[i] {
+			t.Errorf("shape %d: expected %d points, actual %d", i, expected[i], found)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package geohash
+
+import "math"
+
+// Shape 搜索范围，以(Lon, Lat)为中心，IsBox为false时是半径为Radius的圆，否则是宽Width、高Height的矩形，单位都是米
+type Shape struct {
+	Lon    float64
+	Lat    float64
+	IsBox  bool
+	Radius float64
+	Width  float64
+	Height float64
+}
+
+// BoundingBox 返回包含搜索范围的最小经纬度矩形：最小经度、最小纬度、最大经度、最大纬度
+func (s *Shape) BoundingBox() (minLon, minLat, maxLon, maxLat float64) {
+	height, width := s.Radius, s.Radius
+	if s.IsBox {
+		height, width = s.Height/2, s.Width/2
+	}
+	latDelta := radToDeg(height / EarthRadius)
+	lonDeltaTop := radToDeg(width / EarthRadius / math.Cos(degToRad(s.Lat+latDelta)))
+	lonDeltaBottom := radToDeg(width / EarthRadius / math.Cos(degToRad(s.Lat-latDelta)))
+	// 南北半球中离赤道较远的一边经度跨度更大
+	if s.Lat < 0 {
+		minLon, maxLon = s.Lon-lonDeltaBottom, s.Lon+lonDeltaBottom
+	} else {
+		minLon, maxLon = s.Lon-lonDeltaTop, s.Lon+lonDeltaTop
+	}
+	return minLon, s.Lat - latDelta, maxLon, s.Lat + latDelta
+}
+
+// Contains 判断点是否在搜索范围内，在范围内时返回点到中心的距离
+func (s *Shape) Contains(lon, lat float64) (float64, bool) {
+	if !s.IsBox {
+		distance := Distance(s.Lon, s.Lat, lon, lat)
+		return distance, distance <= s.Radius
+	}
+	// 纬度方向的距离计算代价较小，先检查纬度
+	if LatDistance(lat, s.Lat) > s.Height/2 {
+		return 0, false
+	}
+	if Distance(lon, lat, s.Lon, lat) > s.Width/2 {
+		return 0, false
+	}
+	return Distance(s.Lon, s.Lat, lon, lat), true
+}
+
+// estimateSteps 根据搜索半径估算geohash的精度，使中心所在区域及其周围8个区域能够覆盖搜索范围
+func estimateSteps(rangeMeters, lat float64) uint8 {
+	if rangeMeters == 0 {
+		return StepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2
+	// 越靠近两极，相同经度跨度的距离越小，需要更大的区域
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > StepMax {
+		step = StepMax
+	}
+	return uint8(step)
+}
+
+func moveX(hash Bits, d int) Bits {
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.Step*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.Step*2)
+	return Bits{Bits: x | y, Step: hash.Step}
+}
+
+func moveY(hash Bits, d int) Bits {
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.Step*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= 0x5555555555555555 >> (64 - hash.Step*2)
+	return Bits{Bits: x | y, Step: hash.Step}
+}
+
+// 周围8个区域在neighbors中的下标
+const (
+	north = iota
+	south
+	east
+	west
+	northEast
+	northWest
+	southEast
+	southWest
+)
+
+// neighbors 返回周围的8个区域，经度方向超出范围时绕回另一侧
+func neighbors(hash Bits) [8]Bits {
+	var n [8]Bits
+	n[north] = moveY(hash, 1)
+	n[south] = moveY(hash, -1)
+	n[east] = moveX(hash, 1)
+	n[west] = moveX(hash, -1)
+	n[northEast] = moveY(moveX(hash, 1), 1)
+	n[northWest] = moveY(moveX(hash, -1), 1)
+	n[southEast] = moveY(moveX(hash, 1), -1)
+	n[southWest] = moveY(moveX(hash, -1), -1)
+	return n
+}
+
+// ScoreRange 有序集合中score的范围[Min, Max)
+type ScoreRange struct {
+	Min uint64
+	Max uint64
+}
+
+// SearchRanges 返回需要扫描的score范围，依次为中心区域和北、南、东、西、东北、西北、东南、西南的相邻区域
+// 与搜索范围不相交的相邻区域被排除，与前一个区域相同的区域只扫描一次
+func (s *Shape) SearchRanges() []ScoreRange {
+	minLon, minLat, maxLon, maxLat := s.BoundingBox()
+	radius := s.Radius
+	if s.IsBox {
+		radius = math.Sqrt((s.Width/2)*(s.Width/2) + (s.Height/2)*(s.Height/2))
+	}
+	steps := estimateSteps(radius, s.Lat)
+
+	hash, _ := Encode(lonRange, latRange, s.Lon, s.Lat, steps)
+	around := neighbors(hash)
+	area := Decode(lonRange, latRange, hash)
+
+	// 估算的精度在搜索范围靠近区域边界时可能不够，此时降低一级精度
+	if steps > 1 &&
+		(Decode(lonRange, latRange, around[north]).Latitude.Max < maxLat ||
+			Decode(lonRange, latRange, around[south]).Latitude.Min > minLat ||
+			Decode(lonRange, latRange, around[east]).Longitude.Max < maxLon ||
+			Decode(lonRange, latRange, around[west]).Longitude.Min > minLon) {
+		steps--
+		hash, _ = Encode(lonRange, latRange, s.Lon, s.Lat, steps)
+		around = neighbors(hash)
+		area = Decode(lonRange, latRange, hash)
+	}
+
+	// 排除不可能包含结果的相邻区域
+	if steps >= 2 {
+		if area.Latitude.Min < minLat {
+			around[south], around[southWest], around[southEast] = Bits{}, Bits{}, Bits{}
+		}
+		if area.Latitude.Max > maxLat {
+			around[north], around[northEast], around[northWest] = Bits{}, Bits{}, Bits{}
+		}
+		if area.Longitude.Min < minLon {
+			around[west], around[southWest], around[northWest] = Bits{}, Bits{}, Bits{}
+		}
+		if area.Longitude.Max > maxLon {
+			around[east], around[southEast], around[northEast] = Bits{}, Bits{}, Bits{}
+		}
+	}
+
+	boxes := append([]Bits{hash}, around[:]...)
+	ranges := make([]ScoreRange, 0, len(boxes))
+	last := -1
+	for i, box := range boxes {
+		if box.Step == 0 && box.Bits == 0 {
+			continue
+		}
+		// 半径很大时相邻区域可能相同
+		if last >= 0 && box == boxes[last] {
+			continue
+		}
+		shift := 52 - box.Step*2
+		ranges = append(ranges, ScoreRange{
+			Min: box.Bits << shift,
+			Max: (box.Bits + 1) << shift,
+		})
+		last = i
+	}
+	return ranges
+}
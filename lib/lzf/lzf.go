@@ -0,0 +1,160 @@
+// Package lzf 实现了LZF压缩算法，与Redis RDB文件中使用的liblzf格式兼容
+package lzf
+
+import "errors"
+
+const (
+	hashLog  = 14
+	hashSize = 1 << hashLog
+	// 回溯引用的最大偏移量
+	maxOffset = 1 << 13
+	// 单次回溯引用的最大长度
+	maxRef = (1 << 8) + (1 << 3)
+	// 单段字面量的最大长度
+	maxLiteral = 1 << 5
+)
+
+// ErrCorrupted 压缩数据已损坏
+var ErrCorrupted = errors.New("lzf: corrupted data")
+
+func hash(in []byte, i int) int {
+	v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+	return int(((v >> (3*8 - hashLog)) - v*5) & (hashSize - 1))
+}
+
+// Compress 压缩数据，如果压缩后长度不小于原长度，返回nil
+func Compress(in []byte) []byte {
+	if len(in) < 4 {
+		return nil
+	}
+	var table [hashSize]int
+	out := make([]byte, 0, len(in))
+	// 当前字面量段的长度和控制字节位置
+	lit := 0
+	litPos := len(out)
+	out = append(out, 0)
+
+	ip := 0
+	for ip+2 < len(in) {
+		h := hash(in, ip)
+		ref := table[h] - 1
+		table[h] = ip + 1
+		off := ip - ref - 1
+		if ref >= 0 && off < maxOffset &&
+			in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+			maxLen := len(in) - ip
+			if maxLen > maxRef {
+				maxLen = maxRef
+			}
+			length := 3
+			for length < maxLen && in[ref+length] == in[ip+length] {
+				length++
+			}
+
+			// 结束当前字面量段
+			if lit == 0 {
+				out = out[:litPos]
+			} else {
+				out[litPos] = byte(lit - 1)
+			}
+
+			l := length - 2
+			if l < 7 {
+				out = append(out, byte(l<<5|off>>8))
+			} else {
+				out = append(out, byte(7<<5|off>>8), byte(l-7))
+			}
+			out = append(out, byte(off))
+			if len(out) >= len(in) {
+				return nil
+			}
+
+			ip += length
+			lit = 0
+			litPos = len(out)
+			out = append(out, 0)
+			continue
+		}
+
+		out = append(out, in[ip])
+		ip++
+		lit++
+		if lit == maxLiteral {
+			out[litPos] = maxLiteral - 1
+			lit = 0
+			litPos = len(out)
+			out = append(out, 0)
+		}
+		if len(out) >= len(in) {
+			return nil
+		}
+	}
+
+	for ip < len(in) {
+		out = append(out, in[ip])
+		ip++
+		lit++
+		if lit == maxLiteral {
+			out[litPos] = maxLiteral - 1
+			lit = 0
+			litPos = len(out)
+			out = append(out, 0)
+		}
+	}
+	if lit == 0 {
+		out = out[:litPos]
+	} else {
+		out[litPos] = byte(lit - 1)
+	}
+	if len(out) >= len(in) {
+		return nil
+	}
+	return out
+}
+
+// Decompress 解压数据，outLen为解压后的长度
+func Decompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	ip := 0
+	for ip < len(in) {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < maxLiteral {
+			// 字面量段
+			length := ctrl + 1
+			if ip+length > len(in) || len(out)+length > outLen {
+				return nil, ErrCorrupted
+			}
+			out = append(out, in[ip:ip+length]...)
+			ip += length
+			continue
+		}
+
+		// 回溯引用
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, ErrCorrupted
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, ErrCorrupted
+		}
+		ref := len(out) - ((ctrl & 0x1f) << 8) - 1 - int(in[ip])
+		ip++
+		length += 2
+		if ref < 0 || len(out)+length > outLen {
+			return nil, ErrCorrupted
+		}
+		// 引用区间可能与输出重叠，需要逐字节复制
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != outLen {
+		return nil, ErrCorrupted
+	}
+	return out, nil
+}
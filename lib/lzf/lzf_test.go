@@ -0,0 +1,48 @@
+package lzf
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCompressAndDecompress(t *testing.T) {
+	inputs := [][]byte{
+		[]byte(strings.Repeat("a", 1000)),
+		[]byte(strings.Repeat("hello world ", 100)),
+		[]byte("abcabcabcabcabcabcabcabcabcabcabcabcabcabcabcabcabcabc"),
+	}
+	random := make([]byte, 4096)
+	for i := range random {
+		random[i] = byte('a' + rand.Intn(4))
+	}
+	inputs = append(inputs, random)
+
+	for _, in := range inputs {
+		compressed := Compress(in)
+		if compressed == nil {
+			t.Fatalf("expected %q to be compressible", in[:16])
+		}
+		out, err := Decompress(compressed, len(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(in, out) {
+			t.Fatalf("decompressed data mismatch")
+		}
+	}
+}
+
+func TestIncompressible(t *testing.T) {
+	if Compress([]byte("abcdefg")) != nil {
+		t.Fatal("short input without repetition should not be compressed")
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+	// 回溯引用指向输出之前的位置
+	if _, err := Decompress([]byte{0x20, 0x10}, 3); err == nil {
+		t.Fatal("expected error for corrupted data")
+	}
+}
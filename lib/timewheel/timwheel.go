@@ -138,7 +138,8 @@ func (tw *TimeWheel) tickHandler() {
 	} else {
 		tw.currentSlotPos += 1
 	}
-	go tw.scanAndRunTasks(l)
+	// 在时间轮的协程中扫描，与添加、移除任务互斥；任务在新的协程中执行，可以在任务中重新添加同一个key的任务
+	tw.scanAndRunTasks(l)
 }
 
 func (tw *TimeWheel) scanAndRunTasks(l *list.List) {
@@ -9,13 +9,6 @@ import (
 	"zedis/tcp"
 )
 
-var defaultConfig = &config.ServerConfig{
-	RunId:      config.GenRandomRunID(40),
-	Bind:       "0.0.0.0",
-	Port:       6379,
-	MaxClients: 100,
-}
-
 var banner = `
    ______          ___
       / /___  ____/ (_)____
@@ -38,15 +31,29 @@ func main() {
 		TimeFormat: "2006-01-02",
 	})
 
-	if fileExists("redis.yaml") {
-		config.SetupConfig("redis.yaml")
+	// zedis [配置文件] [--sentinel]，默认使用当前目录下的redis.yaml
+	configFile, sentinelMode := "redis.yaml", false
+	for _, arg := range os.Args[1:] {
+		if arg == "--sentinel" {
+			sentinelMode = true
+		} else {
+			configFile = arg
+		}
+	}
+	if fileExists(configFile) {
+		config.SetupConfig(configFile)
 	} else {
-		config.Config = defaultConfig
+		// 没有配置文件时使用默认配置，监听所有网卡
+		config.Config.Bind = "0.0.0.0"
 	}
 
+	handler := redisServer.NewHandler
+	if sentinelMode {
+		handler = redisServer.NewSentinelHandler
+	}
 	err := tcp.ListenAndServeWithSignal(&tcp.Config{
 		Address: fmt.Sprintf("%s:%d", config.Config.Bind, config.Config.Port),
-	}, redisServer.NewHandler())
+	}, handler())
 	if err != nil {
 		logger.Error(err)
 	}
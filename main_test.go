@@ -25,7 +25,7 @@ func TestPing(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	_, err = conn.Write([]byte("测试其他\r\n"))
+	_, err = conn.Write([]byte("PING\r\n"))
 	if err != nil {
 		t.Error(err)
 		return
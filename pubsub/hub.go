@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"sync"
+	"zedis/interface/redis"
+	"zedis/lib/wildcard"
+)
+
+// Hub 保存所有频道和模式的订阅关系
+type Hub struct {
+	mu sync.RWMutex
+	// 频道 -> 订阅该频道的连接
+	channels map[string]map[redis.Connection]struct{}
+	// 模式 -> 订阅该模式的连接
+	patterns map[string]*patternSubscribers
+}
+
+// patternSubscribers 表示订阅同一个模式的所有连接
+type patternSubscribers struct {
+	// 模式不合法时为nil，不匹配任何频道
+	pattern *wildcard.Pattern
+	conns   map[redis.Connection]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		channels: make(map[string]map[redis.Connection]struct{}),
+		patterns: make(map[string]*patternSubscribers),
+	}
+}
+
+// subscribe 订阅频道，已经订阅过时返回false
+func (h *Hub) subscribe(c redis.Connection, channel string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conns, ok := h.channels[channel]
+	if !ok {
+		conns = make(map[redis.Connection]struct{})
+		h.channels[channel] = conns
+	}
+	if _, exists := conns[c]; exists {
+		return false
+	}
+	conns[c] = struct{}{}
+	return true
+}
+
+// unsubscribe 取消订阅频道，没有订阅过时返回false
+func (h *Hub) unsubscribe(c redis.Connection, channel string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conns, ok := h.channels[channel]
+	if !ok {
+		return false
+	}
+	if _, exists := conns[c]; !exists {
+		return false
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.channels, channel)
+	}
+	return true
+}
+
+// psubscribe 订阅模式，已经订阅过时返回false
+func (h *Hub) psubscribe(c redis.Connection, pattern string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.patterns[pattern]
+	if !ok {
+		compiled, _ := wildcard.CompilePattern(pattern)
+		subs = &patternSubscribers{
+			pattern: compiled,
+			conns:   make(map[redis.Connection]struct{}),
+		}
+		h.patterns[pattern] = subs
+	}
+	if _, exists := subs.conns[c]; exists {
+		return false
+	}
+	subs.conns[c] = struct{}{}
+	return true
+}
+
+// punsubscribe 取消订阅模式，没有订阅过时返回false
+func (h *Hub) punsubscribe(c redis.Connection, pattern string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.patterns[pattern]
+	if !ok {
+		return false
+	}
+	if _, exists := subs.conns[c]; !exists {
+		return false
+	}
+	delete(subs.conns, c)
+	if len(subs.conns) == 0 {
+		delete(h.patterns, pattern)
+	}
+	return true
+}
+
+// patternMatch 表示一个匹配到频道的模式订阅者
+type patternMatch struct {
+	pattern string
+	conn    redis.Connection
+}
+
+// receivers 返回频道的订阅者和匹配该频道的模式订阅者
+func (h *Hub) receivers(channel string) ([]redis.Connection, []patternMatch) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	conns := make([]redis.Connection, 0, len(h.channels[channel]))
+	for c := range h.channels[channel] {
+		conns = append(conns, c)
+	}
+	matches := make([]patternMatch, 0)
+	for pattern, subs := range h.patterns {
+		if subs.pattern == nil || !subs.pattern.IsMatch(channel) {
+			continue
+		}
+		for c := range subs.conns {
+			matches = append(matches, patternMatch{pattern: pattern, conn: c})
+		}
+	}
+	return conns, matches
+}
+
+// activeChannels 返回至少有一个订阅者、且匹配pattern的频道，pattern为nil时返回所有频道
+func (h *Hub) activeChannels(pattern *wildcard.Pattern) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	channels := make([]string, 0, len(h.channels))
+	for channel := range h.channels {
+		if pattern == nil || pattern.IsMatch(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// numSub 返回频道的订阅者数量
+func (h *Hub) numSub(channel string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.channels[channel])
+}
+
+// numPat 返回被订阅的模式数量
+func (h *Hub) numPat() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.patterns)
+}
@@ -0,0 +1,212 @@
+package pubsub
+
+import (
+	"strings"
+	"zedis/interface/redis"
+	"zedis/lib/wildcard"
+	"zedis/redis/protocol"
+)
+
+var (
+	subscribeBytes    = []byte("subscribe")
+	unsubscribeBytes  = []byte("unsubscribe")
+	psubscribeBytes   = []byte("psubscribe")
+	punsubscribeBytes = []byte("punsubscribe")
+	messageBytes      = []byte("message")
+	pmessageBytes     = []byte("pmessage")
+)
+
+// makeSubsReply 生成订阅、取消订阅的响应: [kind, channel, 订阅总数]，RESP3下为推送消息
+func makeSubsReply(c redis.Connection, kind []byte, channel []byte) []byte {
+	var channelReply redis.Reply = protocol.NullBulkReply
+	if channel != nil {
+		channelReply = protocol.NewBulkReply(channel)
+	}
+	return protocol.Encode(protocol.NewPushReply([]redis.Reply{
+		protocol.NewBulkReply(kind),
+		channelReply,
+		protocol.NewIntReply(int64(c.SubsCount())),
+	}), c.GetProtocol())
+}
+
+// Subscribe 订阅频道，每个频道的响应都直接写入连接
+// SUBSCRIBE channel [channel ...]
+func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("subscribe")
+	}
+	for _, arg := range args {
+		channel := string(arg)
+		if hub.subscribe(c, channel) {
+			c.Subscribe(channel)
+		}
+		_, _ = c.Write(makeSubsReply(c, subscribeBytes, arg))
+	}
+	return protocol.NoReply
+}
+
+// UnSubscribe 取消订阅频道，不指定频道时取消订阅所有频道
+// UNSUBSCRIBE [channel [channel ...]]
+func UnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	channels := make([]string, 0, len(args))
+	if len(args) == 0 {
+		channels = c.GetChannels()
+	} else {
+		for _, arg := range args {
+			channels = append(channels, string(arg))
+		}
+	}
+	if len(channels) == 0 {
+		_, _ = c.Write(makeSubsReply(c, unsubscribeBytes, nil))
+		return protocol.NoReply
+	}
+	for _, channel := range channels {
+		if hub.unsubscribe(c, channel) {
+			c.UnSubscribe(channel)
+		}
+		_, _ = c.Write(makeSubsReply(c, unsubscribeBytes, []byte(channel)))
+	}
+	return protocol.NoReply
+}
+
+// PSubscribe 订阅模式，模式使用glob风格的通配符
+// PSUBSCRIBE pattern [pattern ...]
+func PSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("psubscribe")
+	}
+	for _, arg := range args {
+		pattern := string(arg)
+		if hub.psubscribe(c, pattern) {
+			c.PSubscribe(pattern)
+		}
+		_, _ = c.Write(makeSubsReply(c, psubscribeBytes, arg))
+	}
+	return protocol.NoReply
+}
+
+// PUnSubscribe 取消订阅模式，不指定模式时取消订阅所有模式
+// PUNSUBSCRIBE [pattern [pattern ...]]
+func PUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	patterns := make([]string, 0, len(args))
+	if len(args) == 0 {
+		patterns = c.GetPatterns()
+	} else {
+		for _, arg := range args {
+			patterns = append(patterns, string(arg))
+		}
+	}
+	if len(patterns) == 0 {
+		_, _ = c.Write(makeSubsReply(c, punsubscribeBytes, nil))
+		return protocol.NoReply
+	}
+	for _, pattern := range patterns {
+		if hub.punsubscribe(c, pattern) {
+			c.PUnSubscribe(pattern)
+		}
+		_, _ = c.Write(makeSubsReply(c, punsubscribeBytes, []byte(pattern)))
+	}
+	return protocol.NoReply
+}
+
+// UnsubscribeAll 取消连接的所有订阅，在连接关闭时调用
+func UnsubscribeAll(hub *Hub, c redis.Connection) {
+	for _, channel := range c.GetChannels() {
+		hub.unsubscribe(c, channel)
+		c.UnSubscribe(channel)
+	}
+	for _, pattern := range c.GetPatterns() {
+		hub.punsubscribe(c, pattern)
+		c.PUnSubscribe(pattern)
+	}
+}
+
+// Publish 向频道发布消息，消息异步推送给订阅者，返回接收到消息的客户端数量
+// PUBLISH channel message
+func Publish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.NewArgNumErrReply("publish")
+	}
+	channel, message := args[0], args[1]
+	conns, matches := hub.receivers(string(channel))
+	if len(conns) > 0 {
+		msg := protocol.NewPushReply(protocol.BulkReplies([][]byte{messageBytes, channel, message}))
+		for _, c := range conns {
+			c.Push(protocol.Encode(msg, c.GetProtocol()))
+		}
+	}
+	for _, match := range matches {
+		msg := protocol.NewPushReply(protocol.BulkReplies([][]byte{pmessageBytes, []byte(match.pattern), channel, message}))
+		match.conn.Push(protocol.Encode(msg, match.conn.GetProtocol()))
+	}
+	return protocol.NewIntReply(int64(len(conns) + len(matches)))
+}
+
+// PubSub 查看订阅状态
+// PUBSUB CHANNELS [pattern]
+// PUBSUB NUMSUB [channel [channel ...]]
+// PUBSUB NUMPAT
+func PubSub(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "channels":
+		if len(args) > 2 {
+			return protocol.NewArgNumErrReply("pubsub|channels")
+		}
+		var pattern *wildcard.Pattern
+		if len(args) == 2 {
+			var err error
+			pattern, err = wildcard.CompilePattern(string(args[1]))
+			if err != nil {
+				return protocol.EmptyMultiBulkReply
+			}
+		}
+		channels := hub.activeChannels(pattern)
+		result := make([][]byte, 0, len(channels))
+		for _, channel := range channels {
+			result = append(result, []byte(channel))
+		}
+		return protocol.NewMultiBulkReply(result)
+	case "numsub":
+		replies := make([]redis.Reply, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			replies = append(replies,
+				protocol.NewBulkReply(arg),
+				protocol.NewIntReply(int64(hub.numSub(string(arg)))))
+		}
+		return protocol.NewArrayReply(replies)
+	case "numpat":
+		if len(args) != 1 {
+			return protocol.NewArgNumErrReply("pubsub|numpat")
+		}
+		return protocol.NewIntReply(int64(hub.numPat()))
+	}
+	return protocol.NewErrorReply("ERR unknown subcommand '" + subCmd + "'. Try PUBSUB HELP.")
+}
+
+// IsAllowedInSubscribeMode 判断处于订阅模式的连接能否执行该命令
+func IsAllowedInSubscribeMode(cmdName string) bool {
+	switch cmdName {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ping":
+		return true
+	}
+	return false
+}
+
+// MakeSubscribeModeErrReply 订阅模式下执行其他命令时的错误
+func MakeSubscribeModeErrReply(cmdName string) redis.Reply {
+	return protocol.NewErrorReply("ERR Can't execute '" + cmdName +
+		"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+}
+
+// MakePingReply 订阅模式下PING的响应为 [pong, message]
+func MakePingReply(args [][]byte) redis.Reply {
+	message := []byte("")
+	if len(args) > 0 {
+		message = args[0]
+	}
+	return protocol.NewMultiBulkReply([][]byte{[]byte("pong"), message})
+}
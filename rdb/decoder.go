@@ -0,0 +1,472 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"zedis/lib/crc64"
+	"zedis/lib/lzf"
+)
+
+// crcReader 读取数据的同时计算校验和
+type crcReader struct {
+	reader io.Reader
+	crc    uint64
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.crc = crc64.Update(r.crc, p[:n])
+	return n, err
+}
+
+// Decoder 从io.Reader中解析RDB格式的数据
+type Decoder struct {
+	reader  *crcReader
+	version int
+	buf     [8]byte
+}
+
+// NewDecoder 创建Decoder
+func NewDecoder(reader io.Reader) *Decoder {
+	return &Decoder{
+		reader: &crcReader{
+			reader: bufio.NewReader(reader),
+		},
+	}
+}
+
+func (d *Decoder) readFull(p []byte) error {
+	_, err := io.ReadFull(d.reader, p)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	err := d.readFull(d.buf[:1])
+	return d.buf[0], err
+}
+
+// readLength 读取长度编码，如果是字符串的特殊编码，第二个返回值为true，长度为编码类型
+func (d *Decoder) readLength() (uint64, bool, error) {
+	first, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case lenEncVal:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		if err := d.readFull(d.buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(d.buf[:4])), false, nil
+	case len64Bit:
+		if err := d.readFull(d.buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(d.buf[:8]), false, nil
+	}
+	return 0, false, fmt.Errorf("rdb: unknown length encoding %x", first)
+}
+
+// readLen 读取普通的长度编码
+func (d *Decoder) readLen() (int, error) {
+	length, special, err := d.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if special {
+		return 0, errors.New("rdb: unexpected string encoding")
+	}
+	return int(length), nil
+}
+
+// readString 读取字符串，包括整数编码和LZF压缩的字符串
+func (d *Decoder) readString() ([]byte, error) {
+	length, special, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !special {
+		s := make([]byte, length)
+		return s, d.readFull(s)
+	}
+	switch length {
+	case encInt8:
+		b, err := d.readByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case encInt16:
+		if err := d.readFull(d.buf[:2]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(d.buf[:2]))))), nil
+	case encInt32:
+		if err := d.readFull(d.buf[:4]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(d.buf[:4]))))), nil
+	case encLZF:
+		compressedLen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		compressed := make([]byte, compressedLen)
+		if err := d.readFull(compressed); err != nil {
+			return nil, err
+		}
+		return lzf.Decompress(compressed, rawLen)
+	}
+	return nil, fmt.Errorf("rdb: unknown string encoding %d", length)
+}
+
+// readFloat 读取RDB_TYPE_ZSET中以字符串保存的score
+func (d *Decoder) readFloat() (float64, error) {
+	length, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	s := make([]byte, length)
+	if err := d.readFull(s); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(s), 64)
+}
+
+// readBinaryFloat 读取8字节小端序的double
+func (d *Decoder) readBinaryFloat() (float64, error) {
+	if err := d.readFull(d.buf[:8]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:8])), nil
+}
+
+// Parse 解析RDB数据，每读取到一个键值对或函数库调用一次cb，cb返回false时停止解析
+func (d *Decoder) Parse(cb func(object *Object) bool) error {
+	header := make([]byte, 9)
+	if err := d.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:5]) != magic {
+		return errors.New("rdb: wrong signature")
+	}
+	ver, err := strconv.Atoi(string(header[5:]))
+	if err != nil || ver < 1 || ver > maxVersion {
+		return fmt.Errorf("rdb: can't handle RDB format version %s", header[5:])
+	}
+	d.version = ver
+
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		opCode, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case opCodeEOF:
+			return d.verifyChecksum()
+		case opCodeSelectDB:
+			if dbIndex, err = d.readLen(); err != nil {
+				return err
+			}
+		case opCodeResizeDB:
+			if _, err = d.readLen(); err != nil {
+				return err
+			}
+			if _, err = d.readLen(); err != nil {
+				return err
+			}
+		case opCodeAux:
+			if _, err = d.readString(); err != nil {
+				return err
+			}
+			if _, err = d.readString(); err != nil {
+				return err
+			}
+		case opCodeExpireTimeMs:
+			if err = d.readFull(d.buf[:8]); err != nil {
+				return err
+			}
+			at := time.UnixMilli(int64(binary.LittleEndian.Uint64(d.buf[:8])))
+			expiration = &at
+		case opCodeExpireTime:
+			if err = d.readFull(d.buf[:4]); err != nil {
+				return err
+			}
+			at := time.Unix(int64(binary.LittleEndian.Uint32(d.buf[:4])), 0)
+			expiration = &at
+		case opCodeIdle:
+			if _, err = d.readLen(); err != nil {
+				return err
+			}
+		case opCodeFreq:
+			if _, err = d.readByte(); err != nil {
+				return err
+			}
+		case opCodeFunction2:
+			code, err := d.readString()
+			if err != nil {
+				return err
+			}
+			if !cb(&Object{Type: FunctionObject, Value: code}) {
+				return nil
+			}
+		case opCodeSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err = d.readLen(); err != nil {
+					return err
+				}
+			}
+		case opCodeModuleAux:
+			return errors.New("rdb: module data is not supported")
+		default:
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+			object, err := d.readObject(opCode)
+			if err != nil {
+				return fmt.Errorf("rdb: read key %s failed: %v", key, err)
+			}
+			object.DBIndex = dbIndex
+			object.Key = string(key)
+			object.Expiration = expiration
+			expiration = nil
+			if !cb(object) {
+				return nil
+			}
+		}
+	}
+}
+
+// verifyChecksum 校验文件末尾的校验和，校验和为0表示未开启校验
+func (d *Decoder) verifyChecksum() error {
+	if d.version < 5 {
+		return nil
+	}
+	expected := d.reader.crc
+	if err := d.readFull(d.buf[:8]); err != nil {
+		return err
+	}
+	checksum := binary.LittleEndian.Uint64(d.buf[:8])
+	if checksum != 0 && checksum != expected {
+		return errors.New("rdb: wrong checksum")
+	}
+	return nil
+}
+
+func (d *Decoder) readObject(objType byte) (*Object, error) {
+	switch objType {
+	case typeString:
+		value, err := d.readString()
+		return &Object{Type: StringObject, Value: value}, err
+	case typeList:
+		values, err := d.readStrings(1)
+		return &Object{Type: ListObject, Value: values}, err
+	case typeSet:
+		members, err := d.readStrings(1)
+		return &Object{Type: SetObject, Value: members}, err
+	case typeZSet, typeZSet2:
+		entries, err := d.readZSet(objType == typeZSet2)
+		return &Object{Type: ZSetObject, Value: entries}, err
+	case typeHash:
+		values, err := d.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return &Object{Type: HashObject, Value: pairsToHash(values)}, nil
+	case typeListZiplist:
+		values, err := d.readEncoded(parseZiplist)
+		return &Object{Type: ListObject, Value: values}, err
+	case typeSetIntset:
+		members, err := d.readEncoded(parseIntset)
+		return &Object{Type: SetObject, Value: members}, err
+	case typeSetListpack:
+		members, err := d.readEncoded(parseListpack)
+		return &Object{Type: SetObject, Value: members}, err
+	case typeZSetZiplist, typeZSetListpack:
+		parse := parseZiplist
+		if objType == typeZSetListpack {
+			parse = parseListpack
+		}
+		values, err := d.readEncoded(parse)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := pairsToZSet(values)
+		return &Object{Type: ZSetObject, Value: entries}, err
+	case typeHashZiplist, typeHashListpack:
+		parse := parseZiplist
+		if objType == typeHashListpack {
+			parse = parseListpack
+		}
+		values, err := d.readEncoded(parse)
+		if err != nil {
+			return nil, err
+		}
+		if len(values)%2 != 0 {
+			return nil, errors.New("odd number of hash elements")
+		}
+		return &Object{Type: HashObject, Value: pairsToHash(values)}, nil
+	case typeListQuicklist, typeListQuicklist2:
+		values, err := d.readQuicklist(objType == typeListQuicklist2)
+		return &Object{Type: ListObject, Value: values}, err
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		version := 1
+		if objType == typeStreamListpacks2 {
+			version = 2
+		} else if objType == typeStreamListpacks3 {
+			version = 3
+		}
+		stream, err := d.readStream(version)
+		return &Object{Type: StreamObject, Value: stream}, err
+	case typeHashZipmap:
+		return nil, errors.New("zipmap encoding is not supported")
+	}
+	return nil, fmt.Errorf("unsupported object type %d", objType)
+}
+
+// readStrings 读取 长度 + 长度*n 个字符串
+func (d *Decoder) readStrings(n int) ([][]byte, error) {
+	length, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, length*n)
+	for i := 0; i < length*n; i++ {
+		value, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (d *Decoder) readZSet(binaryScore bool) ([]*ZSetEntry, error) {
+	length, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*ZSetEntry, 0, length)
+	for i := 0; i < length; i++ {
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			score, err = d.readBinaryFloat()
+		} else {
+			score, err = d.readFloat()
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &ZSetEntry{
+			Member: string(member),
+			Score:  score,
+		})
+	}
+	return entries, nil
+}
+
+// readEncoded 读取一个字符串，并按ziplist、listpack或intset格式解析
+func (d *Decoder) readEncoded(parse func([]byte) ([][]byte, error)) ([][]byte, error) {
+	buf, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(buf)
+}
+
+// readQuicklist 读取quicklist，每个节点是一个ziplist；quicklist2中的节点是listpack或单个元素
+func (d *Decoder) readQuicklist(v2 bool) ([][]byte, error) {
+	nodeCount, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0)
+	for i := 0; i < nodeCount; i++ {
+		container := quicklistNodePacked
+		if v2 {
+			if container, err = d.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if container == quicklistNodePlain {
+			values = append(values, buf)
+			continue
+		}
+		parse := parseZiplist
+		if v2 {
+			parse = parseListpack
+		}
+		nodeValues, err := parse(buf)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, nodeValues...)
+	}
+	return values, nil
+}
+
+func pairsToHash(values [][]byte) map[string][]byte {
+	hash := make(map[string][]byte, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		hash[string(values[i])] = values[i+1]
+	}
+	return hash
+}
+
+func pairsToZSet(values [][]byte) ([]*ZSetEntry, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("odd number of zset elements")
+	}
+	entries := make([]*ZSetEntry, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := strconv.ParseFloat(string(values[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &ZSetEntry{
+			Member: string(values[i]),
+			Score:  score,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,151 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"zedis/lib/crc64"
+)
+
+// ErrBadDumpPayload DUMP数据的版本号或校验和不正确
+var ErrBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+/*
+DUMP命令使用的序列化格式，与Redis兼容：
+值类型(1字节) + 值 + RDB版本号(2字节，小端) + CRC64校验和(8字节，小端)
+校验和覆盖之前的所有数据，值的编码与RDB文件相同，但不包含key和过期时间
+FUNCTION DUMP使用相同的格式，值替换为多个函数库：(0xF5 + 函数库源码)...
+*/
+
+// Dump 将对象的值序列化为DUMP格式，忽略对象的key和过期时间
+func Dump(object *Object) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	var err error
+	switch object.Type {
+	case StringObject:
+		if err = enc.writeByte(typeString); err == nil {
+			err = enc.writeString(object.Value.([]byte))
+		}
+	case ListObject:
+		if err = enc.writeByte(typeList); err == nil {
+			err = enc.writeStrings(object.Value.([][]byte))
+		}
+	case SetObject:
+		if err = enc.writeByte(typeSet); err == nil {
+			err = enc.writeStrings(object.Value.([][]byte))
+		}
+	case HashObject:
+		if err = enc.writeByte(typeHash); err == nil {
+			err = enc.writeHash(object.Value.(map[string][]byte))
+		}
+	case ZSetObject:
+		if err = enc.writeByte(typeZSet2); err == nil {
+			err = enc.writeZSet(object.Value.([]*ZSetEntry))
+		}
+	case StreamObject:
+		if err = enc.writeByte(typeStreamListpacks); err == nil {
+			err = enc.writeStream(object.Value.(*StreamData))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported object type %d", object.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = enc.writeDumpFooter(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeDumpFooter 写入RDB版本号和校验和
+func (e *Encoder) writeDumpFooter() error {
+	binary.LittleEndian.PutUint16(e.buf[:2], version)
+	if err := e.write(e.buf[:2]); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	return e.write(e.buf[:8])
+}
+
+// checkDumpFooter 检查DUMP数据的版本号和校验和，返回去掉末尾10字节的数据和版本号
+func checkDumpFooter(payload []byte) ([]byte, int, error) {
+	if len(payload) < 10 {
+		return nil, 0, ErrBadDumpPayload
+	}
+	footer := payload[len(payload)-10:]
+	ver := int(binary.LittleEndian.Uint16(footer[:2]))
+	if ver > maxVersion {
+		return nil, 0, ErrBadDumpPayload
+	}
+	if crc64.Checksum(payload[:len(payload)-8]) != binary.LittleEndian.Uint64(footer[2:]) {
+		return nil, 0, ErrBadDumpPayload
+	}
+	return payload[:len(payload)-10], ver, nil
+}
+
+// Restore 解析DUMP格式的数据，返回的对象不包含key和过期时间
+func Restore(payload []byte) (*Object, error) {
+	body, ver, err := checkDumpFooter(payload)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoder(bytes.NewReader(body))
+	d.version = ver
+	objType, err := d.readByte()
+	if err != nil {
+		return nil, ErrBadDumpPayload
+	}
+	object, err := d.readObject(objType)
+	if err != nil {
+		return nil, fmt.Errorf("bad data format: %v", err)
+	}
+	return object, nil
+}
+
+// DumpFunctions 将多个函数库的源码序列化为FUNCTION DUMP的格式
+func DumpFunctions(codes [][]byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	for _, code := range codes {
+		if err := enc.WriteFunction(code); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.writeDumpFooter(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RestoreFunctions 解析FUNCTION DUMP格式的数据，返回所有函数库的源码
+func RestoreFunctions(payload []byte) ([][]byte, error) {
+	body, ver, err := checkDumpFooter(payload)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoder(bytes.NewReader(body))
+	d.version = ver
+	codes := make([][]byte, 0)
+	for {
+		// readByte将io.EOF转换为io.ErrUnexpectedEOF，读取操作码时遇到结尾表示数据已读完
+		opCode, err := d.readByte()
+		if err == io.ErrUnexpectedEOF {
+			return codes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opCode != opCodeFunction2 {
+			return nil, fmt.Errorf("given type is not a function")
+		}
+		code, err := d.readString()
+		if err != nil {
+			return nil, fmt.Errorf("bad data format: %v", err)
+		}
+		codes = append(codes, code)
+	}
+}
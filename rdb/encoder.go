@@ -0,0 +1,287 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"zedis/lib/crc64"
+	"zedis/lib/lzf"
+)
+
+// Encoder 将数据编码为RDB格式并写入io.Writer
+type Encoder struct {
+	writer io.Writer
+	crc    uint64
+	buf    [9]byte
+}
+
+// NewEncoder 创建Encoder
+func NewEncoder(writer io.Writer) *Encoder {
+	return &Encoder{
+		writer: writer,
+	}
+}
+
+func (e *Encoder) write(p []byte) error {
+	_, err := e.writer.Write(p)
+	if err != nil {
+		return err
+	}
+	e.crc = crc64.Update(e.crc, p)
+	return nil
+}
+
+func (e *Encoder) writeByte(b byte) error {
+	e.buf[0] = b
+	return e.write(e.buf[:1])
+}
+
+// writeLength 写入长度编码
+func (e *Encoder) writeLength(length uint64) error {
+	var p []byte
+	switch {
+	case length < 1<<6:
+		e.buf[0] = byte(length)
+		p = e.buf[:1]
+	case length < 1<<14:
+		e.buf[0] = byte(len14Bit<<6 | length>>8)
+		e.buf[1] = byte(length)
+		p = e.buf[:2]
+	case length <= math.MaxUint32:
+		e.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(length))
+		p = e.buf[:5]
+	default:
+		e.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(e.buf[1:], length)
+		p = e.buf[:9]
+	}
+	return e.write(p)
+}
+
+// writeString 写入字符串，能表示为整数的字符串使用整数编码，较长的字符串尝试LZF压缩
+func (e *Encoder) writeString(s []byte) error {
+	if len(s) <= 11 {
+		if ok, err := e.tryWriteIntString(s); ok || err != nil {
+			return err
+		}
+	}
+	if len(s) > 20 {
+		if compressed := lzf.Compress(s); compressed != nil && len(compressed) < len(s)-4 {
+			if err := e.writeByte(lenEncVal<<6 | encLZF); err != nil {
+				return err
+			}
+			if err := e.writeLength(uint64(len(compressed))); err != nil {
+				return err
+			}
+			if err := e.writeLength(uint64(len(s))); err != nil {
+				return err
+			}
+			return e.write(compressed)
+		}
+	}
+	if err := e.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	return e.write(s)
+}
+
+// tryWriteIntString 如果字符串是规范的32位整数，使用整数编码写入
+func (e *Encoder) tryWriteIntString(s []byte) (bool, error) {
+	value, err := strconv.ParseInt(string(s), 10, 32)
+	if err != nil || strconv.FormatInt(value, 10) != string(s) {
+		return false, nil
+	}
+	var p []byte
+	switch {
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		e.buf[0] = lenEncVal<<6 | encInt8
+		e.buf[1] = byte(value)
+		p = e.buf[:2]
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		e.buf[0] = lenEncVal<<6 | encInt16
+		binary.LittleEndian.PutUint16(e.buf[1:], uint16(value))
+		p = e.buf[:3]
+	default:
+		e.buf[0] = lenEncVal<<6 | encInt32
+		binary.LittleEndian.PutUint32(e.buf[1:], uint32(value))
+		p = e.buf[:5]
+	}
+	return true, e.write(p)
+}
+
+// WriteHeader 写入文件头和辅助字段
+func (e *Encoder) WriteHeader() error {
+	if err := e.write([]byte(fmt.Sprintf("%s%04d", magic, version))); err != nil {
+		return err
+	}
+	auxFields := [][2]string{
+		{"redis-ver", "6.0.0"},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	for _, field := range auxFields {
+		if err := e.WriteAux(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAux 写入辅助字段
+func (e *Encoder) WriteAux(key, value string) error {
+	if err := e.writeByte(opCodeAux); err != nil {
+		return err
+	}
+	if err := e.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return e.writeString([]byte(value))
+}
+
+// WriteFunction 写入函数库的源码，需要在WriteDBHeader之前调用
+// 函数库是RDB版本10新增的内容，包含函数库的文件不能被Redis 7.0之前的版本加载
+func (e *Encoder) WriteFunction(code []byte) error {
+	if err := e.writeByte(opCodeFunction2); err != nil {
+		return err
+	}
+	return e.writeString(code)
+}
+
+// WriteDBHeader 写入数据库编号及该数据库中key的数量和设置了过期时间的key的数量
+func (e *Encoder) WriteDBHeader(dbIndex int, keyCount, ttlCount uint64) error {
+	if err := e.writeByte(opCodeSelectDB); err != nil {
+		return err
+	}
+	if err := e.writeLength(uint64(dbIndex)); err != nil {
+		return err
+	}
+	if err := e.writeByte(opCodeResizeDB); err != nil {
+		return err
+	}
+	if err := e.writeLength(keyCount); err != nil {
+		return err
+	}
+	return e.writeLength(ttlCount)
+}
+
+// writeObjectHeader 写入过期时间、值类型和key
+func (e *Encoder) writeObjectHeader(key string, objType byte, expiration *time.Time) error {
+	if expiration != nil {
+		if err := e.writeByte(opCodeExpireTimeMs); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(e.buf[:8], uint64(expiration.UnixMilli()))
+		if err := e.write(e.buf[:8]); err != nil {
+			return err
+		}
+	}
+	if err := e.writeByte(objType); err != nil {
+		return err
+	}
+	return e.writeString([]byte(key))
+}
+
+// WriteStringObject 写入字符串类型的键值对
+func (e *Encoder) WriteStringObject(key string, value []byte, expiration *time.Time) error {
+	if err := e.writeObjectHeader(key, typeString, expiration); err != nil {
+		return err
+	}
+	return e.writeString(value)
+}
+
+// WriteListObject 写入列表类型的键值对
+func (e *Encoder) WriteListObject(key string, values [][]byte, expiration *time.Time) error {
+	if err := e.writeObjectHeader(key, typeList, expiration); err != nil {
+		return err
+	}
+	return e.writeStrings(values)
+}
+
+// WriteSetObject 写入集合类型的键值对
+func (e *Encoder) WriteSetObject(key string, members [][]byte, expiration *time.Time) error {
+	if err := e.writeObjectHeader(key, typeSet, expiration); err != nil {
+		return err
+	}
+	return e.writeStrings(members)
+}
+
+// WriteHashObject 写入哈希类型的键值对
+func (e *Encoder) WriteHashObject(key string, hash map[string][]byte, expiration *time.Time) error {
+	if err := e.writeObjectHeader(key, typeHash, expiration); err != nil {
+		return err
+	}
+	return e.writeHash(hash)
+}
+
+// WriteZSetObject 写入有序集合类型的键值对，score使用二进制double编码
+func (e *Encoder) WriteZSetObject(key string, entries []*ZSetEntry, expiration *time.Time) error {
+	if err := e.writeObjectHeader(key, typeZSet2, expiration); err != nil {
+		return err
+	}
+	return e.writeZSet(entries)
+}
+
+// writeStrings 写入 长度 + 字符串，用于列表和集合的值
+func (e *Encoder) writeStrings(values [][]byte) error {
+	if err := e.writeLength(uint64(len(values))); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := e.writeString(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) writeHash(hash map[string][]byte) error {
+	if err := e.writeLength(uint64(len(hash))); err != nil {
+		return err
+	}
+	// 按field排序，保证相同数据生成的文件相同
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if err := e.writeString([]byte(field)); err != nil {
+			return err
+		}
+		if err := e.writeString(hash[field]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) writeZSet(entries []*ZSetEntry) error {
+	if err := e.writeLength(uint64(len(entries))); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := e.writeString([]byte(entry.Member)); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(entry.Score))
+		if err := e.write(e.buf[:8]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEnd 写入结束标志和校验和
+func (e *Encoder) WriteEnd() error {
+	if err := e.writeByte(opCodeEOF); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	_, err := e.writer.Write(e.buf[:8])
+	return err
+}
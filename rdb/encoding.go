@@ -0,0 +1,218 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var errBadEncoding = errors.New("rdb: corrupted compact encoding")
+
+// listpack中整数编码对应的字节数
+var listpackIntSizes = map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}
+
+// parseZiplist 解析ziplist
+// <zlbytes 4字节><zltail 4字节><zllen 2字节><entry>...<0xFF>
+// entry: <prevlen 1或5字节><encoding><content>
+func parseZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, errBadEncoding
+	}
+	values := make([][]byte, 0, binary.LittleEndian.Uint16(buf[8:10]))
+	pos := 10
+	for {
+		if pos >= len(buf) {
+			return nil, errBadEncoding
+		}
+		if buf[pos] == 0xFF {
+			return values, nil
+		}
+		// 跳过prevlen
+		if buf[pos] == 0xFE {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(buf) {
+			return nil, errBadEncoding
+		}
+		value, n, err := parseZiplistEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		pos += n
+	}
+}
+
+// parseZiplistEntry 解析ziplist entry的encoding和content，返回值和占用的字节数
+func parseZiplistEntry(buf []byte) ([]byte, int, error) {
+	enc := buf[0]
+	var strLen, headerLen int
+	switch enc >> 6 {
+	case 0:
+		strLen, headerLen = int(enc&0x3f), 1
+	case 1:
+		if len(buf) < 2 {
+			return nil, 0, errBadEncoding
+		}
+		strLen, headerLen = int(enc&0x3f)<<8|int(buf[1]), 2
+	case 2:
+		if len(buf) < 5 {
+			return nil, 0, errBadEncoding
+		}
+		strLen, headerLen = int(binary.BigEndian.Uint32(buf[1:5])), 5
+	default:
+		return parseZiplistInt(buf)
+	}
+	if len(buf) < headerLen+strLen {
+		return nil, 0, errBadEncoding
+	}
+	return buf[headerLen : headerLen+strLen], headerLen + strLen, nil
+}
+
+func parseZiplistInt(buf []byte) ([]byte, int, error) {
+	enc := buf[0]
+	var size int
+	switch enc {
+	case 0xC0:
+		size = 2
+	case 0xD0:
+		size = 4
+	case 0xE0:
+		size = 8
+	case 0xF0:
+		size = 3
+	case 0xFE:
+		size = 1
+	default:
+		// 1111xxxx，xxxx在0001到1101之间，值为xxxx-1
+		if enc >= 0xF1 && enc <= 0xFD {
+			return []byte(strconv.Itoa(int(enc&0x0f) - 1)), 1, nil
+		}
+		return nil, 0, errBadEncoding
+	}
+	if len(buf) < 1+size {
+		return nil, 0, errBadEncoding
+	}
+	value := readIntLE(buf[1:1+size], size)
+	return []byte(strconv.FormatInt(value, 10)), 1 + size, nil
+}
+
+// readIntLE 读取size字节的小端序有符号整数
+func readIntLE(buf []byte, size int) int64 {
+	var value uint64
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(buf[i])
+	}
+	// 符号扩展
+	shift := uint(64 - size*8)
+	return int64(value<<shift) >> shift
+}
+
+// parseListpack 解析listpack
+// <total bytes 4字节><num elements 2字节><entry>...<0xFF>
+// entry: <encoding><content><backlen>
+func parseListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < 7 {
+		return nil, errBadEncoding
+	}
+	values := make([][]byte, 0)
+	pos := 6
+	for {
+		if pos >= len(buf) {
+			return nil, errBadEncoding
+		}
+		if buf[pos] == 0xFF {
+			return values, nil
+		}
+		value, n, err := parseListpackEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		pos += n + listpackBacklenSize(n)
+	}
+}
+
+// parseListpackEntry 解析listpack entry的encoding和content，返回值和占用的字节数(不包括backlen)
+func parseListpackEntry(buf []byte) ([]byte, int, error) {
+	enc := buf[0]
+	var strLen, headerLen int
+	switch {
+	case enc&0x80 == 0:
+		// 0xxxxxxx 7位无符号整数
+		return []byte(strconv.Itoa(int(enc & 0x7f))), 1, nil
+	case enc&0xC0 == 0x80:
+		// 10xxxxxx 6位长度的字符串
+		strLen, headerLen = int(enc&0x3f), 1
+	case enc&0xE0 == 0xC0:
+		// 110xxxxx yyyyyyyy 13位有符号整数
+		if len(buf) < 2 {
+			return nil, 0, errBadEncoding
+		}
+		value := int(enc&0x1f)<<8 | int(buf[1])
+		if value >= 1<<12 {
+			value -= 1 << 13
+		}
+		return []byte(strconv.Itoa(value)), 2, nil
+	case enc&0xF0 == 0xE0:
+		// 1110xxxx yyyyyyyy 12位长度的字符串
+		if len(buf) < 2 {
+			return nil, 0, errBadEncoding
+		}
+		strLen, headerLen = int(enc&0x0f)<<8|int(buf[1]), 2
+	case enc == 0xF0:
+		// 32位长度的字符串
+		if len(buf) < 5 {
+			return nil, 0, errBadEncoding
+		}
+		strLen, headerLen = int(binary.LittleEndian.Uint32(buf[1:5])), 5
+	default:
+		size, ok := listpackIntSizes[enc]
+		if !ok || len(buf) < 1+size {
+			return nil, 0, errBadEncoding
+		}
+		value := readIntLE(buf[1:1+size], size)
+		return []byte(strconv.FormatInt(value, 10)), 1 + size, nil
+	}
+	if len(buf) < headerLen+strLen {
+		return nil, 0, errBadEncoding
+	}
+	return buf[headerLen : headerLen+strLen], headerLen + strLen, nil
+}
+
+// listpackBacklenSize backlen每个字节保存7位，返回保存entryLen需要的字节数
+func listpackBacklenSize(entryLen int) int {
+	switch {
+	case entryLen < 1<<7:
+		return 1
+	case entryLen < 1<<14:
+		return 2
+	case entryLen < 1<<21:
+		return 3
+	case entryLen < 1<<28:
+		return 4
+	}
+	return 5
+}
+
+// parseIntset 解析intset
+// <encoding 4字节><length 4字节><contents>
+func parseIntset(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, errBadEncoding
+	}
+	size := int(binary.LittleEndian.Uint32(buf[0:4]))
+	length := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if size != 2 && size != 4 && size != 8 || len(buf) < 8+size*length {
+		return nil, errBadEncoding
+	}
+	values := make([][]byte, 0, length)
+	for i := 0; i < length; i++ {
+		pos := 8 + i*size
+		value := readIntLE(buf[pos:pos+size], size)
+		values = append(values, []byte(strconv.FormatInt(value, 10)))
+	}
+	return values, nil
+}
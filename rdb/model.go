@@ -0,0 +1,151 @@
+// Package rdb 实现了Redis RDB文件格式的编码与解码
+package rdb
+
+import "time"
+
+// RDB文件中的值类型
+const (
+	typeString         = 0
+	typeList           = 1
+	typeSet            = 2
+	typeZSet           = 3
+	typeHash           = 4
+	typeZSet2          = 5
+	typeHashZipmap     = 9
+	typeListZiplist    = 10
+	typeSetIntset      = 11
+	typeZSetZiplist    = 12
+	typeHashZiplist    = 13
+	typeListQuicklist  = 14
+	typeHashListpack   = 16
+	typeZSetListpack   = 17
+	typeListQuicklist2 = 18
+	typeSetListpack    = 20
+	// 消息流，2表示Redis 7.0增加了首条消息ID、最大删除ID、添加总数和消费者组已读数量，3表示增加了消费者的活跃时间
+	typeStreamListpacks  = 15
+	typeStreamListpacks2 = 19
+	typeStreamListpacks3 = 21
+)
+
+// RDB文件中的操作码
+const (
+	opCodeSlotInfo     = 0xF4
+	opCodeFunction2    = 0xF5
+	opCodeModuleAux    = 0xF7
+	opCodeIdle         = 0xF8
+	opCodeFreq         = 0xF9
+	opCodeAux          = 0xFA
+	opCodeResizeDB     = 0xFB
+	opCodeExpireTimeMs = 0xFC
+	opCodeExpireTime   = 0xFD
+	opCodeSelectDB     = 0xFE
+	opCodeEOF          = 0xFF
+)
+
+// 长度编码
+const (
+	len6Bit      = 0
+	len14Bit     = 1
+	len32Or64Bit = 2
+	lenEncVal    = 3
+	len32Bit     = 0x80
+	len64Bit     = 0x81
+)
+
+// 字符串的特殊编码
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// quicklist2中节点的类型
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+const (
+	magic = "REDIS"
+	// 写入的RDB版本号，可以被Redis 5.0及以上版本加载
+	version = 9
+	// 能够读取的最高RDB版本号
+	maxVersion = 12
+)
+
+// 对象类型
+const (
+	StringObject = iota + 1
+	ListObject
+	SetObject
+	HashObject
+	ZSetObject
+	StreamObject
+	// 函数库，不属于任何数据库
+	FunctionObject
+)
+
+// ZSetEntry 有序集合中的一个元素
+type ZSetEntry struct {
+	Member string
+	Score  float64
+}
+
+// StreamID 消息ID
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// StreamEntry 消息流中的一条消息，Fields中字段名和值交替排列
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// StreamPendingEntry 消费者组中已发送未确认的消息，DeliveryTime为毫秒时间戳
+type StreamPendingEntry struct {
+	ID            StreamID
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+// StreamConsumer 消费者，Pending为发送给它的未确认消息，SeenTime和ActiveTime为毫秒时间戳
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64
+	ActiveTime int64
+	Pending    []StreamID
+}
+
+// StreamGroup 消费者组，EntriesRead为-1表示已读的数量未知
+type StreamGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	Pending     []*StreamPendingEntry
+	Consumers   []*StreamConsumer
+}
+
+// StreamData 消息流，Entries按ID从小到大排列
+type StreamData struct {
+	Entries      []*StreamEntry
+	LastID       StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	Groups       []*StreamGroup
+}
+
+// Object 从RDB文件中读取的一个键值对
+// 不同类型的Value分别为：
+// StringObject []byte，ListObject [][]byte，SetObject [][]byte，
+// HashObject map[string][]byte，ZSetObject []*ZSetEntry，StreamObject *StreamData
+// FunctionObject的Value为函数库的源码[]byte，Key为空
+type Object struct {
+	DBIndex    int
+	Key        string
+	Type       int
+	Value      any
+	Expiration *time.Time
+}
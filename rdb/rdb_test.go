@@ -0,0 +1,287 @@
+package rdb
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeAndDecode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	expireAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	longValue := []byte(strings.Repeat("zedis", 100))
+
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	_ = enc.WriteDBHeader(0, 6, 1)
+	_ = enc.WriteStringObject("str", []byte("hello"), nil)
+	_ = enc.WriteStringObject("int", []byte("-12345"), &expireAt)
+	_ = enc.WriteStringObject("long", longValue, nil)
+	_ = enc.WriteListObject("list", [][]byte{[]byte("a"), []byte("100000")}, nil)
+	_ = enc.WriteSetObject("set", [][]byte{[]byte("x")}, nil)
+	_ = enc.WriteHashObject("hash", map[string][]byte{"f": []byte("v")}, nil)
+	_ = enc.WriteDBHeader(3, 1, 0)
+	_ = enc.WriteZSetObject("zset", []*ZSetEntry{{Member: "m", Score: 1.5}, {Member: "n", Score: math.Inf(1)}}, nil)
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := make(map[string]*Object)
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(object *Object) bool {
+		objects[object.Key] = object
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 7 {
+		t.Fatalf("expected 7 objects, got %d", len(objects))
+	}
+	if string(objects["str"].Value.([]byte)) != "hello" {
+		t.Fatal("wrong string value")
+	}
+	if o := objects["int"]; string(o.Value.([]byte)) != "-12345" || o.Expiration == nil || !o.Expiration.Equal(expireAt) {
+		t.Fatal("wrong int string value or expiration")
+	}
+	if !bytes.Equal(objects["long"].Value.([]byte), longValue) {
+		t.Fatal("wrong compressed string value")
+	}
+	if list := objects["list"].Value.([][]byte); len(list) != 2 || string(list[1]) != "100000" {
+		t.Fatal("wrong list value")
+	}
+	if hash := objects["hash"].Value.(map[string][]byte); string(hash["f"]) != "v" {
+		t.Fatal("wrong hash value")
+	}
+	zset := objects["zset"]
+	entries := zset.Value.([]*ZSetEntry)
+	if zset.DBIndex != 3 || len(entries) != 2 || entries[0].Score != 1.5 || !math.IsInf(entries[1].Score, 1) {
+		t.Fatal("wrong zset value")
+	}
+
+	// 修改任意一个字节后校验和不匹配
+	corrupted := append([]byte{}, buf.Bytes()...)
+	corrupted[20] ^= 0xff
+	err = NewDecoder(bytes.NewReader(corrupted)).Parse(func(object *Object) bool {
+		return true
+	})
+	if err == nil {
+		t.Fatal("expected error for corrupted file")
+	}
+}
+
+func TestParseZiplist(t *testing.T) {
+	// 依次为 "ab"、12(立即数)、-2(int8)、1000(int16)
+	buf := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 4, 0,
+		0x00, 0x02, 'a', 'b',
+		0x04, 0xFD,
+		0x02, 0xFE, 0xFE,
+		0x03, 0xC0, 0xE8, 0x03,
+		0xFF,
+	}
+	values, err := parseZiplist(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ab", "12", "-2", "1000"}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d values, got %d", len(expected), len(values))
+	}
+	for i, value := range values {
+		if string(value) != expected[i] {
+			t.Fatalf("expected %s, got %s", expected[i], value)
+		}
+	}
+}
+
+func TestParseListpack(t *testing.T) {
+	// 依次为 "a"、1(7位整数)、-1(13位整数)、70000(24位整数)
+	buf := []byte{
+		0, 0, 0, 0, 4, 0,
+		0x81, 'a', 0x02,
+		0x01, 0x01,
+		0xDF, 0xFF, 0x02,
+		0xF2, 0x70, 0x11, 0x01, 0x04,
+		0xFF,
+	}
+	values, err := parseListpack(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "1", "-1", "70000"}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d values, got %d", len(expected), len(values))
+	}
+	for i, value := range values {
+		if string(value) != expected[i] {
+			t.Fatalf("expected %s, got %s", expected[i], value)
+		}
+	}
+}
+
+func TestParseIntset(t *testing.T) {
+	buf := []byte{
+		2, 0, 0, 0, 3, 0, 0, 0,
+		0xFF, 0xFF, 0x01, 0x00, 0x00, 0x01,
+	}
+	values, err := parseIntset(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || string(values[0]) != "-1" || string(values[1]) != "1" || string(values[2]) != "256" {
+		t.Fatalf("wrong intset values: %q", values)
+	}
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	// Redis文档中DUMP的例子：SET mykey 10
+	payload, err := Dump(&Object{Type: StringObject, Value: []byte("10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"); !bytes.Equal(payload, expected) {
+		t.Fatalf("wrong payload: %q", payload)
+	}
+
+	objects := []*Object{
+		{Type: StringObject, Value: []byte(strings.Repeat("zedis", 100))},
+		{Type: ListObject, Value: [][]byte{[]byte("a"), []byte("100000")}},
+		{Type: SetObject, Value: [][]byte{[]byte("x"), []byte("y")}},
+		{Type: HashObject, Value: map[string][]byte{"f": []byte("v")}},
+		{Type: ZSetObject, Value: []*ZSetEntry{{Member: "m", Score: 1.5}}},
+	}
+	for _, object := range objects {
+		payload, err := Dump(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := Restore(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Type != object.Type || !reflect.DeepEqual(restored.Value, object.Value) {
+			t.Fatalf("restored %v, expected %v", restored.Value, object.Value)
+		}
+		corrupted := append([]byte{}, payload...)
+		corrupted[1] ^= 0xff
+		if _, err := Restore(corrupted); err != ErrBadDumpPayload {
+			t.Fatalf("expected ErrBadDumpPayload for corrupted payload, got %v", err)
+		}
+	}
+	if _, err := Restore([]byte("short")); err != ErrBadDumpPayload {
+		t.Fatalf("expected ErrBadDumpPayload for short payload, got %v", err)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	codes := [][]byte{
+		[]byte("#!lua name=lib1\nredis.register_function('f1', function() return 1 end)"),
+		[]byte("#!lua name=lib2\nredis.register_function('f2', function() return 2 end)"),
+	}
+	payload, err := DumpFunctions(codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreFunctions(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, codes) {
+		t.Fatalf("restored %q, expected %q", restored, codes)
+	}
+	if _, err := RestoreFunctions(payload[1:]); err != ErrBadDumpPayload {
+		t.Fatalf("expected ErrBadDumpPayload for corrupted payload, got %v", err)
+	}
+	if valuePayload, _ := Dump(&Object{Type: StringObject, Value: []byte("v")}); valuePayload != nil {
+		if _, err := RestoreFunctions(valuePayload); err == nil {
+			t.Fatal("expected error for value payload")
+		}
+	}
+
+	// RDB文件中的函数库在数据库之前
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	_ = enc.WriteHeader()
+	_ = enc.WriteFunction(codes[0])
+	_ = enc.WriteDBHeader(0, 1, 0)
+	_ = enc.WriteStringObject("k", []byte("v"), nil)
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	objects := make([]*Object, 0)
+	err = NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(object *Object) bool {
+		objects = append(objects, object)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Type != FunctionObject || !bytes.Equal(objects[0].Value.([]byte), codes[0]) {
+		t.Fatalf("wrong function object: %v", objects)
+	}
+	if objects[1].Key != "k" {
+		t.Fatalf("wrong key: %s", objects[1].Key)
+	}
+}
+
+func TestStream(t *testing.T) {
+	stream := &StreamData{LastID: StreamID{Ms: 200, Seq: 1}, EntriesAdded: 150}
+	for i := 0; i < 150; i++ {
+		fields := [][]byte{[]byte("name"), []byte("job"), []byte("retry"), []byte("3")}
+		if i%7 == 0 {
+			fields = [][]byte{[]byte("payload"), []byte(strings.Repeat("x", 100+i))}
+		}
+		// 序号小于节点中第一条消息的序号时差值为负数
+		stream.Entries = append(stream.Entries, &StreamEntry{
+			ID:     StreamID{Ms: uint64(50 + i), Seq: uint64(5 - i%6)},
+			Fields: fields,
+		})
+	}
+	stream.Groups = []*StreamGroup{{
+		Name:        "workers",
+		LastID:      StreamID{Ms: 52, Seq: 3},
+		EntriesRead: -1,
+		Pending: []*StreamPendingEntry{
+			{ID: StreamID{Ms: 50, Seq: 5}, DeliveryTime: 1700000000000, DeliveryCount: 1},
+			{ID: StreamID{Ms: 52, Seq: 3}, DeliveryTime: 1700000000123, DeliveryCount: 4},
+		},
+		Consumers: []*StreamConsumer{
+			{Name: "alice", SeenTime: 1700000000123, ActiveTime: 1700000000123, Pending: []StreamID{{Ms: 50, Seq: 5}, {Ms: 52, Seq: 3}}},
+			{Name: "bob", SeenTime: 1700000000000, ActiveTime: 1700000000000},
+		},
+	}}
+
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	_ = enc.WriteHeader()
+	_ = enc.WriteDBHeader(0, 1, 0)
+	_ = enc.WriteStreamObject("jobs", stream, nil)
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	var decoded *Object
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(object *Object) bool {
+		decoded = object
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded == nil || decoded.Type != StreamObject || !reflect.DeepEqual(decoded.Value, stream) {
+		t.Fatal("wrong stream value")
+	}
+
+	payload, err := Dump(&Object{Type: StreamObject, Value: stream})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(payload)
+	if err != nil || !reflect.DeepEqual(restored.Value, stream) {
+		t.Fatalf("wrong restored stream value: %v", err)
+	}
+}
@@ -0,0 +1,505 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+/*
+消息流的编码：
+<listpack数量> (<节点key: 第一条消息的ID，16字节大端序> <listpack>)...
+<消息数量> <最后的ID ms> <最后的ID seq>
+[v2: <第一条消息ID ms> <seq> <最大删除ID ms> <seq> <添加总数>]
+<消费者组数量> (<组名> <最后发送的ID ms> <seq> [v2: <已读数量>] <PEL> <消费者>)...
+PEL: <数量> (<ID 16字节> <发送时间 8字节小端序毫秒> <发送次数>)...
+消费者: <数量> (<名称> <seen time 8字节> [v3: <active time 8字节>] <数量> (<ID 16字节>)...)...
+
+listpack中的第一组元素是master entry: <count> <deleted> <字段数量> <字段名>... <0>
+之后每条消息为: <flags> <ms差值> <seq差值> [<字段数量> (<字段名> <值>)... | (<值>)...] <lp-count>
+差值相对于节点key中的ID，flags包含SAMEFIELDS时字段名与master entry相同，只保存值
+*/
+
+const (
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+	// 每个listpack中最多保存的消息数量
+	streamNodeMaxEntries = 100
+)
+
+var errBadStream = errors.New("rdb: corrupted stream")
+
+// WriteStreamObject 写入消息流类型的键值对
+func (e *Encoder) WriteStreamObject(key string, stream *StreamData, expiration *time.Time) error {
+	if err := e.writeObjectHeader(key, typeStreamListpacks, expiration); err != nil {
+		return err
+	}
+	return e.writeStream(stream)
+}
+
+// writeStream 使用RDB版本9支持的typeStreamListpacks格式写入消息流
+func (e *Encoder) writeStream(stream *StreamData) error {
+	nodeCount := (len(stream.Entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	if err := e.writeLength(uint64(nodeCount)); err != nil {
+		return err
+	}
+	for i := 0; i < len(stream.Entries); i += streamNodeMaxEntries {
+		end := i + streamNodeMaxEntries
+		if end > len(stream.Entries) {
+			end = len(stream.Entries)
+		}
+		entries := stream.Entries[i:end]
+		if err := e.writeString(encodeStreamID(entries[0].ID)); err != nil {
+			return err
+		}
+		if err := e.writeString(buildStreamListpack(entries)); err != nil {
+			return err
+		}
+	}
+	for _, n := range []uint64{uint64(len(stream.Entries)), stream.LastID.Ms, stream.LastID.Seq} {
+		if err := e.writeLength(n); err != nil {
+			return err
+		}
+	}
+	if err := e.writeLength(uint64(len(stream.Groups))); err != nil {
+		return err
+	}
+	for _, group := range stream.Groups {
+		if err := e.writeString([]byte(group.Name)); err != nil {
+			return err
+		}
+		if err := e.writeLength(group.LastID.Ms); err != nil {
+			return err
+		}
+		if err := e.writeLength(group.LastID.Seq); err != nil {
+			return err
+		}
+		if err := e.writeLength(uint64(len(group.Pending))); err != nil {
+			return err
+		}
+		for _, pe := range group.Pending {
+			if err := e.write(encodeStreamID(pe.ID)); err != nil {
+				return err
+			}
+			if err := e.writeMillisecondTime(pe.DeliveryTime); err != nil {
+				return err
+			}
+			if err := e.writeLength(pe.DeliveryCount); err != nil {
+				return err
+			}
+		}
+		if err := e.writeLength(uint64(len(group.Consumers))); err != nil {
+			return err
+		}
+		for _, consumer := range group.Consumers {
+			if err := e.writeString([]byte(consumer.Name)); err != nil {
+				return err
+			}
+			if err := e.writeMillisecondTime(consumer.SeenTime); err != nil {
+				return err
+			}
+			if err := e.writeLength(uint64(len(consumer.Pending))); err != nil {
+				return err
+			}
+			for _, id := range consumer.Pending {
+				if err := e.write(encodeStreamID(id)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) writeMillisecondTime(ms int64) error {
+	binary.LittleEndian.PutUint64(e.buf[:8], uint64(ms))
+	return e.write(e.buf[:8])
+}
+
+// encodeStreamID 将ID编码为16字节的大端序，保证按字节比较的顺序与ID的顺序相同
+func encodeStreamID(id StreamID) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
+
+func decodeStreamID(buf []byte) (StreamID, error) {
+	if len(buf) != 16 {
+		return StreamID{}, errBadStream
+	}
+	return StreamID{
+		Ms:  binary.BigEndian.Uint64(buf[:8]),
+		Seq: binary.BigEndian.Uint64(buf[8:]),
+	}, nil
+}
+
+// buildStreamListpack 将一个节点中的消息编码为listpack，字段名与第一条消息相同的消息使用SAMEFIELDS
+func buildStreamListpack(entries []*StreamEntry) []byte {
+	master := entries[0]
+	lp := newListpackWriter()
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(len(master.Fields) / 2))
+	for i := 0; i < len(master.Fields); i += 2 {
+		lp.appendString(master.Fields[i])
+	}
+	lp.appendInt(0)
+	for _, entry := range entries {
+		sameFields := len(entry.Fields) == len(master.Fields)
+		for i := 0; sameFields && i < len(entry.Fields); i += 2 {
+			sameFields = string(entry.Fields[i]) == string(master.Fields[i])
+		}
+		flags := int64(0)
+		if sameFields {
+			flags = streamItemFlagSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(entry.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.ID.Seq))
+		if sameFields {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				lp.appendString(entry.Fields[i])
+			}
+			lp.appendInt(int64(3 + len(entry.Fields)/2))
+		} else {
+			lp.appendInt(int64(len(entry.Fields) / 2))
+			for _, field := range entry.Fields {
+				lp.appendString(field)
+			}
+			lp.appendInt(int64(4 + len(entry.Fields)))
+		}
+	}
+	return lp.bytes()
+}
+
+// readStream 读取消息流，version为1、2、3分别对应typeStreamListpacks、typeStreamListpacks2、typeStreamListpacks3
+func (d *Decoder) readStream(version int) (*StreamData, error) {
+	stream := &StreamData{}
+	nodeCount, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nodeCount; i++ {
+		nodeKey, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		masterID, err := decodeStreamID(nodeKey)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		values, err := parseListpack(buf)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseStreamListpack(masterID, values)
+		if err != nil {
+			return nil, err
+		}
+		stream.Entries = append(stream.Entries, entries...)
+	}
+	// 消息数量可以由消息推算，不需要保存
+	if _, err = d.readLen(); err != nil {
+		return nil, err
+	}
+	if stream.LastID, err = d.readStreamIDLength(); err != nil {
+		return nil, err
+	}
+	stream.EntriesAdded = uint64(len(stream.Entries))
+	if version >= 2 {
+		// 第一条消息的ID可以由消息得到
+		if _, err = d.readStreamIDLength(); err != nil {
+			return nil, err
+		}
+		if stream.MaxDeletedID, err = d.readStreamIDLength(); err != nil {
+			return nil, err
+		}
+		if stream.EntriesAdded, _, err = d.readLength(); err != nil {
+			return nil, err
+		}
+	}
+
+	groupCount, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < groupCount; i++ {
+		group, err := d.readStreamGroup(version)
+		if err != nil {
+			return nil, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream, nil
+}
+
+func (d *Decoder) readStreamGroup(version int) (*StreamGroup, error) {
+	name, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	group := &StreamGroup{Name: string(name), EntriesRead: -1}
+	if group.LastID, err = d.readStreamIDLength(); err != nil {
+		return nil, err
+	}
+	if version >= 2 {
+		entriesRead, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		group.EntriesRead = int64(entriesRead)
+	}
+	pendingCount, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < pendingCount; i++ {
+		id, err := d.readRawStreamID()
+		if err != nil {
+			return nil, err
+		}
+		deliveryTime, err := d.readMillisecondTime()
+		if err != nil {
+			return nil, err
+		}
+		deliveryCount, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		group.Pending = append(group.Pending, &StreamPendingEntry{
+			ID:            id,
+			DeliveryTime:  deliveryTime,
+			DeliveryCount: deliveryCount,
+		})
+	}
+	consumerCount, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < consumerCount; i++ {
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		consumer := &StreamConsumer{Name: string(name)}
+		if consumer.SeenTime, err = d.readMillisecondTime(); err != nil {
+			return nil, err
+		}
+		consumer.ActiveTime = consumer.SeenTime
+		if version >= 3 {
+			if consumer.ActiveTime, err = d.readMillisecondTime(); err != nil {
+				return nil, err
+			}
+		}
+		count, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < count; j++ {
+			id, err := d.readRawStreamID()
+			if err != nil {
+				return nil, err
+			}
+			consumer.Pending = append(consumer.Pending, id)
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return group, nil
+}
+
+// readStreamIDLength 读取两个长度编码的ms和seq
+func (d *Decoder) readStreamIDLength() (StreamID, error) {
+	ms, _, err := d.readLength()
+	if err != nil {
+		return StreamID{}, err
+	}
+	seq, _, err := d.readLength()
+	return StreamID{Ms: ms, Seq: seq}, err
+}
+
+func (d *Decoder) readRawStreamID() (StreamID, error) {
+	buf := make([]byte, 16)
+	if err := d.readFull(buf); err != nil {
+		return StreamID{}, err
+	}
+	return decodeStreamID(buf)
+}
+
+func (d *Decoder) readMillisecondTime() (int64, error) {
+	if err := d.readFull(d.buf[:8]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(d.buf[:8])), nil
+}
+
+// parseStreamListpack 解析一个节点的listpack，跳过被标记为删除的消息
+func parseStreamListpack(masterID StreamID, values [][]byte) ([]*StreamEntry, error) {
+	pos := 0
+	next := func() ([]byte, error) {
+		if pos >= len(values) {
+			return nil, errBadStream
+		}
+		pos++
+		return values[pos-1], nil
+	}
+	nextInt := func() (int64, error) {
+		value, err := next()
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, errBadStream
+		}
+		return n, nil
+	}
+
+	count, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+	masterFieldCount, err := nextInt()
+	if err != nil || masterFieldCount < 0 {
+		return nil, errBadStream
+	}
+	masterFields := make([][]byte, 0, masterFieldCount)
+	for i := int64(0); i < masterFieldCount; i++ {
+		field, err := next()
+		if err != nil {
+			return nil, err
+		}
+		masterFields = append(masterFields, field)
+	}
+	// master entry的结束标志
+	if _, err = next(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*StreamEntry, 0, count)
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		entry := &StreamEntry{
+			ID: StreamID{
+				Ms:  masterID.Ms + uint64(msDiff),
+				Seq: masterID.Seq + uint64(seqDiff),
+			},
+		}
+		if flags&streamItemFlagSameFields > 0 {
+			for _, field := range masterFields {
+				value, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, field, value)
+			}
+		} else {
+			fieldCount, err := nextInt()
+			if err != nil || fieldCount < 0 {
+				return nil, errBadStream
+			}
+			for j := int64(0); j < fieldCount*2; j++ {
+				value, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, value)
+			}
+		}
+		// lp-count，用于反向遍历
+		if _, err = next(); err != nil {
+			return nil, err
+		}
+		if flags&streamItemFlagDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// listpackWriter 生成listpack
+type listpackWriter struct {
+	buf   []byte
+	count int
+}
+
+func newListpackWriter() *listpackWriter {
+	// 总字节数和元素数量在bytes中写入
+	return &listpackWriter{buf: make([]byte, 6)}
+}
+
+func (w *listpackWriter) appendEntry(entry []byte) {
+	w.buf = append(w.buf, entry...)
+	// backlen从右向左读取，每个字节保存7位，除最左边的字节外最高位为1
+	size := listpackBacklenSize(len(entry))
+	for i := size - 1; i >= 0; i-- {
+		b := byte(len(entry)>>(7*i)) & 0x7f
+		if i != size-1 {
+			b |= 0x80
+		}
+		w.buf = append(w.buf, b)
+	}
+	w.count++
+}
+
+func (w *listpackWriter) appendString(s []byte) {
+	var header []byte
+	switch {
+	case len(s) < 1<<6:
+		header = []byte{0x80 | byte(len(s))}
+	case len(s) < 1<<12:
+		header = []byte{0xE0 | byte(len(s)>>8), byte(len(s))}
+	default:
+		header = []byte{0xF0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(header[1:], uint32(len(s)))
+	}
+	w.appendEntry(append(header, s...))
+}
+
+func (w *listpackWriter) appendInt(value int64) {
+	switch {
+	case value >= 0 && value <= 127:
+		w.appendEntry([]byte{byte(value)})
+	case value >= -4096 && value <= 4095:
+		v := uint16(value) & 0x1fff
+		w.appendEntry([]byte{0xC0 | byte(v>>8), byte(v)})
+	default:
+		entry := make([]byte, 9)
+		entry[0] = 0xF4
+		binary.LittleEndian.PutUint64(entry[1:], uint64(value))
+		w.appendEntry(entry)
+	}
+}
+
+func (w *listpackWriter) bytes() []byte {
+	w.buf = append(w.buf, 0xFF)
+	binary.LittleEndian.PutUint32(w.buf[0:4], uint32(len(w.buf)))
+	count := w.count
+	if count > math.MaxUint16 {
+		// 元素数量超过65535时需要遍历才能得到
+		count = math.MaxUint16
+	}
+	binary.LittleEndian.PutUint16(w.buf[4:6], uint16(count))
+	return w.buf
+}
@@ -0,0 +1,92 @@
+// Package client 实现了一个简单的同步redis客户端，用于节点之间的通信，如集群节点交换信息、迁移key
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+	"zedis/interface/redis"
+	"zedis/redis/parser"
+	"zedis/redis/protocol"
+)
+
+var ErrClientClosed = errors.New("client closed")
+
+// Client 同一时间只能有一个请求，Send发送命令后等待响应返回
+type Client struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	replies <-chan *parser.Payload
+	// 连接、发送和等待响应的超时时间
+	timeout time.Duration
+	closed  bool
+}
+
+// MakeClient 连接addr，password不为空时先进行认证
+func MakeClient(addr string, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		replies: parser.ParseStream(conn),
+		timeout: timeout,
+	}
+	if password != "" {
+		reply, err := c.Send([][]byte{[]byte("AUTH"), []byte(password)})
+		if err != nil {
+			return nil, err
+		}
+		if protocol.IsErrorReply(reply) {
+			c.Close()
+			return nil, errors.New(string(reply.ToBytes()))
+		}
+	}
+	return c, nil
+}
+
+// Send 发送命令并返回响应，网络错误或超时时关闭客户端并返回错误，命令执行出错时返回错误响应
+func (c *Client) Send(cmdLine [][]byte) (redis.Reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	deadline := time.Now().Add(c.timeout)
+	_ = c.conn.SetDeadline(deadline)
+	if _, err := c.conn.Write(protocol.NewMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		c.closeLocked()
+		return nil, err
+	}
+	payload, ok := <-c.replies
+	if !ok {
+		c.closeLocked()
+		return nil, ErrClientClosed
+	}
+	if payload.Error != nil {
+		c.closeLocked()
+		return nil, payload.Error
+	}
+	return payload.Data, nil
+}
+
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
+
+func (c *Client) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	_ = c.conn.Close()
+	// 连接关闭后解析协程会发送错误并关闭channel，读完避免协程泄漏
+	go func() {
+		for range c.replies {
+		}
+	}()
+}
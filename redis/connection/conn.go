@@ -3,8 +3,9 @@ package connection
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
-	"zedis/lib/sync/wait"
+	"zedis/config"
 	"zedis/logger"
 )
 
@@ -12,33 +13,171 @@ import (
 type Connection struct {
 	conn net.Conn
 
-	// 等待直到发送完数据，用于客户端的优雅关闭
-	sendingData wait.Wait
+	// 发送队列，响应和推送消息按顺序放入队列，由writeLoop协程写入连接
+	// 发布订阅、客户端缓存的推送消息来自其他协程，放入队列后立即返回，不会被读取缓慢的客户端阻塞
+	outMu    sync.Mutex
+	outCond  *sync.Cond
+	outBufs  [][]byte
+	outBytes int
+	// 为true时不再接收新的数据，writeLoop发送完队列中的数据后退出
+	outClosed bool
+	// writeLoop退出时关闭
+	writerDone chan struct{}
 
-	// 当服务端发送响应时的锁
+	// 连接断开时关闭
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// 保护订阅的频道和模式
 	mu sync.Mutex
 
 	// 密码可能在运行时被配置文件修改，所以存储起来
 	password string
 	// 表示是否超出最大连接数，如果是，则该字段被置为true，无法执行任何命令
 	exceedMaxClients bool
+
+	// 当前选择的数据库
+	selectedDB int
+
+	// 订阅的频道和模式
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// 事务相关状态，只会被连接自己的协程访问
+	multiState bool
+	queue      [][][]byte
+	txErrors   []error
+	watching   map[int]map[string]uint64
+
+	// 执行ASKING后为true，只会被连接自己的协程访问
+	asking bool
+
+	id int64
+	// RESP协议版本和连接名称，由HELLO命令设置
+	protocol   int
+	clientName string
 }
 
+// nextID 用于分配连接ID
+var nextID int64
+
+// Write 将命令的响应放入发送队列，队列超过ClientOutputBufferLimit时等待发送，只阻塞连接自己的协程
 func (c *Connection) Write(bytes []byte) (int, error) {
 	if len(bytes) == 0 {
 		return 0, nil
 	}
-	c.sendingData.Add(1)
-	defer c.sendingData.Done()
+	limit := config.Config.ClientOutputBufferLimit
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	for limit > 0 && c.outBytes >= limit && !c.outClosed {
+		c.outCond.Wait()
+	}
+	if c.outClosed {
+		return 0, net.ErrClosed
+	}
+	c.enqueueLocked(bytes)
+	return len(bytes), nil
+}
 
-	return c.conn.Write(bytes)
+// Push 将推送消息放入发送队列，不会阻塞调用方
+// 客户端读取过慢，队列超过ClientOutputBufferLimit时丢弃消息并断开连接，与Redis的client-output-buffer-limit相同
+func (c *Connection) Push(bytes []byte) {
+	if len(bytes) == 0 {
+		return
+	}
+	limit := config.Config.ClientOutputBufferLimit
+	c.outMu.Lock()
+	if c.outClosed {
+		c.outMu.Unlock()
+		return
+	}
+	if limit > 0 && c.outBytes+len(bytes) > limit {
+		c.outClosed = true
+		c.outBufs = nil
+		c.outCond.Broadcast()
+		c.outMu.Unlock()
+		logger.Warnf("client %s closed for overcoming of output buffer limits", c.RemoteAddr())
+		// 关闭底层连接，解析协程读取出错后按正常流程释放连接
+		c.markDone()
+		_ = c.conn.Close()
+		return
+	}
+	c.enqueueLocked(bytes)
+	c.outMu.Unlock()
+}
+
+func (c *Connection) enqueueLocked(bytes []byte) {
+	c.outBufs = append(c.outBufs, bytes)
+	c.outBytes += len(bytes)
+	c.outCond.Broadcast()
+}
+
+// writeLoop 按顺序发送队列中的数据，每次取出队列中的全部数据一起写入
+func (c *Connection) writeLoop(conn net.Conn, writerDone chan struct{}) {
+	defer close(writerDone)
+	for {
+		c.outMu.Lock()
+		for len(c.outBufs) == 0 && !c.outClosed {
+			c.outCond.Wait()
+		}
+		if len(c.outBufs) == 0 {
+			c.outMu.Unlock()
+			return
+		}
+		bufs, n := net.Buffers(c.outBufs), c.outBytes
+		c.outBufs = nil
+		c.outMu.Unlock()
+
+		_, err := bufs.WriteTo(conn)
+
+		c.outMu.Lock()
+		c.outBytes -= n
+		if err != nil {
+			c.outClosed = true
+			c.outBufs = nil
+			c.outBytes = 0
+		}
+		c.outCond.Broadcast()
+		c.outMu.Unlock()
+		if err != nil {
+			c.markDone()
+			return
+		}
+	}
+}
+
+// Read 从连接读取数据，读取出错说明连接已经断开，此时通知被阻塞的命令
+// 解析协议的协程通过该方法读取数据，即使处理命令的协程被阻塞，也能及时发现连接断开
+func (c *Connection) Read(p []byte) (int, error) {
+	n, err := c.conn.Read(p)
+	if err != nil {
+		c.markDone()
+	}
+	return n, err
+}
+
+func (c *Connection) markDone() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
 }
 
 func (c *Connection) Close() error {
-	c.sendingData.WaitWithTimeout(10 * time.Second)
+	c.markDone()
+	// 等待发送完队列中的数据，用于客户端的优雅关闭
+	c.outMu.Lock()
+	c.outClosed = true
+	c.outCond.Broadcast()
+	c.outMu.Unlock()
+	select {
+	case <-c.writerDone:
+	case <-time.After(10 * time.Second):
+	}
 	_ = c.conn.Close()
-	c.password = ""
-	connPool.Put(c)
 	return nil
 }
 
@@ -62,6 +201,137 @@ func (c *Connection) CheckExceedMaxClients() bool {
 	return c.exceedMaxClients
 }
 
+func (c *Connection) GetDBIndex() int {
+	return c.selectedDB
+}
+
+func (c *Connection) SelectDB(dbNum int) {
+	c.selectedDB = dbNum
+}
+
+func (c *Connection) Subscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+	}
+	c.channels[channel] = struct{}{}
+}
+
+func (c *Connection) UnSubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.channels, channel)
+}
+
+func (c *Connection) PSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.patterns == nil {
+		c.patterns = make(map[string]struct{})
+	}
+	c.patterns[pattern] = struct{}{}
+}
+
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.patterns, pattern)
+}
+
+func (c *Connection) SubsCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels) + len(c.patterns)
+}
+
+func (c *Connection) IsSubscribed(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.channels[channel]
+	return ok
+}
+
+func (c *Connection) GetChannels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (c *Connection) GetPatterns() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	patterns := make([]string, 0, len(c.patterns))
+	for pattern := range c.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+func (c *Connection) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+func (c *Connection) Watch(dbIndex int, key string, version uint64) {
+	if c.watching == nil {
+		c.watching = make(map[int]map[string]uint64)
+	}
+	keys, ok := c.watching[dbIndex]
+	if !ok {
+		keys = make(map[string]uint64)
+		c.watching[dbIndex] = keys
+	}
+	keys[key] = version
+}
+
+func (c *Connection) GetWatching() map[int]map[string]uint64 {
+	return c.watching
+}
+
+func (c *Connection) ClearWatching() {
+	c.watching = nil
+}
+
+func (c *Connection) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+func (c *Connection) IsAsking() bool {
+	return c.asking
+}
+
 func (c *Connection) Name() string {
 	if c.conn != nil {
 		return c.conn.RemoteAddr().String()
@@ -69,18 +339,37 @@ func (c *Connection) Name() string {
 	return ""
 }
 
-var connPool = sync.Pool{
-	New: func() any {
-		return &Connection{exceedMaxClients: false}
-	},
+func (c *Connection) ID() int64 {
+	return c.id
+}
+
+func (c *Connection) SetProtocol(protocol int) {
+	c.protocol = protocol
+}
+
+func (c *Connection) GetProtocol() int {
+	return c.protocol
 }
 
+func (c *Connection) SetClientName(name string) {
+	c.clientName = name
+}
+
+func (c *Connection) GetClientName() string {
+	return c.clientName
+}
+
+// NewConnection 为每个客户端新建连接，不复用已关闭的连接
+// 发布订阅和客户端缓存可能在连接关闭后仍持有它并推送消息，复用会导致消息发给新的客户端
 func NewConnection(conn net.Conn) *Connection {
-	c, ok := connPool.Get().(*Connection)
-	if !ok {
-		logger.Error("connection pool make wrong type")
-		return &Connection{conn: conn, password: "", exceedMaxClients: false}
+	c := &Connection{
+		conn:       conn,
+		id:         atomic.AddInt64(&nextID, 1),
+		protocol:   2,
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
 	}
-	c.conn = conn
+	c.outCond = sync.NewCond(&c.outMu)
+	go c.writeLoop(conn, c.writerDone)
 	return c
 }
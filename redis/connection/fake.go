@@ -0,0 +1,174 @@
+package connection
+
+// FakeConn 伪连接，不对应真实的客户端，用于加载AOF等需要在服务端内部执行命令的场景
+// 写入的数据会被直接丢弃
+type FakeConn struct {
+	password   string
+	selectedDB int
+
+	multiState bool
+	queue      [][][]byte
+	txErrors   []error
+	watching   map[int]map[string]uint64
+	asking     bool
+}
+
+func NewFakeConn() *FakeConn {
+	return &FakeConn{}
+}
+
+func (c *FakeConn) Write(bytes []byte) (int, error) {
+	return len(bytes), nil
+}
+
+func (c *FakeConn) Push(bytes []byte) {
+}
+
+func (c *FakeConn) Close() error {
+	return nil
+}
+
+// Done 伪连接不会断开，返回nil，从nil channel读取会一直阻塞
+func (c *FakeConn) Done() <-chan struct{} {
+	return nil
+}
+
+func (c *FakeConn) RemoteAddr() string {
+	return ""
+}
+
+func (c *FakeConn) SetPassword(s string) {
+	c.password = s
+}
+
+func (c *FakeConn) GetPassword() string {
+	return c.password
+}
+
+func (c *FakeConn) SetExceedMaxClients(b bool) {
+}
+
+func (c *FakeConn) CheckExceedMaxClients() bool {
+	return false
+}
+
+func (c *FakeConn) GetDBIndex() int {
+	return c.selectedDB
+}
+
+func (c *FakeConn) SelectDB(dbNum int) {
+	c.selectedDB = dbNum
+}
+
+// FakeConn 不会执行发布订阅命令，以下方法均为空实现
+
+func (c *FakeConn) Subscribe(channel string) {
+}
+
+func (c *FakeConn) UnSubscribe(channel string) {
+}
+
+func (c *FakeConn) PSubscribe(pattern string) {
+}
+
+func (c *FakeConn) PUnSubscribe(pattern string) {
+}
+
+func (c *FakeConn) SubsCount() int {
+	return 0
+}
+
+func (c *FakeConn) IsSubscribed(channel string) bool {
+	return false
+}
+
+func (c *FakeConn) GetChannels() []string {
+	return nil
+}
+
+func (c *FakeConn) GetPatterns() []string {
+	return nil
+}
+
+func (c *FakeConn) InMultiState() bool {
+	return c.multiState
+}
+
+func (c *FakeConn) SetMultiState(state bool) {
+	if !state {
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+func (c *FakeConn) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+func (c *FakeConn) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+func (c *FakeConn) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+func (c *FakeConn) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+func (c *FakeConn) GetTxErrors() []error {
+	return c.txErrors
+}
+
+func (c *FakeConn) Watch(dbIndex int, key string, version uint64) {
+	if c.watching == nil {
+		c.watching = make(map[int]map[string]uint64)
+	}
+	keys, ok := c.watching[dbIndex]
+	if !ok {
+		keys = make(map[string]uint64)
+		c.watching[dbIndex] = keys
+	}
+	keys[key] = version
+}
+
+func (c *FakeConn) GetWatching() map[int]map[string]uint64 {
+	return c.watching
+}
+
+func (c *FakeConn) ClearWatching() {
+	c.watching = nil
+}
+
+func (c *FakeConn) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+func (c *FakeConn) IsAsking() bool {
+	return c.asking
+}
+
+func (c *FakeConn) Name() string {
+	return "fake"
+}
+
+func (c *FakeConn) ID() int64 {
+	return 0
+}
+
+// 伪连接的响应不会发送给客户端，协议版本固定为2
+func (c *FakeConn) SetProtocol(protocol int) {
+}
+
+func (c *FakeConn) GetProtocol() int {
+	return 2
+}
+
+func (c *FakeConn) SetClientName(name string) {
+}
+
+func (c *FakeConn) GetClientName() string {
+	return ""
+}
@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"zedis/config"
+)
+
+var (
+	errTooBigInline     = errors.New("ERR Protocol error: too big inline request")
+	errUnbalancedQuotes = errors.New("ERR Protocol error: unbalanced quotes in request")
+
+	errInvalidMultiBulkLength = errors.New("ERR Protocol error: invalid multibulk length")
+	errInvalidBulkLength      = errors.New("ERR Protocol error: invalid bulk length")
+)
+
+// readInline 读取一行内联命令并拆分为参数，行的长度不能超过ProtoInlineMaxSize
+// 行尾可以是CRLF或者只有LF，返回的错误会导致连接被关闭
+func readInline(reader *bufio.Reader) ([][]byte, error) {
+	maxSize := config.Config.ProtoInlineMaxSize
+	line := make([]byte, 0)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if maxSize > 0 && len(line) > maxSize {
+			return nil, errTooBigInline
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	return splitArgs(line)
+}
+
+// splitArgs 按照redis-cli的规则拆分参数，参数之间以空白字符分隔
+// 双引号中支持 \n \r \t \b \a \" \\ 和 \xHH 转义，单引号中只支持 \' 转义
+// 引号没有闭合，或者闭合的引号后面不是空白字符时返回错误
+func splitArgs(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i, n := 0, len(line)
+	for {
+		for i < n && isSpace(line[i]) {
+			i++
+		}
+		if i >= n {
+			return args, nil
+		}
+		inDoubleQuotes, inSingleQuotes := false, false
+		arg := make([]byte, 0)
+		for done := false; !done; i++ {
+			if i >= n {
+				if inDoubleQuotes || inSingleQuotes {
+					return nil, errUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDoubleQuotes:
+				if c == '\\' && i+3 < n && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					arg = append(arg, hexDigitValue(line[i+2])<<4|hexDigitValue(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < n {
+					i++
+					arg = append(arg, unescape(line[i]))
+				} else if c == '"' {
+					if i+1 < n && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			case inSingleQuotes:
+				if c == '\\' && i+1 < n && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if c == '\'' {
+					if i+1 < n && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			case isSpace(c):
+				done = true
+			case c == '"':
+				inDoubleQuotes = true
+			case c == '\'':
+				inSingleQuotes = true
+			default:
+				arg = append(arg, c)
+			}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// unescape 双引号中反斜杠后的字符，不是特殊字符时保持原样
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
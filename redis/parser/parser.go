@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"runtime/debug"
 	"strconv"
@@ -12,8 +13,6 @@ import (
 	"zedis/redis/protocol"
 )
 
-var originBytes = make([]byte, 0)
-
 // Payload 存储redis.Reply或者error
 type Payload struct {
 	Data  redis.Reply
@@ -57,6 +56,7 @@ func parseBulkReply(header []byte, reader *bufio.Reader, ch chan<- *Payload) err
 		ch <- &Payload{
 			Data: protocol.NullBulkReply,
 		}
+		return nil
 	}
 
 	body := make([]byte, strLen+2)
@@ -79,19 +79,34 @@ func parseMultiBulkReply(header []byte, reader *bufio.Reader, ch chan<- *Payload
 		ch <- &Payload{
 			Data: protocol.EmptyMultiBulkReply,
 		}
+		return nil
 	}
 
 	lines := make([][]byte, 0, nStrs)
+	// 数组中出现非字符串的元素时不为nil，此时返回ArrayReply
+	var replies []redis.Reply
 	for i := int64(0); i < nStrs; i++ {
 		var line []byte
 		line, err = reader.ReadBytes('\n')
-
-		originBytes = append(originBytes, line...)
-
 		if err != nil {
 			return err
 		}
 		length := len(line)
+		if length >= 3 && line[0] != '$' && line[length-2] == '\r' {
+			// 其他节点的回复中可能包含整数、嵌套数组等元素，如订阅的确认消息
+			if replies == nil {
+				replies = make([]redis.Reply, 0, nStrs)
+				for _, text := range lines {
+					replies = append(replies, protocol.NewBulkReply(text))
+				}
+			}
+			element, err := readElement(line[:length-2], reader)
+			if err != nil {
+				return err
+			}
+			replies = append(replies, element)
+			continue
+		}
 		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
 			parseError("illegal bulk string header "+string(line), ch)
 			break
@@ -105,18 +120,22 @@ func parseMultiBulkReply(header []byte, reader *bufio.Reader, ch chan<- *Payload
 		} else {
 			body := make([]byte, strLen+2)
 			_, err := io.ReadFull(reader, body)
-
-			originBytes = append(originBytes, body...)
-
 			if err != nil {
 				return err
 			}
 			lines = append(lines, body[:len(body)-2])
 		}
+		if replies != nil {
+			replies = append(replies, protocol.NewBulkReply(lines[len(lines)-1]))
+		}
 
 	}
-
-	//logger.Infof("origin cmd: %s", bytes.Replace(originBytes, []byte("\r\n"), []byte(" [r][n] "), -1))
+	if replies != nil {
+		ch <- &Payload{
+			Data: protocol.NewArrayReply(replies),
+		}
+		return nil
+	}
 
 	ch <- &Payload{
 		Data: protocol.NewMultiBulkReply(lines),
@@ -124,22 +143,215 @@ func parseMultiBulkReply(header []byte, reader *bufio.Reader, ch chan<- *Payload
 	return nil
 }
 
+// parseMultiBulkRequest 解析数组形式的命令，数组中的元素只能是批量字符串
+// 格式不正确时返回协议错误，与内联命令相同，返回的错误会导致连接被关闭
+func parseMultiBulkRequest(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil {
+		return errInvalidMultiBulkLength
+	}
+	// 与Redis相同，忽略空数组
+	if n <= 0 {
+		return nil
+	}
+	args := make([][]byte, 0, n)
+	for i := int64(0); i < n; i++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
+		if len(line) == 0 || line[0] != '$' {
+			got := ""
+			if len(line) > 0 {
+				got = string(line[0])
+			}
+			return fmt.Errorf("ERR Protocol error: expected '$', got '%s'", got)
+		}
+		strLen, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || strLen < 0 {
+			return errInvalidBulkLength
+		}
+		body := make([]byte, strLen+2)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return err
+		}
+		args = append(args, body[:strLen])
+	}
+	ch <- &Payload{
+		Data: protocol.NewMultiBulkReply(args),
+	}
+	return nil
+}
+
+// readElement 读取数组中的一个元素，line为去掉CRLF的第一行，嵌套的数组递归读取
+func readElement(line []byte, reader *bufio.Reader) (redis.Reply, error) {
+	switch line[0] {
+	case '+':
+		return protocol.NewSingleReply(string(line[1:])), nil
+	case '-':
+		return protocol.NewErrorReply(string(line[1:])), nil
+	case ':':
+		value, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, errors.New("parse error: illegal number " + string(line[1:]))
+		}
+		return protocol.NewIntReply(value), nil
+	case '$':
+		strLen, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || strLen < -1 {
+			return nil, errors.New("parse error: illegal bulk string header " + string(line))
+		} else if strLen == -1 {
+			return protocol.NullBulkReply, nil
+		}
+		body := make([]byte, strLen+2)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, err
+		}
+		return protocol.NewBulkReply(body[:strLen]), nil
+	case '*':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || n < -1 {
+			return nil, errors.New("parse error: illegal array header " + string(line[1:]))
+		}
+		replies, err := readElements(n, reader)
+		if err != nil {
+			return nil, err
+		}
+		return protocol.NewArrayReply(replies), nil
+	case '_':
+		return protocol.NullBulkReply, nil
+	case ',':
+		value, err := strconv.ParseFloat(string(line[1:]), 64)
+		if err != nil {
+			return nil, errors.New("parse error: illegal double " + string(line[1:]))
+		}
+		return protocol.NewDoubleReply(value), nil
+	case '#':
+		if len(line) != 2 || (line[1] != 't' && line[1] != 'f') {
+			return nil, errors.New("parse error: illegal boolean " + string(line))
+		}
+		return protocol.NewBooleanReply(line[1] == 't'), nil
+	case '(':
+		return protocol.NewBigNumberReply(string(line[1:])), nil
+	case '=':
+		strLen, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || strLen < 4 {
+			return nil, errors.New("parse error: illegal verbatim string header " + string(line))
+		}
+		body := make([]byte, strLen+2)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, err
+		}
+		return protocol.NewVerbatimReply(string(body[:3]), body[4:strLen]), nil
+	case '%', '~', '>', '|':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || n < 0 {
+			return nil, errors.New("parse error: illegal aggregate header " + string(line))
+		}
+		if line[0] == '%' || line[0] == '|' {
+			n *= 2
+		}
+		replies, err := readElements(n, reader)
+		if err != nil {
+			return nil, err
+		}
+		switch line[0] {
+		case '%':
+			return protocol.NewMapReply(replies), nil
+		case '~':
+			return protocol.NewSetReply(replies), nil
+		case '>':
+			return protocol.NewPushReply(replies), nil
+		}
+		// 属性后面紧跟真正的响应
+		next, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := readElement(next, reader)
+		if err != nil {
+			return nil, err
+		}
+		return protocol.NewAttributeReply(replies, reply), nil
+	}
+	return nil, errors.New("parse error: unknown reply type " + string(line))
+}
+
+// readElements 依次读取n个元素，用于数组、映射、集合等聚合类型
+func readElements(n int64, reader *bufio.Reader) ([]redis.Reply, error) {
+	replies := make([]redis.Reply, 0)
+	for i := int64(0); i < n; i++ {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		element, err := readElement(line, reader)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, element)
+	}
+	return replies, nil
+}
+
+// readLine 读取一行并去掉CRLF
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("parse error: illegal line " + string(line))
+	}
+	return line[:len(line)-2], nil
+}
+
+// parseResp3Reply 解析RESP3新增的数据类型，客户端切换到RESP3后服务端会返回这些类型
+func parseResp3Reply(line []byte, reader *bufio.Reader, ch chan<- *Payload) error {
+	reply, err := readElement(line, reader)
+	if err != nil {
+		return err
+	}
+	ch <- &Payload{
+		Data: reply,
+	}
+	return nil
+}
+
 var parseHandlerMap = map[byte]LineParser{
 	'+': parseSingleReply,
 	'-': parseErrorReply,
 	':': parseIntReply,
 	'$': parseBulkReply,
 	'*': parseMultiBulkReply,
+	'_': parseResp3Reply,
+	',': parseResp3Reply,
+	'#': parseResp3Reply,
+	'(': parseResp3Reply,
+	'=': parseResp3Reply,
+	'%': parseResp3Reply,
+	'~': parseResp3Reply,
+	'|': parseResp3Reply,
+	'>': parseResp3Reply,
 }
 
-// ParseStream 从Reader读取数据，并通过channel发送payloads
+// ParseStream 从Reader读取数据，并通过channel发送payloads，用于解析服务端的响应
 func ParseStream(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
-	go parse0(reader, ch)
+	go parse0(reader, ch, false)
+	return ch
+}
+
+// ParseRequestStream 解析客户端发送的命令，用于服务端、AOF和从节点接收的复制流
+// 与Redis相同，首字节为'*'时是数组形式的命令，其他情况都是内联命令
+func ParseRequestStream(reader io.Reader) <-chan *Payload {
+	ch := make(chan *Payload)
+	go parse0(reader, ch, true)
 	return ch
 }
 
-func parse0(rawReader io.Reader, ch chan<- *Payload) {
+func parse0(rawReader io.Reader, ch chan<- *Payload, request bool) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(err, string(debug.Stack()))
@@ -148,36 +360,43 @@ func parse0(rawReader io.Reader, ch chan<- *Payload) {
 
 	reader := bufio.NewReader(rawReader)
 	for {
-		line, err := reader.ReadBytes('\n')
-
-		originBytes = make([]byte, 0)
-
-		for _, b := range line {
-			originBytes = append(originBytes, b)
-		}
-
+		first, err := reader.Peek(1)
 		if err != nil {
 			ch <- &Payload{Error: err}
 			close(ch)
 			return
 		}
-
-		length := len(line)
-		if length <= 2 || line[length-1] != '\n' {
-			// 可能会在流量复制中出现空行，直接忽略
+		// 内联命令，如通过telnet输入的 SET a b；解析响应时，首字节不是RESP类型前缀的行同样按内联命令处理
+		_, isReply := parseHandlerMap[first[0]]
+		if (request && first[0] != '*') || (!request && !isReply) {
+			args, err := readInline(reader)
+			if err != nil {
+				ch <- &Payload{Error: err}
+				close(ch)
+				return
+			}
+			// 空行可能出现在流量复制中，直接忽略
+			if len(args) > 0 {
+				ch <- &Payload{
+					Data: protocol.NewMultiBulkReply(args),
+				}
+			}
 			continue
 		}
+
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			ch <- &Payload{Error: err}
+			close(ch)
+			return
+		}
 		// 去掉CRLF后缀
 		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
-		lineHandler, ok := parseHandlerMap[line[0]]
-		if !ok {
-			lines := bytes.Split(line, []byte{' '})
-			ch <- &Payload{
-				Data: protocol.NewMultiBulkReply(lines),
-			}
-			continue
+		if request {
+			err = parseMultiBulkRequest(line, reader, ch)
+		} else {
+			err = parseHandlerMap[line[0]](line, reader, ch)
 		}
-		err = lineHandler(line, reader, ch)
 
 		if err != nil {
 			ch <- &Payload{Error: err}
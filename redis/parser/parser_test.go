@@ -0,0 +1,227 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"zedis/config"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+// parseAll 读取channel中的全部结果，返回所有命令或响应以及最后的错误
+func parseAll(ch <-chan *Payload) ([]redis.Reply, error) {
+	replies := make([]redis.Reply, 0)
+	for payload := range ch {
+		if payload.Error != nil {
+			return replies, payload.Error
+		}
+		replies = append(replies, payload.Data)
+	}
+	return replies, nil
+}
+
+// parseRequests 按客户端命令解析全部输入
+func parseRequests(input string) ([]redis.Reply, error) {
+	return parseAll(ParseRequestStream(strings.NewReader(input)))
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		input string
+		args  []string
+	}{
+		{"SET a b\r\n", []string{"SET", "a", "b"}},
+		{"PING\n", []string{"PING"}},
+		{"  set\t key   value  \r\n", []string{"set", "key", "value"}},
+		{`set k "hello world"` + "\r\n", []string{"set", "k", "hello world"}},
+		{`set k "a\nb\t\"c\"\\"` + "\r\n", []string{"set", "k", "a\nb\t\"c\"\\"}},
+		{`set k "\x41\x6a\x4"` + "\r\n", []string{"set", "k", "Ajx4"}},
+		{`set k 'it\'s "raw" \n'` + "\r\n", []string{"set", "k", `it's "raw" \n`}},
+		{`set k ""` + "\r\n", []string{"set", "k", ""}},
+		{"set k a\"b c\"\r\n", []string{"set", "k", "ab c"}},
+		// 首字节不是'*'时都是内联命令，即使是RESP的类型前缀
+		{"+PING\r\n", []string{"+PING"}},
+		{"$3 ~x >y\r\n", []string{"$3", "~x", ">y"}},
+		{"_ , # ( ! = %\r\n", []string{"_", ",", "#", "(", "!", "=", "%"}},
+	}
+	for _, tt := range tests {
+		replies, err := parseRequests(tt.input)
+		if err != io.EOF {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if len(replies) != 1 {
+			t.Errorf("%q: expect 1 command, got %d", tt.input, len(replies))
+			continue
+		}
+		r, ok := replies[0].(*protocol.MultiBulkReply)
+		if !ok {
+			t.Errorf("%q: expect multi bulk reply, got %T", tt.input, replies[0])
+			continue
+		}
+		if len(r.Texts) != len(tt.args) {
+			t.Errorf("%q: expect %q, got %q", tt.input, tt.args, r.Texts)
+			continue
+		}
+		for i, arg := range tt.args {
+			if string(r.Texts[i]) != arg {
+				t.Errorf("%q: expect %q, got %q", tt.input, tt.args, r.Texts)
+				break
+			}
+		}
+	}
+}
+
+func TestParseInlineSkipEmptyLines(t *testing.T) {
+	replies, err := parseRequests("\r\n\n   \r\nPING\r\n*1\r\n$4\r\nPING\r\n")
+	if err != io.EOF {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(replies) != 2 {
+		t.Fatalf("expect 2 commands, got %d", len(replies))
+	}
+	for _, reply := range replies {
+		if string(reply.ToBytes()) != "*1\r\n$4\r\nPING\r\n" {
+			t.Errorf("unexpected command %q", reply.ToBytes())
+		}
+	}
+}
+
+func TestParseInlineErrors(t *testing.T) {
+	for _, input := range []string{
+		`set k "abc` + "\r\n",
+		`set k 'abc` + "\r\n",
+		`set k "abc"def` + "\r\n",
+		`set k 'abc'def` + "\r\n",
+	} {
+		replies, err := parseRequests(input + "PING\r\n")
+		if err != errUnbalancedQuotes {
+			t.Errorf("%q: expect unbalanced quotes error, got %v", input, err)
+		}
+		// 协议错误后不再解析后续的命令
+		if len(replies) != 0 {
+			t.Errorf("%q: expect no command, got %d", input, len(replies))
+		}
+	}
+}
+
+func TestParseInlineMaxSize(t *testing.T) {
+	maxSize := config.Config.ProtoInlineMaxSize
+	defer func() {
+		config.Config.ProtoInlineMaxSize = maxSize
+	}()
+	config.Config.ProtoInlineMaxSize = 16
+
+	replies, err := parseRequests("set k 1234567\r\n")
+	if err != io.EOF || len(replies) != 1 {
+		t.Errorf("expect 1 command, got %d, error %v", len(replies), err)
+	}
+	_, err = parseRequests("set k " + strings.Repeat("x", 100) + "\r\n")
+	if err != errTooBigInline {
+		t.Errorf("expect too big inline error, got %v", err)
+	}
+	// 没有换行符时同样限制长度，不会一直读取
+	_, err = parseRequests(strings.Repeat("x", 10000))
+	if err != errTooBigInline {
+		t.Errorf("expect too big inline error, got %v", err)
+	}
+	// 多行字符串不受内联命令长度的限制
+	value := strings.Repeat("x", 100)
+	replies, err = parseRequests("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$100\r\n" + value + "\r\n")
+	if err != io.EOF || len(replies) != 1 {
+		t.Fatalf("expect 1 command, got %d, error %v", len(replies), err)
+	}
+	if r := replies[0].(*protocol.MultiBulkReply); string(r.Texts[2]) != value {
+		t.Errorf("unexpected value %q", r.Texts[2])
+	}
+}
+
+func TestParseMultiBulk(t *testing.T) {
+	cmd := protocol.NewMultiBulkReply([][]byte{[]byte("set"), []byte("k"), []byte("a\r\nb")}).ToBytes()
+	replies, err := parseRequests(string(cmd) + string(cmd))
+	if err != io.EOF {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(replies) != 2 {
+		t.Fatalf("expect 2 commands, got %d", len(replies))
+	}
+	for _, reply := range replies {
+		if !bytes.Equal(reply.ToBytes(), cmd) {
+			t.Errorf("expect %q, got %q", cmd, reply.ToBytes())
+		}
+	}
+}
+
+func TestParseMultiBulkErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"*x\r\n", errInvalidMultiBulkLength.Error()},
+		{"*2\r\n$3\r\nget\r\n:1\r\n", "ERR Protocol error: expected '$', got ':'"},
+		{"*1\r\n+PING\r\n", "ERR Protocol error: expected '$', got '+'"},
+		{"*1\r\n$-1\r\n", errInvalidBulkLength.Error()},
+		{"*1\r\n$abc\r\n", errInvalidBulkLength.Error()},
+	}
+	for _, tt := range tests {
+		replies, err := parseRequests(tt.input + "PING\r\n")
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: expect error %q, got %v", tt.input, tt.err, err)
+		}
+		if len(replies) != 0 {
+			t.Errorf("%q: expect no command, got %d", tt.input, len(replies))
+		}
+	}
+	// 空数组被忽略
+	replies, err := parseRequests("*0\r\nPING\r\n")
+	if err != io.EOF || len(replies) != 1 {
+		t.Errorf("expect 1 command, got %d, error %v", len(replies), err)
+	}
+}
+
+func TestParseReplies(t *testing.T) {
+	replies := []redis.Reply{
+		protocol.OKReply,
+		protocol.NewErrorReply("ERR unknown"),
+		protocol.NewIntReply(-12),
+		protocol.NewBulkReply([]byte("hello")),
+		protocol.NullBulkReply,
+		protocol.NewArrayReply([]redis.Reply{
+			protocol.NewBulkReply([]byte("message")),
+			protocol.NewIntReply(1),
+			protocol.NewArrayReply([]redis.Reply{protocol.NewSingleReply("nested")}),
+		}),
+		protocol.NewDoubleReply(1.5),
+		protocol.NewBooleanReply(true),
+		protocol.NewBigNumberReply("3492890328409238509324850943850943825024385"),
+		protocol.NewVerbatimReply("txt", []byte("Some string")),
+		protocol.NewMapReply([]redis.Reply{
+			protocol.NewBulkReply([]byte("proto")), protocol.NewIntReply(3),
+			protocol.NewBulkReply([]byte("modules")), protocol.EmptyMultiBulkReply,
+		}),
+		protocol.NewSetReply(protocol.BulkReplies([][]byte{[]byte("a"), []byte("b")})),
+		protocol.NewAttributeReply([]redis.Reply{
+			protocol.NewSingleReply("ttl"), protocol.NewIntReply(100),
+		}, protocol.NewBulkReply([]byte("value"))),
+		protocol.NewPushReply(protocol.BulkReplies([][]byte{[]byte("message"), []byte("ch"), []byte("hi")})),
+	}
+	var buf bytes.Buffer
+	for _, reply := range replies {
+		buf.Write(protocol.Encode(reply, protocol.RESP3))
+	}
+	parsed, err := parseAll(ParseStream(&buf))
+	if err != io.EOF {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(parsed) != len(replies) {
+		t.Fatalf("expect %d replies, got %d", len(replies), len(parsed))
+	}
+	for i, reply := range replies {
+		expected := protocol.Encode(reply, protocol.RESP3)
+		if actual := protocol.Encode(parsed[i], protocol.RESP3); !bytes.Equal(expected, actual) {
+			t.Errorf("expect %q, got %q", expected, actual)
+		}
+	}
+}
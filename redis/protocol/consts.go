@@ -10,6 +10,17 @@ func (r *nullBulkReply) ToBytes() []byte {
 	return nullBulkBytes
 }
 
+/* ---- nullMultiBulkReply ---- */
+
+// nullMultiBulkReply 是一个空数组，例如事务因WATCH的key被修改而放弃执行时的响应
+type nullMultiBulkReply struct{}
+
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+func (r *nullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
 /* ---- emptyMultiBulkReply ---- */
 
 type emptyMultiBulkReply struct{}
@@ -20,6 +31,17 @@ func (m *emptyMultiBulkReply) ToBytes() []byte {
 	return emptyMultiBulkBytes
 }
 
+/* ---- noReply ---- */
+
+// noReply 不向客户端发送任何数据，用于已经直接写入连接的响应，如SUBSCRIBE
+type noReply struct{}
+
+var noBytes = []byte("")
+
+func (r *noReply) ToBytes() []byte {
+	return noBytes
+}
+
 /* ---- OKReply ---- */
 
 var okBytes = []byte("+OK\r\n")
@@ -54,9 +76,11 @@ func (r *zeroReply) ToBytes() []byte {
 
 var (
 	NullBulkReply       = &nullBulkReply{}
+	NullMultiBulkReply  = &nullMultiBulkReply{}
 	EmptyBulkReply      = NewBulkReply([]byte(""))
 	OKReply             = &okReply{}
 	PongReply           = &pongReply{}
 	ZeroReply           = &zeroReply{}
 	EmptyMultiBulkReply = &emptyMultiBulkReply{}
+	NoReply             = &noReply{}
 )
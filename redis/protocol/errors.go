@@ -45,4 +45,6 @@ var (
 	ErrorSyntaxReply          = NewErrorReply("Err syntax error")
 	ErrorNoSuchKeyReply       = NewErrorReply("ERR no such key")
 	ErrorIndexOutOfRangeReply = NewErrorReply("ERR index out of range")
+	ErrorNotIntegerReply      = NewErrorReply("ERR value is not an integer or out of range")
+	ErrorNotValidFloatReply   = NewErrorReply("ERR value is not a valid float")
 )
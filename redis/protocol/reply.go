@@ -93,6 +93,10 @@ func NewIntReply(number int64) *IntReply {
 	return &IntReply{number: number}
 }
 
+func (r *IntReply) Number() int64 {
+	return r.number
+}
+
 /* ---- 多行字符串(二进制安全) ---- */
 
 // BulkReply 表示多行字符串
@@ -114,21 +118,21 @@ func NewBulkReply(text []byte) *BulkReply {
 /* ---- 数组，即混合消息数组，表示包含多种消息类型的数组 ---- */
 
 type ArrayReply struct {
-	replies []redis.Reply
+	Replies []redis.Reply
 }
 
 func (r *ArrayReply) ToBytes() []byte {
-	argLen := len(r.replies)
+	argLen := len(r.Replies)
 	var buf bytes.Buffer
 	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
-	for _, reply := range r.replies {
+	for _, reply := range r.Replies {
 		buf.Write(reply.ToBytes())
 	}
 	return buf.Bytes()
 }
 
 func NewArrayReply(replies []redis.Reply) *ArrayReply {
-	return &ArrayReply{replies: replies}
+	return &ArrayReply{Replies: replies}
 }
 
 /* ---- 多行字符串数组(不属于RESP协议的五种数据类型，但较为常用) ---- */
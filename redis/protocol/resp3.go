@@ -0,0 +1,294 @@
+package protocol
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"zedis/interface/redis"
+)
+
+/*
+RESP3在RESP2的基础上增加了以下数据类型，客户端通过HELLO 3切换到RESP3：
+1. 空值(Null)：_\r\n，代替RESP2中的 $-1\r\n 和 *-1\r\n
+2. 浮点数(Double)：首字节为 , 例如 ,1.23\r\n，inf、-inf、nan表示无穷大和非数字
+3. 布尔值(Boolean)：#t\r\n 或 #f\r\n
+4. 大数(Big Number)：首字节为 ( 后跟任意长度的整数
+5. 原样字符串(Verbatim String)：格式与多行字符串相同，首字节为 = ，内容的前4个字节为格式和冒号，如 txt:
+6. 映射(Map)：首字节为 % 后跟键值对的数量，然后依次是每个键和值
+7. 集合(Set)：首字节为 ~ ，格式与数组相同，元素无序且不重复
+8. 属性(Attribute)：首字节为 | ，格式与映射相同，后面紧跟真正的响应，用于附带额外信息
+9. 推送(Push)：首字节为 > ，格式与数组相同，是服务端主动发送的消息，如发布订阅的消息
+
+这些类型的ToBytes返回RESP2下的编码，ToResp3Bytes返回RESP3下的编码
+服务端根据连接协商的协议版本，通过Encode选择编码方式
+*/
+
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Resp3Reply 在RESP2和RESP3下编码不同的响应
+type Resp3Reply interface {
+	redis.Reply
+	ToResp3Bytes() []byte
+}
+
+// Encode 按照协议版本编码响应
+func Encode(reply redis.Reply, protocolVersion int) []byte {
+	if protocolVersion == RESP3 {
+		if r, ok := reply.(Resp3Reply); ok {
+			return r.ToResp3Bytes()
+		}
+	}
+	return reply.ToBytes()
+}
+
+// encodeAggregate 编码数组、映射、集合等聚合类型，子元素同样按RESP3编码
+func encodeAggregate(prefix byte, n int, replies []redis.Reply) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(n) + CRLF)
+	for _, reply := range replies {
+		buf.Write(Encode(reply, RESP3))
+	}
+	return buf.Bytes()
+}
+
+// BulkReplies 将字符串数组转换为多行字符串响应的数组，用于构造映射和集合
+func BulkReplies(texts [][]byte) []redis.Reply {
+	replies := make([]redis.Reply, len(texts))
+	for i, text := range texts {
+		if text == nil {
+			replies[i] = NullBulkReply
+		} else {
+			replies[i] = NewBulkReply(text)
+		}
+	}
+	return replies
+}
+
+/* ---- 空值，RESP2中的空字符串、空数组在RESP3中均编码为 _ ---- */
+
+var nullBytes = []byte("_\r\n")
+
+func (r *nullBulkReply) ToResp3Bytes() []byte {
+	return nullBytes
+}
+
+func (r *nullMultiBulkReply) ToResp3Bytes() []byte {
+	return nullBytes
+}
+
+func (r *BulkReply) ToResp3Bytes() []byte {
+	if r.Text == nil {
+		return nullBytes
+	}
+	return r.ToBytes()
+}
+
+func (r *MultiBulkReply) ToResp3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Texts)) + CRLF)
+	for _, text := range r.Texts {
+		if text == nil {
+			buf.Write(nullBytes)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(text)) + CRLF + string(text) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (r *ArrayReply) ToResp3Bytes() []byte {
+	return encodeAggregate('*', len(r.Replies), r.Replies)
+}
+
+/* ---- 浮点数，RESP2下编码为多行字符串 ---- */
+
+type DoubleReply struct {
+	Value float64
+}
+
+func NewDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+func (r *DoubleReply) format() string {
+	switch {
+	case math.IsInf(r.Value, 1):
+		return "inf"
+	case math.IsInf(r.Value, -1):
+		return "-inf"
+	case math.IsNaN(r.Value):
+		return "nan"
+	}
+	return strconv.FormatFloat(r.Value, 'g', -1, 64)
+}
+
+func (r *DoubleReply) ToBytes() []byte {
+	return r.Resp2().ToBytes()
+}
+
+// Resp2 返回RESP2下对应的多行字符串
+func (r *DoubleReply) Resp2() *BulkReply {
+	return NewBulkReply([]byte(r.format()))
+}
+
+func (r *DoubleReply) ToResp3Bytes() []byte {
+	return []byte("," + r.format() + CRLF)
+}
+
+/* ---- 布尔值，RESP2下编码为整数1或0 ---- */
+
+type BooleanReply struct {
+	Value bool
+}
+
+func NewBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{Value: value}
+}
+
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return []byte(":1" + CRLF)
+	}
+	return zeroBytes
+}
+
+func (r *BooleanReply) ToResp3Bytes() []byte {
+	if r.Value {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+/* ---- 大数，RESP2下编码为多行字符串 ---- */
+
+type BigNumberReply struct {
+	Text string
+}
+
+func NewBigNumberReply(text string) *BigNumberReply {
+	return &BigNumberReply{Text: text}
+}
+
+func (r *BigNumberReply) ToBytes() []byte {
+	return []byte("$" + strconv.Itoa(len(r.Text)) + CRLF + r.Text + CRLF)
+}
+
+func (r *BigNumberReply) ToResp3Bytes() []byte {
+	return []byte("(" + r.Text + CRLF)
+}
+
+/* ---- 原样字符串，Format为3个字节的格式，如txt、mkd，RESP2下编码为多行字符串 ---- */
+
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+func NewVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{Format: format, Text: text}
+}
+
+func (r *VerbatimReply) ToBytes() []byte {
+	return []byte("$" + strconv.Itoa(len(r.Text)) + CRLF + string(r.Text) + CRLF)
+}
+
+func (r *VerbatimReply) ToResp3Bytes() []byte {
+	return []byte("=" + strconv.Itoa(len(r.Text)+4) + CRLF + r.Format + ":" + string(r.Text) + CRLF)
+}
+
+/* ---- 映射，Pairs中键和值交替排列，RESP2下编码为数组 ---- */
+
+type MapReply struct {
+	Pairs []redis.Reply
+	// 为true时RESP2下每个键值对编码为一个二元数组，例如XREAD的响应
+	Nested bool
+}
+
+func NewMapReply(pairs []redis.Reply) *MapReply {
+	return &MapReply{Pairs: pairs}
+}
+
+// NewNestedMapReply 新建RESP2下编码为 [[键, 值], ...] 的映射
+func NewNestedMapReply(pairs []redis.Reply) *MapReply {
+	return &MapReply{Pairs: pairs, Nested: true}
+}
+
+// Resp2 返回RESP2下映射对应的数组
+func (r *MapReply) Resp2() *ArrayReply {
+	if !r.Nested {
+		return NewArrayReply(r.Pairs)
+	}
+	replies := make([]redis.Reply, 0, len(r.Pairs)/2)
+	for i := 0; i+1 < len(r.Pairs); i += 2 {
+		replies = append(replies, NewArrayReply([]redis.Reply{r.Pairs[i], r.Pairs[i+1]}))
+	}
+	return NewArrayReply(replies)
+}
+
+func (r *MapReply) ToBytes() []byte {
+	return r.Resp2().ToBytes()
+}
+
+func (r *MapReply) ToResp3Bytes() []byte {
+	return encodeAggregate('%', len(r.Pairs)/2, r.Pairs)
+}
+
+/* ---- 集合，RESP2下编码为数组 ---- */
+
+type SetReply struct {
+	Members []redis.Reply
+}
+
+func NewSetReply(members []redis.Reply) *SetReply {
+	return &SetReply{Members: members}
+}
+
+func (r *SetReply) ToBytes() []byte {
+	return NewArrayReply(r.Members).ToBytes()
+}
+
+func (r *SetReply) ToResp3Bytes() []byte {
+	return encodeAggregate('~', len(r.Members), r.Members)
+}
+
+/* ---- 属性，Pairs为附带的键值对，Reply为真正的响应，RESP2下只编码Reply ---- */
+
+type AttributeReply struct {
+	Pairs []redis.Reply
+	Reply redis.Reply
+}
+
+func NewAttributeReply(pairs []redis.Reply, reply redis.Reply) *AttributeReply {
+	return &AttributeReply{Pairs: pairs, Reply: reply}
+}
+
+func (r *AttributeReply) ToBytes() []byte {
+	return r.Reply.ToBytes()
+}
+
+func (r *AttributeReply) ToResp3Bytes() []byte {
+	buf := encodeAggregate('|', len(r.Pairs)/2, r.Pairs)
+	return append(buf, Encode(r.Reply, RESP3)...)
+}
+
+/* ---- 推送，RESP2下编码为数组 ---- */
+
+type PushReply struct {
+	Replies []redis.Reply
+}
+
+func NewPushReply(replies []redis.Reply) *PushReply {
+	return &PushReply{Replies: replies}
+}
+
+func (r *PushReply) ToBytes() []byte {
+	return NewArrayReply(r.Replies).ToBytes()
+}
+
+func (r *PushReply) ToResp3Bytes() []byte {
+	return encodeAggregate('>', len(r.Replies), r.Replies)
+}
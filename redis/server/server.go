@@ -10,17 +10,27 @@ import (
 	"sync/atomic"
 	"zedis/config"
 	"zedis/database"
+	"zedis/interface/redis"
 	"zedis/logger"
 	"zedis/redis/connection"
 	"zedis/redis/parser"
 	"zedis/redis/protocol"
+	"zedis/sentinel"
 	"zedis/tcp"
 )
 
+// Engine 执行客户端的命令，普通模式下为database.Engine，哨兵模式下为sentinel.Sentinel
+type Engine interface {
+	Exec(c redis.Connection, cmdLine [][]byte) redis.Reply
+	AfterClientConnect(c redis.Connection)
+	AfterClientClose(c redis.Connection)
+	Close()
+}
+
 // Handler 实现了tcp.Handle，作为Redis的服务处理器
 type Handler struct {
 	activeConn sync.Map
-	engine     *database.Engine
+	engine     Engine
 	closing    atomic.Bool
 }
 
@@ -30,7 +40,15 @@ func NewHandler() *Handler {
 	}
 }
 
+// NewSentinelHandler 创建哨兵模式的服务处理器
+func NewSentinelHandler() *Handler {
+	return &Handler{
+		engine: sentinel.NewSentinel(),
+	}
+}
+
 func (h *Handler) closeClient(client *connection.Connection) {
+	h.engine.AfterClientClose(client)
 	_ = client.Close()
 	h.activeConn.Delete(client)
 }
@@ -40,9 +58,11 @@ func (h *Handler) Close() error {
 	h.closing.Store(true)
 	h.activeConn.Range(func(key, value any) bool {
 		client := key.(*connection.Connection)
+		h.engine.AfterClientClose(client)
 		_ = client.Close()
 		return true
 	})
+	h.engine.Close()
 	return nil
 }
 
@@ -55,13 +75,14 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 
 	client := connection.NewConnection(conn)
 	h.activeConn.Store(client, struct{}{})
+	h.engine.AfterClientConnect(client)
 
 	// 检查是否超出最大客户端数量
 	if tcp.ClientCounter > int32(config.Config.MaxClients) {
 		client.SetExceedMaxClients(true)
 	}
 
-	ch := parser.ParseStream(conn)
+	ch := parser.ParseRequestStream(client)
 	for payload := range ch {
 		if payload.Error != nil {
 			if payload.Error == io.EOF || errors.Is(payload.Error, io.ErrUnexpectedEOF) || strings.Contains(payload.Error.Error(), "use of closed network connection") {
@@ -98,8 +119,10 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 		logger.Infof("cmd: %s", strings.Join(strs, " "))
 
 		reply := h.engine.Exec(client, r.Texts)
-		_, _ = client.Write(reply.ToBytes())
+		_, _ = client.Write(protocol.Encode(reply, client.GetProtocol()))
 
 	}
-
+	// 协议错误时解析协程发送错误后关闭channel，此时关闭连接
+	logger.Info("connection closed: " + client.RemoteAddr())
+	h.closeClient(client)
 }
@@ -0,0 +1,294 @@
+package sentinel
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"zedis/logger"
+	"zedis/redis/protocol"
+)
+
+// 故障转移的状态
+const (
+	failoverNone = iota
+	// 等待被选举为领头哨兵
+	failoverWaitStart
+	// 选择提升为主节点的从节点
+	failoverSelectReplica
+	// 等待从节点提升为主节点
+	failoverWaitPromotion
+	// 让其他从节点复制新的主节点
+	failoverReconfReplicas
+)
+
+var failoverStateNames = map[int]string{
+	failoverNone:           "none",
+	failoverWaitStart:      "wait_start",
+	failoverSelectReplica:  "select_slave",
+	failoverWaitPromotion:  "wait_promotion",
+	failoverReconfReplicas: "reconf_slaves",
+}
+
+// checkDown 更新节点的主观下线状态，主节点主观下线时询问其他哨兵，判断是否客观下线
+func (s *Sentinel) checkDown(m *masterInstance) {
+	s.mu.Lock()
+	for _, inst := range m.instances() {
+		down := time.Since(inst.lastPong) > getDownAfter()
+		if down && !inst.sdown {
+			inst.sdown = true
+			s.event("+sdown", "%s", s.describe(m, inst))
+		} else if !down && inst.sdown {
+			inst.sdown = false
+			s.event("-sdown", "%s", s.describe(m, inst))
+		}
+	}
+	masterDown := m.master.sdown
+	s.mu.Unlock()
+
+	if masterDown {
+		s.askPeers(m)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	quorum := 1
+	for _, peer := range m.sentinels {
+		if peer.masterDown && time.Since(peer.downReplyTime) < downReplyValidity {
+			quorum++
+		}
+	}
+	odown := m.master.sdown && quorum >= m.quorum
+	if odown && !m.odown {
+		m.odown = true
+		s.event("+odown", "%s #quorum %d/%d", s.describe(m, m.master), quorum, m.quorum)
+	} else if !odown && m.odown {
+		m.odown = false
+		s.event("-odown", "%s", s.describe(m, m.master))
+	}
+}
+
+// askPeers 询问其他哨兵主节点是否下线，等待被选举为领头哨兵时同时请求其他哨兵投票
+// SENTINEL IS-MASTER-DOWN-BY-ADDR ip port current-epoch runid，runid为*时只询问下线状态
+func (s *Sentinel) askPeers(m *masterInstance) {
+	s.mu.Lock()
+	runID := "*"
+	if m.failoverState == failoverWaitStart {
+		runID = s.myID
+	}
+	cmdLine := [][]byte{
+		[]byte("SENTINEL"), []byte("IS-MASTER-DOWN-BY-ADDR"),
+		[]byte(m.master.host), []byte(strconv.Itoa(m.master.port)),
+		[]byte(strconv.FormatUint(s.currentEpoch, 10)), []byte(runID),
+	}
+	peers := make([]*sentinelPeer, 0, len(m.sentinels))
+	for _, peer := range m.sentinels {
+		peers = append(peers, peer)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer *sentinelPeer) {
+			defer wg.Done()
+			reply, err := peer.link.send(cmdLine)
+			if err != nil {
+				return
+			}
+			// [下线状态, 投票给的领头哨兵, 领头哨兵的纪元]
+			r, ok := reply.(*protocol.ArrayReply)
+			if !ok || len(r.Replies) != 3 {
+				return
+			}
+			down, ok1 := r.Replies[0].(*protocol.IntReply)
+			leader, ok2 := r.Replies[1].(*protocol.BulkReply)
+			leaderEpoch, ok3 := r.Replies[2].(*protocol.IntReply)
+			if !ok1 || !ok2 || !ok3 {
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			peer.masterDown = down.Number() == 1
+			peer.downReplyTime = time.Now()
+			if string(leader.Text) != "*" {
+				peer.leader = string(leader.Text)
+				peer.leaderEpoch = uint64(leaderEpoch.Number())
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// voteLeader 在reqEpoch纪元中投票给runID，每个纪元只投一票，返回本哨兵投票给的领头哨兵及其纪元，调用方需要持有锁
+func (s *Sentinel) voteLeader(m *masterInstance, reqEpoch uint64, runID string) (string, uint64) {
+	if reqEpoch > s.currentEpoch {
+		s.currentEpoch = reqEpoch
+		s.event("+new-epoch", "%d", reqEpoch)
+	}
+	if m.leaderEpoch < reqEpoch && s.currentEpoch <= reqEpoch {
+		m.leader = runID
+		m.leaderEpoch = s.currentEpoch
+		s.event("+vote-for-leader", "%s %d", runID, m.leaderEpoch)
+		// 投票给其他哨兵后，一段时间内本哨兵不开始故障转移，加上随机时间避免多个哨兵同时开始
+		if runID != s.myID {
+			m.failoverStart = time.Now().Add(time.Duration(rand.Int63n(int64(time.Second))))
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// electedLeader 返回在故障转移的纪元中获得过半且不少于quorum选票的哨兵，调用方需要持有锁
+// 与Redis相同，本哨兵在这个纪元中还没有投票时，投票给其他哨兵中得票最多的，没有时投票给自己
+func (s *Sentinel) electedLeader(m *masterInstance) string {
+	votes := make(map[string]int)
+	for _, peer := range m.sentinels {
+		if peer.leader != "" && peer.leaderEpoch == m.failoverEpoch {
+			votes[peer.leader]++
+		}
+	}
+	winner, maxVotes := "", 0
+	for runID, count := range votes {
+		if count > maxVotes || (count == maxVotes && runID < winner) {
+			winner, maxVotes = runID, count
+		}
+	}
+	if winner == "" {
+		winner = s.myID
+	}
+	if leader, epoch := s.voteLeader(m, m.failoverEpoch, winner); epoch == m.failoverEpoch {
+		votes[leader]++
+	}
+	voters := len(m.sentinels) + 1
+	for runID, count := range votes {
+		if count >= voters/2+1 && count >= m.quorum {
+			return runID
+		}
+	}
+	return ""
+}
+
+// shouldStartFailover 主节点客观下线，且距离上一次故障转移或投票给其他哨兵超过SentinelFailoverTimeout的两倍，调用方需要持有锁
+func shouldStartFailover(m *masterInstance) bool {
+	return m.failoverState == failoverNone && m.odown && time.Since(m.failoverStart) > 2*getFailoverTimeout()
+}
+
+// selectReplica 选择在线且信息没有过时的从节点，复制偏移量最大的优先，偏移量相同时选择runID较小的，调用方需要持有锁
+func (s *Sentinel) selectReplica(m *masterInstance) *instance {
+	candidates := make([]*instance, 0, len(m.replicas))
+	for _, replica := range m.replicas {
+		if replica.sdown || replica.role != "slave" || time.Since(replica.infoTime) > infoValidity {
+			continue
+		}
+		candidates = append(candidates, replica)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].replOffset != candidates[j].replOffset {
+			return candidates[i].replOffset > candidates[j].replOffset
+		}
+		return candidates[i].runID < candidates[j].runID
+	})
+	return candidates[0]
+}
+
+// abortFailover 放弃故障转移，调用方需要持有锁
+func (s *Sentinel) abortFailover(m *masterInstance, reason string) {
+	s.event(reason, "%s", s.describe(m, m.master))
+	m.failoverState = failoverNone
+	m.promoted = nil
+}
+
+// failoverStep 推进故障转移，每次检查主节点后调用
+func (s *Sentinel) failoverStep(m *masterInstance) {
+	s.mu.Lock()
+	switch m.failoverState {
+	case failoverNone:
+		if !shouldStartFailover(m) {
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		// 等待随机的时间再开始，避免多个哨兵同时请求投票导致选票分散，等待期间可能已经投票给了其他哨兵
+		time.Sleep(time.Duration(rand.Int63n(int64(time.Second))))
+		s.mu.Lock()
+		if !shouldStartFailover(m) {
+			s.mu.Unlock()
+			return
+		}
+		s.currentEpoch++
+		m.failoverEpoch = s.currentEpoch
+		m.failoverStart = time.Now()
+		m.failoverState = failoverWaitStart
+		s.event("+new-epoch", "%d", s.currentEpoch)
+		s.event("+try-failover", "%s", s.describe(m, m.master))
+		s.mu.Unlock()
+		// 立即请求其他哨兵投票
+		s.askPeers(m)
+	case failoverWaitStart:
+		timeout := electionTimeout
+		if failoverTimeout := getFailoverTimeout(); failoverTimeout < timeout {
+			timeout = failoverTimeout
+		}
+		if leader := s.electedLeader(m); leader == s.myID {
+			m.failoverState = failoverSelectReplica
+			s.event("+elected-leader", "%s", s.describe(m, m.master))
+		} else if time.Since(m.failoverStart) > timeout {
+			s.abortFailover(m, "-failover-abort-not-elected")
+		}
+		s.mu.Unlock()
+	case failoverSelectReplica:
+		replica := s.selectReplica(m)
+		if replica == nil {
+			s.abortFailover(m, "-failover-abort-no-good-slave")
+			s.mu.Unlock()
+			return
+		}
+		m.promoted = replica
+		m.failoverState = failoverWaitPromotion
+		s.event("+selected-slave", "%s", s.describe(m, replica))
+		s.mu.Unlock()
+
+		if err := sendReplicaOf(replica, "", 0); err != nil {
+			logger.Warnf("sentinel promote %s failed: %v", replica.addr(), err)
+		}
+	case failoverWaitPromotion:
+		// 从节点的INFO中角色变为主节点后，提升完成，使用故障转移的纪元作为新的配置纪元
+		if m.promoted.role == "master" {
+			m.configEpoch = m.failoverEpoch
+			m.failoverState = failoverReconfReplicas
+			s.event("+promoted-slave", "%s", s.describe(m, m.promoted))
+		} else if time.Since(m.failoverStart) > getFailoverTimeout() {
+			s.abortFailover(m, "-failover-abort-slave-timeout")
+		}
+		s.mu.Unlock()
+	case failoverReconfReplicas:
+		promoted := m.promoted
+		replicas := make([]*instance, 0, len(m.replicas))
+		for _, replica := range m.replicas {
+			if replica != promoted && !replica.sdown {
+				replica.lastReconf = time.Now()
+				replicas = append(replicas, replica)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, replica := range replicas {
+			if err := sendReplicaOf(replica, promoted.host, promoted.port); err != nil {
+				logger.Warnf("sentinel reconfigure %s failed: %v", replica.addr(), err)
+			}
+		}
+		// 下线的从节点和旧的主节点重新上线后，由refreshInfo修改它们的配置
+		s.mu.Lock()
+		if m.promoted == promoted {
+			s.event("+failover-end", "%s", s.describe(m, m.master))
+			s.switchMaster(m, promoted.host, promoted.port)
+		}
+		s.mu.Unlock()
+	default:
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,332 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"zedis/config"
+	"zedis/interface/redis"
+	"zedis/logger"
+	"zedis/redis/parser"
+	"zedis/redis/protocol"
+)
+
+var (
+	pingCmd = [][]byte{[]byte("PING")}
+	infoCmd = [][]byte{[]byte("INFO")}
+)
+
+// checkInstance 向节点发送PING、INFO和hello消息，PING失败时不再发送其他命令
+func (s *Sentinel) checkInstance(m *masterInstance, inst *instance) {
+	reply, err := inst.link.send(pingCmd)
+	if err != nil {
+		return
+	}
+	if isValidPingReply(reply) {
+		s.mu.Lock()
+		inst.lastPong = time.Now()
+		s.mu.Unlock()
+	}
+	reply, err = inst.link.send(infoCmd)
+	if err != nil {
+		return
+	}
+	if bulk, ok := reply.(*protocol.BulkReply); ok {
+		s.refreshInfo(m, inst, string(bulk.Text))
+	}
+	s.mu.Lock()
+	hello := s.helloMessage(m)
+	s.mu.Unlock()
+	_, _ = inst.link.send([][]byte{[]byte("PUBLISH"), []byte(helloChannel), []byte(hello)})
+}
+
+// isValidPingReply 与Redis相同，正在加载数据或与主节点断开的节点仍然认为是在线的
+func isValidPingReply(reply redis.Reply) bool {
+	text := string(reply.ToBytes())
+	return text == string(protocol.PongReply.ToBytes()) ||
+		strings.HasPrefix(text, "-LOADING") || strings.HasPrefix(text, "-MASTERDOWN")
+}
+
+// parseInfo 解析INFO的内容，返回所有字段，以及主节点的INFO中列出的从节点地址
+func parseInfo(text string) (map[string]string, []string) {
+	fields := make(map[string]string)
+	replicas := make([]string, 0)
+	for _, line := range strings.Split(text, "\r\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields[key] = value
+		// slave0:ip=127.0.0.1,port=6380,state=online,offset=100,lag=0
+		if _, err := strconv.Atoi(strings.TrimPrefix(key, "slave")); err != nil || !strings.HasPrefix(key, "slave") {
+			continue
+		}
+		var ip, port string
+		for _, kv := range strings.Split(value, ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "ip":
+				ip = v
+			case "port":
+				port = v
+			}
+		}
+		if ip != "" && port != "" {
+			replicas = append(replicas, net.JoinHostPort(ip, port))
+		}
+	}
+	return fields, replicas
+}
+
+// refreshInfo 根据INFO更新节点的信息，发现新的从节点，复制配置与哨兵的配置不一致时修改节点的配置
+func (s *Sentinel) refreshInfo(m *masterInstance, inst *instance, text string) {
+	fields, replicaAddrs := parseInfo(text)
+	s.mu.Lock()
+	now := time.Now()
+	inst.infoTime = now
+	inst.runID = fields["run_id"]
+	if role := fields["role"]; role != inst.role {
+		if inst.role != "" {
+			s.event("+role-change", "%s new reported role is %s", s.describe(m, inst), role)
+		}
+		inst.role = role
+		inst.roleChangeTime = now
+	}
+	if inst.role == "slave" {
+		inst.masterHost = fields["master_host"]
+		inst.masterPort, _ = strconv.Atoi(fields["master_port"])
+		inst.masterLinkUp = fields["master_link_status"] == "up"
+		inst.replOffset, _ = strconv.ParseInt(fields["slave_repl_offset"], 10, 64)
+	}
+	if inst == m.master && inst.role == "master" {
+		for _, addr := range replicaAddrs {
+			if _, ok := m.replicas[addr]; ok || addr == m.master.addr() {
+				continue
+			}
+			host, portStr, _ := net.SplitHostPort(addr)
+			port, _ := strconv.Atoi(portStr)
+			replica := s.newInstance(host, port)
+			m.replicas[addr] = replica
+			s.event("+slave", "%s", s.describe(m, replica))
+		}
+	}
+
+	// 主节点正常时，让角色为主节点或复制其他节点的从节点复制当前的主节点，
+	// 如旧的主节点重新上线，或故障转移后没有修改配置的从节点
+	var reconf bool
+	if inst != m.master && m.failoverState == failoverNone && s.masterLooksSane(m) &&
+		now.Sub(inst.lastReconf) > reconfInterval {
+		if inst.role == "master" && now.Sub(inst.roleChangeTime) > roleChangeWait {
+			reconf = true
+			s.event("+convert-to-slave", "%s", s.describe(m, inst))
+		} else if inst.role == "slave" && (inst.masterHost != m.master.host || inst.masterPort != m.master.port) {
+			reconf = true
+			s.event("+fix-slave-config", "%s", s.describe(m, inst))
+		}
+	}
+	var masterHost string
+	var masterPort int
+	if reconf {
+		inst.lastReconf = now
+		masterHost, masterPort = m.master.host, m.master.port
+	}
+	s.mu.Unlock()
+
+	if reconf {
+		if err := sendReplicaOf(inst, masterHost, masterPort); err != nil {
+			logger.Warnf("sentinel reconfigure %s failed: %v", inst.addr(), err)
+		}
+	}
+}
+
+// masterLooksSane 主节点在线且角色为主节点，调用方需要持有锁
+func (s *Sentinel) masterLooksSane(m *masterInstance) bool {
+	return !m.master.sdown && m.master.role == "master" && time.Since(m.master.infoTime) < infoValidity
+}
+
+// sendReplicaOf 让节点复制指定的主节点，host为空时执行REPLICAOF NO ONE
+func sendReplicaOf(inst *instance, host string, port int) error {
+	cmdLine := [][]byte{[]byte("REPLICAOF"), []byte("NO"), []byte("ONE")}
+	if host != "" {
+		cmdLine = [][]byte{[]byte("REPLICAOF"), []byte(host), []byte(strconv.Itoa(port))}
+	}
+	reply, err := inst.link.send(cmdLine)
+	if err != nil {
+		return err
+	}
+	if protocol.IsErrorReply(reply) {
+		return errors.New(strings.TrimSpace(string(reply.ToBytes())))
+	}
+	return nil
+}
+
+// describe 返回事件中节点的描述，与Redis相同：<类型> <名称> <ip> <port> [@ <主节点名称> <主节点ip> <主节点port>]
+// 调用方需要持有锁
+func (s *Sentinel) describe(m *masterInstance, inst *instance) string {
+	if inst == m.master {
+		return fmt.Sprintf("master %s %s %d", m.name, inst.host, inst.port)
+	}
+	return fmt.Sprintf("slave %s %s %d @ %s %s %d", inst.addr(), inst.host, inst.port,
+		m.name, m.master.host, m.master.port)
+}
+
+/* ---- hello消息 ---- */
+
+// helloMessage 返回发布到节点的hello消息，调用方需要持有锁
+// 格式与Redis相同：哨兵ip,哨兵port,哨兵runid,当前纪元,主节点名称,主节点ip,主节点port,主节点配置纪元
+func (s *Sentinel) helloMessage(m *masterInstance) string {
+	master := currentMaster(m)
+	return fmt.Sprintf("%s,%d,%s,%d,%s,%s,%d,%d", getMyHost(), config.Config.Port, s.myID, s.currentEpoch,
+		m.name, master.host, master.port, m.configEpoch)
+}
+
+// currentMaster 返回当前的主节点，从节点提升完成后，即使故障转移还没有结束，也返回新的主节点，调用方需要持有锁
+// 提升完成时配置纪元已经更新，hello消息中的配置纪元和主节点地址需要保持一致
+func currentMaster(m *masterInstance) *instance {
+	if m.failoverState == failoverReconfReplicas && m.promoted != nil {
+		return m.promoted
+	}
+	return m.master
+}
+
+// subscribeHello 订阅节点的hello频道，断开后每秒重新连接，直到哨兵关闭
+func (s *Sentinel) subscribeHello(addr string) {
+	for {
+		err := s.receiveHello(addr)
+		if err != nil && s.ctx.Err() == nil {
+			logger.Debugf("sentinel hello subscription to %s: %v", addr, err)
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(sentinelTick):
+		}
+	}
+}
+
+func (s *Sentinel) receiveHello(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, instanceTimeout)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	// 哨兵关闭时关闭连接，结束读取
+	go func() {
+		select {
+		case <-s.ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+	ch := parser.ParseStream(conn)
+	defer func() {
+		close(done)
+		_ = conn.Close()
+		for range ch {
+		}
+	}()
+
+	if config.Config.MasterAuth != "" {
+		auth := protocol.NewMultiBulkReply([][]byte{[]byte("AUTH"), []byte(config.Config.MasterAuth)})
+		if _, err := conn.Write(auth.ToBytes()); err != nil {
+			return err
+		}
+		payload := <-ch
+		if payload.Error != nil {
+			return payload.Error
+		}
+		if protocol.IsErrorReply(payload.Data) {
+			return errors.New(strings.TrimSpace(string(payload.Data.ToBytes())))
+		}
+	}
+	subscribe := protocol.NewMultiBulkReply([][]byte{[]byte("SUBSCRIBE"), []byte(helloChannel)})
+	if _, err := conn.Write(subscribe.ToBytes()); err != nil {
+		return err
+	}
+	for payload := range ch {
+		if payload.Error != nil {
+			return payload.Error
+		}
+		msg, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok || len(msg.Texts) != 3 || string(msg.Texts[0]) != "message" {
+			continue
+		}
+		s.processHello(string(msg.Texts[2]))
+	}
+	return nil
+}
+
+// processHello 处理其他哨兵的hello消息，记录新的哨兵，消息中主节点的配置纪元更大时切换到新的主节点
+func (s *Sentinel) processHello(hello string) {
+	parts := strings.Split(hello, ",")
+	if len(parts) != 8 {
+		return
+	}
+	host, runID, name, masterHost := parts[0], parts[2], parts[4], parts[5]
+	port, err1 := strconv.Atoi(parts[1])
+	epoch, err2 := strconv.ParseUint(parts[3], 10, 64)
+	masterPort, err3 := strconv.Atoi(parts[6])
+	configEpoch, err4 := strconv.ParseUint(parts[7], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || runID == s.myID {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return
+	}
+	peer, ok := m.sentinels[runID]
+	if !ok {
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		// 哨兵重启后使用新的runID，删除相同地址的旧记录
+		for id, p := range m.sentinels {
+			if p.addr() == addr {
+				p.link.close()
+				delete(m.sentinels, id)
+			}
+		}
+		peer = &sentinelPeer{runID: runID, host: host, port: port, link: &link{addr: addr}}
+		m.sentinels[runID] = peer
+		s.event("+sentinel", "sentinel %s %s %d @ %s %s %d", runID, host, port, m.name, m.master.host, m.master.port)
+	}
+	peer.lastHello = time.Now()
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.event("+new-epoch", "%d", epoch)
+	}
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if masterHost != m.master.host || masterPort != m.master.port {
+			s.event("+config-update-from", "sentinel %s %s %d @ %s %s %d", runID, host, port, m.name, m.master.host, m.master.port)
+			s.switchMaster(m, masterHost, masterPort)
+		}
+	}
+}
+
+// switchMaster 将主节点切换到指定地址，原主节点和其他从节点都作为新主节点的从节点，结束故障转移，调用方需要持有锁
+func (s *Sentinel) switchMaster(m *masterInstance, host string, port int) {
+	old := m.master
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	newMaster, ok := m.replicas[addr]
+	if !ok {
+		newMaster = s.newInstance(host, port)
+	}
+	delete(m.replicas, addr)
+	m.replicas[old.addr()] = old
+	m.master = newMaster
+	// 新的主节点需要在超过SentinelDownAfterMilliseconds之后才能被判断为下线
+	newMaster.lastPong = time.Now()
+	newMaster.sdown = false
+	m.odown = false
+	m.failoverState = failoverNone
+	m.promoted = nil
+	for _, peer := range m.sentinels {
+		peer.masterDown = false
+	}
+	s.event("+switch-master", "%s %s %d %s %d", m.name, old.host, old.port, host, port)
+}
@@ -0,0 +1,402 @@
+// Package sentinel 实现了哨兵模式，监控主从节点，主节点下线时自动进行故障转移
+package sentinel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zedis/config"
+	"zedis/interface/redis"
+	"zedis/logger"
+	"zedis/pubsub"
+	"zedis/redis/client"
+	"zedis/redis/protocol"
+)
+
+/*
+哨兵模式，参考Redis Sentinel的实现
+每个哨兵每秒向监控的主节点及其从节点发送PING和INFO，从主节点的INFO中发现从节点，
+并向所有节点的__sentinel__:hello频道发布自身的信息，订阅该频道的其他哨兵由此互相发现
+超过SentinelDownAfterMilliseconds没有收到有效的PING回复时，认为节点主观下线(sdown)，
+主节点主观下线后，使用SENTINEL IS-MASTER-DOWN-BY-ADDR询问其他哨兵，认为其下线的哨兵数量达到quorum时，主节点客观下线(odown)
+客观下线后开始故障转移：使用新的纪元请求其他哨兵投票，每个哨兵在一个纪元中只投一票，获得过半且不少于quorum的选票后成为领头哨兵，
+领头哨兵选择复制偏移量最大的从节点执行REPLICAOF NO ONE，提升为主节点后，让其他从节点复制新的主节点，
+其他哨兵通过hello消息中更大的配置纪元得知新的主节点，旧的主节点重新上线后被转换为新主节点的从节点
+*/
+
+const (
+	helloChannel = "__sentinel__:hello"
+	// 发送PING、INFO和hello消息的间隔
+	sentinelTick = time.Second
+	// 连接、发送命令的超时时间
+	instanceTimeout = time.Second
+	// 其他哨兵回复的主节点下线状态的有效期
+	downReplyValidity = 5 * time.Second
+	// INFO超过该时间没有更新时，认为节点的信息已经过时，不能作为新的主节点
+	infoValidity = 5 * time.Second
+	// 从节点的角色变为主节点后，等待该时间没有收到新的配置时才将其转换回从节点
+	roleChangeWait = 8 * time.Second
+	// 两次修改同一个节点的复制配置的最小间隔
+	reconfInterval = 10 * time.Second
+	// 选举领头哨兵的最长时间
+	electionTimeout = 10 * time.Second
+)
+
+// Sentinel 哨兵，实现了与database.Engine相同的Exec、AfterClientConnect、AfterClientClose和Close
+type Sentinel struct {
+	mu   sync.Mutex
+	myID string
+	// 当前纪元，每次故障转移使用一个新的纪元，哨兵在每个纪元中只投一票
+	currentEpoch uint64
+	// 监控的主节点: name -> master
+	masters map[string]*masterInstance
+	// 哨兵的事件发布到与事件同名的频道，如+switch-master
+	hub *pubsub.Hub
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// link 到其他节点或哨兵的连接，出错时关闭，下次发送时重新连接
+type link struct {
+	addr     string
+	password string
+	mu       sync.Mutex
+	c        *client.Client
+}
+
+func (l *link) send(cmdLine [][]byte) (redis.Reply, error) {
+	l.mu.Lock()
+	if l.c == nil {
+		c, err := client.MakeClient(l.addr, l.password, instanceTimeout)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		l.c = c
+	}
+	c := l.c
+	l.mu.Unlock()
+
+	reply, err := c.Send(cmdLine)
+	if err != nil {
+		l.mu.Lock()
+		if l.c == c {
+			l.c = nil
+		}
+		l.mu.Unlock()
+	}
+	return reply, err
+}
+
+func (l *link) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.c != nil {
+		l.c.Close()
+		l.c = nil
+	}
+}
+
+// instance 监控的主节点或从节点，除link外的字段由Sentinel.mu保护
+type instance struct {
+	host string
+	port int
+	link *link
+
+	// 以下字段从INFO中获取
+	runID        string
+	role         string
+	masterHost   string
+	masterPort   int
+	masterLinkUp bool
+	replOffset   int64
+	// 上一次INFO成功的时间
+	infoTime time.Time
+	// 上一次角色改变的时间
+	roleChangeTime time.Time
+
+	// 上一次收到有效的PING回复的时间
+	lastPong time.Time
+	// 主观下线
+	sdown bool
+	// 上一次修改该节点复制配置的时间
+	lastReconf time.Time
+}
+
+func (inst *instance) addr() string {
+	return net.JoinHostPort(inst.host, strconv.Itoa(inst.port))
+}
+
+// sentinelPeer 监控同一个主节点的其他哨兵
+type sentinelPeer struct {
+	runID string
+	host  string
+	port  int
+	link  *link
+	// 上一次收到hello消息的时间
+	lastHello time.Time
+	// 上一次询问主节点下线状态的回复
+	masterDown    bool
+	downReplyTime time.Time
+	leader        string
+	leaderEpoch   uint64
+}
+
+func (p *sentinelPeer) addr() string {
+	return net.JoinHostPort(p.host, strconv.Itoa(p.port))
+}
+
+// masterInstance 监控的主节点，以及它的从节点、监控它的其他哨兵和故障转移的状态
+type masterInstance struct {
+	name   string
+	quorum int
+	master *instance
+	// 配置纪元，故障转移完成后为故障转移使用的纪元，用于在哨兵之间传播新的配置
+	configEpoch uint64
+	// 从节点: addr -> replica
+	replicas map[string]*instance
+	// 其他哨兵: runID -> peer
+	sentinels map[string]*sentinelPeer
+	// 客观下线
+	odown bool
+
+	// 本哨兵在leaderEpoch纪元中投票给的领头哨兵
+	leader      string
+	leaderEpoch uint64
+
+	failoverState int
+	failoverEpoch uint64
+	// 上一次开始故障转移或投票给其他哨兵的时间，用于限制故障转移的频率
+	failoverStart time.Time
+	// 被选为新主节点的从节点
+	promoted *instance
+
+	// 同一时间只有一个协程检查该主节点
+	checking atomic.Bool
+}
+
+func (m *masterInstance) instances() []*instance {
+	instances := make([]*instance, 0, len(m.replicas)+1)
+	instances = append(instances, m.master)
+	for _, replica := range m.replicas {
+		instances = append(instances, replica)
+	}
+	return instances
+}
+
+// NewSentinel 根据SentinelMonitor配置创建哨兵，开始监控主节点
+func NewSentinel() *Sentinel {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sentinel{
+		myID:    genRunID(),
+		masters: make(map[string]*masterInstance),
+		hub:     pubsub.NewHub(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, monitor := range config.Config.SentinelMonitor {
+		if err := s.monitor(monitor); err != nil {
+			panic(fmt.Errorf("invalid SentinelMonitor config %q: %v", monitor, err))
+		}
+	}
+	go s.cron()
+	return s
+}
+
+// genRunID 生成40个字符的十六进制运行ID，与Redis的格式相同
+func genRunID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// monitor 解析"name host port quorum"，开始监控主节点
+func (s *Sentinel) monitor(monitor string) error {
+	fields := strings.Fields(monitor)
+	if len(fields) != 4 {
+		return errors.New("the format should be \"name host port quorum\"")
+	}
+	name, host := fields[0], fields[1]
+	port, err := strconv.Atoi(fields[2])
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port: %s", fields[2])
+	}
+	quorum, err := strconv.Atoi(fields[3])
+	if err != nil || quorum <= 0 {
+		return fmt.Errorf("invalid quorum: %s", fields[3])
+	}
+	if _, ok := s.masters[name]; ok {
+		return fmt.Errorf("duplicated master name: %s", name)
+	}
+	s.masters[name] = &masterInstance{
+		name:      name,
+		quorum:    quorum,
+		master:    s.newInstance(host, port),
+		replicas:  make(map[string]*instance),
+		sentinels: make(map[string]*sentinelPeer),
+	}
+	logger.Infof("sentinel monitoring master %s %s:%d quorum %d", name, host, port, quorum)
+	return nil
+}
+
+// newInstance 创建节点并订阅它的hello频道
+func (s *Sentinel) newInstance(host string, port int) *instance {
+	inst := &instance{
+		host:     host,
+		port:     port,
+		lastPong: time.Now(),
+	}
+	inst.link = &link{addr: inst.addr(), password: config.Config.MasterAuth}
+	go s.subscribeHello(inst.addr())
+	return inst
+}
+
+func getDownAfter() time.Duration {
+	return time.Duration(config.Config.SentinelDownAfterMilliseconds) * time.Millisecond
+}
+
+func getFailoverTimeout() time.Duration {
+	return time.Duration(config.Config.SentinelFailoverTimeout) * time.Millisecond
+}
+
+// getMyHost 返回其他哨兵连接本哨兵使用的地址
+func getMyHost() string {
+	if config.Config.AnnounceHost != "" {
+		return config.Config.AnnounceHost
+	}
+	if config.Config.Bind == "" || config.Config.Bind == "0.0.0.0" {
+		return "127.0.0.1"
+	}
+	return config.Config.Bind
+}
+
+// event 记录哨兵的事件，并发布到与事件同名的频道
+func (s *Sentinel) event(typ string, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	logger.Infof("sentinel %s %s", typ, msg)
+	pubsub.Publish(s.hub, [][]byte{[]byte(typ), []byte(msg)})
+}
+
+// cron 定时检查所有主节点，每个主节点在单独的协程中检查，上一次检查没有完成时跳过
+func (s *Sentinel) cron() {
+	ticker := time.NewTicker(sentinelTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		masters := make([]*masterInstance, 0, len(s.masters))
+		for _, m := range s.masters {
+			masters = append(masters, m)
+		}
+		s.mu.Unlock()
+		for _, m := range masters {
+			if !m.checking.CompareAndSwap(false, true) {
+				continue
+			}
+			go func(m *masterInstance) {
+				defer m.checking.Store(false)
+				s.handleMaster(m)
+			}(m)
+		}
+	}
+}
+
+// handleMaster 检查主节点及其从节点，更新下线状态，推进故障转移
+func (s *Sentinel) handleMaster(m *masterInstance) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("sentinel error: %v\n%s", err, string(debug.Stack()))
+		}
+	}()
+	s.mu.Lock()
+	instances := m.instances()
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func(inst *instance) {
+			defer wg.Done()
+			s.checkInstance(m, inst)
+		}(inst)
+	}
+	wg.Wait()
+	s.checkDown(m)
+	s.failoverStep(m)
+}
+
+func (s *Sentinel) AfterClientConnect(c redis.Connection) {
+}
+
+func (s *Sentinel) AfterClientClose(c redis.Connection) {
+	pubsub.UnsubscribeAll(s.hub, c)
+}
+
+// Close 停止监控，关闭所有连接
+func (s *Sentinel) Close() {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.masters {
+		for _, inst := range m.instances() {
+			inst.link.close()
+		}
+		for _, peer := range m.sentinels {
+			peer.link.close()
+		}
+	}
+}
+
+func (s *Sentinel) Exec(c redis.Connection, cmdLine [][]byte) (res redis.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("error occurs: %v\n%s", err, string(debug.Stack()))
+			res = protocol.ErrorUnknownReply
+		}
+	}()
+
+	if c.CheckExceedMaxClients() {
+		return protocol.NewErrorReply("ERR max number of clients reached")
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmdArgs := cmdLine[1:]
+	if c.SubsCount() > 0 && !pubsub.IsAllowedInSubscribeMode(cmdName) {
+		return pubsub.MakeSubscribeModeErrReply(cmdName)
+	}
+	switch cmdName {
+	case "ping":
+		if c.SubsCount() > 0 {
+			return pubsub.MakePingReply(cmdArgs)
+		}
+		return Ping(cmdArgs)
+	case "sentinel":
+		return Command(s, cmdArgs)
+	case "info":
+		return Info(s, cmdArgs)
+	case "role":
+		return Role(s, cmdArgs)
+	case "subscribe":
+		return pubsub.Subscribe(s.hub, c, cmdArgs)
+	case "unsubscribe":
+		return pubsub.UnSubscribe(s.hub, c, cmdArgs)
+	case "psubscribe":
+		return pubsub.PSubscribe(s.hub, c, cmdArgs)
+	case "punsubscribe":
+		return pubsub.PUnSubscribe(s.hub, c, cmdArgs)
+	}
+	return protocol.NewUnknownCommandErrReply(cmdName)
+}
@@ -0,0 +1,290 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"zedis/config"
+	"zedis/interface/redis"
+	"zedis/redis/protocol"
+)
+
+var errNoSuchMaster = protocol.NewErrorReply("ERR No such master with that name")
+
+// Ping 命令
+// PING [message]
+func Ping(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.PongReply
+	} else if len(args) == 1 {
+		return protocol.NewBulkReply(args[0])
+	}
+	return protocol.NewArgNumErrReply("ping")
+}
+
+// Command SENTINEL命令，查看监控的节点和哨兵，其他哨兵通过IS-MASTER-DOWN-BY-ADDR询问主节点状态和请求投票
+// SENTINEL MASTERS | MASTER name | REPLICAS name | SLAVES name | SENTINELS name | GET-MASTER-ADDR-BY-NAME name | MYID
+// SENTINEL IS-MASTER-DOWN-BY-ADDR ip port current-epoch runid
+func Command(s *Sentinel, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.NewArgNumErrReply("sentinel")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	subArgs := args[1:]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch subCmd {
+	case "myid":
+		return protocol.NewBulkReply([]byte(s.myID))
+	case "masters":
+		names := make([]string, 0, len(s.masters))
+		for name := range s.masters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		replies := make([]redis.Reply, 0, len(names))
+		for _, name := range names {
+			replies = append(replies, s.masterInfo(s.masters[name]))
+		}
+		return protocol.NewArrayReply(replies)
+	case "is-master-down-by-addr":
+		return s.isMasterDownByAddr(subArgs)
+	case "master", "replicas", "slaves", "sentinels", "get-master-addr-by-name":
+		if len(subArgs) != 1 {
+			return protocol.NewArgNumErrReply("sentinel|" + subCmd)
+		}
+		m, ok := s.masters[string(subArgs[0])]
+		if subCmd == "get-master-addr-by-name" {
+			if !ok {
+				return protocol.NullBulkReply
+			}
+			inst := currentMaster(m)
+			return protocol.NewMultiBulkReply([][]byte{[]byte(inst.host), []byte(strconv.Itoa(inst.port))})
+		}
+		if !ok {
+			return errNoSuchMaster
+		}
+		switch subCmd {
+		case "master":
+			return s.masterInfo(m)
+		case "replicas", "slaves":
+			addrs := make([]string, 0, len(m.replicas))
+			for addr := range m.replicas {
+				addrs = append(addrs, addr)
+			}
+			sort.Strings(addrs)
+			replies := make([]redis.Reply, 0, len(addrs))
+			for _, addr := range addrs {
+				replies = append(replies, replicaInfo(m.replicas[addr]))
+			}
+			return protocol.NewArrayReply(replies)
+		default:
+			ids := make([]string, 0, len(m.sentinels))
+			for id := range m.sentinels {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			replies := make([]redis.Reply, 0, len(ids))
+			for _, id := range ids {
+				replies = append(replies, peerInfo(m.sentinels[id]))
+			}
+			return protocol.NewArrayReply(replies)
+		}
+	}
+	return protocol.NewErrorReply(fmt.Sprintf("ERR unknown subcommand '%s'. Try SENTINEL HELP.", string(args[0])))
+}
+
+// isMasterDownByAddr 回复本哨兵是否认为指定地址的主节点主观下线，runid不为*时在current-epoch纪元中为其投票
+// 回复 [下线状态, 投票给的领头哨兵或*, 领头哨兵的纪元]，调用方需要持有锁
+func (s *Sentinel) isMasterDownByAddr(args [][]byte) redis.Reply {
+	if len(args) != 4 {
+		return protocol.NewArgNumErrReply("sentinel|is-master-down-by-addr")
+	}
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	reqEpoch, err := strconv.ParseUint(string(args[2]), 10, 64)
+	if err != nil {
+		return protocol.ErrorNotIntegerReply
+	}
+	runID := string(args[3])
+	var m *masterInstance
+	for _, master := range s.masters {
+		if master.master.addr() == addr {
+			m = master
+			break
+		}
+	}
+	var down int64
+	leader, leaderEpoch := "*", uint64(0)
+	if m != nil {
+		if m.master.sdown {
+			down = 1
+		}
+		if runID != "*" {
+			leader, leaderEpoch = s.voteLeader(m, reqEpoch, runID)
+		}
+	}
+	return protocol.NewArrayReply([]redis.Reply{
+		protocol.NewIntReply(down),
+		protocol.NewBulkReply([]byte(leader)),
+		protocol.NewIntReply(int64(leaderEpoch)),
+	})
+}
+
+// sinceMillis 返回距离t的毫秒数
+func sinceMillis(t time.Time) string {
+	return strconv.FormatInt(time.Since(t).Milliseconds(), 10)
+}
+
+// fieldsReply 将字段名和值交替排列为数组
+func fieldsReply(fields ...string) redis.Reply {
+	texts := make([][]byte, len(fields))
+	for i, field := range fields {
+		texts[i] = []byte(field)
+	}
+	return protocol.NewMultiBulkReply(texts)
+}
+
+// masterInfo 返回SENTINEL MASTER中主节点的信息，调用方需要持有锁
+func (s *Sentinel) masterInfo(m *masterInstance) redis.Reply {
+	inst := m.master
+	flags := "master"
+	if inst.sdown {
+		flags += ",s_down"
+	}
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failoverState != failoverNone {
+		flags += ",failover_in_progress"
+	}
+	return fieldsReply(
+		"name", m.name,
+		"ip", inst.host,
+		"port", strconv.Itoa(inst.port),
+		"runid", inst.runID,
+		"flags", flags,
+		"last-ok-ping-reply", sinceMillis(inst.lastPong),
+		"down-after-milliseconds", strconv.Itoa(config.Config.SentinelDownAfterMilliseconds),
+		"info-refresh", sinceMillis(inst.infoTime),
+		"role-reported", inst.role,
+		"config-epoch", strconv.FormatUint(m.configEpoch, 10),
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"failover-timeout", strconv.Itoa(config.Config.SentinelFailoverTimeout),
+		"failover-state", failoverStateNames[m.failoverState],
+	)
+}
+
+// replicaInfo 返回SENTINEL REPLICAS中从节点的信息，调用方需要持有锁
+func replicaInfo(inst *instance) redis.Reply {
+	flags := inst.role
+	if flags == "" {
+		flags = "slave"
+	}
+	if inst.sdown {
+		flags += ",s_down"
+	}
+	linkStatus := "err"
+	if inst.masterLinkUp {
+		linkStatus = "ok"
+	}
+	return fieldsReply(
+		"name", inst.addr(),
+		"ip", inst.host,
+		"port", strconv.Itoa(inst.port),
+		"runid", inst.runID,
+		"flags", flags,
+		"last-ok-ping-reply", sinceMillis(inst.lastPong),
+		"down-after-milliseconds", strconv.Itoa(config.Config.SentinelDownAfterMilliseconds),
+		"info-refresh", sinceMillis(inst.infoTime),
+		"role-reported", inst.role,
+		"master-link-status", linkStatus,
+		"master-host", inst.masterHost,
+		"master-port", strconv.Itoa(inst.masterPort),
+		"slave-repl-offset", strconv.FormatInt(inst.replOffset, 10),
+	)
+}
+
+// peerInfo 返回SENTINEL SENTINELS中其他哨兵的信息，调用方需要持有锁
+func peerInfo(peer *sentinelPeer) redis.Reply {
+	return fieldsReply(
+		"name", peer.runID,
+		"ip", peer.host,
+		"port", strconv.Itoa(peer.port),
+		"runid", peer.runID,
+		"flags", "sentinel",
+		"last-hello-message", sinceMillis(peer.lastHello),
+		"voted-leader", peer.leader,
+		"voted-leader-epoch", strconv.FormatUint(peer.leaderEpoch, 10),
+	)
+}
+
+// Info 命令，哨兵模式下只有server和sentinel两部分
+// INFO [section]
+func Info(s *Sentinel, args [][]byte) redis.Reply {
+	if len(args) > 1 {
+		return protocol.NewArgNumErrReply("info")
+	}
+	section := "default"
+	if len(args) == 1 {
+		section = strings.ToLower(string(args[0]))
+	}
+	var buf strings.Builder
+	if section == "default" || section == "all" || section == "server" {
+		buf.WriteString("# Server\r\n")
+		buf.WriteString("redis_mode:sentinel\r\n")
+		buf.WriteString(fmt.Sprintf("run_id:%s\r\n", s.myID))
+		buf.WriteString(fmt.Sprintf("tcp_port:%d\r\n", config.Config.Port))
+		buf.WriteString(fmt.Sprintf("uptime_in_seconds:%d\r\n", int64(time.Since(config.EachTimeServerInfo.StartUpTime).Seconds())))
+		buf.WriteString("\r\n")
+	}
+	if section == "default" || section == "all" || section == "sentinel" {
+		s.mu.Lock()
+		names := make([]string, 0, len(s.masters))
+		for name := range s.masters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		buf.WriteString("# Sentinel\r\n")
+		buf.WriteString(fmt.Sprintf("sentinel_masters:%d\r\n", len(names)))
+		buf.WriteString(fmt.Sprintf("sentinel_current_epoch:%d\r\n", s.currentEpoch))
+		for i, name := range names {
+			m := s.masters[name]
+			status := "ok"
+			if m.odown {
+				status = "odown"
+			} else if m.master.sdown {
+				status = "sdown"
+			}
+			buf.WriteString(fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+				i, name, status, m.master.addr(), len(m.replicas), len(m.sentinels)+1))
+		}
+		s.mu.Unlock()
+	}
+	return protocol.NewBulkReply([]byte(buf.String()))
+}
+
+// Role 命令，返回哨兵监控的所有主节点名称
+// ROLE
+func Role(s *Sentinel, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.NewArgNumErrReply("role")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([][]byte, 0, len(s.masters))
+	for name := range s.masters {
+		names = append(names, []byte(name))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return string(names[i]) < string(names[j])
+	})
+	return protocol.NewArrayReply([]redis.Reply{
+		protocol.NewBulkReply([]byte("sentinel")),
+		protocol.NewMultiBulkReply(names),
+	})
+}
@@ -73,7 +73,7 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	// Notify表示sigChan只接收列出的os信号，其余信号不接收
 	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {